@@ -0,0 +1,407 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A followed channel, and whether the user has notifications turned on for it
+// twitch.api.v3.follows.by_user
+type FollowEntry struct {
+	Channel       *ChannelInfo
+	Notifications bool
+}
+
+// This is the set of twitch API calls the ChannelWatcher needs, so that it can poll through
+// either the kraken or the helix API
+type TwitchAPIBackend interface {
+	needed_scopes() []string
+	set_auth_token(authToken string)
+	get_username() (string, error)
+	get_follows(username string) ([]FollowEntry, error)
+	get_streams_channels_following(followed_channels map[ChannelID]bool) (map[ChannelID]StreamChannel, error)
+	rate_limit_budget() RateLimitBudget
+}
+
+// Check a value for the -api option; empty means the default, kraken
+func checkAPIOption(api string) error {
+	if api != "" && api != "kraken" && api != "helix" {
+		return errors.New("expected kraken or helix")
+	}
+	return nil
+}
+
+// Get the API backend picked by the -api option, which checkOptions and the config have checked
+func (app *TwitchNotifierMain) getAPIBackend() TwitchAPIBackend {
+	if app.apiBackend == nil {
+		app.krakenInstance.configure(app.krakenOptions(app.options.kraken_url)...)
+		app.helixInstance.configure(app.krakenOptions(app.options.helix_url)...)
+
+		if app.options.api != nil && *app.options.api == "helix" {
+			app.apiBackend = NewHelixBackend(app)
+		} else {
+			app.apiBackend = NewKrakenBackend(app)
+		}
+	}
+	return app.apiBackend
+}
+
+// The settings for an API instance from the command line options, with baseURL being the option
+// for its base URL
+func (app *TwitchNotifierMain) krakenOptions(baseURL *string) []KrakenOption {
+	out := []KrakenOption{}
+	options := app.options
+	if baseURL != nil && *baseURL != "" {
+		out = append(out, WithBaseURL(*baseURL))
+	}
+	if options.auth_url != nil && *options.auth_url != "" {
+		out = append(out, WithAuthURL(*options.auth_url))
+	}
+	if options.validate_url != nil && *options.validate_url != "" {
+		out = append(out, WithValidateURL(*options.validate_url))
+	}
+	if options.http_timeout != nil && *options.http_timeout > 0 {
+		out = append(out, WithTimeout(time.Duration(*options.http_timeout)*time.Second))
+	}
+	if options.user_agent != nil && *options.user_agent != "" {
+		out = append(out, WithUserAgent(*options.user_agent))
+	}
+	return out
+}
+
+// Check a token with twitch, or wherever the options say to
+func (app *TwitchNotifierMain) validateToken(token string) (*TokenValidation, error) {
+	// getting the backend applies the options to the API instances
+	app.getAPIBackend()
+	return app.krakenInstance.validateToken(token)
+}
+
+// KRAKEN
+
+// The most channels the public kraken streams endpoint takes in one request
+const KRAKEN_MAX_CHANNELS_PER_STREAMS_REQUEST = 100
+
+type KrakenBackend struct {
+	app *TwitchNotifierMain
+
+	// whether we have an OAuth token; without one we're in username-only mode and can only use
+	// the public endpoints
+	authenticated bool
+	// logins of the followed channels, as the public streams endpoint takes channel names under v3
+	channel_logins map[ChannelID]string
+}
+
+func NewKrakenBackend(app *TwitchNotifierMain) *KrakenBackend {
+	return &KrakenBackend{app, false, make(map[ChannelID]string)}
+}
+
+func (backend *KrakenBackend) needed_scopes() []string {
+	return getNeededTwitchScopes()
+}
+
+func (backend *KrakenBackend) set_auth_token(authToken string) {
+	backend.app.krakenInstance.addHeader("Authorization", "OAuth "+authToken)
+	backend.authenticated = true
+}
+
+func (backend *KrakenBackend) rate_limit_budget() RateLimitBudget {
+	return backend.app.krakenInstance.rateLimitBudget()
+}
+
+func (backend *KrakenBackend) get_username() (string, error) {
+	var root_response struct {
+		Token struct {
+			User_Name string
+		}
+	}
+	//app.diag_request()
+	msg("before kraken call for username")
+	err := backend.app.krakenInstance.kraken(&root_response)
+	msg("after kraken call for username")
+	return root_response.Token.User_Name, err
+}
+
+func (backend *KrakenBackend) get_follows(username string) ([]FollowEntry, error) {
+	out := []FollowEntry{}
+
+	msg("before paged kraken call for follows by user response")
+	resultsListKey := "follows"
+
+	pager, err := backend.app.krakenInstance.PagedKraken(resultsListKey, backend.app.queryPageSize, nil,
+		"users", username, "follows",
+		"channels")
+	msg("after paged kraken call for follows by user response")
+	if err != nil {
+		return out, err
+	}
+	for pager.More() {
+		var follow FollowEntry
+		err = pager.Next(&follow)
+		if err != nil {
+			return out, err
+		}
+		if follow.Channel != nil {
+			backend.channel_logins[follow.Channel.Id] = strings.ToLower(channelLogin(follow.Channel))
+		}
+		out = append(out, follow)
+	}
+	return out, nil
+}
+
+func (backend *KrakenBackend) get_streams_channels_following(followed_channels map[ChannelID]bool) (map[ChannelID]StreamChannel, error) {
+	if !backend.authenticated {
+		return backend.get_public_streams_channels_following(followed_channels)
+	}
+
+	app := backend.app
+
+	initialRequestHTTPTries := uint(2)
+
+	additionalParams := make(url.Values)
+	additionalParams.Add("stream_type", "live")
+	pager, err := app.PagedKrakenWithRetry(initialRequestHTTPTries, "streams", app.queryPageSize, &additionalParams, "streams", "followed")
+
+	if err != nil {
+		return map[ChannelID]StreamChannel{}, err
+	}
+
+	return app.get_streams_channels_following(pager, followed_channels)
+}
+
+// Username-only mode: check the followed channels through the public streams endpoint, which
+// takes a batch of channel names and doesn't need a login
+func (backend *KrakenBackend) get_public_streams_channels_following(followed_channels map[ChannelID]bool) (map[ChannelID]StreamChannel, error) {
+	app := backend.app
+	out := map[ChannelID]StreamChannel{}
+
+	channelLogins := []string{}
+	for channel_id, followed := range followed_channels {
+		login := backend.channel_logins[channel_id]
+		if followed && login != "" {
+			channelLogins = append(channelLogins, login)
+		}
+	}
+	// keep the batches the same from poll to poll
+	sort.Strings(channelLogins)
+
+	initialRequestHTTPTries := uint(2)
+
+	for start := 0; start < len(channelLogins); start += KRAKEN_MAX_CHANNELS_PER_STREAMS_REQUEST {
+		end := start + KRAKEN_MAX_CHANNELS_PER_STREAMS_REQUEST
+		if end > len(channelLogins) {
+			end = len(channelLogins)
+		}
+
+		additionalParams := make(url.Values)
+		additionalParams.Add("channel", strings.Join(channelLogins[start:end], ","))
+		additionalParams.Add("stream_type", "live")
+		pager, err := app.PagedKrakenWithRetry(initialRequestHTTPTries, "streams", app.queryPageSize, &additionalParams, "streams")
+		if err != nil {
+			return out, err
+		}
+
+		batch, err := app.get_streams_channels_following(pager, followed_channels)
+		for channel_id, channel_stream := range batch {
+			out[channel_id] = channel_stream
+		}
+		if err != nil {
+			return out, err
+		}
+	}
+
+	return out, nil
+}
+
+// HELIX
+
+type HelixBackend struct {
+	app *TwitchNotifierMain
+
+	// helix user ids of the usernames we've looked up
+	user_ids map[string]string
+	// logo URLs of the followed channels, as helix streams don't include them
+	channel_logos map[ChannelID]*string
+
+	authenticated bool
+}
+
+func NewHelixBackend(app *TwitchNotifierMain) *HelixBackend {
+	out := &HelixBackend{}
+	out.app = app
+	out.user_ids = make(map[string]string)
+	out.channel_logos = make(map[ChannelID]*string)
+	return out
+}
+
+func (backend *HelixBackend) needed_scopes() []string {
+	return getNeededHelixScopes()
+}
+
+func (backend *HelixBackend) set_auth_token(authToken string) {
+	backend.app.helixInstance.addHeader("Authorization", "Bearer "+authToken)
+	backend.authenticated = true
+}
+
+func (backend *HelixBackend) rate_limit_budget() RateLimitBudget {
+	return backend.app.helixInstance.rateLimitBudget()
+}
+
+func (backend *HelixBackend) get_username() (string, error) {
+	// with no params, helix gives us the user the token belongs to
+	var response struct {
+		Data []helixUser
+	}
+	err := backend.app.helixInstance.helix(&response, nil, "users")
+	if err != nil {
+		return "", err
+	}
+	if len(response.Data) != 1 {
+		return "", NewKrakenError(200, "Expected 1 user for the token but got %v", len(response.Data))
+	}
+	user := response.Data[0]
+	backend.user_ids[user.Login] = user.Id
+	return user.Login, nil
+}
+
+func (backend *HelixBackend) get_user_id(username string) (string, error) {
+	userId, ok := backend.user_ids[username]
+	if ok {
+		return userId, nil
+	}
+
+	params := url.Values{}
+	params.Add("login", username)
+	var response struct {
+		Data []helixUser
+	}
+	err := backend.app.helixInstance.helix(&response, &params, "users")
+	if err != nil {
+		return "", err
+	}
+	if len(response.Data) != 1 {
+		return "", NewKrakenError(200, "Expected 1 user for username '%s' but got %v", username, len(response.Data))
+	}
+	userId = response.Data[0].Id
+	backend.user_ids[username] = userId
+	return userId, nil
+}
+
+func (backend *HelixBackend) get_follows(username string) ([]FollowEntry, error) {
+	out := []FollowEntry{}
+
+	if !backend.authenticated {
+		// not a rejected login, so the watcher doesn't go asking for one
+		return out, errHelixUsernameOnly
+	}
+
+	userId, err := backend.get_user_id(username)
+	if err != nil {
+		return out, err
+	}
+
+	params := url.Values{}
+	params.Add("user_id", userId)
+
+	pager, err := backend.app.helixInstance.CursorPagedKraken("data", backend.app.queryPageSize, &params,
+		"channels", "followed")
+	if err != nil {
+		return out, err
+	}
+
+	followedChannels := []helixFollowedChannel{}
+	for pager.More() {
+		var followed helixFollowedChannel
+		err = pager.Next(&followed)
+		if err != nil {
+			return out, err
+		}
+		followedChannels = append(followedChannels, followed)
+	}
+
+	// helix follows don't come with channel logos, so look up the broadcasters' profile images
+	broadcasterIds := []string{}
+	for _, followed := range followedChannels {
+		broadcasterIds = append(broadcasterIds, followed.Broadcaster_Id)
+	}
+	users, err := backend.app.helixInstance.getUsersById(broadcasterIds)
+	if err != nil {
+		return out, err
+	}
+	logos := make(map[string]*string)
+	for i := range users {
+		if users[i].Profile_Image_Url != "" {
+			logos[users[i].Id] = &users[i].Profile_Image_Url
+		}
+	}
+
+	backend.channel_logos = make(map[ChannelID]*string)
+	for _, followed := range followedChannels {
+		channel, err := followed.toChannelInfo(logos[followed.Broadcaster_Id])
+		if err != nil {
+			return out, err
+		}
+		backend.channel_logos[channel.Id] = channel.Logo
+		// helix doesn't tell us about per-follow notification settings
+		out = append(out, FollowEntry{channel, true})
+	}
+
+	return out, nil
+}
+
+func (backend *HelixBackend) get_streams_channels_following(followed_channels map[ChannelID]bool) (map[ChannelID]StreamChannel, error) {
+	app := backend.app
+
+	username := ""
+	if app.options.username != nil {
+		username = *app.options.username
+	}
+	userId, err := backend.get_user_id(username)
+	if err != nil {
+		return map[ChannelID]StreamChannel{}, err
+	}
+
+	initialRequestHTTPTries := uint(2)
+
+	params := url.Values{}
+	params.Add("user_id", userId)
+	pager, err := app.CursorPagedHelixWithRetry(initialRequestHTTPTries, "data", app.queryPageSize, &params, "streams", "followed")
+
+	if err != nil {
+		return map[ChannelID]StreamChannel{}, err
+	}
+
+	return app.get_streams_channels_following(&HelixStreamPager{pager, backend.channel_logos}, followed_channels)
+}
+
+// Wraps a pager of helix streams to give *StreamInfo values like the kraken streams pager does
+type HelixStreamPager struct {
+	pager         ResultsPager
+	channel_logos map[ChannelID]*string
+}
+
+func (streamPager *HelixStreamPager) More() bool {
+	return streamPager.pager.More()
+}
+
+func (streamPager *HelixStreamPager) Next(val interface{}) error {
+	streamOut, wasStreamOut := val.(**StreamInfo)
+	assert(wasStreamOut, "HelixStreamPager.Next() needs a **StreamInfo, got %T", val)
+
+	var helixStreamEntry helixStream
+	err := streamPager.pager.Next(&helixStreamEntry)
+	if err != nil {
+		return err
+	}
+
+	stream, err := helixStreamEntry.toStreamInfo()
+	if err != nil {
+		return err
+	}
+	stream.Channel.Logo = streamPager.channel_logos[stream.Channel.Id]
+
+	*streamOut = stream
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
@@ -17,29 +18,149 @@ import (
 
 type TwitchNotifierMain struct {
 	need_channels_refresh   bool
+	need_reauth             bool
 	_auth_oauth             string
+	// whether _auth_oauth is the one in the token file, rather than from -auth-oauth or the config
+	_auth_oauth_from_file   bool
 	krakenInstance          *Kraken
+	helixInstance           *Helix
+	apiBackend              TwitchAPIBackend
 	options                 *Options
 	windows_balloon_tip_obj WindowsBalloonTipInterface
 	mainEventsInterface     MainEventsInterface
 	queryPageSize           uint
+	follow_notification     map[ChannelID]bool
+	notificationRules       *NotificationRules
+	lastReloadTime          time.Time
+	// nil if we can't tell when the user is away
+	idleDetector            IdleDetector
+	away                    bool
+	held_notifications      []heldNotification
+	// for the -webhook option; nil until they're first needed
+	webhookSinks            []*WebhookEventSink
+	// Discord and Slack destinations; nil until they're first needed
+	chatRoutes              *ChatRoutes
+	// channels muted from the context menu; nil until they're first needed
+	channelMutes            *ChannelMutes
+	// nil until it's first needed, or if there's no history
+	streamHistory           *StreamHistory
+	streamHistoryOpened     bool
+	// the sessions from the stream history; nil until they're first needed and after each write
+	streamSessions          []*StreamSession
 }
 
 func InitTwitchNotifierMain() *TwitchNotifierMain {
 	out := &TwitchNotifierMain{}
 
 	msg("init kraken")
-	out.krakenInstance = InitKraken()
-
-	out.krakenInstance.addHeader("Accept", "application/vnd.twitchtv.v3+json")
+	out.init_api_instances()
 
 	out.need_channels_refresh = true
+	out.need_reauth = false
 	out._auth_oauth = ""
 	out.queryPageSize = 25
+	out.follow_notification = make(map[ChannelID]bool)
+	out.lastReloadTime = time.Now()
+
+	return out
+}
+
+func (app *TwitchNotifierMain) init_api_instances() {
+	app.krakenInstance = InitKraken()
+
+	app.krakenInstance.addHeader("Accept", "application/vnd.twitchtv.v3+json")
+
+	app.helixInstance = InitHelix()
+
+	// getAPIBackend() applies the options to the new instances
+	app.apiBackend = nil
+}
 
+/** Look for changes to the config file, and start using the new settings if there are any.
+    Returns whether there were changes.
+ */
+func (app *TwitchNotifierMain) check_config() bool {
+	config := app.options.config
+	if config == nil || !config.changedOnDisk() {
+		return false
+	}
+	app.getEventsInterface().log("Config file changed; reloading settings")
+	old_api_settings := app.api_settings()
+	err := config.reload()
+	if err != nil {
+		app.getEventsInterface().log(fmt.Sprintf("Error in config: %s", err))
+	}
+	app.settings_changed(app.api_settings() != old_api_settings)
+	return true
+}
+
+// The options the API instances are set up from
+type apiSettings struct {
+	api                 string
+	kraken_url          string
+	helix_url           string
+	auth_url            string
+	validate_url        string
+	http_timeout        int
+	user_agent          string
+	authorization_oauth string
+}
+
+func (app *TwitchNotifierMain) api_settings() apiSettings {
+	str := func(value *string) string {
+		if value == nil {
+			return ""
+		}
+		return *value
+	}
+	options := app.options
+	out := apiSettings{
+		api:                 str(options.api),
+		kraken_url:          str(options.kraken_url),
+		helix_url:           str(options.helix_url),
+		auth_url:            str(options.auth_url),
+		validate_url:        str(options.validate_url),
+		user_agent:          str(options.user_agent),
+		authorization_oauth: str(options.authorization_oauth),
+	}
+	if options.http_timeout != nil {
+		out.http_timeout = *options.http_timeout
+	}
 	return out
 }
 
+// Change a setting, as from the GUI, and save it to the config file
+func (app *TwitchNotifierMain) change_setting(name string, value string) {
+	config := app.options.config
+	if config == nil {
+		app.getEventsInterface().log(fmt.Sprintf("No config file to save the %s setting to", name))
+		return
+	}
+	err := config.Set(name, value)
+	if err != nil {
+		app.getEventsInterface().log(fmt.Sprintf("Error saving the %s setting: %s", name, err))
+	}
+}
+
+/**
+Start using changed settings and reload the followed channels. The API instances are only set up
+again if api_changed, as that loses where we were with the rate limit.
+*/
+func (app *TwitchNotifierMain) settings_changed(api_changed bool) {
+	if api_changed {
+		app.init_api_instances()
+	}
+	app.notificationRules = nil
+	app.channelMutes = nil
+	app.reset_webhook_sinks()
+	app.reset_chat_routes()
+	if app.options.authorization_oauth != nil && *app.options.authorization_oauth != "" {
+		app._auth_oauth = *app.options.authorization_oauth
+		app._auth_oauth_from_file = false
+	}
+	app.need_channels_refresh = true
+}
+
 func (app *TwitchNotifierMain) need_browser_auth() bool {
 	msg("options.no_browser_auth %s", app.options.no_browser_auth)
 	if app.options.no_browser_auth != nil {
@@ -77,6 +198,16 @@ type StreamInfo struct {
 	Id          StreamID `json:"_id"`
 	Created_at  string
 	Game        *string
+	Viewers     int
+	Preview     StreamPreview
+}
+
+// URLs of a stream's preview image; the template has {width} and {height} to fill in
+type StreamPreview struct {
+	Small    string
+	Medium   string
+	Large    string
+	Template string
 }
 
 // Pair of stream and channel for maps
@@ -85,10 +216,25 @@ type StreamChannel struct {
 	channel *ChannelInfo
 }
 
+type StreamChangeKind int
+
+const (
+	STREAM_CHANGE_GAME StreamChangeKind = iota
+	STREAM_CHANGE_TITLE
+)
+
+// A change to the game or title of a stream that was already live
+type StreamChange struct {
+	kind      StreamChangeKind
+	old_value string
+	new_value string
+}
+
 // This is for "virtual functions" in the base app class that should go through the extended app class
 type MainEventsInterface interface {
 	init_channel_display(followed_channel_entries []*ChannelInfo)
 	stream_state_change(channel_id ChannelID, stream_we_consider_online bool, stream *StreamInfo)
+	stream_change(channel_id ChannelID, stream *StreamInfo, change *StreamChange)
 	assume_all_streams_offline()
 	done_state_changes()
 	_channels_reload_complete()
@@ -106,6 +252,10 @@ func (app *TwitchNotifierMain) stream_state_change(channel_id ChannelID, stream_
 
 }
 
+func (app *TwitchNotifierMain) stream_change(channel_id ChannelID, stream *StreamInfo, change *StreamChange) {
+
+}
+
 func (app *TwitchNotifierMain) assume_all_streams_offline() {
 
 }
@@ -172,40 +322,220 @@ func (app *TwitchNotifierMain) create_offline_event_message(channel_name string)
 	return fmt.Sprintf(`<span style="font-weight: bold;">%s</span> went offline`, html.EscapeString(channel_name))
 }
 
-func (app *TwitchNotifierMain) get_stream_start_time(stream *StreamInfo) time.Time {
+func (app *TwitchNotifierMain) create_change_message(channel_name string, change *StreamChange) string {
+	switch change.kind {
+	case STREAM_CHANGE_GAME:
+		if change.new_value == "" {
+			return fmt.Sprintf("%s is no longer playing %s", channel_name, change.old_value)
+		}
+		return fmt.Sprintf("%s switched to %s", channel_name, change.new_value)
+	default:
+		return fmt.Sprintf("%s changed the title to '%s'", channel_name, change.new_value)
+	}
+}
+
+func (app *TwitchNotifierMain) create_change_event_message(channel_name string, change *StreamChange) string {
+	switch change.kind {
+	case STREAM_CHANGE_GAME:
+		if change.new_value == "" {
+			return fmt.Sprintf(`<span style="font-weight: bold;">%s</span> is no longer playing %s`,
+				html.EscapeString(channel_name), html.EscapeString(change.old_value))
+		}
+		return fmt.Sprintf(`<span style="font-weight: bold;">%s</span> switched to %s`,
+			html.EscapeString(channel_name), html.EscapeString(change.new_value))
+	default:
+		return fmt.Sprintf(`<span style="font-weight: bold;">%s</span> changed the title<div>'<span style="font-style: italic">%s</span>'</div>`,
+			html.EscapeString(channel_name), html.EscapeString(change.new_value))
+	}
+}
+
+func (app *TwitchNotifierMain) get_stream_start_time(stream *StreamInfo) (time.Time, error) {
 	created_at := stream.Created_at
 	start_time, err := convert_rfc3339_time(created_at)
-	assert(err == nil, "Error converting time %s", created_at)
+	if err != nil {
+		return start_time, NewKrakenDecodeError("Error converting stream start time '%s': %s", created_at, err)
+	}
+	return start_time, nil
+}
+
+// The stream start time, or now if the API gave us a start time we can't use
+func (app *TwitchNotifierMain) get_stream_start_time_or_now(stream *StreamInfo) time.Time {
+	start_time, err := app.get_stream_start_time(stream)
+	if err != nil {
+		app.getEventsInterface().log(err.Error())
+		return time.Now()
+	}
 	return start_time
 }
 
 func (app *TwitchNotifierMain) create_online_message(channel_name string, stream *StreamInfo) string {
-	start_time := app.get_stream_start_time(stream)
-	elapsed_s := time.Now().Round(time.Second).Sub(start_time.Round(time.Second))
-
 	show_info := app.create_show_info_suffix(stream)
 
+	start_time, err := app.get_stream_start_time(stream)
+	if err != nil {
+		app.getEventsInterface().log(err.Error())
+		return fmt.Sprintf("%s is now live%s", channel_name, show_info)
+	}
+	elapsed_s := time.Now().Round(time.Second).Sub(start_time.Round(time.Second))
+
 	message := fmt.Sprintf("%s is now live%s (up %s)", channel_name, show_info, time_desc(elapsed_s))
 	return message
 }
 
-// Create a notification for the given stream
-func (app *TwitchNotifierMain) notify_for_stream(channel_name string, stream *StreamInfo) {
+var errNoRulesFile = errors.New("there's no rules file to save to")
+
+// The -rules file, or the default one in the prefs dir. Options that weren't made from the command
+// line, as in tests, have no rules file unless they give one.
+func (app *TwitchNotifierMain) rules_filename() string {
+	if app.options.rules_file == nil {
+		return ""
+	}
+	if *app.options.rules_file != "" {
+		return *app.options.rules_file
+	}
+	return getRulesFilename()
+}
+
+// The notification rules from the rules file, loaded the first time we need them
+func (app *TwitchNotifierMain) getNotificationRules() *NotificationRules {
+	if app.notificationRules == nil {
+		rulesFilename := app.rules_filename()
+		rules, err := LoadNotificationRules(rulesFilename)
+		if err != nil {
+			app.getEventsInterface().log(fmt.Sprintf("Error loading notification rules from '%s': %s", rulesFilename, err))
+			rules, _ = NewNotificationRules(nil)
+		}
+		app.notificationRules = rules
+	}
+	return app.notificationRules
+}
+
+// The settings for a channel that the per-channel rules dialog edits
+type ChannelRuleSettings struct {
+	// empty to leave it to the follow's notification setting and the other rules
+	Action RuleAction
+	// the games the action is for; none for any game
+	Games                []string
+	Change_Notifications bool
+	// the other rules for the channel that are left as they are
+	Other_Rules int
+	// true if change_notifications has "*", so every channel gets them anyway
+	All_Change_Notifications bool
+}
+
+func (app *TwitchNotifierMain) channel_rule_settings(channel *ChannelInfo) (*ChannelRuleSettings, error) {
+	rulesFile, err := LoadNotificationRulesFile(app.rules_filename())
+	if err != nil {
+		return nil, err
+	}
+	out := &ChannelRuleSettings{}
+	simple, others := rulesFile.channel_rule(channel)
+	if simple != nil {
+		out.Action = simple.Action
+		out.Games = simple.Games
+	}
+	out.Other_Rules = others
+	out.Change_Notifications, out.All_Change_Notifications = rulesFile.channel_change_notifications(channel)
+	return out, nil
+}
+
+// Save a channel's settings to the rules file, keeping the rest of it as it was, and use them from now on
+func (app *TwitchNotifierMain) save_channel_rule_settings(channel *ChannelInfo, settings *ChannelRuleSettings) error {
+	rulesFilename := app.rules_filename()
+	if rulesFilename == "" {
+		return errNoRulesFile
+	}
+	rulesFile, err := LoadNotificationRulesFile(rulesFilename)
+	if err != nil {
+		return err
+	}
+	rulesFile.set_channel_rule(channel, settings.Action, settings.Games)
+	rulesFile.set_channel_change_notifications(channel, settings.Change_Notifications)
+	err = rulesFile.save(rulesFilename)
+	if err != nil {
+		return err
+	}
+	app.notificationRules = nil
+	return nil
+}
+
+// Create a notification for the given stream if the notification rules or the follow's
+// notification setting call for one. Returns false if the rules can't decide until the stream
+// has been up longer, in which case this should be called again for the stream later.
+func (app *TwitchNotifierMain) notify_for_stream(channel_name string, stream *StreamInfo) bool {
+	if app.getChannelMutes().is_muted(stream.Channel.Id, time.Now()) {
+		msg("Notification for %s muted", channel_name)
+		return true
+	}
+	high_priority := false
+	switch app.getNotificationRules().evaluate(stream, app.get_stream_start_time_or_now(stream), time.Now()) {
+	case RULE_DECISION_WAIT:
+		msg("Holding off on notification for %s until it has been up longer", channel_name)
+		return false
+	case RULE_DECISION_SUPPRESS:
+		msg("Notification for %s suppressed by a rule", channel_name)
+		return true
+	case RULE_DECISION_NOTIFY_HIGH:
+		high_priority = true
+	case RULE_DECISION_DEFAULT:
+		if !app.follow_notification[stream.Channel.Id] {
+			return true
+		}
+	}
 
 	message := app.create_online_message(channel_name, stream)
 	msg("Showing message: '%s'", message)
 	stream_browser_link := stream.Channel.Url
 
 	// Supply a callback to handle the event where the notification was clicked
-	callback := NotificationCallback{channel_name, stream_browser_link}
+	callback := NotificationCallback{channel_name, stream_browser_link, high_priority, channel_logo_url(stream.Channel), app.open_stream_default}
+
+	// the chat channels get it even when the user is away
+	app.send_chat_notification(message, stream, high_priority)
 
+	if app.away {
+		msg("Holding notification for %s until the user is back", channel_name)
+		app.hold_notification(channel_name, message, callback)
+		return true
+	}
+	app.show_notification(message, callback)
+	return true
+}
+
+// Tell the app about a game or title change on a live stream, and create a notification for it,
+// if the channel is one the notification rules want change notifications for
+func (app *TwitchNotifierMain) notify_for_stream_change(channel_id ChannelID, stream *StreamInfo, change *StreamChange) {
+	if !app.getNotificationRules().wantsChangeNotifications(stream.Channel) {
+		return
+	}
+	app.getEventsInterface().stream_change(channel_id, stream, change)
+
+	channel_name := stream.Channel.Display_Name
+	if app.getChannelMutes().is_muted(channel_id, time.Now()) {
+		msg("Change notification for %s muted", channel_name)
+		return
+	}
+	if app.getNotificationRules().evaluate(stream, app.get_stream_start_time_or_now(stream), time.Now()) == RULE_DECISION_SUPPRESS {
+		msg("Change notification for %s suppressed by a rule", channel_name)
+		return
+	}
+	if app.away {
+		// it will be old news by the time the user is back
+		return
+	}
+	message := app.create_change_message(channel_name, change)
+	msg("Showing message: '%s'", message)
+	app.show_notification(message, NotificationCallback{channel_name, stream.Channel.Url, false, channel_logo_url(stream.Channel), app.open_stream_default})
+}
+
+func (app *TwitchNotifierMain) show_notification(message string, callback NotificationCallback) {
 	popupsEnabled := true
 	if app.options.no_popups != nil {
 		popupsEnabled = !*app.options.no_popups
 	}
 
 	if popupsEnabled && app.windows_balloon_tip_obj != nil {
-		app.windows_balloon_tip_obj.balloon_tip("twitch-notifier-go", message, callback, stream_browser_link)
+		app.windows_balloon_tip_obj.balloon_tip("twitch-notifier-go", message, callback, callback.stream_browser_link)
 	}
 }
 
@@ -214,27 +544,30 @@ type WindowsBalloonTipInterface interface {
 	balloon_tip(title string, message string, callback NotificationCallback, url string)
 }
 
-type OurWindowsBalloonTip struct {
-	main_window *MainStatusWindowImpl
-}
-
-func NewOurWindowsBalloonTip(main_window *MainStatusWindowImpl) *OurWindowsBalloonTip {
-	return &OurWindowsBalloonTip{main_window}
-}
-
-func (tip *OurWindowsBalloonTip) balloon_tip(title string, msg string, callback NotificationCallback, url string) {
-	tip.main_window.enqueue_notification(title, msg, callback, url)
-}
-
 type NotificationCallback struct {
 	channel_name        string
 	stream_browser_link string
+	// a rule asked for this notification to stand out
+	high_priority bool
+	// for notification backends that can show the channel logo; "" if there isn't one
+	logo_url string
+	// opens the link when the notification is clicked; nil for the browser
+	opener func(url string, channel_name string) error
+}
+
+func channel_logo_url(channel *ChannelInfo) string {
+	if channel == nil || channel.Logo == nil {
+		return ""
+	}
+	return *channel.Logo
 }
 
 func (callback NotificationCallback) callback() error {
 	fmt.Printf("notification for %s clicked\n", callback.channel_name)
-	webbrowser_open(callback.stream_browser_link)
-	return nil
+	if callback.opener != nil {
+		return callback.opener(callback.stream_browser_link, callback.channel_name)
+	}
+	return webbrowser_open(callback.stream_browser_link)
 }
 
 func (app *TwitchNotifierMain) diag_request(parts ...string) {
@@ -250,18 +583,24 @@ func (app *TwitchNotifierMain) diag_request(parts ...string) {
 	msg("Output of request %s was %s", url_parts, prettyOutput)
 }
 
-func (app *TwitchNotifierMain) nextWithRetry(pager *KrakenPager, val interface{}, httpErrorTries uint) error {
+/**
+Whether the error is for an HTTP error status that the request didn't already retry. A 429 or a 5xx
+has had its retries by the time we see it, so trying again here would just multiply them.
+*/
+func isUnretriedHTTPError(err error) bool {
+	kind, wasKrakenError := krakenErrorKind(err)
+	return wasKrakenError && kind == KRAKEN_HTTP_STATUS_ERROR && !isRetryableStatus(err.(*KrakenError).statusCode)
+}
+
+func (app *TwitchNotifierMain) nextWithRetry(pager ResultsPager, val interface{}, httpErrorTries uint) error {
 	var err error
 	for httpErrorTries > 0 {
 		err = pager.Next(val)
 		if err != nil {
-			krakenError, wasKrakenError := err.(*KrakenError)
-			if wasKrakenError && krakenError != nil {
-				if krakenError.statusCode != 200 {
-					httpErrorTries -= 1
-					app.getEventsInterface().log(fmt.Sprintf("Got HTTP error %v while loading item; tries left %v", krakenError.statusCode, httpErrorTries))
-					continue
-				}
+			if isUnretriedHTTPError(err) && pager.More() {
+				httpErrorTries -= 1
+				app.getEventsInterface().log(fmt.Sprintf("Got HTTP error %v while loading item; tries left %v", err.(*KrakenError).statusCode, httpErrorTries))
+				continue
 			}
 		}
 		break
@@ -269,19 +608,17 @@ func (app *TwitchNotifierMain) nextWithRetry(pager *KrakenPager, val interface{}
 	return err
 }
 
-func (app *TwitchNotifierMain) PagedKrakenWithRetry(httpErrorTries uint, resultsListKey string, pageSize uint, addParams *url.Values, path ...string) (*KrakenPager, error) {
+// Create a pager with newPager, retrying it if the initial page request gets an HTTP error
+func (app *TwitchNotifierMain) pagerWithRetry(httpErrorTries uint, newPager func() (ResultsPager, error)) (ResultsPager, error) {
 	var err error
-	var pager *KrakenPager
+	var pager ResultsPager
 	for httpErrorTries > 0 {
-		pager, err = app.krakenInstance.PagedKraken(resultsListKey, pageSize, addParams, path...)
+		pager, err = newPager()
 		if err != nil {
-			krakenError, wasKrakenError := err.(*KrakenError)
-			if wasKrakenError && krakenError != nil {
-				if krakenError.statusCode != 200 {
-					httpErrorTries -= 1
-					app.getEventsInterface().log(fmt.Sprintf("Got HTTP error %v while doing initial pager request; tries left %v", krakenError.statusCode, httpErrorTries))
-					continue
-				}
+			if isUnretriedHTTPError(err) {
+				httpErrorTries -= 1
+				app.getEventsInterface().log(fmt.Sprintf("Got HTTP error %v while doing initial pager request; tries left %v", err.(*KrakenError).statusCode, httpErrorTries))
+				continue
 			}
 		}
 		break
@@ -289,18 +626,29 @@ func (app *TwitchNotifierMain) PagedKrakenWithRetry(httpErrorTries uint, results
 	return pager, err
 }
 
-func (app *TwitchNotifierMain) get_streams_channels_following(followed_channels map[ChannelID]bool) (map[ChannelID]StreamChannel, error) {
-	out := map[ChannelID]StreamChannel{}
-
-	initialRequestHTTPTries := uint(2)
+func (app *TwitchNotifierMain) PagedKrakenWithRetry(httpErrorTries uint, resultsListKey string, pageSize uint, addParams *url.Values, path ...string) (ResultsPager, error) {
+	return app.pagerWithRetry(httpErrorTries, func() (ResultsPager, error) {
+		pager, err := app.krakenInstance.PagedKraken(resultsListKey, pageSize, addParams, path...)
+		if err != nil {
+			return nil, err
+		}
+		return pager, nil
+	})
+}
 
-	additionalParams := make(url.Values)
-	additionalParams.Add("stream_type", "live")
-	pager, err := app.PagedKrakenWithRetry(initialRequestHTTPTries, "streams", app.queryPageSize, &additionalParams, "streams", "followed")
+func (app *TwitchNotifierMain) CursorPagedHelixWithRetry(httpErrorTries uint, resultsListKey string, pageSize uint, addParams *url.Values, path ...string) (ResultsPager, error) {
+	return app.pagerWithRetry(httpErrorTries, func() (ResultsPager, error) {
+		pager, err := app.helixInstance.CursorPagedKraken(resultsListKey, pageSize, addParams, path...)
+		if err != nil {
+			return nil, err
+		}
+		return pager, nil
+	})
+}
 
-	if err != nil {
-		return out, err
-	}
+// Collect the streams from a pager of *StreamInfo values that are for channels in followed_channels
+func (app *TwitchNotifierMain) get_streams_channels_following(pager ResultsPager, followed_channels map[ChannelID]bool) (map[ChannelID]StreamChannel, error) {
+	out := map[ChannelID]StreamChannel{}
 
 	for pager.More() {
 		var stream *StreamInfo
@@ -310,10 +658,13 @@ func (app *TwitchNotifierMain) get_streams_channels_following(followed_channels
 			return out, err
 		}
 
-		assert(err == nil, "next return error: %s", err)
-		assert(stream != nil, "stream was nil")
+		if stream == nil {
+			return out, NewKrakenDecodeError("got a null stream in the streams list")
+		}
 		channel := stream.Channel
-		assert(channel != nil, "stream has no channel")
+		if channel == nil {
+			return out, NewKrakenDecodeError("stream %v has no channel", stream.Id)
+		}
 		channel_id := stream.Channel.Id
 		val, ok := followed_channels[channel_id]
 		if val && ok {
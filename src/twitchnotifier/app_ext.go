@@ -1,13 +1,13 @@
+// +build !headless
+
 package main
 
 import (
 	"fmt"
 	"github.com/rakslice/wxGo/wx"
 	"github.com/tomcatzh/asynchttpclient"
-	"log"
 	"net/http"
 	"sort"
-	"strings"
 	"time"
 )
 
@@ -18,14 +18,11 @@ type OurTwitchNotifierMain struct {
 	TwitchNotifierMain
 	window_impl               *MainStatusWindowImpl
 	main_loop_iter            *ChannelWatcher
-	followed_channel_entries  []*ChannelInfo
-	channel_status_by_id      map[ChannelID]*ChannelStatus
+	// the followed channels, and what's in the online and offline lists
+	channels                  *ChannelListModel
 	previously_online_streams map[ChannelID]bool
-	stream_by_channel_id      map[ChannelID]*StreamInfo
-	follow_notification       map[ChannelID]bool
 	asynchttpclient           *asynchttpclient.Client
 	need_relayout             bool
-	lastReloadTime            time.Time
 	stream_event_channels	  []ChannelID
 	stream_event_times	  []time.Time
 }
@@ -34,30 +31,15 @@ func InitOurTwitchNotifierMain() *OurTwitchNotifierMain {
 	out := &OurTwitchNotifierMain{}
 	out.TwitchNotifierMain = *InitTwitchNotifierMain()
 	out.mainEventsInterface = out
-	out.channel_status_by_id = make(map[ChannelID]*ChannelStatus)
+	out.channels = NewChannelListModel()
 	out.previously_online_streams = make(map[ChannelID]bool)
-	out.stream_by_channel_id = make(map[ChannelID]*StreamInfo)
-	out.follow_notification = make(map[ChannelID]bool)
 	msg("before http client")
 	out.asynchttpclient = &asynchttpclient.Client{}
 	out.asynchttpclient.Concurrency = 3
 	out.need_relayout = false
-	out.lastReloadTime = time.Now()
 	return out
 }
 
-// STRUCTS USED IN OurTwitchNotifierMain
-
-type ChannelStatus struct {
-	online bool
-	idx    uint
-}
-
-type WaitItem struct {
-	length time.Duration
-	reason string
-}
-
 // METHODS TO IMPLEMENT MainEventsInterface
 
 // These are "virtual" methods called from the enclosed TwitchNotifierMain
@@ -67,8 +49,7 @@ func (app *OurTwitchNotifierMain) init_channel_display(followed_channel_entries
 
 	msg("** init channel display with %v entries", len(followed_channel_entries))
 
-	app.followed_channel_entries = followed_channel_entries
-	app.reset_lists()
+	app.channels.set_channels(followed_channel_entries)
 }
 
 /**
@@ -77,59 +58,21 @@ This is called when a channel has gone online or offline
 func (app *OurTwitchNotifierMain) stream_state_change(channel_id ChannelID, new_online bool, stream *StreamInfo) {
 	msg("stream state change for channel %v", uint64(channel_id))
 
-	if stream != nil {
-		app._store_updated_channel_info(stream.Channel)
-	}
-
 	val, ok := app.previously_online_streams[channel_id]
 	if ok && val {
 		delete(app.previously_online_streams, channel_id)
 	}
 
-	app.stream_by_channel_id[channel_id] = stream
-
-	channel_obj := app._channel_for_id(channel_id)
-	if channel_obj == nil {
-		msg("skipping channel id %s state change check ")
+	entry := app.channels.entry(channel_id)
+	if entry == nil {
+		msg("skipping channel id %v state change check", channel_id)
 		return
 	}
+	old_online := entry.Online
+	app.channels.set_stream(channel_id, new_online, stream)
+	channel_obj := entry.Channel
 
-	channel_status, channel_status_ok := app.channel_status_by_id[channel_id]
-	assert(channel_status_ok, "channel status for channel %s not found", channel_id)
-	assert(channel_status != nil, "nil channel status entry at %s", channel_id)
-	old_online := channel_status.online
 	if old_online != new_online {
-		// item is moving from one list to another
-		new_line_item := app.channel_display_name(channel_obj)
-
-		// remove item from the old list
-		old_index := channel_status.idx
-		out_of_list := app._list_for_is_online(old_online)
-		out_of_list.Delete(old_index)
-
-		// figure out location to insert in the new list
-		into_list := app._list_for_is_online(new_online)
-		//into_list_count := into_list.GetCount()
-		into_list_items := into_list.GetStrings()
-		new_index := uint(sort.Search(len(into_list_items), func(i int) bool { return strings.ToLower(into_list_items[i]) >= strings.ToLower(new_line_item) }))
-
-		// update the other list indexes
-		for _, cur_status := range app.channel_status_by_id {
-			if cur_status.online == old_online && cur_status.idx > old_index {
-				// items in the old list after the removed item get their index reduced by one
-				cur_status.idx -= 1
-			} else if cur_status.online == new_online && cur_status.idx >= new_index {
-				// items in the new list that should go after the new item get their index increased by one
-				cur_status.idx += 1
-			}
-		}
-
-		// actually insert into the dest list
-		into_list.Insert(new_line_item, new_index)
-
-		channel_status.online = new_online
-		channel_status.idx = new_index
-
 		app.need_relayout = true
 
 		// Add stream event log entry
@@ -137,22 +80,32 @@ func (app *OurTwitchNotifierMain) stream_state_change(channel_id ChannelID, new_
 		var streamEventTime time.Time
 		if new_online {
 			streamEventMessage = app.create_online_event_message(channel_obj.Display_Name, stream)
-			streamEventTime = app.get_stream_start_time(stream)
+			streamEventTime = app.get_stream_start_time_or_now(stream)
 		} else {
 			streamEventMessage = app.create_offline_event_message(channel_obj.Display_Name)
 			streamEventTime = time.Now()
 		}
 		app.stream_event_log(streamEventMessage, channel_id, streamEventTime)
+		app.record_stream_history(channel_obj, new_online, stream, streamEventTime)
+		app.send_webhook_event(app.new_stream_state_event(channel_obj, new_online, stream))
 	}
 }
 
-func (app *OurTwitchNotifierMain) assume_all_streams_offline() {
-	app.previously_online_streams = make(map[ChannelID]bool)
-	for channel_id, channel_status := range app.channel_status_by_id {
-		if channel_status.online {
-			app.previously_online_streams[channel_id] = true
-		}
+/**
+This is called when a live channel has switched games or changed its title, if the user wants to know
+*/
+func (app *OurTwitchNotifierMain) stream_change(channel_id ChannelID, stream *StreamInfo, change *StreamChange) {
+	channel_obj := app._channel_for_id(channel_id)
+	if channel_obj == nil {
+		msg("skipping channel id %v change", channel_id)
+		return
 	}
+	app.stream_event_log(app.create_change_event_message(channel_obj.Display_Name, change), channel_id, time.Now())
+	app.send_webhook_event(app.new_stream_change_event(stream, change))
+}
+
+func (app *OurTwitchNotifierMain) assume_all_streams_offline() {
+	app.previously_online_streams = app.channels.online_channel_ids()
 }
 
 func (app *OurTwitchNotifierMain) done_state_changes() {
@@ -254,14 +207,23 @@ func (app *OurTwitchNotifierMain) stream_event_log(message string, channel_id Ch
 }
 
 func (app *OurTwitchNotifierMain) openSiteForStreamEventListEntryIndex(index int) {
-	if index == -1 {
-		return
+	app.openStreamEventListEntryWith(index, app.open_with_player_by_default())
+}
+
+// The channel of a stream event log entry, or nil if we don't follow it any more
+func (app *OurTwitchNotifierMain) getChannelForStreamEventListEntry(index int) *ChannelInfo {
+	if index < 0 || index >= len(app.stream_event_channels) {
+		return nil
 	}
 	event_num := len(app.stream_event_channels) - index - 1
-	channel_id := app.stream_event_channels[event_num]
-	channel := app._channel_for_id(channel_id)
+	return app._channel_for_id(app.stream_event_channels[event_num])
+}
+
+// Open the stream for a stream event log entry in the player, or the browser
+func (app *OurTwitchNotifierMain) openStreamEventListEntryWith(index int, in_player bool) {
+	channel := app.getChannelForStreamEventListEntry(index)
 	if channel != nil {
-		webbrowser_open(channel.Url)
+		app.open_stream(channel.Url, channel.Display_Name, in_player)
 	}
 }
 
@@ -279,31 +241,43 @@ func (app *OurTwitchNotifierMain) cancelDelayedUrlLoadsForContext(ctx string) {
 }
 
 func (app *OurTwitchNotifierMain) getChannelAndStreamForListEntry(isOnline bool, index int) (*ChannelInfo, *StreamInfo) {
-	var stream *StreamInfo = nil
-	var channel *ChannelInfo = nil
-
-	channelId := app.getChannelIdForListEntry(isOnline, index)
-	if channelId != nil {
-		stream = app.stream_by_channel_id[*channelId]
-		channel = app._channel_for_id(*channelId)
-		if channel == nil {
-			app.log(fmt.Sprintf("Channel entry not found for id %v", channelId))
-		}
+	entry := app.channels.entry_at(isOnline, index)
+	if entry == nil {
+		return nil, nil
 	}
-
-	return channel, stream
+	return entry.Channel, entry.Stream
 }
 
-func (app *OurTwitchNotifierMain) getChannelIdForListEntry(isOnline bool, index int) *ChannelID {
-	for channelId, curStatus := range app.channel_status_by_id {
-		if curStatus.idx == uint(index) && curStatus.online == isOnline {
-			return &channelId
+// Fill in the stream event log from the saved history, so it carries on from the last run
+func (app *OurTwitchNotifierMain) load_stream_history() {
+	history := app.getStreamHistory()
+	if history == nil {
+		return
+	}
+	events, err := history.events_since(time.Time{})
+	if err != nil {
+		app.log(fmt.Sprintf("Error reading the stream history: %s", err))
+		return
+	}
+	for _, event := range events {
+		var message string
+		if event.Online {
+			stream := &StreamInfo{Channel: &ChannelInfo{Status: event.Title}, Game: &event.Game}
+			message = app.create_online_event_message(event.Channel, stream)
+		} else {
+			message = app.create_offline_event_message(event.Channel)
 		}
+		app.stream_event_log(message, event.Channel_Id, event.Time)
 	}
-	return nil
 }
 
 func (app *OurTwitchNotifierMain) main_loop_main_window_timer() {
+	app.load_stream_history()
+
+	if app._auth_oauth != "" {
+		app.validate_auth()
+	}
+
 	//msg("need browser auth")
 	if app.need_browser_auth() {
 		msg("do browser auth")
@@ -318,9 +292,9 @@ func (app *OurTwitchNotifierMain) do_browser_auth() {
 	//debug := app.options.debug_output == nil || *app.options.debug_output
 	debug := true
 
-	scopes := getNeededTwitchScopes()
+	scopes := app.getAPIBackend().needed_scopes()
 
-	doBrowserAuth(app._auth_complete_callback, scopes, debug)
+	doBrowserAuth(app.krakenInstance.authURL, app._auth_complete_callback, scopes, debug)
 }
 
 func (app *OurTwitchNotifierMain) _auth_complete_callback(token string) {
@@ -328,50 +302,102 @@ func (app *OurTwitchNotifierMain) _auth_complete_callback(token string) {
 
 	app._auth_oauth = token
 
+	tokenFilename := getTokenFilename()
+	err := saveTokenFile(tokenFilename, token)
+	if err != nil {
+		app.log(fmt.Sprintf("Error saving OAuth token to '%s': %s", tokenFilename, err))
+	}
+	app._auth_oauth_from_file = err == nil
+
 	app.main_loop_main_window_timer_with_auth()
 }
 
+/** Check the OAuth token with twitch, and forget it if it's no good so we'll login again
+ */
+func (app *OurTwitchNotifierMain) validate_auth() {
+	validation, err := app.validateToken(app._auth_oauth)
+	if isAuthError(err) {
+		app.log("The OAuth token has expired or been revoked")
+		app.forget_auth()
+	} else if err != nil {
+		// we can't tell either way, so just try using it
+		app.log(fmt.Sprintf("Error validating the OAuth token: %s", err))
+	} else {
+		app.log(fmt.Sprintf("OAuth token for %s is valid for %v s", validation.Login, validation.Expires_In))
+	}
+}
+
+// Stop using the OAuth token, and delete it from the token file if that's where it came from; a token
+// from -auth-oauth or the config file leaves the saved one alone
+func (app *OurTwitchNotifierMain) forget_auth() {
+	app._auth_oauth = ""
+	if !app._auth_oauth_from_file {
+		return
+	}
+	app._auth_oauth_from_file = false
+
+	tokenFilename := getTokenFilename()
+	err := deleteTokenFile(tokenFilename)
+	if err != nil {
+		app.log(fmt.Sprintf("Error deleting OAuth token file '%s': %s", tokenFilename, err))
+	}
+}
+
 /** Do a poll of the API and set up a timer to get us to the next poll
  */
 func (app *OurTwitchNotifierMain) set_next_time() {
 	msg("doing iterator call")
 	next_wait := app.main_loop_iter.next()
 	app.log(next_wait.reason)
+
+	if app.need_reauth {
+		app.need_reauth = false
+		app.forget_auth()
+		if app.need_browser_auth() {
+			// this gets us a new ChannelWatcher once the login is done
+			app.do_browser_auth()
+			return
+		}
+		app.log("Not logging in again because of -no-browser-auth")
+	}
+
 	app.window_impl.set_timer_with_callback(next_wait.length, app.set_next_time)
 }
 
+// Check the config file for changes every so often, and apply them right away
+func (app *OurTwitchNotifierMain) watch_config() {
+	if app.check_config() {
+		app.doChannelsReload()
+	}
+	app.window_impl.set_timeout(CONFIG_CHECK_INTERVAL, app.watch_config)
+}
+
+// Check every so often whether the user is back, rather than waiting for the next poll
+func (app *OurTwitchNotifierMain) watch_idle() {
+	app.check_idle()
+	app.window_impl.set_timeout(IDLE_CHECK_INTERVAL, app.watch_idle)
+}
+
 func (app *OurTwitchNotifierMain) main_loop_main_window_timer_with_auth() {
 	msg("creating channel watcher")
 	app.main_loop_iter = app.NewChannelWatcher()
 	app.set_next_time()
 }
 
-func (app *OurTwitchNotifierMain) _channel_for_id(channel_id ChannelID) *ChannelInfo {
-	for _, channel := range app.followed_channel_entries {
-		if channel.Id == channel_id {
-			return channel
-		}
-	}
-	return nil
+// The channels that are online now, for the stats
+func (app *OurTwitchNotifierMain) live_channels() map[ChannelID]bool {
+	return app.channels.online_channel_ids()
 }
 
-func (app *OurTwitchNotifierMain) _store_updated_channel_info(updatedChannel *ChannelInfo) {
-	if updatedChannel == nil {
-		return
-	}
-	for i, existingChannel := range app.followed_channel_entries {
-		if existingChannel.Id == updatedChannel.Id {
-			app.followed_channel_entries[i] = updatedChannel
-			break
-		}
-	}
+func (app *OurTwitchNotifierMain) _channel_for_id(channel_id ChannelID) *ChannelInfo {
+	return app.channels.channel(channel_id)
 }
 
-func (app *OurTwitchNotifierMain) _list_for_is_online(online bool) wx.ListBox {
+func (app *OurTwitchNotifierMain) _list_for_is_online(online bool) ChannelListControl {
 	if online {
-		return app.window_impl.list_online
+		return app.window_impl.online_list
 	} else {
-		return app.window_impl.list_offline
+		return app.window_impl.offline_list
 	}
 }
 
@@ -400,10 +426,19 @@ func (app *OurTwitchNotifierMain) getUrlForListEntry(isOnline bool, index int) (
 }
 
 func (app *OurTwitchNotifierMain) openSiteForListEntryIndex(isOnline bool, index int) {
+	app.openListEntryWith(isOnline, index, app.open_with_player_by_default())
+}
+
+// Open a list entry's stream in the player, or the browser
+func (app *OurTwitchNotifierMain) openListEntryWith(isOnline bool, index int, in_player bool) {
 	url, found := app.getUrlForListEntry(isOnline, index)
 
 	if found {
-		webbrowser_open(url)
+		channel_name := ""
+		if channel, _ := app.getChannelAndStreamForListEntry(isOnline, index); channel != nil {
+			channel_name = channel.Display_Name
+		}
+		app.open_stream(url, channel_name, in_player)
 	}
 }
 
@@ -427,19 +462,15 @@ func (app *OurTwitchNotifierMain) doDelayedUrlLoad(ctx string, url string, callb
 	})
 }
 
-func (app *OurTwitchNotifierMain) reset_lists() {
-	msg("resetting lists")
-	app.window_impl.list_online.Clear()
-	app.window_impl.list_offline.Clear()
-	app.channel_status_by_id = make(map[ChannelID]*ChannelStatus)
-
-	for i, channel := range app.followed_channel_entries {
-		app.window_impl.list_offline.Append(app.channel_display_name(channel))
-		channel_id := channel.Id
-		app.channel_status_by_id[channel_id] = &ChannelStatus{false, uint(i)}
-	}
-	msg("done resetting lists")
+// Narrow the channel lists down to the channels matching filter; "" shows them all
+func (app *OurTwitchNotifierMain) set_channel_filter(filter string) {
+	app.channels.set_filter(channelFilterWords(filter))
+}
 
+// Sort the online list a different way
+func (app *OurTwitchNotifierMain) set_online_sort(order OnlineListSort) {
+	app.channels.set_online_sort(order)
+	app.window_impl.online_list.show_sort(order)
 }
 
 func (app *OurTwitchNotifierMain) _init_notifier() {
@@ -451,261 +482,8 @@ func (app *OurTwitchNotifierMain) _notifier_fini() {
 	// pass
 }
 
-type ChannelWatcher struct {
-	app               *OurTwitchNotifierMain
-	channels_followed map[ChannelID]bool
-	channel_info      map[ChannelID]*ChannelInfo
-	last_streams      map[ChannelID]StreamID
-
-	channels_followed_names []string
-	channel_load_retries int
-}
-
 func (app *OurTwitchNotifierMain) NewChannelWatcher() *ChannelWatcher {
 	msg("init notifier")
 	app._init_notifier()
-	watcher := &ChannelWatcher{}
-	watcher.app = app
-	watcher.channels_followed = make(map[ChannelID]bool)
-	watcher.channel_info = make(map[ChannelID]*ChannelInfo)
-	watcher.last_streams = make(map[ChannelID]StreamID)
-	watcher.channel_load_retries = 0
-	return watcher
-}
-
-func (watcher *ChannelWatcher) checkFollowsRequestError(err error, context string) *WaitItem {
-	assert(err == nil || watcher.channel_load_retries < 10, "follows %s error: %s; %v retries failed", context, err, watcher.channel_load_retries)
-	if err != nil {
-		watcher.channel_load_retries += 1
-		msg("follows %s error: %s; retry %v", context, err, watcher.channel_load_retries)
-		// we can't really do much with follows in a bad state... we need a quick retry
-		// we haven't cleared the flag for a channel reload yet, so just go around
-		return &WaitItem{10 * time.Second, "retrying followed channels list"}
-	}
-	return nil
-}
-
-func (watcher *ChannelWatcher) next() WaitItem {
-	/* This method does one API poll, potentially loading the user's list of followed channels
-	   first, makes the calls to stuff in watcher.app to update followed stream details, and then
-	   returns a token with info about the pause before the next poll so the caller can
-	   sleep and/or schedule the next call
-	*/
-
-	// check if it's time to do a channel reload
-	curTime := time.Now()
-	elapsedSinceLastRefresh := curTime.Sub(watcher.app.lastReloadTime)
-	msg("%0.2f seconds since last refresh", elapsedSinceLastRefresh.Seconds())
-	app := watcher.app
-
-	var reloadTimeInterval time.Duration
-	if app.options.reload_time_interval_mins != nil {
-		reloadTimeInterval = time.Duration(*app.options.reload_time_interval_mins) * time.Minute
-	} else {
-		reloadTimeInterval = 10 * time.Minute
-	}
-	msg("%0.2f seconds between autorefreshes", reloadTimeInterval.Seconds())
-	if elapsedSinceLastRefresh >= reloadTimeInterval {
-		app.need_channels_refresh = true
-		msg("doing scheduled refresh")
-	}
-
-	// do channel reload if necessary
-	if app.need_channels_refresh {
-		msg("doing a refresh")
-		watcher.channels_followed = make(map[ChannelID]bool)
-		watcher.channel_info = make(map[ChannelID]*ChannelInfo)
-		watcher.channels_followed_names = []string{}
-
-		// first time querying
-
-		if app._auth_oauth != "" {
-			var authToken string = strings.TrimSpace(app._auth_oauth)
-
-			tmiOauthPrefix := "oauth:"
-			if strings.HasPrefix(authToken, tmiOauthPrefix) {
-				authToken = authToken[len(tmiOauthPrefix):]
-			}
-
-			authorization := "OAuth " + authToken
-			app.krakenInstance.addHeader("Authorization", authorization)
-
-			// FIXME set fast query mode (support for slow query later)
-
-			if app.options.username == nil || *app.options.username == "" {
-				var root_response struct {
-					Token struct {
-						User_Name string
-					}
-				}
-				//app.diag_request()
-				msg("before kraken call for username")
-				err := app.krakenInstance.kraken(&root_response)
-				msg("after kraken call for username")
-				assert(err == nil, "root request error: %s", err)
-				assert(root_response.Token.User_Name != "", "got empty username from root request")
-				app.options.username = &root_response.Token.User_Name
-
-			}
-		}
-
-		notificationsDisabledFor := []string{}
-
-		// twitch.api.v3.follows.by_user
-		type FollowEntry struct {
-			Channel       *ChannelInfo
-			Notifications bool
-		}
-
-		assert(app.options.username != nil, "username was nil during request")
-		assert(*app.options.username != "", "username was empty during request")
-
-		msg("got username")
-
-		msg("before paged kraken call for follows by user response")
-		resultsListKey := "follows"
-
-		pager, err := app.krakenInstance.PagedKraken(resultsListKey, app.queryPageSize, nil,
-			"users", *app.options.username, "follows",
-			"channels")
-		msg("after paged kraken call for follows by user response")
-		if ret := watcher.checkFollowsRequestError(err, "pager"); ret != nil {
-			return *ret
-		}
-		for pager.More() {
-			var follow FollowEntry
-			err = pager.Next(&follow)
-			if ret := watcher.checkFollowsRequestError(err, "get request"); ret != nil {
-				return *ret
-			}
-
-			channel := follow.Channel
-			channel_id := channel.Id
-			channel_name := channel.Display_Name
-			msg("processing channel follow for %s", channel_name)
-			notifications_enabled := follow.Notifications
-			if (app.options.all != nil && *app.options.all) || notifications_enabled {
-				watcher.channels_followed[channel_id] = true
-				watcher.channels_followed_names = append(watcher.channels_followed_names, channel_name)
-				watcher.channel_info[channel_id] = channel
-				watcher.app.follow_notification[channel_id] = notifications_enabled
-			} else {
-				notificationsDisabledFor = append(notificationsDisabledFor, channel_name)
-			}
-		}
-
-		msg("processing followed channels")
-
-		followed_channel_entries := ChannelSlice{}
-
-		for channel_id, present := range watcher.channels_followed {
-			if !present {
-				continue
-			}
-			followed_channel_entries = append(followed_channel_entries, watcher.channel_info[channel_id])
-		}
-
-		msg("sorting")
-
-		sort.Sort(followed_channel_entries)
-
-		msg("init channel display")
-
-		app.getEventsInterface().init_channel_display(followed_channel_entries)
-
-		msg("channels reload complete")
-
-		watcher.app.lastReloadTime = curTime
-		app.need_channels_refresh = false
-		watcher.channel_load_retries = 0
-
-		app.getEventsInterface()._channels_reload_complete()
-	} // done channels refresh
-
-	// regular status change checks time
-	log.Println("STUB: lock and idle check implementation")
-
-	// FIXME just fast query implemented for now
-	channel_stream_iterator, streamsError := app.get_streams_channels_following(watcher.channels_followed)
-
-	if streamsError != nil {
-		app.getEventsInterface().log(fmt.Sprintf("Error during update streams follows request: %s", streamsError))
-		app.getEventsInterface().log("Processing any partial update and waiting until the next request time")
-	} else {
-		app.assume_all_streams_offline()
-	}
-
-	for channel_id, channel_stream := range channel_stream_iterator {
-		var channel *ChannelInfo = channel_stream.channel
-		var stream *StreamInfo = channel_stream.stream
-		assert(channel != nil, "channel_stream had no channel")
-		channel_name := channel.Display_Name
-
-		// update channel info from the channel object in this stream
-		watcher.channel_info[channel_id] = channel
-
-		stream_we_consider_online := stream != nil && !stream.Is_playlist
-
-		app.getEventsInterface().stream_state_change(channel_id, stream_we_consider_online, stream)
-
-		if stream_we_consider_online {
-			stream_id := stream.Id
-			val, ok := watcher.last_streams[channel_id]
-			//msg("stream fetch output: %v, %v", uint64(val), ok)
-			if !ok || val != stream_id {
-				// stream was previously offline or was a different stream id
-				ok, notifications_enabled := app.follow_notification[channel_id]
-				if ok && notifications_enabled {
-					app.notify_for_stream(channel_name, stream)
-				}
-			}
-			watcher.last_streams[channel_id] = stream_id
-		} else {
-			//msg("channel %s is offline", channel_name)
-			if stream == nil {
-
-				app.getEventsInterface().log(fmt.Sprintf("channel_id %v had stream null", channel_id))
-			} else {
-				app.getEventsInterface().log(fmt.Sprintf("channel_id %v is_playlist %v", channel_id, stream.Is_playlist))
-			}
-			_, ok := watcher.last_streams[channel_id]
-			if ok {
-				// was previously online
-				delete(watcher.last_streams, channel_id)
-			}
-		}
-
-	}
-
-	app.getEventsInterface().done_state_changes()
-
-	var sleep_until_next_poll_s int
-	if app.options.poll == nil {
-		sleep_until_next_poll_s = 60
-	} else {
-		sleep_until_next_poll_s = *app.options.poll
-	}
-
-	if sleep_until_next_poll_s < 60 {
-		sleep_until_next_poll_s = 60
-	}
-	reason := fmt.Sprintf("Waiting %v s for next poll", sleep_until_next_poll_s)
-	return WaitItem{time.Duration(sleep_until_next_poll_s) * time.Second, reason}
-
-}
-
-// SORTABLE LIST OF CHANNELS
-
-type ChannelSlice []*ChannelInfo
-
-func (l ChannelSlice) Len() int {
-	return len(l)
-}
-
-func (l ChannelSlice) Less(i, j int) bool {
-	return strings.ToLower(l[i].Display_Name) < strings.ToLower(l[j].Display_Name)
-}
-
-func (l ChannelSlice) Swap(i, j int) {
-	l[i], l[j] = l[j], l[i]
+	return app.TwitchNotifierMain.NewChannelWatcher()
 }
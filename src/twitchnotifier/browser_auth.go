@@ -1,3 +1,5 @@
+// +build !headless
+
 package main
 
 /**
@@ -88,7 +90,7 @@ func (browserDialog *BrowserAuthDialog) onNewURLOpen(urlToParse string) {
 This runs the browser auth in a standalone wx.App, shutting it down and running the given
  callback when the auth is done.
 */
-func doBrowserAuth(tokenCallback func(string), scopes []string, debug bool) {
+func doBrowserAuth(baseAuthURL string, tokenCallback func(string), scopes []string, debug bool) {
 	msg("init browser dialog")
 	dialog := InitBrowserAuthDialog(debug)
 
@@ -119,7 +121,7 @@ func doBrowserAuth(tokenCallback func(string), scopes []string, debug bool) {
 	redirectURI := "notifier://main"
 
 	msg("getting auth url")
-	authURL := getAuthURL(CLIENT_ID, redirectURI, scopes, nil)
+	authURL := getAuthURL(baseAuthURL, CLIENT_ID, redirectURI, scopes, nil)
 	msg("loading auth url %s", authURL)
 
 	dialog.browser.LoadURL(authURL)
@@ -139,8 +141,7 @@ func doBrowserAuth(tokenCallback func(string), scopes []string, debug bool) {
 	}
 }
 
-func getAuthURL(clientId string, redirectURI string, scopes []string, state *string) string {
-	baseURL := "https://api.twitch.tv/kraken/oauth2/authorize"
+func getAuthURL(baseURL string, clientId string, redirectURI string, scopes []string, state *string) string {
 
 	params := make(url.Values)
 	params.Add("response_type", "token")
@@ -1,3 +1,5 @@
+// +build !headless
+
 package main
 
 import (
@@ -122,8 +124,8 @@ func guiTestExactLastPage(t *testing.T, frame *MainStatusWindowImpl, testDoneCal
 	frame.main_obj.log(next_wait.reason)
 
 	msg("checks")
-	streams_online := frame.list_online.GetCount()
-	streams_offline := frame.list_offline.GetCount()
+	streams_online := frame.online_list.count()
+	streams_offline := frame.offline_list.count()
 	assertEqual(1, streams_online, "streams online")
 	assertEqual(0, streams_offline, "streams offline")
 
@@ -184,8 +186,8 @@ func guiTestStreamsGoOffline(t *testing.T, frame *MainStatusWindowImpl, testDone
 	frame.main_obj.log(next_wait.reason)
 
 	msg("checks")
-	streams_online := frame.list_online.GetCount()
-	streams_offline := frame.list_offline.GetCount()
+	streams_online := frame.online_list.count()
+	streams_offline := frame.offline_list.count()
 	assertEqual(1, streams_online, "streams online")
 	assertEqual(0, streams_offline, "streams offline")
 
@@ -200,8 +202,8 @@ func guiTestStreamsGoOffline(t *testing.T, frame *MainStatusWindowImpl, testDone
 	frame.main_obj.log(next_wait.reason)
 
 	msg("checks")
-	streams_online = frame.list_online.GetCount()
-	streams_offline = frame.list_offline.GetCount()
+	streams_online = frame.online_list.count()
+	streams_offline = frame.offline_list.count()
 	assertEqual(0, streams_online, "streams online")
 	assertEqual(1, streams_offline, "streams offline")
 
@@ -266,8 +268,8 @@ func guiTestChannelTextUpdatesFromStreamUpdate(t *testing.T, frame *MainStatusWi
 	frame.main_obj.log(next_wait.reason)
 
 	msg("basic checks")
-	streams_online := frame.list_online.GetCount()
-	streams_offline := frame.list_offline.GetCount()
+	streams_online := frame.online_list.count()
+	streams_offline := frame.offline_list.count()
 	assertEqual(1, streams_online, "streams online")
 	assertEqual(0, streams_offline, "streams offline")
 
@@ -278,7 +280,7 @@ func guiTestChannelTextUpdatesFromStreamUpdate(t *testing.T, frame *MainStatusWi
 	msg("wait update (1)")
 	frame.set_timeout(delay_amount, func() {
 		msg("select the list item for the stream")
-		frame.list_online.SetSelection(0)
+		frame.online_list.set_selection(0)
 		// in wx, setting the selection programmatically doesn't trigger the event handler for a selection change,
 		// so we'll just call the event handler directly here
 		frame._on_list_gen_int(0, true)
@@ -0,0 +1,43 @@
+package main
+
+/**
+The filter for the online and offline channel lists. Each word of the filter has to be in the
+channel's name, its game, or its title, ignoring case, so "speed mario" finds a channel speedrunning
+a Mario game.
+*/
+
+import (
+	"strings"
+)
+
+// The words of a filter, lower-cased; none if the filter is blank
+func channelFilterWords(filter string) []string {
+	return strings.Fields(strings.ToLower(filter))
+}
+
+// Whether a channel passes a filter. stream is nil for offline channels.
+func channelMatchesFilter(words []string, channel *ChannelInfo, stream *StreamInfo) bool {
+	if len(words) == 0 {
+		return true
+	}
+	fields := []string{channel.Display_Name, channel.Status}
+	// the channel's own URL ends with its login, which can differ from the display name
+	if slash := strings.LastIndex(channel.Url, "/"); slash >= 0 {
+		fields = append(fields, channel.Url[slash+1:])
+	}
+	if stream != nil {
+		if stream.Game != nil {
+			fields = append(fields, *stream.Game)
+		}
+		if stream.Channel != nil {
+			fields = append(fields, stream.Channel.Status)
+		}
+	}
+	text := strings.ToLower(strings.Join(fields, "\n"))
+	for _, word := range words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+// TESTS
+
+func TestChannelMatchesFilter(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	game := "Super Mario 64"
+	channel := &ChannelInfo{Id: 1, Display_Name: "FakeChannel", Url: "https://www.twitch.tv/fake_channel_login", Status: "Any% Speedrun attempts"}
+	stream := &StreamInfo{Channel: channel, Game: &game}
+
+	cases := []struct {
+		filter   string
+		stream   *StreamInfo
+		expected bool
+	}{
+		{"", nil, true},
+		{"   ", stream, true},
+		{"fakechan", nil, true},
+		{"FAKE_CHANNEL_LOGIN", nil, true},
+		{"speedrun", nil, true},
+		{"mario", stream, true},
+		{"speed mario", stream, true},
+		// offline channels have no game
+		{"mario", nil, false},
+		{"speed zelda", stream, false},
+		{"otherchannel", stream, false},
+	}
+	for _, c := range cases {
+		actual := channelMatchesFilter(channelFilterWords(c.filter), channel, c.stream)
+		if ctx.assert(actual == c.expected, "filter '%s' (online %v): expected %v but got %v", c.filter, c.stream != nil, c.expected, actual) {
+			return
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// +build !headless
+
+package main
+
+/**
+A wx.Dialog listing a channel's past streams from the stream history, newest first, from its
+context menu
+*/
+
+import (
+	"fmt"
+
+	"github.com/rakslice/wxGo/wx"
+)
+
+var channelHistoryHeadings = []string{"Started", "Length", "Game", "Title"}
+
+type ChannelHistoryDialog struct {
+	wx.Dialog
+	sizer wx.BoxSizer
+
+	list_sessions wx.ListCtrl
+}
+
+func InitChannelHistoryDialog(parent wx.Window, channel *ChannelInfo, sessions []*StreamSession) *ChannelHistoryDialog {
+	out := &ChannelHistoryDialog{}
+	out.Dialog = wx.NewDialog(parent, wx.ID_ANY, fmt.Sprintf("Stream history for %s", channel.Display_Name),
+		wx.DefaultPosition, wx.DefaultSize, wx.DEFAULT_DIALOG_STYLE|wx.RESIZE_BORDER)
+	out.sizer = wx.NewBoxSizer(wx.VERTICAL)
+
+	summary := fmt.Sprintf("%v streams", len(sessions))
+	if len(sessions) == 0 {
+		summary = fmt.Sprintf("No streams by %s in the history yet", channel.Display_Name)
+	}
+	out.sizer.Add(wx.NewStaticText(out, wx.ID_ANY, summary), 0, wx.LEFT|wx.RIGHT|wx.TOP, 10)
+
+	out.list_sessions = wx.NewListCtrl(out, wx.ID_ANY, wx.DefaultPosition, wx.NewSize(700, 300), wx.LC_REPORT|wx.LC_SINGLE_SEL|wx.SUNKEN_BORDER)
+	widths := []int{150, 80, 180, 280}
+	for column, heading := range channelHistoryHeadings {
+		out.list_sessions.InsertColumn(column, heading, wx.LIST_FORMAT_LEFT, widths[column])
+	}
+	for row, session := range sessions {
+		out.list_sessions.InsertItem(row, session.Start.Local().Format("Mon Jan 2 2006 15:04"), -1)
+		// we don't know how long streams that ended while the app wasn't running went
+		if !session.End.IsZero() {
+			out.list_sessions.SetItem(row, 1, time_desc(session.length()))
+		}
+		out.list_sessions.SetItem(row, 2, session.Game)
+		out.list_sessions.SetItem(row, 3, session.Title)
+	}
+	out.sizer.Add(out.list_sessions, 1, wx.ALL|wx.EXPAND, 10)
+
+	buttons := out.CreateStdDialogButtonSizer(wx.OK)
+	out.sizer.Add(buttons, 0, wx.LEFT|wx.RIGHT|wx.BOTTOM|wx.EXPAND, 10)
+
+	out.SetSizerAndFit(out.sizer)
+	return out
+}
+
+// Show the channel's past streams from the stream history
+func (win *MainStatusWindowImpl) showChannelHistory(channel *ChannelInfo) {
+	sessions, err := win.main_obj.stream_sessions()
+	if err != nil {
+		wx.MessageBox(fmt.Sprintf("Can't show the history for %s: %s", channel.Display_Name, err), "twitch-notifier", wx.OK|wx.ICON_WARNING)
+		return
+	}
+	dialog := InitChannelHistoryDialog(win, channel, channelSessions(sessions, channel.Id))
+	dialog.ShowModal()
+	dialog.Destroy()
+}
@@ -0,0 +1,303 @@
+// +build !headless
+
+package main
+
+/**
+The online and offline channel lists. The offline list is a plain list box of names; the online list
+is a report view with the viewers, game and uptime of each stream and a preview thumbnail, sortable
+by clicking the column headings. ChannelListsView keeps both in step with the app's ChannelListModel
+through ChannelListControl.
+*/
+
+import (
+	"fmt"
+	"github.com/rakslice/wxGo/wx"
+	"net/http"
+	"os"
+	"time"
+)
+
+const ONLINE_THUMBNAIL_WIDTH = 80
+const ONLINE_THUMBNAIL_HEIGHT = 45
+
+// twitch makes a new preview image every few minutes, so there's no use getting it more often than this
+const ONLINE_THUMBNAIL_REFRESH_INTERVAL = 5 * time.Minute
+
+// What the app needs from a channel list, whichever kind of control it is
+type ChannelListControl interface {
+	count() uint
+	// the selected entry, or -1 if there isn't one
+	selection() int
+	// select an entry, or clear the selection with -1, without sending a selection event
+	set_selection(index int)
+	insert_entry(index uint, channel *ChannelInfo, stream *StreamInfo)
+	// bring an entry up to date with the latest stream info
+	update_entry(index uint, channel *ChannelInfo, stream *StreamInfo)
+	delete_entry(index uint)
+	clear_entries()
+}
+
+// VIEW
+
+// Shows the app's channel list model in the window's online and offline lists
+type ChannelListsView struct {
+	win *MainStatusWindowImpl
+}
+
+func NewChannelListsView(win *MainStatusWindowImpl) *ChannelListsView {
+	return &ChannelListsView{win}
+}
+
+func (view *ChannelListsView) channel_list_reset(online bool) {
+	model := view.win.main_obj.channels
+	list := view.win.main_obj._list_for_is_online(online)
+	list.clear_entries()
+	for index := 0; index < model.count(online); index++ {
+		entry := model.entry_at(online, index)
+		list.insert_entry(uint(index), entry.Channel, entry.Stream)
+	}
+}
+
+func (view *ChannelListsView) channel_inserted(online bool, index int) {
+	entry := view.win.main_obj.channels.entry_at(online, index)
+	view.win.main_obj._list_for_is_online(online).insert_entry(uint(index), entry.Channel, entry.Stream)
+}
+
+func (view *ChannelListsView) channel_removed(online bool, index int) {
+	view.win.main_obj._list_for_is_online(online).delete_entry(uint(index))
+}
+
+func (view *ChannelListsView) channel_updated(online bool, index int) {
+	entry := view.win.main_obj.channels.entry_at(online, index)
+	view.win.main_obj._list_for_is_online(online).update_entry(uint(index), entry.Channel, entry.Stream)
+}
+
+// Put the selection back on the selected channel, wherever it's moved to
+func (view *ChannelListsView) channel_lists_changed(selection_hidden bool) {
+	model := view.win.main_obj.channels
+	selected_online, selected_index := false, -1
+	if entry := model.selected_entry(); entry != nil {
+		online, index, shown := model.position(entry.Channel.Id)
+		if shown {
+			selected_online, selected_index = online, index
+		}
+	}
+	for _, online := range []bool{true, false} {
+		index := -1
+		if online == selected_online {
+			index = selected_index
+		}
+		list := view.win.main_obj._list_for_is_online(online)
+		if list.selection() != index {
+			list.set_selection(index)
+		}
+	}
+	if selection_hidden {
+		view.win._on_list_gen_int(-1, true)
+	}
+}
+
+// OFFLINE LIST
+
+type OfflineChannelList struct {
+	win  *MainStatusWindowImpl
+	list wx.ListBox
+}
+
+func NewOfflineChannelList(win *MainStatusWindowImpl, list wx.ListBox) *OfflineChannelList {
+	return &OfflineChannelList{win, list}
+}
+
+func (list *OfflineChannelList) count() uint {
+	return list.list.GetCount()
+}
+
+func (list *OfflineChannelList) selection() int {
+	return list.list.GetSelection()
+}
+
+func (list *OfflineChannelList) set_selection(index int) {
+	list.list.SetSelection(index)
+}
+
+func (list *OfflineChannelList) insert_entry(index uint, channel *ChannelInfo, stream *StreamInfo) {
+	list.list.Insert(list.win.main_obj.channel_display_name(channel), index)
+}
+
+func (list *OfflineChannelList) update_entry(index uint, channel *ChannelInfo, stream *StreamInfo) {
+	list.list.SetString(index, list.win.main_obj.channel_display_name(channel))
+}
+
+func (list *OfflineChannelList) delete_entry(index uint) {
+	list.list.Delete(index)
+}
+
+func (list *OfflineChannelList) clear_entries() {
+	list.list.Clear()
+}
+
+// ONLINE LIST
+
+// The preview image we have for a channel's stream
+type onlineThumbnail struct {
+	stream StreamID
+	// position in the image list; -1 until the image has loaded
+	image   int
+	fetched time.Time
+}
+
+type OnlineChannelList struct {
+	win        *MainStatusWindowImpl
+	list       wx.ListCtrl
+	images     wx.ImageList
+	thumbnails map[ChannelID]*onlineThumbnail
+	// the channel in each row, to find where a thumbnail goes when it arrives
+	row_channels []ChannelID
+	// true while we're changing the selection ourselves, so the selection event can be ignored
+	selecting bool
+}
+
+func NewOnlineChannelList(win *MainStatusWindowImpl, list wx.ListCtrl) *OnlineChannelList {
+	out := &OnlineChannelList{win: win, list: list}
+	out.thumbnails = make(map[ChannelID]*onlineThumbnail)
+	out.images = wx.NewImageList(ONLINE_THUMBNAIL_WIDTH, ONLINE_THUMBNAIL_HEIGHT, false, 0)
+	list.SetImageList(out.images, wx.IMAGE_LIST_SMALL)
+
+	widths := [ONLINE_COLUMN_COUNT]int{ONLINE_THUMBNAIL_WIDTH + 150, 70, 200, 90}
+	for column := OnlineColumn(0); column < ONLINE_COLUMN_COUNT; column++ {
+		format := wx.LIST_FORMAT_LEFT
+		if column == ONLINE_COLUMN_VIEWERS {
+			format = wx.LIST_FORMAT_RIGHT
+		}
+		list.InsertColumn(int(column), OnlineListSort{}.heading(column), format, widths[column])
+	}
+	return out
+}
+
+// Put the sort arrow on the heading of the column the list is sorted by
+func (list *OnlineChannelList) show_sort(order OnlineListSort) {
+	for column := OnlineColumn(0); column < ONLINE_COLUMN_COUNT; column++ {
+		item := wx.NewListItem()
+		item.SetMask(wx.LIST_MASK_TEXT)
+		item.SetText(order.heading(column))
+		list.list.SetColumn(int(column), item)
+	}
+}
+
+func (list *OnlineChannelList) count() uint {
+	return uint(list.list.GetItemCount())
+}
+
+func (list *OnlineChannelList) selection() int {
+	return list.list.GetNextItem(-1, wx.LIST_NEXT_ALL, wx.LIST_STATE_SELECTED)
+}
+
+func (list *OnlineChannelList) set_selection(index int) {
+	list.selecting = true
+	defer func() { list.selecting = false }()
+
+	if index < 0 {
+		if selected := list.selection(); selected >= 0 {
+			list.list.SetItemState(selected, 0, wx.LIST_STATE_SELECTED|wx.LIST_STATE_FOCUSED)
+		}
+		return
+	}
+	list.list.SetItemState(index, wx.LIST_STATE_SELECTED|wx.LIST_STATE_FOCUSED, wx.LIST_STATE_SELECTED|wx.LIST_STATE_FOCUSED)
+	list.list.EnsureVisible(index)
+}
+
+func (list *OnlineChannelList) insert_entry(index uint, channel *ChannelInfo, stream *StreamInfo) {
+	list.list.InsertItem(int(index), "", -1)
+	list.row_channels = InsertChannelID(list.row_channels, int(index), channel.Id)
+	list.update_entry(index, channel, stream)
+}
+
+func (list *OnlineChannelList) update_entry(index uint, channel *ChannelInfo, stream *StreamInfo) {
+	texts := onlineColumnTexts(list.win.main_obj.channel_display_name(channel), stream, time.Now())
+	for column, text := range texts {
+		list.list.SetItem(int(index), column, text)
+	}
+	list.row_channels[index] = channel.Id
+	list.list.SetItemImage(int(index), list.thumbnail_image(channel.Id, stream))
+}
+
+func (list *OnlineChannelList) delete_entry(index uint) {
+	list.list.DeleteItem(int(index))
+	list.row_channels = append(list.row_channels[:index], list.row_channels[index+1:]...)
+}
+
+func (list *OnlineChannelList) clear_entries() {
+	list.list.DeleteAllItems()
+	list.row_channels = nil
+}
+
+/**
+The image list position of a channel's thumbnail, or -1 if we don't have one yet. This starts
+fetching the stream's preview if we haven't got it, or if the one we have is getting old; the
+row's image gets set when it arrives.
+*/
+func (list *OnlineChannelList) thumbnail_image(channel_id ChannelID, stream *StreamInfo) int {
+	if stream == nil || stream.Preview.Small == "" {
+		return -1
+	}
+	thumbnail, ok := list.thumbnails[channel_id]
+	if !ok {
+		thumbnail = &onlineThumbnail{image: -1}
+		list.thumbnails[channel_id] = thumbnail
+	}
+	if thumbnail.stream != stream.Id || time.Since(thumbnail.fetched) >= ONLINE_THUMBNAIL_REFRESH_INTERVAL {
+		thumbnail.stream = stream.Id
+		thumbnail.fetched = time.Now()
+		list.fetch_thumbnail(channel_id, stream.Preview.Small)
+	}
+	return thumbnail.image
+}
+
+func (list *OnlineChannelList) fetch_thumbnail(channel_id ChannelID, url string) {
+	app := list.win.main_obj
+	app.doDelayedUrlLoad("thumbnails", url, func(rs *http.Response) {
+		if rs == nil {
+			return
+		}
+		defer rs.Body.Close()
+
+		if rs.StatusCode != 200 {
+			msg("Got HTTP error %v %s retrieving %s", rs.StatusCode, rs.Status, url)
+			return
+		}
+		tempfileName, err := readToTempFile(rs.Body)
+		if err != nil {
+			msg("Error saving thumbnail %s: %s", url, err)
+			return
+		}
+
+		// back to the main thread for the GUI changes
+		list.win.timeHelper.AfterFunc(0, func() {
+			defer os.Remove(tempfileName)
+			image := wx.NewImage(tempfileName)
+			if !image.IsOk() {
+				app.log(fmt.Sprintf("Couldn't read the thumbnail from %s", url))
+				return
+			}
+			list.set_thumbnail(channel_id, wx.NewBitmap(image.Scale(ONLINE_THUMBNAIL_WIDTH, ONLINE_THUMBNAIL_HEIGHT)))
+		})
+	})
+}
+
+func (list *OnlineChannelList) set_thumbnail(channel_id ChannelID, bitmap wx.Bitmap) {
+	thumbnail, ok := list.thumbnails[channel_id]
+	if !ok {
+		return
+	}
+	if thumbnail.image < 0 {
+		thumbnail.image = list.images.Add(bitmap)
+	} else {
+		list.images.Replace(thumbnail.image, bitmap)
+	}
+	for row, row_channel := range list.row_channels {
+		if row_channel == channel_id {
+			list.list.SetItemImage(row, thumbnail.image)
+			list.list.RefreshItem(row)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+/**
+The model behind the online and offline channel lists: every followed channel, which list it's in,
+and each list kept in order with just the channels that pass the filter. Views observe the model
+and are told which positions changed, so they never have to keep track of list positions
+themselves. Nothing here needs wx.
+
+A channel's place in its list is found by binary search, so the entries' sort keys only ever change
+inside the model, where an entry is taken out of its list before it changes and put back after.
+*/
+
+import (
+	"sort"
+	"strings"
+)
+
+type ChannelListEntry struct {
+	Channel *ChannelInfo
+	// the latest stream we've seen; nil once the channel is offline
+	Stream *StreamInfo
+	Online bool
+	// false if the filter is hiding the channel
+	Visible bool
+}
+
+/**
+Something that shows the lists. The positions in these are positions among the shown entries of
+one list, as they are after the change.
+*/
+type ChannelListObserver interface {
+	// the list has changed all over, e.g. for a new filter, so it should be filled in again
+	channel_list_reset(online bool)
+	channel_inserted(online bool, index int)
+	channel_removed(online bool, index int)
+	// the entry's stream info has changed but it's still in the same place
+	channel_updated(online bool, index int)
+	/**
+	Sent after each change to the model, once the others for it are done. selection_hidden is true
+	if the selected channel has just been hidden or dropped, which leaves no selection.
+	*/
+	channel_lists_changed(selection_hidden bool)
+}
+
+type ChannelListModel struct {
+	entries map[ChannelID]*ChannelListEntry
+	// the shown entries of the online and offline lists, in order
+	lists map[bool][]*ChannelListEntry
+	// the words of the filter; empty for no filter
+	filter      []string
+	online_sort OnlineListSort
+	selected    *ChannelListEntry
+	observers   []ChannelListObserver
+}
+
+func NewChannelListModel() *ChannelListModel {
+	out := &ChannelListModel{}
+	out.entries = make(map[ChannelID]*ChannelListEntry)
+	out.lists = map[bool][]*ChannelListEntry{true: nil, false: nil}
+	return out
+}
+
+func (model *ChannelListModel) add_observer(observer ChannelListObserver) {
+	model.observers = append(model.observers, observer)
+}
+
+func (model *ChannelListModel) notify(event func(observer ChannelListObserver)) {
+	for _, observer := range model.observers {
+		event(observer)
+	}
+}
+
+// ORDER
+
+type channelEntryOrder struct {
+	entries []*ChannelListEntry
+	less    func(a *ChannelListEntry, b *ChannelListEntry) bool
+}
+
+func (order channelEntryOrder) Len() int { return len(order.entries) }
+func (order channelEntryOrder) Swap(i, j int) {
+	order.entries[i], order.entries[j] = order.entries[j], order.entries[i]
+}
+func (order channelEntryOrder) Less(i, j int) bool {
+	return order.less(order.entries[i], order.entries[j])
+}
+
+// The order of the online or offline list. No two channels are equal, so each has exactly one place.
+func (model *ChannelListModel) less(online bool) func(a *ChannelListEntry, b *ChannelListEntry) bool {
+	if online {
+		order := model.online_sort
+		return func(a *ChannelListEntry, b *ChannelListEntry) bool {
+			return order.less(a.Channel, a.Stream, b.Channel, b.Stream)
+		}
+	}
+	return func(a *ChannelListEntry, b *ChannelListEntry) bool {
+		a_name := strings.ToLower(a.Channel.Display_Name)
+		b_name := strings.ToLower(b.Channel.Display_Name)
+		if a_name != b_name {
+			return a_name < b_name
+		}
+		return a.Channel.Id < b.Channel.Id
+	}
+}
+
+// Where an entry is or would go in its list, and whether it's there now
+func (model *ChannelListModel) search(entry *ChannelListEntry) (int, bool) {
+	list := model.lists[entry.Online]
+	less := model.less(entry.Online)
+	index := sort.Search(len(list), func(i int) bool { return !less(list[i], entry) })
+	return index, index < len(list) && list[index] == entry
+}
+
+// Lay a list out again from scratch
+func (model *ChannelListModel) rebuild(online bool) {
+	list := []*ChannelListEntry{}
+	for _, entry := range model.entries {
+		if entry.Online == online && entry.Visible {
+			list = append(list, entry)
+		}
+	}
+	sort.Sort(channelEntryOrder{list, model.less(online)})
+	model.lists[online] = list
+}
+
+// LOOKUPS
+
+func (model *ChannelListModel) entry(channel_id ChannelID) *ChannelListEntry {
+	return model.entries[channel_id]
+}
+
+// The channel with an id, or nil if we don't follow it
+func (model *ChannelListModel) channel(channel_id ChannelID) *ChannelInfo {
+	entry := model.entries[channel_id]
+	if entry == nil {
+		return nil
+	}
+	return entry.Channel
+}
+
+// The entry at a position in a list, or nil if there isn't one there
+func (model *ChannelListModel) entry_at(online bool, index int) *ChannelListEntry {
+	list := model.lists[online]
+	if index < 0 || index >= len(list) {
+		return nil
+	}
+	return list[index]
+}
+
+// How many channels a list is showing
+func (model *ChannelListModel) count(online bool) int {
+	return len(model.lists[online])
+}
+
+// Where a channel is shown: which list, and its position; false if it isn't shown
+func (model *ChannelListModel) position(channel_id ChannelID) (bool, int, bool) {
+	entry := model.entries[channel_id]
+	if entry == nil || !entry.Visible {
+		return false, 0, false
+	}
+	index, found := model.search(entry)
+	return entry.Online, index, found
+}
+
+// The channels that are online, shown or not
+func (model *ChannelListModel) online_channel_ids() map[ChannelID]bool {
+	out := make(map[ChannelID]bool)
+	for channel_id, entry := range model.entries {
+		if entry.Online {
+			out[channel_id] = true
+		}
+	}
+	return out
+}
+
+// SELECTION
+
+// Set the selected channel, which the views keep selected as it moves; nil for none
+func (model *ChannelListModel) select_entry(entry *ChannelListEntry) {
+	model.selected = entry
+}
+
+func (model *ChannelListModel) selected_entry() *ChannelListEntry {
+	return model.selected
+}
+
+// CHANGES
+
+/**
+Start over with a new set of channels, all offline until we hear otherwise. The selected channel
+stays selected if we still follow it.
+*/
+func (model *ChannelListModel) set_channels(channels []*ChannelInfo) {
+	model.entries = make(map[ChannelID]*ChannelListEntry)
+	for _, channel := range channels {
+		model.entries[channel.Id] = &ChannelListEntry{Channel: channel, Visible: channelMatchesFilter(model.filter, channel, nil)}
+	}
+	model.reset_lists()
+}
+
+// Narrow the lists down to the channels matching the words of a filter; none for every channel
+func (model *ChannelListModel) set_filter(words []string) {
+	model.filter = words
+	for _, entry := range model.entries {
+		entry.Visible = channelMatchesFilter(words, entry.Channel, entry.Stream)
+	}
+	model.reset_lists()
+}
+
+func (model *ChannelListModel) reset_lists() {
+	selection_hidden := false
+	if model.selected != nil {
+		// a new set of channels has a new entry for the selected one
+		entry := model.entries[model.selected.Channel.Id]
+		if entry == nil || !entry.Visible {
+			entry = nil
+			selection_hidden = true
+		}
+		model.selected = entry
+	}
+	for _, online := range []bool{true, false} {
+		model.rebuild(online)
+		model.notify(func(observer ChannelListObserver) { observer.channel_list_reset(online) })
+	}
+	model.notify(func(observer ChannelListObserver) { observer.channel_lists_changed(selection_hidden) })
+}
+
+func (model *ChannelListModel) set_online_sort(order OnlineListSort) {
+	model.online_sort = order
+	model.rebuild(true)
+	model.notify(func(observer ChannelListObserver) { observer.channel_list_reset(true) })
+	model.notify(func(observer ChannelListObserver) { observer.channel_lists_changed(false) })
+}
+
+/**
+Record a channel's stream as of the latest poll, moving it between or within the lists as needed.
+The stream's channel info replaces what we had, as it's newer. Returns false if we don't follow
+the channel.
+*/
+func (model *ChannelListModel) set_stream(channel_id ChannelID, online bool, stream *StreamInfo) bool {
+	entry := model.entries[channel_id]
+	if entry == nil {
+		return false
+	}
+	model.change(entry, func() {
+		entry.Online = online
+		entry.Stream = stream
+		if stream != nil && stream.Channel != nil {
+			entry.Channel = stream.Channel
+		}
+	})
+	return true
+}
+
+// Make a change to an entry, and put it where it now belongs
+func (model *ChannelListModel) change(entry *ChannelListEntry, change func()) {
+	old_online := entry.Online
+	old_index, was_shown := model.search(entry)
+	if was_shown {
+		list := model.lists[old_online]
+		model.lists[old_online] = append(list[:old_index], list[old_index+1:]...)
+	}
+
+	change()
+	entry.Visible = channelMatchesFilter(model.filter, entry.Channel, entry.Stream)
+
+	if entry.Visible {
+		new_index, _ := model.search(entry)
+		list := append(model.lists[entry.Online], nil)
+		copy(list[new_index+1:], list[new_index:])
+		list[new_index] = entry
+		model.lists[entry.Online] = list
+
+		if was_shown && old_online == entry.Online && old_index == new_index {
+			model.notify(func(observer ChannelListObserver) { observer.channel_updated(entry.Online, new_index) })
+		} else {
+			if was_shown {
+				model.notify(func(observer ChannelListObserver) { observer.channel_removed(old_online, old_index) })
+			}
+			model.notify(func(observer ChannelListObserver) { observer.channel_inserted(entry.Online, new_index) })
+		}
+	} else if was_shown {
+		model.notify(func(observer ChannelListObserver) { observer.channel_removed(old_online, old_index) })
+	}
+
+	selection_hidden := false
+	if entry == model.selected && !entry.Visible {
+		model.selected = nil
+		selection_hidden = true
+	}
+	model.notify(func(observer ChannelListObserver) { observer.channel_lists_changed(selection_hidden) })
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Keeps its own copy of the lists from the model's events, the way a view does
+type mirrorChannelObserver struct {
+	model  *ChannelListModel
+	lists  map[bool][]string
+	events []string
+	// the selection_hidden of each channel_lists_changed
+	hidden []bool
+}
+
+func newMirrorChannelObserver(model *ChannelListModel) *mirrorChannelObserver {
+	out := &mirrorChannelObserver{model: model, lists: map[bool][]string{true: nil, false: nil}}
+	model.add_observer(out)
+	return out
+}
+
+func mirrorListName(online bool) string {
+	if online {
+		return "online"
+	}
+	return "offline"
+}
+
+func (mirror *mirrorChannelObserver) channel_list_reset(online bool) {
+	mirror.events = append(mirror.events, "reset "+mirrorListName(online))
+	mirror.lists[online] = nil
+	for index := 0; index < mirror.model.count(online); index++ {
+		mirror.lists[online] = append(mirror.lists[online], mirror.model.entry_at(online, index).Channel.Display_Name)
+	}
+}
+
+func (mirror *mirrorChannelObserver) channel_inserted(online bool, index int) {
+	mirror.events = append(mirror.events, fmt.Sprintf("insert %s %v", mirrorListName(online), index))
+	list := append(mirror.lists[online], "")
+	copy(list[index+1:], list[index:])
+	list[index] = mirror.model.entry_at(online, index).Channel.Display_Name
+	mirror.lists[online] = list
+}
+
+func (mirror *mirrorChannelObserver) channel_removed(online bool, index int) {
+	mirror.events = append(mirror.events, fmt.Sprintf("remove %s %v", mirrorListName(online), index))
+	list := mirror.lists[online]
+	mirror.lists[online] = append(list[:index], list[index+1:]...)
+}
+
+func (mirror *mirrorChannelObserver) channel_updated(online bool, index int) {
+	mirror.events = append(mirror.events, fmt.Sprintf("update %s %v", mirrorListName(online), index))
+	mirror.lists[online][index] = mirror.model.entry_at(online, index).Channel.Display_Name
+}
+
+func (mirror *mirrorChannelObserver) channel_lists_changed(selection_hidden bool) {
+	mirror.hidden = append(mirror.hidden, selection_hidden)
+}
+
+// Take the events seen so far, and start over
+func (mirror *mirrorChannelObserver) take_events() string {
+	out := strings.Join(mirror.events, "; ")
+	mirror.events = nil
+	return out
+}
+
+func (mirror *mirrorChannelObserver) list(online bool) string {
+	return strings.Join(mirror.lists[online], ",")
+}
+
+func newModelTestChannels() []*ChannelInfo {
+	return []*ChannelInfo{
+		{Id: 1, Display_Name: "delta"},
+		{Id: 2, Display_Name: "Alpha"},
+		{Id: 3, Display_Name: "charlie"},
+		{Id: 4, Display_Name: "bravo"},
+	}
+}
+
+func newModelTestStream(channel *ChannelInfo, viewers int, game string) *StreamInfo {
+	return &StreamInfo{Channel: channel, Id: StreamID(channel.Id * 10), Viewers: viewers, Game: &game, Created_at: "2017-08-01T19:00:00Z"}
+}
+
+// TESTS
+
+func TestChannelModelStreamsMoveBetweenLists(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	model := NewChannelListModel()
+	mirror := newMirrorChannelObserver(model)
+	channels := newModelTestChannels()
+	model.set_channels(channels)
+	if ctx.assertStrEqual("reset online; reset offline", mirror.take_events(), "events for new channels") {
+		return
+	}
+	if ctx.assertStrEqual("Alpha,bravo,charlie,delta", mirror.list(false), "offline list") {
+		return
+	}
+
+	if ctx.assert(model.set_stream(3, true, newModelTestStream(channels[2], 10, "a vidya game")), "set_stream() didn't find the channel") {
+		return
+	}
+	if ctx.assertStrEqual("remove offline 2; insert online 0", mirror.take_events(), "events for going online") {
+		return
+	}
+	online, index, shown := model.position(3)
+	if ctx.assert(online && index == 0 && shown, "expected charlie at online 0 but got %v, %v, %v", online, index, shown) {
+		return
+	}
+
+	model.set_stream(1, true, newModelTestStream(channels[0], 500, "a vidya game"))
+	mirror.take_events()
+	if ctx.assertStrEqual("charlie,delta", mirror.list(true), "online list in name order") {
+		return
+	}
+
+	// most viewers first
+	model.set_online_sort(OnlineListSort{ONLINE_COLUMN_VIEWERS, true})
+	if ctx.assertStrEqual("reset online", mirror.take_events(), "events for a new sort") {
+		return
+	}
+	if ctx.assertStrEqual("delta,charlie", mirror.list(true), "online list by viewers") {
+		return
+	}
+
+	// a new viewer count that doesn't change the order is just an update
+	model.set_stream(3, true, newModelTestStream(channels[2], 20, "a vidya game"))
+	if ctx.assertStrEqual("update online 1", mirror.take_events(), "events for more viewers") {
+		return
+	}
+	// one that does moves the channel
+	model.set_stream(3, true, newModelTestStream(channels[2], 1000, "a vidya game"))
+	if ctx.assertStrEqual("remove online 1; insert online 0", mirror.take_events(), "events for passing another channel") {
+		return
+	}
+
+	model.set_stream(1, false, nil)
+	if ctx.assertStrEqual("remove online 1; insert offline 2", mirror.take_events(), "events for going offline") {
+		return
+	}
+	if ctx.assertStrEqual("charlie", mirror.list(true), "online list") {
+		return
+	}
+	if ctx.assertStrEqual("Alpha,bravo,delta", mirror.list(false), "offline list") {
+		return
+	}
+
+	if ctx.assert(!model.set_stream(99, true, nil), "set_stream() found a channel we don't follow") {
+		return
+	}
+	if ctx.assert(model.channel(2) == channels[1] && model.channel(99) == nil, "channel() lookups went wrong") {
+		return
+	}
+}
+
+func TestChannelModelFilterAndSelection(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	model := NewChannelListModel()
+	mirror := newMirrorChannelObserver(model)
+	channels := newModelTestChannels()
+	model.set_channels(channels)
+	model.set_stream(4, true, newModelTestStream(channels[3], 10, "speedrun game"))
+	model.select_entry(model.entry(4))
+
+	model.set_filter(channelFilterWords("SPEEDRUN"))
+	if ctx.assertStrEqual("bravo", mirror.list(true), "online list with a filter") {
+		return
+	}
+	if ctx.assertStrEqual("", mirror.list(false), "offline list with a filter") {
+		return
+	}
+	if ctx.assert(model.selected_entry() == model.entry(4), "the selected channel should still be selected") {
+		return
+	}
+
+	// a new game takes bravo out of the filter, and with it the selection
+	mirror.take_events()
+	mirror.hidden = nil
+	model.set_stream(4, true, newModelTestStream(channels[3], 10, "other game"))
+	if ctx.assertStrEqual("remove online 0", mirror.take_events(), "events for leaving the filter") {
+		return
+	}
+	if ctx.assert(len(mirror.hidden) == 1 && mirror.hidden[0], "expected the selection to be hidden but got %v", mirror.hidden) {
+		return
+	}
+	if ctx.assert(model.selected_entry() == nil, "expected no selection") {
+		return
+	}
+
+	model.set_filter(nil)
+	if ctx.assertStrEqual("Alpha,charlie,delta", mirror.list(false), "offline list without a filter") {
+		return
+	}
+	if ctx.assertStrEqual("bravo", mirror.list(true), "online list without a filter") {
+		return
+	}
+
+	// a channel reload keeps the selection on the same channel
+	model.select_entry(model.entry(3))
+	model.set_channels(newModelTestChannels())
+	if ctx.assert(model.selected_entry() != nil && model.selected_entry().Channel.Id == 3, "expected charlie to stay selected") {
+		return
+	}
+	if ctx.assert(len(model.online_channel_ids()) == 0, "expected everyone to start offline after a reload") {
+		return
+	}
+}
+
+// Lots of random changes never leave a view out of step with the model, or the lists out of order
+func TestChannelModelRandomChanges(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	random := rand.New(rand.NewSource(1))
+	games := []string{"a vidya game", "other game", "speedrun game"}
+	channels := []*ChannelInfo{}
+	for i := 0; i < 40; i++ {
+		channels = append(channels, &ChannelInfo{Id: ChannelID(i + 1), Display_Name: fmt.Sprintf("channel%02d", random.Intn(30))})
+	}
+
+	model := NewChannelListModel()
+	mirror := newMirrorChannelObserver(model)
+	model.set_channels(channels)
+	for step := 0; step < 2000; step++ {
+		switch random.Intn(20) {
+		case 0:
+			model.set_filter(channelFilterWords([]string{"", "speedrun", "channel1", "other"}[random.Intn(4)]))
+		case 1:
+			model.set_online_sort(OnlineListSort{OnlineColumn(random.Intn(int(ONLINE_COLUMN_COUNT))), random.Intn(2) == 0})
+		default:
+			channel := channels[random.Intn(len(channels))]
+			if random.Intn(3) == 0 {
+				model.set_stream(channel.Id, false, nil)
+			} else {
+				model.set_stream(channel.Id, true, newModelTestStream(channel, random.Intn(5), games[random.Intn(len(games))]))
+			}
+		}
+
+		for _, online := range []bool{true, false} {
+			less := model.less(online)
+			names := []string{}
+			for index := 0; index < model.count(online); index++ {
+				entry := model.entry_at(online, index)
+				names = append(names, entry.Channel.Display_Name)
+				if index > 0 && ctx.assert(less(model.entry_at(online, index-1), entry), "%s list out of order at %v after step %v", mirrorListName(online), index, step) {
+					return
+				}
+				entry_online, entry_index, shown := model.position(entry.Channel.Id)
+				if ctx.assert(shown && entry_online == online && entry_index == index, "position() of %v is wrong after step %v", entry.Channel.Id, step) {
+					return
+				}
+			}
+			if ctx.assertStrEqual(strings.Join(names, ","), mirror.list(online), fmt.Sprintf("%s list after step %v", mirrorListName(online), step)) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// +build !headless
+
+package main
+
+/**
+A wx.Dialog for the notification rules of one channel, from its context menu. It edits the
+channel's simple rule in the rules file (an action, and optionally the games it's for) and whether
+the channel gets change notifications; the rest of the file stays as it is.
+*/
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rakslice/wxGo/wx"
+)
+
+// The actions the dialog offers, in the order they're listed
+var channelRuleActionChoices = []struct {
+	label  string
+	action RuleAction
+}{
+	{"Go by the follow's notification setting", ""},
+	{"Notify", RULE_ACTION_NOTIFY},
+	{"Notify with high priority", RULE_ACTION_NOTIFY_HIGH},
+	{"Don't notify", RULE_ACTION_SUPPRESS},
+}
+
+type ChannelRulesDialog struct {
+	wx.Dialog
+	sizer wx.BoxSizer
+
+	choice_action                 wx.Choice
+	text_games                    wx.TextCtrl
+	checkbox_change_notifications wx.CheckBox
+}
+
+func InitChannelRulesDialog(parent wx.Window, channel *ChannelInfo, settings *ChannelRuleSettings) *ChannelRulesDialog {
+	out := &ChannelRulesDialog{}
+	out.Dialog = wx.NewDialog(parent, wx.ID_ANY, fmt.Sprintf("Notification rules for %s", channel.Display_Name),
+		wx.DefaultPosition, wx.DefaultSize, wx.DEFAULT_DIALOG_STYLE)
+	out.sizer = wx.NewBoxSizer(wx.VERTICAL)
+
+	labels := []string{}
+	selection := 0
+	for i, choice := range channelRuleActionChoices {
+		labels = append(labels, choice.label)
+		if choice.action == settings.Action {
+			selection = i
+		}
+	}
+	out.choice_action = wx.NewChoice(out, wx.ID_ANY, wx.DefaultPosition, wx.DefaultSize, labels)
+	out.choice_action.SetSelection(selection)
+	out.addRow("When it goes live", out.choice_action)
+
+	out.text_games = wx.NewTextCtrl(out, wx.ID_ANY, strings.Join(settings.Games, ", "), wx.DefaultPosition, wx.NewSize(250, -1))
+	out.addRow("Only for these games (comma-separated)", out.text_games)
+
+	out.checkbox_change_notifications = wx.NewCheckBox(out, wx.ID_ANY, "Notify when it changes game or title while live")
+	out.checkbox_change_notifications.SetValue(settings.Change_Notifications || settings.All_Change_Notifications)
+	// with "*" in change_notifications every channel gets them, whatever this says
+	out.checkbox_change_notifications.Enable(!settings.All_Change_Notifications)
+	out.sizer.Add(out.checkbox_change_notifications, 0, wx.LEFT|wx.RIGHT|wx.TOP, 10)
+
+	if settings.Other_Rules > 0 {
+		note := fmt.Sprintf("The rules file has %v more rules for this channel, which are left as they are.", settings.Other_Rules)
+		out.sizer.Add(wx.NewStaticText(out, wx.ID_ANY, note), 0, wx.LEFT|wx.RIGHT|wx.TOP, 10)
+	}
+
+	buttons := out.CreateStdDialogButtonSizer(wx.OK | wx.CANCEL)
+	out.sizer.Add(buttons, 0, wx.ALL|wx.EXPAND, 10)
+
+	out.SetSizerAndFit(out.sizer)
+	return out
+}
+
+func (dialog *ChannelRulesDialog) addRow(label string, control wx.Window) {
+	row := wx.NewBoxSizer(wx.HORIZONTAL)
+	row.Add(wx.NewStaticText(dialog, wx.ID_ANY, label), 1, wx.ALIGN_CENTER_VERTICAL|wx.RIGHT, 10)
+	row.Add(control, 0, 0, 0)
+	dialog.sizer.Add(row, 0, wx.LEFT|wx.RIGHT|wx.TOP|wx.EXPAND, 10)
+}
+
+// The settings as they are in the dialog; the change notifications stay as they were if "*" has them
+func (dialog *ChannelRulesDialog) settings(old *ChannelRuleSettings) *ChannelRuleSettings {
+	out := &ChannelRuleSettings{}
+	if selection := dialog.choice_action.GetSelection(); selection >= 0 && selection < len(channelRuleActionChoices) {
+		out.Action = channelRuleActionChoices[selection].action
+	}
+	out.Games = parseGamesList(dialog.text_games.GetValue())
+	out.Change_Notifications = old.Change_Notifications
+	if !old.All_Change_Notifications {
+		out.Change_Notifications = dialog.checkbox_change_notifications.GetValue()
+	}
+	return out
+}
+
+/**
+Show the rules dialog for a channel, and if it's OKed, save the changes to the rules file; they're
+used from the next notification on
+*/
+func (win *MainStatusWindowImpl) showChannelRules(channel *ChannelInfo) {
+	app := win.main_obj
+	old, err := app.channel_rule_settings(channel)
+	if err != nil {
+		wx.MessageBox(fmt.Sprintf("Can't edit the rules for %s, as the rules file couldn't be read: %s", channel.Display_Name, err), "twitch-notifier", wx.OK|wx.ICON_WARNING)
+		return
+	}
+	dialog := InitChannelRulesDialog(win, channel, old)
+	result := dialog.ShowModal()
+	settings := dialog.settings(old)
+	dialog.Destroy()
+	if result != wx.ID_OK {
+		return
+	}
+
+	err = app.save_channel_rule_settings(channel, settings)
+	if err != nil {
+		wx.MessageBox(fmt.Sprintf("Error saving the rules for %s: %s", channel.Display_Name, err), "twitch-notifier", wx.OK|wx.ICON_WARNING)
+		return
+	}
+	app.log(fmt.Sprintf("Saved the notification rules for %s", channel.Display_Name))
+}
@@ -0,0 +1,332 @@
+package main
+
+/**
+Chat notifications: when a stream gets a notification, post it to Discord or Slack channels through
+their incoming webhooks. Which channels go where is set in a JSON routes file:
+
+	{
+	  "destinations": {
+	    "team-discord": {"type": "discord", "url": "https://discord.com/api/webhooks/..."},
+	    "ops-slack": {"type": "slack", "url": "https://hooks.slack.com/services/..."}
+	  },
+	  "routes": [
+	    {"channel": "SomeChannel", "destinations": ["team-discord", "ops-slack"]},
+	    {"channel": "*", "destinations": ["ops-slack"]}
+	  ]
+	}
+
+The routes for the stream's channel are used if there are any, otherwise the "*" ones.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const (
+	CHAT_TYPE_DISCORD = "discord"
+	CHAT_TYPE_SLACK   = "slack"
+
+	// the twitch purple, for the Discord embed
+	DISCORD_EMBED_COLOR = 0x9146ff
+)
+
+// A chat webhook as it is in the routes file
+type ChatDestinationConfig struct {
+	Type string `json:"type"`
+	Url  string `json:"url"`
+}
+
+type ChatRouteConfig struct {
+	// channel display name or login, or "*" for channels with no routes of their own
+	Channel      string   `json:"channel"`
+	Destinations []string `json:"destinations"`
+}
+
+type ChatRoutesFile struct {
+	Destinations map[string]*ChatDestinationConfig `json:"destinations"`
+	Routes       []*ChatRouteConfig                `json:"routes"`
+}
+
+// What goes into a chat notification
+type ChatNotification struct {
+	channel *ChannelInfo
+	stream  *StreamInfo
+	// from create_online_message
+	message       string
+	uptime        time.Duration
+	high_priority bool
+}
+
+// Makes the webhook request body for a chat service
+type ChatFormatter func(notification *ChatNotification) ([]byte, error)
+
+var chatFormatters = map[string]ChatFormatter{
+	CHAT_TYPE_DISCORD: formatDiscordNotification,
+	CHAT_TYPE_SLACK:   formatSlackNotification,
+}
+
+type ChatDestination struct {
+	name   string
+	format ChatFormatter
+	sink   *WebhookEventSink
+}
+
+type ChatRoutes struct {
+	destinations   map[string]*ChatDestination
+	channel_routes []*ChatRouteConfig
+	default_routes []*ChatRouteConfig
+}
+
+func getChatRoutesFilename() string {
+	newParts := append(prefsRelativePath(), "twitchnotifier.chat.json")
+	return userRelativePath(newParts...)
+}
+
+// Load the routes from a file. If there is no routes file we get no routes and no error.
+func LoadChatRoutes(filename string, timeout time.Duration) (*ChatRoutes, error) {
+	if !fileExists(filename) {
+		return NewChatRoutes(&ChatRoutesFile{}, timeout)
+	}
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	routesFile := &ChatRoutesFile{}
+	err = json.Unmarshal(buf, routesFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewChatRoutes(routesFile, timeout)
+}
+
+func NewChatRoutes(routesFile *ChatRoutesFile, timeout time.Duration) (*ChatRoutes, error) {
+	out := &ChatRoutes{}
+	out.destinations = make(map[string]*ChatDestination)
+	for name, config := range routesFile.Destinations {
+		format, ok := chatFormatters[config.Type]
+		if !ok {
+			out.close()
+			return nil, fmt.Errorf("destination %s: unknown type '%s'", name, config.Type)
+		}
+		sink, err := NewWebhookEventSink(config.Url, "", timeout)
+		if err != nil {
+			out.close()
+			return nil, fmt.Errorf("destination %s: %s", name, err)
+		}
+		out.destinations[name] = &ChatDestination{name, format, sink}
+	}
+
+	for i, route := range routesFile.Routes {
+		if route.Channel == "" {
+			out.close()
+			return nil, fmt.Errorf("route %v: no channel", i+1)
+		}
+		for _, name := range route.Destinations {
+			if _, ok := out.destinations[name]; !ok {
+				out.close()
+				return nil, fmt.Errorf("route %v: unknown destination '%s'", i+1, name)
+			}
+		}
+		if route.Channel == "*" {
+			out.default_routes = append(out.default_routes, route)
+		} else {
+			out.channel_routes = append(out.channel_routes, route)
+		}
+	}
+	return out, nil
+}
+
+// Stop the destinations' webhook sinks
+func (routes *ChatRoutes) close() {
+	for _, destination := range routes.destinations {
+		destination.sink.close()
+	}
+}
+
+// The destinations for a channel's notifications
+func (routes *ChatRoutes) destinations_for(channel *ChannelInfo) []*ChatDestination {
+	matching := []*ChatRouteConfig{}
+	for _, route := range routes.channel_routes {
+		if channelNameMatches(route.Channel, channel) {
+			matching = append(matching, route)
+		}
+	}
+	if len(matching) == 0 {
+		matching = routes.default_routes
+	}
+
+	out := []*ChatDestination{}
+	seen := make(map[string]bool)
+	for _, route := range matching {
+		for _, name := range route.Destinations {
+			if !seen[name] {
+				seen[name] = true
+				out = append(out, routes.destinations[name])
+			}
+		}
+	}
+	return out
+}
+
+// Post a notification to the channel's destinations
+func (routes *ChatRoutes) send(notification *ChatNotification) {
+	event := &HeadlessEvent{Time: time.Now(), Event: HEADLESS_EVENT_NOTIFY, Channel: notification.channel.Display_Name}
+	for _, destination := range routes.destinations_for(notification.channel) {
+		body, err := destination.format(notification)
+		if err != nil {
+			msg("error formatting notification for %s: %s", destination.name, err)
+			continue
+		}
+		destination.sink.enqueue(event, body)
+	}
+}
+
+// FORMATTERS
+
+func chatStreamGame(stream *StreamInfo) string {
+	if stream.Game == nil {
+		return ""
+	}
+	return *stream.Game
+}
+
+type discordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordEmbedImage struct {
+	Url string `json:"url"`
+}
+
+type discordEmbed struct {
+	Title       string              `json:"title"`
+	Url         string              `json:"url"`
+	Description string              `json:"description,omitempty"`
+	Color       int                 `json:"color"`
+	Thumbnail   *discordEmbedImage  `json:"thumbnail,omitempty"`
+	Fields      []discordEmbedField `json:"fields,omitempty"`
+	Timestamp   string              `json:"timestamp,omitempty"`
+}
+
+type discordMessage struct {
+	Content string          `json:"content"`
+	Embeds  []*discordEmbed `json:"embeds"`
+}
+
+// A Discord webhook message with an embed for the stream
+func formatDiscordNotification(notification *ChatNotification) ([]byte, error) {
+	channel := notification.channel
+	stream := notification.stream
+	embed := &discordEmbed{
+		Title:       fmt.Sprintf("%s is live", channel.Display_Name),
+		Url:         channel.Url,
+		Description: channel.Status,
+		Color:       DISCORD_EMBED_COLOR,
+	}
+	if logo := channel_logo_url(channel); logo != "" {
+		embed.Thumbnail = &discordEmbedImage{logo}
+	}
+	if game := chatStreamGame(stream); game != "" {
+		embed.Fields = append(embed.Fields, discordEmbedField{"Game", game, true})
+	}
+	embed.Fields = append(embed.Fields, discordEmbedField{"Uptime", time_desc(notification.uptime), true})
+	if start_time, err := convert_rfc3339_time(stream.Created_at); err == nil {
+		embed.Timestamp = start_time.UTC().Format(time.RFC3339)
+	}
+
+	content := notification.message
+	if notification.high_priority {
+		content = "@here " + content
+	}
+	return json.Marshal(&discordMessage{content, []*discordEmbed{embed}})
+}
+
+// Escape text for Slack mrkdwn
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// A Slack Block Kit message for the stream
+func formatSlackNotification(notification *ChatNotification) ([]byte, error) {
+	channel := notification.channel
+	stream := notification.stream
+
+	text := fmt.Sprintf("*<%s|%s>* is live", channel.Url, slackEscape(channel.Display_Name))
+	if channel.Status != "" {
+		text += "\n" + slackEscape(channel.Status)
+	}
+	section := map[string]interface{}{
+		"type": "section",
+		"text": map[string]interface{}{"type": "mrkdwn", "text": text},
+	}
+	if logo := channel_logo_url(channel); logo != "" {
+		section["accessory"] = map[string]interface{}{"type": "image", "image_url": logo, "alt_text": channel.Display_Name}
+	}
+
+	details := []string{}
+	if game := chatStreamGame(stream); game != "" {
+		details = append(details, "Playing "+slackEscape(game))
+	}
+	details = append(details, "Up "+time_desc(notification.uptime))
+	context := map[string]interface{}{
+		"type":     "context",
+		"elements": []interface{}{map[string]interface{}{"type": "mrkdwn", "text": strings.Join(details, " · ")}},
+	}
+
+	fallback := notification.message
+	if notification.high_priority {
+		fallback = "<!here> " + fallback
+	}
+	return json.Marshal(map[string]interface{}{
+		"text":   fallback,
+		"blocks": []interface{}{section, context},
+	})
+}
+
+// APP CHAT SUPPORT
+
+// The -chat-routes file, or the default one in the prefs dir. Like the rules file, there's none for
+// options that weren't made from the command line.
+func (app *TwitchNotifierMain) chat_routes_filename() string {
+	if app.options.chat_routes_file == nil {
+		return ""
+	}
+	if *app.options.chat_routes_file != "" {
+		return *app.options.chat_routes_file
+	}
+	return getChatRoutesFilename()
+}
+
+// The chat routes from the routes file, loaded the first time we need them
+func (app *TwitchNotifierMain) getChatRoutes() *ChatRoutes {
+	if app.chatRoutes == nil {
+		routesFilename := app.chat_routes_filename()
+		routes, err := LoadChatRoutes(routesFilename, app.webhook_timeout())
+		if err != nil {
+			app.getEventsInterface().log(fmt.Sprintf("Error loading chat routes from '%s': %s", routesFilename, err))
+			routes, _ = NewChatRoutes(&ChatRoutesFile{}, app.webhook_timeout())
+		}
+		app.chatRoutes = routes
+	}
+	return app.chatRoutes
+}
+
+// Stop the chat destinations, so the routes are loaded again with the current options
+func (app *TwitchNotifierMain) reset_chat_routes() {
+	if app.chatRoutes != nil {
+		app.chatRoutes.close()
+	}
+	app.chatRoutes = nil
+}
+
+// Post a stream's notification to the chat destinations for its channel
+func (app *TwitchNotifierMain) send_chat_notification(message string, stream *StreamInfo, high_priority bool) {
+	uptime := time.Now().Sub(app.get_stream_start_time_or_now(stream))
+	app.getChatRoutes().send(&ChatNotification{stream.Channel, stream, message, uptime, high_priority})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func noChatRoutes() *ChatRoutes {
+	routes, _ := NewChatRoutes(&ChatRoutesFile{}, time.Second)
+	return routes
+}
+
+func newChatTestNotification() *ChatNotification {
+	logo := "https://example.com/logo.png"
+	game := "a vidya game"
+	stream := &StreamInfo{
+		Channel:    &ChannelInfo{Id: 123, Display_Name: "FakeChannel", Url: "https://www.twitch.tv/fakechannel", Status: "some <b>title</b>", Logo: &logo},
+		Id:         456,
+		Created_at: "2017-08-01T12:00:00Z",
+		Game:       &game,
+	}
+	return &ChatNotification{stream.Channel, stream, "FakeChannel is now live with a vidya game (up 5 min)", 5 * time.Minute, false}
+}
+
+// TESTS
+
+func TestDiscordFormat(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	body, err := formatDiscordNotification(newChatTestNotification())
+	if ctx.assertNoErr(err, "formatDiscordNotification()") {
+		return
+	}
+	message := &discordMessage{}
+	if ctx.assertNoErr(json.Unmarshal(body, message), "json.Unmarshal()") {
+		return
+	}
+	if ctx.assertStrEqual("FakeChannel is now live with a vidya game (up 5 min)", message.Content, "discord content") {
+		return
+	}
+	if ctx.assert(len(message.Embeds) == 1, "expected 1 embed but got %v", len(message.Embeds)) {
+		return
+	}
+	embed := message.Embeds[0]
+	if ctx.assert(embed.Title == "FakeChannel is live" && embed.Url == "https://www.twitch.tv/fakechannel" &&
+		embed.Description == "some <b>title</b>" && embed.Thumbnail != nil && embed.Thumbnail.Url == "https://example.com/logo.png" &&
+		embed.Timestamp == "2017-08-01T12:00:00Z",
+		"unexpected embed %+v", *embed) {
+		return
+	}
+	if ctx.assert(len(embed.Fields) == 2 && embed.Fields[0].Value == "a vidya game" && embed.Fields[1].Value == "5 min",
+		"unexpected embed fields %+v", embed.Fields) {
+		return
+	}
+}
+
+func TestSlackFormat(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	notification := newChatTestNotification()
+	notification.high_priority = true
+	body, err := formatSlackNotification(notification)
+	if ctx.assertNoErr(err, "formatSlackNotification()") {
+		return
+	}
+	var message struct {
+		Text   string
+		Blocks []struct {
+			Type string
+			Text struct {
+				Text string
+			}
+			Accessory struct {
+				Image_Url string
+			}
+			Elements []struct {
+				Text string
+			}
+		}
+	}
+	if ctx.assertNoErr(json.Unmarshal(body, &message), "json.Unmarshal()") {
+		return
+	}
+	if ctx.assertStrEqual("<!here> FakeChannel is now live with a vidya game (up 5 min)", message.Text, "slack fallback text") {
+		return
+	}
+	if ctx.assert(len(message.Blocks) == 2, "expected 2 blocks but got %v", len(message.Blocks)) {
+		return
+	}
+	if ctx.assertStrEqual("*<https://www.twitch.tv/fakechannel|FakeChannel>* is live\nsome &lt;b&gt;title&lt;/b&gt;", message.Blocks[0].Text.Text, "slack section") {
+		return
+	}
+	if ctx.assertStrEqual("https://example.com/logo.png", message.Blocks[0].Accessory.Image_Url, "slack logo") {
+		return
+	}
+	if ctx.assert(len(message.Blocks[1].Elements) == 1, "expected 1 context element") {
+		return
+	}
+	if ctx.assertStrEqual("Playing a vidya game · Up 5 min", message.Blocks[1].Elements[0].Text, "slack context") {
+		return
+	}
+}
+
+func TestChatRoutesConfig(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	_, err := NewChatRoutes(&ChatRoutesFile{
+		Destinations: map[string]*ChatDestinationConfig{"team": {Type: "irc", Url: "https://example.com/"}},
+	}, time.Second)
+	if ctx.assertGotErr("destination team: unknown type 'irc'", err, "NewChatRoutes() with a bad type") {
+		return
+	}
+
+	_, err = NewChatRoutes(&ChatRoutesFile{
+		Destinations: map[string]*ChatDestinationConfig{"team": {Type: CHAT_TYPE_SLACK, Url: "https://example.com/"}},
+		Routes:       []*ChatRouteConfig{{Channel: "*", Destinations: []string{"team", "other"}}},
+	}, time.Second)
+	if ctx.assertGotErr("route 1: unknown destination 'other'", err, "NewChatRoutes() with a bad destination") {
+		return
+	}
+}
+
+func TestChatRouting(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	discordServer, discordReceived := newWebhookTestServer(0)
+	defer discordServer.Close()
+	slackServer, slackReceived := newWebhookTestServer(0)
+	defer slackServer.Close()
+
+	twitch := newStreamChangeTestTwitch()
+	server := httptest.NewServer(twitch)
+	defer server.Close()
+
+	app := newTestApp(fakeTwitchOptions(server))
+	routes, err := NewChatRoutes(&ChatRoutesFile{
+		Destinations: map[string]*ChatDestinationConfig{
+			"discord": {Type: CHAT_TYPE_DISCORD, Url: discordServer.URL},
+			"slack":   {Type: CHAT_TYPE_SLACK, Url: slackServer.URL},
+		},
+		Routes: []*ChatRouteConfig{
+			{Channel: "fakechannel", Destinations: []string{"discord"}},
+			{Channel: "*", Destinations: []string{"slack"}},
+		},
+	}, time.Second)
+	if ctx.assertNoErr(err, "NewChatRoutes()") {
+		return
+	}
+	app.chatRoutes = routes
+	defer app.reset_chat_routes()
+
+	app.main_loop_iter.next()
+
+	discordWebhook := waitForWebhook(ctx, discordReceived)
+	if discordWebhook == nil {
+		return
+	}
+	discord := &discordMessage{}
+	if ctx.assertNoErr(json.Unmarshal(discordWebhook.body, discord), "json.Unmarshal() of the discord message") {
+		return
+	}
+	if ctx.assert(len(discord.Embeds) == 1 && discord.Embeds[0].Title == "FakeChannel is live", "unexpected discord message %s", discordWebhook.body) {
+		return
+	}
+
+	// the channel with no routes of its own goes to the default destination
+	slackWebhook := waitForWebhook(ctx, slackReceived)
+	if slackWebhook == nil {
+		return
+	}
+	slack := map[string]interface{}{}
+	if ctx.assertNoErr(json.Unmarshal(slackWebhook.body, &slack), "json.Unmarshal() of the slack message") {
+		return
+	}
+	text, _ := slack["text"].(string)
+	if ctx.assert(strings.HasPrefix(text, "OtherChannel is now live"), "unexpected slack message %s", slackWebhook.body) {
+		return
+	}
+
+	select {
+	case extra := <-discordReceived:
+		ctx.assert(false, "unexpected extra discord message %s", extra.body)
+	case extra := <-slackReceived:
+		ctx.assert(false, "unexpected extra slack message %s", extra.body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+/**
+Settings from a config file, so the app doesn't need everything on the command line when it's
+started at login.
+
+The config file is JSON with the same names as the command line flags:
+
+	{
+	  "poll": 120,
+	  "all": true,
+	  "api": "helix"
+	}
+
+Each setting comes from, in order of precedence: the command line, a TWITCH_NOTIFIER_<NAME>
+environment variable (e.g. TWITCH_NOTIFIER_AUTH_OAUTH for -auth-oauth), the config file, and
+then the flag default. The config applies its values to the flags themselves, so the Options
+pointers see them, and it can reload the file when it changes on disk.
+*/
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CONFIG_ENV_PREFIX = "TWITCH_NOTIFIER_"
+
+	// how often we look for changes to the config file
+	CONFIG_CHECK_INTERVAL = 2 * time.Second
+)
+
+// flags that only make sense on the command line
+var configSkippedFlags = map[string]bool{
+	"config": true,
+	"help":   true,
+}
+
+// checks for settings whose flags take any string, so a bad value is rejected like a bad number is
+var configValueChecks = map[string]func(string) error{
+	"api": checkAPIOption,
+}
+
+type Config struct {
+	filename string
+	flags    *flag.FlagSet
+
+	// values from the file, as flag value strings
+	fileValues map[string]string
+	// flags given on the command line, which the file and environment don't override
+	setOnCommandLine map[string]bool
+	envValues        map[string]string
+
+	// so we can tell when the file has changed
+	modTime time.Time
+	size    int64
+}
+
+func getConfigFilename() string {
+	newParts := append(prefsRelativePath(), "twitchnotifier.config.json")
+	return userRelativePath(newParts...)
+}
+
+// The environment variable for a flag, e.g. TWITCH_NOTIFIER_AUTH_OAUTH for auth-oauth
+func configEnvName(flagName string) string {
+	return CONFIG_ENV_PREFIX + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+/**
+Load the config file and apply it and the environment to flags, which should already have been
+parsed. lookupEnv is normally os.LookupEnv. A missing config file is the same as an empty one.
+Bad values are reported in the error but don't stop the other settings being applied.
+*/
+func LoadConfig(filename string, flags *flag.FlagSet, lookupEnv func(string) (string, bool)) (*Config, error) {
+	out := &Config{}
+	out.filename = filename
+	out.flags = flags
+
+	out.setOnCommandLine = make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		out.setOnCommandLine[f.Name] = true
+	})
+
+	out.envValues = make(map[string]string)
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := lookupEnv(configEnvName(f.Name))
+		if ok {
+			out.envValues[f.Name] = value
+		}
+	})
+
+	// a file with problems can still have good settings in it, so apply what we got either way
+	err := out.readFile()
+	applyErr := out.apply()
+	if err != nil && applyErr != nil {
+		return out, fmt.Errorf("%s; %s", err, applyErr)
+	} else if err != nil {
+		return out, err
+	}
+	return out, applyErr
+}
+
+func (config *Config) readFile() error {
+	config.fileValues = make(map[string]string)
+	config.modTime = time.Time{}
+	config.size = 0
+
+	info, err := os.Stat(config.filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	config.modTime = info.ModTime()
+	config.size = info.Size()
+
+	buf, err := ioutil.ReadFile(config.filename)
+	if err != nil {
+		return err
+	}
+	values := map[string]interface{}{}
+	err = json.Unmarshal(buf, &values)
+	if err != nil {
+		return fmt.Errorf("error reading config file '%s': %s", config.filename, err)
+	}
+
+	unknown := []string{}
+	for name, value := range values {
+		if config.flags.Lookup(name) == nil || configSkippedFlags[name] {
+			unknown = append(unknown, name)
+			continue
+		}
+		config.fileValues[name] = configValueString(value)
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown settings in config file '%s': %s", config.filename, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// A JSON value as a flag value string
+func configValueString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return ""
+	default:
+		buf, _ := json.Marshal(v)
+		return string(buf)
+	}
+}
+
+// Set each flag from the environment, the file or its default, unless it was on the command line
+func (config *Config) apply() error {
+	errors := []string{}
+	config.flags.VisitAll(func(f *flag.Flag) {
+		if config.setOnCommandLine[f.Name] || configSkippedFlags[f.Name] {
+			return
+		}
+		value, source := f.DefValue, "default"
+		if envValue, ok := config.envValues[f.Name]; ok {
+			value, source = envValue, configEnvName(f.Name)
+		} else if fileValue, ok := config.fileValues[f.Name]; ok {
+			value, source = fileValue, "config file"
+		}
+		err := setConfigFlag(f, value)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("bad value '%s' for %s from %s: %s", value, f.Name, source, err))
+		}
+	})
+	if len(errors) > 0 {
+		return fmt.Errorf("%s", strings.Join(errors, "; "))
+	}
+	return nil
+}
+
+// Set a flag, if the value passes the setting's check. A bad value leaves the flag as it was.
+func setConfigFlag(f *flag.Flag, value string) error {
+	if check, ok := configValueChecks[f.Name]; ok {
+		err := check(value)
+		if err != nil {
+			return err
+		}
+	}
+	old := f.Value.String()
+	err := f.Value.Set(value)
+	if err != nil {
+		// a failed parse can still change the value
+		f.Value.Set(old)
+	}
+	return err
+}
+
+// Whether the file has changed since we last read it
+func (config *Config) changedOnDisk() bool {
+	info, err := os.Stat(config.filename)
+	if err != nil {
+		// it was deleted, or was never there
+		return !config.modTime.IsZero()
+	}
+	return !info.ModTime().Equal(config.modTime) || info.Size() != config.size
+}
+
+// Read the file again and apply it
+func (config *Config) reload() error {
+	err := config.readFile()
+	applyErr := config.apply()
+	if err != nil && applyErr != nil {
+		return fmt.Errorf("%s; %s", err, applyErr)
+	} else if err != nil {
+		return err
+	}
+	return applyErr
+}
+
+/**
+Change a setting and save it to the config file, as when it's changed in the GUI. It takes effect
+even if the command line or environment had set it, since the user just asked for it.
+*/
+func (config *Config) Set(name string, value string) error {
+	f := config.flags.Lookup(name)
+	if f == nil || configSkippedFlags[name] {
+		return fmt.Errorf("unknown setting '%s'", name)
+	}
+	err := setConfigFlag(f, value)
+	if err != nil {
+		return fmt.Errorf("bad value '%s' for %s: %s", value, name, err)
+	}
+	config.fileValues[name] = value
+	delete(config.setOnCommandLine, name)
+	delete(config.envValues, name)
+	return config.save()
+}
+
+// A flag value string as a JSON value of the flag's type
+func configJSONValue(f *flag.Flag, value string) interface{} {
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return value
+	}
+	switch getter.Get().(type) {
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err == nil {
+			return b
+		}
+	case int, int64, uint, uint64, float64:
+		_, err := strconv.ParseFloat(value, 64)
+		if err == nil {
+			return json.Number(value)
+		}
+	}
+	return value
+}
+
+// Write the file's settings back out. Command line and environment values stay out of the file.
+func (config *Config) save() error {
+	values := map[string]interface{}{}
+	for name, value := range config.fileValues {
+		values[name] = configJSONValue(config.flags.Lookup(name), value)
+	}
+	buf, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+	// it can have the OAuth token in it
+	err = ioutil.WriteFile(config.filename, append(buf, '\n'), 0600)
+	if err != nil {
+		return err
+	}
+	// so our own write doesn't look like a change
+	info, err := os.Stat(config.filename)
+	if err != nil {
+		return err
+	}
+	config.modTime = info.ModTime()
+	config.size = info.Size()
+	return nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type configTestFlags struct {
+	flags    *flag.FlagSet
+	username *string
+	poll     *int
+	all      *bool
+	api      *string
+}
+
+func newConfigTestFlags(args ...string) *configTestFlags {
+	out := &configTestFlags{}
+	out.flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	out.username = out.flags.String("username", "", "username to use")
+	out.poll = out.flags.Int("poll", 60, "poll interval")
+	out.all = out.flags.Bool("all", false, "Watch all followed streams")
+	out.api = out.flags.String("api", "kraken", "Twitch API to use")
+	out.flags.Parse(args)
+	return out
+}
+
+func noEnv(string) (string, bool) {
+	return "", false
+}
+
+func writeTestConfig(ctx *TestContext, filename string, values map[string]interface{}) bool {
+	buf, err := json.Marshal(values)
+	if ctx.assertNoErr(err, "json.Marshal()") {
+		return true
+	}
+	return ctx.assertNoErr(ioutil.WriteFile(filename, buf, 0600), "ioutil.WriteFile()")
+}
+
+func tempConfigFilename(ctx *TestContext) (string, func()) {
+	dir, err := ioutil.TempDir("", "twitchnotifier")
+	if ctx.assertNoErr(err, "ioutil.TempDir()") {
+		return "", func() {}
+	}
+	return filepath.Join(dir, "twitchnotifier.config.json"), func() { os.RemoveAll(dir) }
+}
+
+// TESTS
+
+func TestConfigPrecedence(t *testing.T) {
+	ctx := NewTestCtx(t)
+	filename, cleanup := tempConfigFilename(ctx)
+	defer cleanup()
+
+	if writeTestConfig(ctx, filename, map[string]interface{}{"username": "fileuser", "poll": 120, "all": true, "api": "helix"}) {
+		return
+	}
+	env := map[string]string{"TWITCH_NOTIFIER_POLL": "300", "TWITCH_NOTIFIER_API": "kraken"}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	testFlags := newConfigTestFlags("-api", "helix")
+
+	_, err := LoadConfig(filename, testFlags.flags, lookupEnv)
+	if ctx.assertNoErr(err, "LoadConfig()") {
+		return
+	}
+
+	if ctx.assertStrEqual("fileuser", *testFlags.username, "username from the file") {
+		return
+	}
+	if ctx.assert(*testFlags.all, "all from the file wasn't applied") {
+		return
+	}
+	if ctx.assert(*testFlags.poll == 300, "expected poll 300 from the environment but got %v", *testFlags.poll) {
+		return
+	}
+	if ctx.assertStrEqual("helix", *testFlags.api, "api from the command line") {
+		return
+	}
+}
+
+func TestConfigMissingFileAndBadValues(t *testing.T) {
+	ctx := NewTestCtx(t)
+	filename, cleanup := tempConfigFilename(ctx)
+	defer cleanup()
+
+	testFlags := newConfigTestFlags()
+	_, err := LoadConfig(filename, testFlags.flags, noEnv)
+	if ctx.assertNoErr(err, "LoadConfig() with no file") {
+		return
+	}
+	if ctx.assert(*testFlags.poll == 60, "expected the default poll but got %v", *testFlags.poll) {
+		return
+	}
+
+	if writeTestConfig(ctx, filename, map[string]interface{}{"poll": "often", "username": "fileuser", "colour": "blue"}) {
+		return
+	}
+	testFlags = newConfigTestFlags()
+	_, err = LoadConfig(filename, testFlags.flags, noEnv)
+	if ctx.assert(err != nil, "expected an error for the bad values") {
+		return
+	}
+	expectedPrefix := "unknown settings in config file '" + filename + "': colour; bad value 'often' for poll from config file: "
+	if ctx.assert(strings.HasPrefix(err.Error(), expectedPrefix), "expected error starting '%s' but got '%s'", expectedPrefix, err) {
+		return
+	}
+	// the good settings still apply
+	if ctx.assertStrEqual("fileuser", *testFlags.username, "username from the file") {
+		return
+	}
+	if ctx.assert(*testFlags.poll == 60, "expected the default poll after a bad value but got %v", *testFlags.poll) {
+		return
+	}
+}
+
+func TestConfigSetAndReload(t *testing.T) {
+	ctx := NewTestCtx(t)
+	filename, cleanup := tempConfigFilename(ctx)
+	defer cleanup()
+
+	if writeTestConfig(ctx, filename, map[string]interface{}{"username": "fileuser"}) {
+		return
+	}
+	testFlags := newConfigTestFlags("-all")
+	config, err := LoadConfig(filename, testFlags.flags, noEnv)
+	if ctx.assertNoErr(err, "LoadConfig()") {
+		return
+	}
+
+	// changing a setting saves it with the file's other settings, and isn't seen as a change on disk
+	err = config.Set("poll", "90")
+	if ctx.assertNoErr(err, "config.Set()") {
+		return
+	}
+	if ctx.assert(*testFlags.poll == 90, "expected poll 90 after Set() but got %v", *testFlags.poll) {
+		return
+	}
+	if ctx.assert(!config.changedOnDisk(), "our own save looked like a change") {
+		return
+	}
+	buf, err := ioutil.ReadFile(filename)
+	if ctx.assertNoErr(err, "ioutil.ReadFile()") {
+		return
+	}
+	saved := map[string]interface{}{}
+	if ctx.assertNoErr(json.Unmarshal(buf, &saved), "json.Unmarshal()") {
+		return
+	}
+	if ctx.assert(saved["poll"] == float64(90) && saved["username"] == "fileuser" && len(saved) == 2,
+		"unexpected saved config %v", saved) {
+		return
+	}
+
+	err = config.Set("nonsense", "1")
+	if ctx.assertGotErr("unknown setting 'nonsense'", err, "config.Set() of an unknown setting") {
+		return
+	}
+	err = config.Set("api", "nonsense")
+	if ctx.assertGotErr("bad value 'nonsense' for api: expected kraken or helix", err, "config.Set() of an unknown api") {
+		return
+	}
+	if ctx.assertStrEqual("kraken", *testFlags.api, "api after a bad Set()") {
+		return
+	}
+
+	// someone else edits the file; settings they took out go back to their defaults
+	if writeTestConfig(ctx, filename, map[string]interface{}{"api": "helix", "all": false, "some": "padding"}) {
+		return
+	}
+	// make sure the modification time moves even on filesystems with coarse times
+	future := time.Now().Add(time.Minute)
+	if ctx.assertNoErr(os.Chtimes(filename, future, future), "os.Chtimes()") {
+		return
+	}
+	if ctx.assert(config.changedOnDisk(), "didn't notice the file change") {
+		return
+	}
+	err = config.reload()
+	if ctx.assertGotErr("unknown settings in config file '"+filename+"': some", err, "config.reload()") {
+		return
+	}
+	if ctx.assertStrEqual("helix", *testFlags.api, "api after reload") {
+		return
+	}
+	if ctx.assertStrEqual("", *testFlags.username, "username after it was taken out of the file") {
+		return
+	}
+	if ctx.assert(*testFlags.poll == 60, "expected the default poll after it was taken out of the file but got %v", *testFlags.poll) {
+		return
+	}
+	if ctx.assert(*testFlags.all, "the command line -all was overridden by the file") {
+		return
+	}
+}
+
+func TestCheckConfigReloadsChannels(t *testing.T) {
+	ctx := NewTestCtx(t)
+	filename, cleanup := tempConfigFilename(ctx)
+	defer cleanup()
+
+	testFlags := newConfigTestFlags()
+	config, err := LoadConfig(filename, testFlags.flags, noEnv)
+	if ctx.assertNoErr(err, "LoadConfig()") {
+		return
+	}
+	app := InitTwitchNotifierMain()
+	app.options = &Options{api: testFlags.api, config: config}
+	app.need_channels_refresh = false
+	firstBackend := app.getAPIBackend()
+
+	if ctx.assert(!app.check_config(), "check_config() saw a change with no file") {
+		return
+	}
+
+	if writeTestConfig(ctx, filename, map[string]interface{}{"api": "helix"}) {
+		return
+	}
+	if ctx.assert(app.check_config(), "check_config() didn't see the new file") {
+		return
+	}
+	if ctx.assert(app.need_channels_refresh, "expected a channel refresh after the config changed") {
+		return
+	}
+	backend := app.getAPIBackend()
+	_, isHelix := backend.(*HelixBackend)
+	if ctx.assert(backend != firstBackend && isHelix, "expected a new helix backend after the config changed") {
+		return
+	}
+}
+
+func TestCheckConfigKeepsAPIInstances(t *testing.T) {
+	ctx := NewTestCtx(t)
+	filename, cleanup := tempConfigFilename(ctx)
+	defer cleanup()
+
+	testFlags := newConfigTestFlags()
+	config, err := LoadConfig(filename, testFlags.flags, noEnv)
+	if ctx.assertNoErr(err, "LoadConfig()") {
+		return
+	}
+	app := InitTwitchNotifierMain()
+	app.options = &Options{api: testFlags.api, poll: testFlags.poll, config: config}
+	firstKraken := app.krakenInstance
+	firstBackend := app.getAPIBackend()
+
+	// a poll change keeps the API instances, and so the rate limit state
+	if writeTestConfig(ctx, filename, map[string]interface{}{"poll": 120}) {
+		return
+	}
+	if ctx.assert(app.check_config(), "check_config() didn't see the new file") {
+		return
+	}
+	if ctx.assert(*app.options.poll == 120, "the poll setting wasn't applied") {
+		return
+	}
+	if ctx.assert(app.krakenInstance == firstKraken && app.getAPIBackend() == firstBackend, "the API instances were set up again for a poll change") {
+		return
+	}
+
+	if writeTestConfig(ctx, filename, map[string]interface{}{"poll": 120, "api": "helix"}) {
+		return
+	}
+	if ctx.assert(app.check_config(), "check_config() didn't see the changed file") {
+		return
+	}
+	if ctx.assert(app.krakenInstance != firstKraken && app.getAPIBackend() != firstBackend, "the API instances weren't set up again for an api change") {
+		return
+	}
+
+	// an unknown api is reported, and we keep the one we had
+	helixKraken := app.krakenInstance
+	helixBackend := app.getAPIBackend()
+	if writeTestConfig(ctx, filename, map[string]interface{}{"poll": 120, "api": "nonsense"}) {
+		return
+	}
+	if ctx.assert(app.check_config(), "check_config() didn't see the bad api") {
+		return
+	}
+	if ctx.assertStrEqual("helix", *app.options.api, "api after a bad value") {
+		return
+	}
+	if ctx.assert(app.krakenInstance == helixKraken && app.getAPIBackend() == helixBackend, "the API instances were set up again for a bad api") {
+		return
+	}
+}
@@ -0,0 +1,98 @@
+// +build !headless
+
+package main
+
+/**
+Right-click menus for the channel lists and the stream event log, with what can be done with a
+channel: opening its stream in the browser or the player, opening its chat, copying its URL, muting
+its notifications, its notification rules and its past streams
+*/
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rakslice/wxGo/wx"
+)
+
+func (win *MainStatusWindowImpl) bindContextMenus() {
+	// mouse events don't propagate up to the frame, so these are bound on the lists themselves
+	wx.Bind(win, wx.EVT_LIST_ITEM_RIGHT_CLICK, win._on_list_online_right_click, win.list_online.GetId())
+	wx.Bind(win.list_offline, wx.EVT_RIGHT_DOWN, win._on_list_offline_right_click, wx.ID_ANY)
+	wx.Bind(win.list_stream_event_log, wx.EVT_RIGHT_DOWN, win._on_list_stream_event_log_right_click, wx.ID_ANY)
+}
+
+func (win *MainStatusWindowImpl) _on_list_online_right_click(e wx.Event) {
+	win.showListContextMenu(wx.ToListEvent(e).GetIndex(), true)
+}
+
+func (win *MainStatusWindowImpl) _on_list_offline_right_click(e wx.Event) {
+	win.showListContextMenu(win.list_offline.HitTest(wx.ToMouseEvent(e).GetPosition()), false)
+}
+
+func (win *MainStatusWindowImpl) _on_list_stream_event_log_right_click(e wx.Event) {
+	list := win.list_stream_event_log
+	index := list.VirtualHitTest(wx.ToMouseEvent(e).GetPosition().GetY())
+	if index < 0 {
+		return
+	}
+	list.SetSelection(index)
+	channel := win.main_obj.getChannelForStreamEventListEntry(index)
+	if channel == nil {
+		return
+	}
+	win.showChannelMenu(channel, func(in_player bool) {
+		win.main_obj.openStreamEventListEntryWith(index, in_player)
+	})
+}
+
+// Select the channel that was right-clicked, the same as a left click would, and show the menu for it
+func (win *MainStatusWindowImpl) showListContextMenu(index int, isOnline bool) {
+	if index < 0 {
+		return
+	}
+	win.main_obj._list_for_is_online(isOnline).set_selection(index)
+	win._on_list_gen_int(index, isOnline)
+	channel, _ := win.main_obj.getChannelAndStreamForListEntry(isOnline, index)
+	if channel == nil {
+		return
+	}
+	win.showChannelMenu(channel, func(in_player bool) {
+		win.main_obj.openListEntryWith(isOnline, index, in_player)
+	})
+}
+
+// Add an item to a menu that calls action when it's picked
+func addMenuAction(menu wx.Menu, label string, action func()) wx.MenuItem {
+	item := menu.Append(wx.ID_ANY, label)
+	wx.Bind(menu, wx.EVT_MENU, func(e wx.Event) { action() }, item.GetId())
+	return item
+}
+
+// Pop up the menu for a channel; open is called to open its stream in the player or the browser
+func (win *MainStatusWindowImpl) showChannelMenu(channel *ChannelInfo, open func(in_player bool)) {
+	app := win.main_obj
+	menu := wx.NewMenu()
+	addMenuAction(menu, "Open in Browser", func() { open(false) })
+	playerItem := addMenuAction(menu, "Open in Player", func() { open(true) })
+	// without a player command there's nothing to open it with
+	playerItem.Enable(app.has_player())
+	chatItem := addMenuAction(menu, "Open Chat", func() { app.open_chat(channel) })
+	chatItem.Enable(chatPopoutUrl(channel) != "")
+	addMenuAction(menu, "Copy URL", func() { copyToClipboard(channel.Url) })
+	menu.AppendSeparator()
+
+	if mute := app.getChannelMutes().muted_until(channel.Id, time.Now()); mute != nil {
+		addMenuAction(menu, fmt.Sprintf("Unmute Notifications (%s)", mute.desc()), func() { app.unmute_channel(channel) })
+	} else {
+		muteMenu := wx.NewMenu()
+		for _, duration := range muteDurations {
+			length := duration.length
+			addMenuAction(muteMenu, duration.label, func() { app.mute_channel(channel, length) })
+		}
+		menu.AppendSubMenu(muteMenu, "Mute Notifications")
+	}
+	addMenuAction(menu, "Notification Rules...", func() { win.showChannelRules(channel) })
+	addMenuAction(menu, "View History...", func() { win.showChannelHistory(channel) })
+	win.PopupMenu(menu)
+}
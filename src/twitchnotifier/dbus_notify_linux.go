@@ -0,0 +1,188 @@
+// +build linux
+
+package main
+
+/**
+Desktop notifications through the freedesktop.org org.freedesktop.Notifications D-Bus service,
+which unlike wx.NotificationMessage tells us when a notification is clicked, so we can open the
+stream.
+
+See https://specifications.freedesktop.org/notification-spec/latest/
+*/
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	DBUS_NOTIFICATIONS_NAME      = "org.freedesktop.Notifications"
+	DBUS_NOTIFICATIONS_PATH      = "/org/freedesktop/Notifications"
+	DBUS_NOTIFICATIONS_INTERFACE = "org.freedesktop.Notifications"
+
+	// the action for a click on the notification itself
+	DBUS_ACTION_DEFAULT = "default"
+	DBUS_ACTION_OPEN    = "open"
+	DBUS_ACTION_DISMISS = "dismiss"
+
+	DBUS_URGENCY_NORMAL   = byte(1)
+	DBUS_URGENCY_CRITICAL = byte(2)
+)
+
+// A notification that is still on the screen
+type dbusShownNotification struct {
+	key     string
+	on_open func()
+}
+
+type DBusNotifier struct {
+	conn    *dbus.Conn
+	obj     dbus.BusObject
+	signals chan *dbus.Signal
+
+	// runs the action callbacks where they need to go, e.g. on the GUI thread
+	dispatch func(func())
+
+	mutex sync.Mutex
+	shown map[uint32]*dbusShownNotification
+	// the notification still on the screen for each key, which a new one for the key replaces
+	ids_by_key map[string]uint32
+}
+
+// A DBusNotifier on the session bus
+func ConnectDBusNotifier(dispatch func(func())) (*DBusNotifier, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, err
+	}
+	return NewDBusNotifier(conn, dispatch)
+}
+
+/**
+A DBusNotifier using the notification service on the given bus connection. Callbacks for the
+notification actions are passed to dispatch, from the goroutine that handles the D-Bus signals.
+*/
+func NewDBusNotifier(conn *dbus.Conn, dispatch func(func())) (*DBusNotifier, error) {
+	out := &DBusNotifier{}
+	out.conn = conn
+	out.obj = conn.Object(DBUS_NOTIFICATIONS_NAME, DBUS_NOTIFICATIONS_PATH)
+	out.dispatch = dispatch
+	out.shown = make(map[uint32]*dbusShownNotification)
+	out.ids_by_key = make(map[string]uint32)
+
+	// make sure there is a notification service there before we rely on it
+	var capabilities []string
+	err := out.obj.Call(DBUS_NOTIFICATIONS_INTERFACE+".GetCapabilities", 0).Store(&capabilities)
+	if err != nil {
+		return nil, err
+	}
+	hasActions := false
+	for _, capability := range capabilities {
+		if capability == "actions" {
+			hasActions = true
+		}
+	}
+	if !hasActions {
+		return nil, errors.New("the notification service doesn't support actions")
+	}
+
+	rule := "type='signal',interface='" + DBUS_NOTIFICATIONS_INTERFACE + "',path='" + DBUS_NOTIFICATIONS_PATH + "'"
+	err = conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, rule).Err
+	if err != nil {
+		return nil, err
+	}
+	out.signals = make(chan *dbus.Signal, 10)
+	conn.Signal(out.signals)
+	go out.handle_signals()
+
+	return out, nil
+}
+
+/**
+Show a notification, replacing the one for the same key if it's still on the screen. icon is a
+file path or file:// URI, or "" for none. on_open is dispatched if the user clicks the
+notification or its Open button.
+*/
+func (notifier *DBusNotifier) notify(key string, title string, message string, icon string, high_priority bool, on_open func()) (uint32, error) {
+	notifier.mutex.Lock()
+	replaces_id := notifier.ids_by_key[key]
+	notifier.mutex.Unlock()
+
+	actions := []string{DBUS_ACTION_DEFAULT, "Open", DBUS_ACTION_OPEN, "Open", DBUS_ACTION_DISMISS, "Dismiss"}
+	urgency := DBUS_URGENCY_NORMAL
+	if high_priority {
+		urgency = DBUS_URGENCY_CRITICAL
+	}
+	hints := map[string]dbus.Variant{"urgency": dbus.MakeVariant(urgency)}
+
+	var id uint32
+	err := notifier.obj.Call(DBUS_NOTIFICATIONS_INTERFACE+".Notify", 0,
+		"twitch-notifier-go", replaces_id, icon, title, message, actions, hints, int32(-1)).Store(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+	if replaces_id != 0 && replaces_id != id {
+		delete(notifier.shown, replaces_id)
+	}
+	notifier.shown[id] = &dbusShownNotification{key, on_open}
+	notifier.ids_by_key[key] = id
+	return id, nil
+}
+
+func (notifier *DBusNotifier) close_notification(id uint32) error {
+	return notifier.obj.Call(DBUS_NOTIFICATIONS_INTERFACE+".CloseNotification", 0, id).Err
+}
+
+// Stop handling signals; the connection is left open, since the session bus one is shared
+func (notifier *DBusNotifier) Close() {
+	notifier.conn.RemoveSignal(notifier.signals)
+	close(notifier.signals)
+}
+
+func (notifier *DBusNotifier) forget(id uint32) *dbusShownNotification {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+	shown, ok := notifier.shown[id]
+	if !ok {
+		return nil
+	}
+	delete(notifier.shown, id)
+	if notifier.ids_by_key[shown.key] == id {
+		delete(notifier.ids_by_key, shown.key)
+	}
+	return shown
+}
+
+func (notifier *DBusNotifier) handle_signals() {
+	for signal := range notifier.signals {
+		if signal.Path != DBUS_NOTIFICATIONS_PATH || len(signal.Body) < 2 {
+			continue
+		}
+		id, ok := signal.Body[0].(uint32)
+		if !ok {
+			continue
+		}
+		switch signal.Name {
+		case DBUS_NOTIFICATIONS_INTERFACE + ".ActionInvoked":
+			action, _ := signal.Body[1].(string)
+			switch action {
+			case DBUS_ACTION_DEFAULT, DBUS_ACTION_OPEN:
+				shown := notifier.forget(id)
+				if shown != nil && shown.on_open != nil {
+					notifier.dispatch(shown.on_open)
+				}
+				notifier.close_notification(id)
+			case DBUS_ACTION_DISMISS:
+				notifier.forget(id)
+				notifier.close_notification(id)
+			}
+		case DBUS_NOTIFICATIONS_INTERFACE + ".NotificationClosed":
+			notifier.forget(id)
+		}
+	}
+}
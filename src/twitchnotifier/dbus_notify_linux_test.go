@@ -0,0 +1,195 @@
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// A stand-in for the desktop's notification service, which records what it's asked to show
+type fakeNotificationServer struct {
+	mutex   sync.Mutex
+	next_id uint32
+	// the arguments of each Notify call
+	notified []fakeNotifyCall
+	closed   chan uint32
+}
+
+type fakeNotifyCall struct {
+	replaces_id uint32
+	icon        string
+	summary     string
+	body        string
+	actions     []string
+	urgency     byte
+}
+
+func (server *fakeNotificationServer) GetCapabilities() ([]string, *dbus.Error) {
+	return []string{"actions", "body"}, nil
+}
+
+func (server *fakeNotificationServer) Notify(app_name string, replaces_id uint32, icon string, summary string, body string,
+	actions []string, hints map[string]dbus.Variant, timeout int32) (uint32, *dbus.Error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	urgency, _ := hints["urgency"].Value().(byte)
+	server.notified = append(server.notified, fakeNotifyCall{replaces_id, icon, summary, body, actions, urgency})
+	if replaces_id != 0 {
+		return replaces_id, nil
+	}
+	server.next_id += 1
+	return server.next_id, nil
+}
+
+func (server *fakeNotificationServer) CloseNotification(id uint32) *dbus.Error {
+	server.closed <- id
+	return nil
+}
+
+func (server *fakeNotificationServer) lastNotify() fakeNotifyCall {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.notified[len(server.notified)-1]
+}
+
+// Start a private session bus, returning its address and a function to stop it
+func startTestSessionBus(ctx *TestContext) (string, func()) {
+	cmd := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if ctx.assertNoErr(err, "cmd.StdoutPipe()") {
+		return "", nil
+	}
+	if ctx.assertNoErr(cmd.Start(), "starting dbus-daemon") {
+		return "", nil
+	}
+	stop := func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if ctx.assertNoErr(err, "reading the dbus-daemon address") {
+		stop()
+		return "", nil
+	}
+	return strings.TrimSpace(address), stop
+}
+
+func dialTestBus(ctx *TestContext, address string) *dbus.Conn {
+	conn, err := dbus.Dial(address)
+	if ctx.assertNoErr(err, "dbus.Dial()") {
+		return nil
+	}
+	if ctx.assertNoErr(conn.Auth(nil), "conn.Auth()") {
+		conn.Close()
+		return nil
+	}
+	if ctx.assertNoErr(conn.Hello(), "conn.Hello()") {
+		conn.Close()
+		return nil
+	}
+	return conn
+}
+
+// TESTS
+
+func TestDBusNotifier(t *testing.T) {
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("no dbus-daemon to run a test session bus with")
+	}
+	ctx := NewTestCtx(t)
+
+	address, stop := startTestSessionBus(ctx)
+	if stop == nil {
+		return
+	}
+	defer stop()
+
+	serverConn := dialTestBus(ctx, address)
+	if serverConn == nil {
+		return
+	}
+	defer serverConn.Close()
+	server := &fakeNotificationServer{closed: make(chan uint32, 10)}
+	if ctx.assertNoErr(serverConn.Export(server, DBUS_NOTIFICATIONS_PATH, DBUS_NOTIFICATIONS_INTERFACE), "serverConn.Export()") {
+		return
+	}
+	_, err := serverConn.RequestName(DBUS_NOTIFICATIONS_NAME, dbus.NameFlagDoNotQueue)
+	if ctx.assertNoErr(err, "serverConn.RequestName()") {
+		return
+	}
+
+	clientConn := dialTestBus(ctx, address)
+	if clientConn == nil {
+		return
+	}
+	defer clientConn.Close()
+	opened := make(chan string, 10)
+	notifier, err := NewDBusNotifier(clientConn, func(callback func()) { callback() })
+	if ctx.assertNoErr(err, "NewDBusNotifier()") {
+		return
+	}
+	defer notifier.Close()
+
+	id, err := notifier.notify("FakeChannel", "twitch-notifier-go", "FakeChannel is now live", "/tmp/fakelogo.png", true,
+		func() { opened <- "FakeChannel" })
+	if ctx.assertNoErr(err, "notifier.notify()") {
+		return
+	}
+	call := server.lastNotify()
+	if ctx.assert(call.replaces_id == 0 && call.icon == "/tmp/fakelogo.png" && call.body == "FakeChannel is now live" && call.urgency == DBUS_URGENCY_CRITICAL,
+		"unexpected Notify call %+v", call) {
+		return
+	}
+	if ctx.assertStrEqual("default,Open,open,Open,dismiss,Dismiss", strings.Join(call.actions, ","), "notification actions") {
+		return
+	}
+
+	// a newer notification for the channel replaces the one on the screen
+	_, err = notifier.notify("FakeChannel", "twitch-notifier-go", "FakeChannel switched to another vidya game", "", false,
+		func() { opened <- "FakeChannel again" })
+	if ctx.assertNoErr(err, "notifier.notify() for the update") {
+		return
+	}
+	if ctx.assert(server.lastNotify().replaces_id == id, "expected the update to replace notification %v but got %+v", id, server.lastNotify()) {
+		return
+	}
+
+	// clicking Open runs the callback for the latest notification, and closes it
+	if ctx.assertNoErr(serverConn.Emit(DBUS_NOTIFICATIONS_PATH, DBUS_NOTIFICATIONS_INTERFACE+".ActionInvoked", id, DBUS_ACTION_OPEN), "Emit()") {
+		return
+	}
+	select {
+	case which := <-opened:
+		if ctx.assertStrEqual("FakeChannel again", which, "opened notification") {
+			return
+		}
+	case <-time.After(5 * time.Second):
+		ctx.assert(false, "the Open action didn't run the callback")
+		return
+	}
+	select {
+	case closedId := <-server.closed:
+		if ctx.assert(closedId == id, "expected notification %v to be closed but got %v", id, closedId) {
+			return
+		}
+	case <-time.After(5 * time.Second):
+		ctx.assert(false, "the notification wasn't closed after it was opened")
+		return
+	}
+
+	// once it's gone, the next notification for the channel is a new one
+	_, err = notifier.notify("FakeChannel", "twitch-notifier-go", "FakeChannel is now live", "", false, nil)
+	if ctx.assertNoErr(err, "notifier.notify() after the open") {
+		return
+	}
+	if ctx.assert(server.lastNotify().replaces_id == 0, "expected a new notification but got %+v", server.lastNotify()) {
+		return
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+/**
+Exporting the stream history as a list of past streams (channel, start, end, game, title), as CSV,
+JSON Lines, or an iCalendar file with an event per stream. This is the File > Export action in the
+GUI, and the export subcommand:
+
+	twitchnotifier export [-format csv|jsonl|ics] [-history FILE] [-o FILE]
+*/
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	EXPORT_FORMAT_CSV   = "csv"
+	EXPORT_FORMAT_JSONL = "jsonl"
+	EXPORT_FORMAT_ICS   = "ics"
+
+	ICS_PRODID = "-//twitch-notifier-go//Stream History//EN"
+	// iCalendar lines are folded at this many bytes
+	ICS_LINE_LENGTH = 75
+)
+
+type SessionWriter func(w io.Writer, sessions []*StreamSession, now time.Time) error
+
+type ExportFormat struct {
+	name      string
+	extension string
+	// for the save dialog's wildcard
+	description string
+	write       SessionWriter
+}
+
+// in the order they're offered in the save dialog
+var exportFormats = []*ExportFormat{
+	{EXPORT_FORMAT_CSV, ".csv", "CSV files", writeSessionsCSV},
+	{EXPORT_FORMAT_JSONL, ".jsonl", "JSON Lines files", writeSessionsJSONLines},
+	{EXPORT_FORMAT_ICS, ".ics", "iCalendar files", writeSessionsICS},
+}
+
+func exportFormatByName(name string) *ExportFormat {
+	for _, format := range exportFormats {
+		if format.name == name {
+			return format
+		}
+	}
+	return nil
+}
+
+// The format for a filename's extension, or CSV if it doesn't have one we know
+func exportFormatForFilename(filename string) *ExportFormat {
+	extension := strings.ToLower(filepath.Ext(filename))
+	for _, format := range exportFormats {
+		if format.extension == extension {
+			return format
+		}
+	}
+	if extension == ".json" {
+		return exportFormatByName(EXPORT_FORMAT_JSONL)
+	}
+	return exportFormatByName(EXPORT_FORMAT_CSV)
+}
+
+// The wildcard for a wx file dialog, e.g. "CSV files (*.csv)|*.csv|..."
+func exportFormatsWildcard() string {
+	parts := []string{}
+	for _, format := range exportFormats {
+		parts = append(parts, fmt.Sprintf("%s (*%s)|*%s", format.description, format.extension, format.extension))
+	}
+	return strings.Join(parts, "|")
+}
+
+// A session's end time for export, or "" if we don't know it
+func sessionEndString(session *StreamSession) string {
+	if session.End.IsZero() {
+		return ""
+	}
+	return session.End.UTC().Format(time.RFC3339)
+}
+
+// WRITERS
+
+func writeSessionsCSV(w io.Writer, sessions []*StreamSession, now time.Time) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"channel", "start", "end", "game", "title", "url", "stream"})
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		err = writer.Write([]string{
+			session.Channel,
+			session.Start.UTC().Format(time.RFC3339),
+			sessionEndString(session),
+			session.Game,
+			session.Title,
+			session.Url,
+			strconv.FormatUint(uint64(session.Stream), 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+type exportedSession struct {
+	Channel string    `json:"channel"`
+	Start   time.Time `json:"start"`
+	// nil if we don't know when it ended
+	End    *time.Time `json:"end"`
+	Game   string     `json:"game"`
+	Title  string     `json:"title"`
+	Url    string     `json:"url"`
+	Stream StreamID   `json:"stream,omitempty"`
+}
+
+func writeSessionsJSONLines(w io.Writer, sessions []*StreamSession, now time.Time) error {
+	encoder := json.NewEncoder(w)
+	for _, session := range sessions {
+		exported := &exportedSession{
+			Channel: session.Channel,
+			Start:   session.Start.UTC(),
+			Game:    session.Game,
+			Title:   session.Title,
+			Url:     session.Url,
+			Stream:  session.Stream,
+		}
+		if !session.End.IsZero() {
+			end := session.End.UTC()
+			exported.End = &end
+		}
+		// Encode puts each one on its own line
+		err := encoder.Encode(exported)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Escape an iCalendar TEXT value
+func icsEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+func icsTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// Write an iCalendar content line, folded to the maximum line length without splitting a UTF-8 character
+func writeICSLine(w io.Writer, line string) error {
+	for len(line) > ICS_LINE_LENGTH {
+		// continuation lines start with a space, which counts towards their length
+		cut := ICS_LINE_LENGTH
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		_, err := io.WriteString(w, line[:cut]+"\r\n")
+		if err != nil {
+			return err
+		}
+		line = " " + line[cut:]
+	}
+	_, err := io.WriteString(w, line+"\r\n")
+	return err
+}
+
+// A calendar with an event for each stream; now is the DTSTAMP
+func writeSessionsICS(w io.Writer, sessions []*StreamSession, now time.Time) error {
+	lines := []string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:" + ICS_PRODID, "CALSCALE:GREGORIAN"}
+	for _, session := range sessions {
+		uid := fmt.Sprintf("%v-%v@twitch-notifier-go", session.Stream, session.Start.Unix())
+		summary := session.Channel + " streaming"
+		if session.Game != "" {
+			summary += " " + session.Game
+		}
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			"UID:"+uid,
+			"DTSTAMP:"+icsTime(now),
+			"DTSTART:"+icsTime(session.Start))
+		if !session.End.IsZero() {
+			lines = append(lines, "DTEND:"+icsTime(session.End))
+		}
+		lines = append(lines, "SUMMARY:"+icsEscape(summary))
+		if session.Title != "" {
+			lines = append(lines, "DESCRIPTION:"+icsEscape(session.Title))
+		}
+		if session.Url != "" {
+			lines = append(lines, "URL:"+session.Url)
+		}
+		lines = append(lines, "END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, line := range lines {
+		err := writeICSLine(w, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the sessions to a file, in the given format
+func exportSessionsToFile(filename string, format *ExportFormat, sessions []*StreamSession) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = format.write(file, sessions, time.Now())
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// SUBCOMMAND
+
+/**
+The export subcommand: write the saved stream history to a file, or stdout. This reads the
+history database directly, so it can't run while the app has it open.
+*/
+func runExportCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	formatName := flags.String("format", "", "Format to write: csv, jsonl or ics (default from the -o file's extension, or csv)")
+	historyFilename := flags.String("history", "", "Stream history database (default twitchnotifier.history.db in the prefs dir)")
+	outFilename := flags.String("o", "", "File to write to (default stdout)")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	format := exportFormatByName(EXPORT_FORMAT_CSV)
+	if *formatName != "" {
+		format = exportFormatByName(*formatName)
+		if format == nil {
+			return fmt.Errorf("unknown export format '%s'", *formatName)
+		}
+	} else if *outFilename != "" {
+		format = exportFormatForFilename(*outFilename)
+	}
+
+	if *historyFilename == "" {
+		*historyFilename = getHistoryFilename()
+	}
+	if !fileExists(*historyFilename) {
+		return fmt.Errorf("there is no stream history at '%s'", *historyFilename)
+	}
+	// opening the history prunes it, so keep everything here and leave that to the app
+	history, err := OpenStreamHistory(*historyFilename, 0)
+	if err == bolt.ErrTimeout {
+		return fmt.Errorf("the stream history '%s' is in use; quit twitch-notifier or use File > Export instead", *historyFilename)
+	} else if err != nil {
+		return err
+	}
+	defer history.Close()
+	events, err := history.events_since(time.Time{})
+	if err != nil {
+		return err
+	}
+	sessions := historySessions(events)
+
+	if *outFilename == "" {
+		return format.write(stdout, sessions, time.Now())
+	}
+	return exportSessionsToFile(*outFilename, format, sessions)
+}
+
+// Run a subcommand instead of the app, if the command line starts with one. Returns whether it did.
+func runSubcommand(args []string) bool {
+	if len(args) == 0 || args[0] != "export" {
+		return false
+	}
+	err := runExportCommand(args[1:], os.Stdout)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %s\n", err)
+		os.Exit(1)
+	}
+	return true
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newExportTestSessions() []*StreamSession {
+	start := time.Date(2017, 8, 1, 19, 0, 0, 0, time.UTC)
+	return []*StreamSession{
+		{Channel_Id: 1, Channel: "FakeChannel", Url: "https://www.twitch.tv/fakechannel", Stream: 10, Start: start, End: start.Add(2 * time.Hour),
+			Game: "a vidya game", Title: "speedruns, glitches; and\nmore"},
+		{Channel_Id: 2, Channel: "OtherChannel", Url: "https://www.twitch.tv/otherchannel", Stream: 20, Start: start.Add(time.Hour)},
+	}
+}
+
+// TESTS
+
+func TestExportCSV(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	buf := &bytes.Buffer{}
+	if ctx.assertNoErr(writeSessionsCSV(buf, newExportTestSessions(), time.Now()), "writeSessionsCSV()") {
+		return
+	}
+	expected := "channel,start,end,game,title,url,stream\n" +
+		"FakeChannel,2017-08-01T19:00:00Z,2017-08-01T21:00:00Z,a vidya game,\"speedruns, glitches; and\nmore\",https://www.twitch.tv/fakechannel,10\n" +
+		"OtherChannel,2017-08-01T20:00:00Z,,,,https://www.twitch.tv/otherchannel,20\n"
+	if ctx.assertStrEqual(expected, buf.String(), "CSV export") {
+		return
+	}
+}
+
+func TestExportJSONLines(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	buf := &bytes.Buffer{}
+	if ctx.assertNoErr(writeSessionsJSONLines(buf, newExportTestSessions(), time.Now()), "writeSessionsJSONLines()") {
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if ctx.assert(len(lines) == 2, "expected 2 lines but got %v", len(lines)) {
+		return
+	}
+	first := map[string]interface{}{}
+	if ctx.assertNoErr(json.Unmarshal([]byte(lines[0]), &first), "json.Unmarshal() of the first line") {
+		return
+	}
+	if ctx.assert(first["channel"] == "FakeChannel" && first["start"] == "2017-08-01T19:00:00Z" && first["end"] == "2017-08-01T21:00:00Z" &&
+		first["game"] == "a vidya game" && first["stream"] == float64(10),
+		"unexpected first line %v", first) {
+		return
+	}
+	second := map[string]interface{}{}
+	if ctx.assertNoErr(json.Unmarshal([]byte(lines[1]), &second), "json.Unmarshal() of the second line") {
+		return
+	}
+	end, hasEnd := second["end"]
+	if ctx.assert(hasEnd && end == nil, "expected a null end for the unfinished stream but got %v", second) {
+		return
+	}
+}
+
+func TestExportICS(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	sessions := newExportTestSessions()
+	sessions[1].Title = strings.Repeat("é", 50)
+	now := time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)
+	buf := &bytes.Buffer{}
+	if ctx.assertNoErr(writeSessionsICS(buf, sessions, now), "writeSessionsICS()") {
+		return
+	}
+	ics := buf.String()
+
+	expected := "BEGIN:VEVENT\r\n" +
+		"UID:10-1501614000@twitch-notifier-go\r\n" +
+		"DTSTAMP:20170901T000000Z\r\n" +
+		"DTSTART:20170801T190000Z\r\n" +
+		"DTEND:20170801T210000Z\r\n" +
+		"SUMMARY:FakeChannel streaming a vidya game\r\n" +
+		"DESCRIPTION:speedruns\\, glitches\\; and\\nmore\r\n" +
+		"URL:https://www.twitch.tv/fakechannel\r\n" +
+		"END:VEVENT\r\n"
+	if ctx.assert(strings.HasPrefix(ics, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n") && strings.HasSuffix(ics, "END:VCALENDAR\r\n"),
+		"expected a VCALENDAR but got %q", ics) {
+		return
+	}
+	if ctx.assert(strings.Contains(ics, expected), "expected the first stream's event in %q", ics) {
+		return
+	}
+	if ctx.assert(!strings.Contains(ics, "DTEND:20170801T2000"), "the unfinished stream got an end") {
+		return
+	}
+
+	// the long description is folded without splitting a character
+	for _, line := range strings.Split(ics, "\r\n") {
+		if ctx.assert(len(line) <= ICS_LINE_LENGTH, "line longer than %v bytes: %q", ICS_LINE_LENGTH, line) {
+			return
+		}
+	}
+	unfolded := strings.Replace(ics, "\r\n ", "", -1)
+	if ctx.assert(strings.Contains(unfolded, "DESCRIPTION:"+sessions[1].Title+"\r\n"), "the folded description didn't unfold to the title") {
+		return
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	ctx := NewTestCtx(t)
+	filename, cleanup := tempHistoryFilename(ctx)
+	defer cleanup()
+
+	history, err := OpenStreamHistory(filename, 0)
+	if ctx.assertNoErr(err, "OpenStreamHistory()") {
+		return
+	}
+	for _, event := range newStatsTestEvents() {
+		_, err = history.add(event)
+		if ctx.assertNoErr(err, "add()") {
+			return
+		}
+	}
+
+	// the app has the history open
+	err = runExportCommand([]string{"-history", filename}, ioutil.Discard)
+	if ctx.assert(err != nil && strings.Contains(err.Error(), "is in use"), "expected an in use error but got %v", err) {
+		return
+	}
+	if ctx.assertNoErr(history.Close(), "Close()") {
+		return
+	}
+
+	out := &bytes.Buffer{}
+	if ctx.assertNoErr(runExportCommand([]string{"-history", filename, "-format", "jsonl"}, out), "runExportCommand()") {
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if ctx.assert(len(lines) == 7, "expected 7 streams but got %v", len(lines)) {
+		return
+	}
+
+	outFilename := filename + ".ics"
+	if ctx.assertNoErr(runExportCommand([]string{"-history", filename, "-o", outFilename}, ioutil.Discard), "runExportCommand() to a file") {
+		return
+	}
+	buf, err := ioutil.ReadFile(outFilename)
+	if ctx.assertNoErr(err, "ioutil.ReadFile()") {
+		return
+	}
+	if ctx.assert(strings.Count(string(buf), "BEGIN:VEVENT") == 7, "expected 7 events in the exported calendar") {
+		return
+	}
+
+	err = runExportCommand([]string{"-history", filename, "-format", "xml"}, ioutil.Discard)
+	if ctx.assertGotErr("unknown export format 'xml'", err, "runExportCommand() with a bad format") {
+		return
+	}
+}
@@ -0,0 +1,39 @@
+/**
+faketwitch-server runs a faketwitch.Server on localhost, set up from a scenario file.
+
+	go run twitchnotifier/faketwitch/faketwitch-server -scenario scenario.json
+
+Then point the notifier at it with
+
+	twitchnotifier -kraken-url http://localhost:8080/kraken -validate-url http://localhost:8080/oauth2/validate -auth-oauth fakeoauth123
+*/
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"twitchnotifier/faketwitch"
+)
+
+func main() {
+	listen := flag.String("listen", "localhost:8080", "Address to listen on")
+	scenarioFilename := flag.String("scenario", "", "JSON file with the users, follows, stream schedule and errors to serve")
+	flag.Parse()
+
+	server := faketwitch.NewServer()
+	if *scenarioFilename != "" {
+		scenario, err := faketwitch.LoadScenario(*scenarioFilename)
+		if err != nil {
+			log.Fatalf("Couldn't load scenario %s: %s", *scenarioFilename, err)
+		}
+		err = server.Apply(scenario)
+		if err != nil {
+			log.Fatalf("Bad scenario %s: %s", *scenarioFilename, err)
+		}
+	}
+
+	log.Printf("fake twitch listening on http://%s/kraken", *listen)
+	log.Fatal(http.ListenAndServe(*listen, server))
+}
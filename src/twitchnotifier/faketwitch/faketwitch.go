@@ -0,0 +1,523 @@
+/**
+Package faketwitch is a stand-in for the parts of the twitch kraken API that twitch-notifier uses,
+so the notifier and its tests can run against localhost with no network.
+
+The server has a little world of users, follows and live streams that you script up front,
+including streams that go live or offline on a schedule, and errors and latency to inject:
+
+	server := faketwitch.NewServer()
+	server.AddUser("fakeusername", "FakeUsername")
+	server.AddUser("fakechannel", "FakeChannel")
+	server.AddToken("fakeoauth123", "fakeusername")
+	server.Follow("fakeusername", "fakechannel", true)
+	server.ScheduleLive(30*time.Second, "fakechannel", "a vidya game", "some title")
+	http.ListenAndServe("localhost:8080", server)
+
+and then run the notifier with -kraken-url http://localhost:8080/kraken.
+*/
+package faketwitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_PAGE_SIZE = 25
+	MAX_PAGE_SIZE     = 100
+)
+
+// A twitch user, who is also a channel
+type User struct {
+	Id           int64
+	Login        string
+	Display_Name string
+	Logo         *string
+	// the channel status, which is the stream title
+	Status string
+	Game   string
+}
+
+type Follow struct {
+	Channel       *User
+	Notifications bool
+}
+
+// A live stream
+type Stream struct {
+	Id        int64
+	Channel   *User
+	Game      string
+	CreatedAt time.Time
+	Viewers   int
+}
+
+// A change to the world that happens at a set time
+type ScheduledEvent struct {
+	At      time.Time
+	Channel string
+	Live    bool
+	Game    string
+	Title   string
+}
+
+// Requests to paths starting with Path get an error response with Status, Count times
+type InjectedError struct {
+	Path   string
+	Status int
+	Count  int
+}
+
+type Server struct {
+	mutex sync.Mutex
+
+	users   map[string]*User
+	tokens  map[string]string
+	follows map[string][]*Follow
+	streams map[string]*Stream
+
+	schedule []*ScheduledEvent
+	errors   []*InjectedError
+	latency  time.Duration
+
+	nextId int64
+	start  time.Time
+
+	// so tests can move time along
+	Now func() time.Time
+}
+
+func NewServer() *Server {
+	out := &Server{}
+	out.users = make(map[string]*User)
+	out.tokens = make(map[string]string)
+	out.follows = make(map[string][]*Follow)
+	out.streams = make(map[string]*Stream)
+	out.nextId = 1000
+	out.Now = time.Now
+	out.start = out.Now()
+	return out
+}
+
+// SCRIPTING THE WORLD
+
+// Add a user, which is also a channel, and get its id
+func (server *Server) AddUser(login string, displayName string) int64 {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	login = strings.ToLower(login)
+	user, ok := server.users[login]
+	if !ok {
+		server.nextId += 1
+		user = &User{Id: server.nextId, Login: login}
+		server.users[login] = user
+	}
+	user.Display_Name = displayName
+	return user.Id
+}
+
+func (server *Server) SetLogo(login string, logo string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.mustUser(login).Logo = &logo
+}
+
+// Make token a valid OAuth token for the user
+func (server *Server) AddToken(token string, login string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.tokens[token] = strings.ToLower(login)
+}
+
+func (server *Server) RevokeToken(token string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	delete(server.tokens, token)
+}
+
+func (server *Server) Follow(login string, channel string, notifications bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	user := server.mustUser(login)
+	server.follows[user.Login] = append(server.follows[user.Login], &Follow{server.mustUser(channel), notifications})
+}
+
+// Start a stream on the channel now
+func (server *Server) GoLive(channel string, game string, title string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.goLive(channel, game, title, server.Now())
+}
+
+// End the stream on the channel now
+func (server *Server) GoOffline(channel string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	delete(server.streams, strings.ToLower(channel))
+}
+
+func (server *Server) SetViewers(channel string, viewers int) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	stream, ok := server.streams[strings.ToLower(channel)]
+	if ok {
+		stream.Viewers = viewers
+	}
+}
+
+// Start a stream on the channel after the given time from when the server was created
+func (server *Server) ScheduleLive(after time.Duration, channel string, game string, title string) {
+	server.addEvent(&ScheduledEvent{server.start.Add(after), strings.ToLower(channel), true, game, title})
+}
+
+// End the stream on the channel after the given time from when the server was created
+func (server *Server) ScheduleOffline(after time.Duration, channel string) {
+	server.addEvent(&ScheduledEvent{At: server.start.Add(after), Channel: strings.ToLower(channel), Live: false})
+}
+
+// Make the next count requests to paths starting with path get the HTTP status
+func (server *Server) InjectError(path string, status int, count int) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.errors = append(server.errors, &InjectedError{path, status, count})
+}
+
+// Wait this long before answering each request
+func (server *Server) SetLatency(latency time.Duration) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.latency = latency
+}
+
+func (server *Server) addEvent(event *ScheduledEvent) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.schedule = append(server.schedule, event)
+	sort.Stable(eventsByTime(server.schedule))
+}
+
+func (server *Server) mustUser(login string) *User {
+	user, ok := server.users[strings.ToLower(login)]
+	if !ok {
+		panic(fmt.Sprintf("faketwitch: no user '%s'; AddUser() it first", login))
+	}
+	return user
+}
+
+func (server *Server) goLive(channel string, game string, title string, at time.Time) {
+	user := server.mustUser(channel)
+	user.Game = game
+	user.Status = title
+	stream, ok := server.streams[user.Login]
+	if ok {
+		// already live, so this is a game or title change
+		stream.Game = game
+		return
+	}
+	server.nextId += 1
+	server.streams[user.Login] = &Stream{Id: server.nextId, Channel: user, Game: game, CreatedAt: at}
+}
+
+// Apply the scheduled events that are due
+func (server *Server) runSchedule() {
+	now := server.Now()
+	for len(server.schedule) > 0 && !server.schedule[0].At.After(now) {
+		event := server.schedule[0]
+		server.schedule = server.schedule[1:]
+		if event.Live {
+			server.goLive(event.Channel, event.Game, event.Title, event.At)
+		} else {
+			delete(server.streams, event.Channel)
+		}
+	}
+}
+
+// SERVING
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	server.mutex.Lock()
+	latency := server.latency
+	server.mutex.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.runSchedule()
+
+	if server.injectedError(w, req) {
+		return
+	}
+
+	path := strings.Trim(req.URL.Path, "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "oauth2/validate":
+		server.serveValidate(w, req)
+	case path == "kraken":
+		server.serveRoot(w, req)
+	case len(parts) == 5 && parts[0] == "kraken" && parts[1] == "users" && parts[3] == "follows" && parts[4] == "channels":
+		server.serveFollows(w, req, parts[2])
+	case path == "kraken/streams/followed":
+		server.serveStreamsFollowed(w, req)
+	case path == "kraken/streams":
+		server.serveStreams(w, req)
+	default:
+		writeError(w, http.StatusNotFound, "Not Found", fmt.Sprintf("No such endpoint /%s", path))
+	}
+}
+
+func (server *Server) injectedError(w http.ResponseWriter, req *http.Request) bool {
+	for i, injected := range server.errors {
+		if strings.HasPrefix(req.URL.Path, injected.Path) {
+			injected.Count -= 1
+			if injected.Count <= 0 {
+				server.errors = append(server.errors[:i], server.errors[i+1:]...)
+			}
+			writeError(w, injected.Status, http.StatusText(injected.Status), "injected error")
+			return true
+		}
+	}
+	return false
+}
+
+// The user whose token is in the Authorization header, or nil
+func (server *Server) authUser(req *http.Request) *User {
+	auth := req.Header.Get("Authorization")
+	for _, prefix := range []string{"OAuth ", "Bearer "} {
+		if strings.HasPrefix(auth, prefix) {
+			login, ok := server.tokens[strings.TrimPrefix(auth, prefix)]
+			if ok {
+				return server.users[login]
+			}
+		}
+	}
+	return nil
+}
+
+func (server *Server) serveValidate(w http.ResponseWriter, req *http.Request) {
+	user := server.authUser(req)
+	if user == nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"status": 401, "message": "invalid access token"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"client_id":  req.Header.Get("Client-ID"),
+		"login":      user.Login,
+		"user_id":    strconv.FormatInt(user.Id, 10),
+		"scopes":     []string{"user_read"},
+		"expires_in": 3600,
+	})
+}
+
+func (server *Server) serveRoot(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") != "" && server.authUser(req) == nil {
+		writeError(w, http.StatusUnauthorized, "Unauthorized", "invalid oauth token")
+		return
+	}
+	token := map[string]interface{}{"valid": false}
+	user := server.authUser(req)
+	if user != nil {
+		token = map[string]interface{}{"valid": true, "user_name": user.Login, "user_id": user.Id}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"token": token})
+}
+
+func (server *Server) serveFollows(w http.ResponseWriter, req *http.Request, login string) {
+	user, ok := server.users[strings.ToLower(login)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found", fmt.Sprintf("User '%s' does not exist", login))
+		return
+	}
+	follows := server.follows[user.Login]
+	limit, offset, err := pageParams(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request", err.Error())
+		return
+	}
+
+	items := []interface{}{}
+	for i := offset; i < offset+limit && i < len(follows); i++ {
+		items = append(items, map[string]interface{}{
+			"notifications": follows[i].Notifications,
+			"channel":       channelJSON(follows[i].Channel),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"_total": len(follows), "follows": items})
+}
+
+func (server *Server) serveStreamsFollowed(w http.ResponseWriter, req *http.Request) {
+	user := server.authUser(req)
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, "Unauthorized", "authentication failed")
+		return
+	}
+	streams := []*Stream{}
+	for _, follow := range server.follows[user.Login] {
+		stream, ok := server.streams[follow.Channel.Login]
+		if ok {
+			streams = append(streams, stream)
+		}
+	}
+	server.writeStreams(w, req, streams)
+}
+
+// Whether a kraken request asked for API v5, where channels are given by id rather than by name
+func isKrakenV5(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), "application/vnd.twitchtv.v5+json")
+}
+
+func (server *Server) serveStreams(w http.ResponseWriter, req *http.Request) {
+	// v3 takes channel names, v5 takes channel ids
+	v5 := isKrakenV5(req)
+	channelIds := map[int64]bool{}
+	channelLogins := map[string]bool{}
+	channelParam := req.URL.Query().Get("channel")
+	if channelParam != "" {
+		channels := strings.Split(channelParam, ",")
+		for _, channel := range channels {
+			id, err := strconv.ParseInt(channel, 10, 64)
+			if v5 && err != nil {
+				writeError(w, http.StatusBadRequest, "Bad Request", fmt.Sprintf("Bad channel id '%s'", channel))
+				return
+			}
+			if !v5 && err == nil {
+				writeError(w, http.StatusBadRequest, "Bad Request",
+					fmt.Sprintf("Channel '%s' looks like an id; API v3 takes channel names", channel))
+				return
+			}
+			channelIds[id] = true
+			channelLogins[strings.ToLower(channel)] = true
+		}
+		if len(channels) > MAX_PAGE_SIZE {
+			writeError(w, http.StatusBadRequest, "Bad Request", "Too many channels")
+			return
+		}
+	}
+
+	streams := []*Stream{}
+	for _, stream := range server.streams {
+		if channelParam == "" || (v5 && channelIds[stream.Channel.Id]) || (!v5 && channelLogins[stream.Channel.Login]) {
+			streams = append(streams, stream)
+		}
+	}
+	server.writeStreams(w, req, streams)
+}
+
+// Write a page of streams, most viewers first like twitch does
+func (server *Server) writeStreams(w http.ResponseWriter, req *http.Request, streams []*Stream) {
+	limit, offset, err := pageParams(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request", err.Error())
+		return
+	}
+	sort.Sort(streamsByViewers(streams))
+
+	items := []interface{}{}
+	for i := offset; i < offset+limit && i < len(streams); i++ {
+		stream := streams[i]
+		items = append(items, map[string]interface{}{
+			"_id":         stream.Id,
+			"game":        stream.Game,
+			"viewers":     stream.Viewers,
+			"created_at":  stream.CreatedAt.UTC().Format(time.RFC3339),
+			"is_playlist": false,
+			"stream_type": "live",
+			"preview":     previewJSON(stream.Channel),
+			"channel":     channelJSON(stream.Channel),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"_total": len(streams), "streams": items})
+}
+
+type eventsByTime []*ScheduledEvent
+
+func (l eventsByTime) Len() int           { return len(l) }
+func (l eventsByTime) Less(i, j int) bool { return l[i].At.Before(l[j].At) }
+func (l eventsByTime) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+type streamsByViewers []*Stream
+
+func (l streamsByViewers) Len() int { return len(l) }
+func (l streamsByViewers) Less(i, j int) bool {
+	if l[i].Viewers != l[j].Viewers {
+		return l[i].Viewers > l[j].Viewers
+	}
+	return l[i].Id < l[j].Id
+}
+func (l streamsByViewers) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+
+func channelJSON(user *User) map[string]interface{} {
+	return map[string]interface{}{
+		"_id":          user.Id,
+		"name":         user.Login,
+		"display_name": user.Display_Name,
+		"url":          "https://www.twitch.tv/" + user.Login,
+		"status":       user.Status,
+		"game":         user.Game,
+		"logo":         user.Logo,
+	}
+}
+
+// The preview image URLs of a user's stream, in the same form as twitch's
+func previewJSON(user *User) map[string]interface{} {
+	base := "https://static-cdn.jtvnw.net/previews-ttv/live_user_" + user.Login
+	return map[string]interface{}{
+		"small":    base + "-80x45.jpg",
+		"medium":   base + "-320x180.jpg",
+		"large":    base + "-640x360.jpg",
+		"template": base + "-{width}x{height}.jpg",
+	}
+}
+
+func pageParams(req *http.Request) (int, int, error) {
+	limit := DEFAULT_PAGE_SIZE
+	offset := 0
+	query := req.URL.Query()
+	if query.Get("limit") != "" {
+		value, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || value < 1 || value > MAX_PAGE_SIZE {
+			return 0, 0, fmt.Errorf("Bad limit '%s'", query.Get("limit"))
+		}
+		limit = value
+	}
+	if query.Get("offset") != "" {
+		value, err := strconv.Atoi(query.Get("offset"))
+		if err != nil || value < 0 {
+			return 0, 0, fmt.Errorf("Bad offset '%s'", query.Get("offset"))
+		}
+		offset = value
+	}
+	return limit, offset, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// Write an error the way kraken does
+func writeError(w http.ResponseWriter, status int, errorName string, message string) {
+	writeJSON(w, status, map[string]interface{}{"error": errorName, "status": status, "message": message})
+}
@@ -0,0 +1,213 @@
+package faketwitch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestServer() *Server {
+	server := NewServer()
+	server.AddUser("fakeusername", "FakeUsername")
+	server.AddUser("fakechannel", "FakeChannel")
+	server.AddUser("otherchannel", "OtherChannel")
+	server.AddToken("fakeoauth123", "fakeusername")
+	server.Follow("fakeusername", "fakechannel", true)
+	server.Follow("fakeusername", "otherchannel", false)
+	return server
+}
+
+// Make a request to the server and decode the JSON it sends back
+func get(t *testing.T, server *Server, url string, token string) (int, map[string]interface{}) {
+	req := httptest.NewRequest("GET", url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "OAuth "+token)
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+
+	out := map[string]interface{}{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &out)
+	if err != nil {
+		t.Fatalf("Couldn't decode the response to %s: %s", url, err)
+	}
+	return recorder.Code, out
+}
+
+// TESTS
+
+func TestFollowsPaging(t *testing.T) {
+	server := newTestServer()
+
+	status, page := get(t, server, "http://localhost/kraken/users/fakeusername/follows/channels?limit=1&offset=0", "")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200 but got %v", status)
+	}
+	if page["_total"] != float64(2) {
+		t.Errorf("Expected _total 2 but got %v", page["_total"])
+	}
+	follows := page["follows"].([]interface{})
+	if len(follows) != 1 {
+		t.Fatalf("Expected 1 follow on the first page but got %v", len(follows))
+	}
+	channel := follows[0].(map[string]interface{})["channel"].(map[string]interface{})
+	if channel["display_name"] != "FakeChannel" {
+		t.Errorf("Expected FakeChannel first but got %v", channel["display_name"])
+	}
+
+	_, page = get(t, server, "http://localhost/kraken/users/fakeusername/follows/channels?limit=1&offset=2", "")
+	if len(page["follows"].([]interface{})) != 0 {
+		t.Errorf("Expected an empty page past the end but got %v", page["follows"])
+	}
+
+	status, _ = get(t, server, "http://localhost/kraken/users/nobody/follows/channels", "")
+	if status != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown user but got %v", status)
+	}
+}
+
+func TestStreamsFollowedNeedsAuth(t *testing.T) {
+	server := newTestServer()
+	server.GoLive("fakechannel", "a vidya game", "some title")
+
+	status, _ := get(t, server, "http://localhost/kraken/streams/followed", "")
+	if status != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a token but got %v", status)
+	}
+	status, _ = get(t, server, "http://localhost/kraken/streams/followed", "badtoken")
+	if status != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 with a bad token but got %v", status)
+	}
+
+	status, page := get(t, server, "http://localhost/kraken/streams/followed", "fakeoauth123")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200 but got %v", status)
+	}
+	if page["_total"] != float64(1) {
+		t.Errorf("Expected 1 live stream but got %v", page["_total"])
+	}
+
+	server.RevokeToken("fakeoauth123")
+	status, _ = get(t, server, "http://localhost/kraken/streams/followed", "fakeoauth123")
+	if status != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 with a revoked token but got %v", status)
+	}
+}
+
+func TestStreamsChannelNamesAndIds(t *testing.T) {
+	server := newTestServer()
+	server.GoLive("fakechannel", "a vidya game", "some title")
+	channelId := strconv.FormatInt(server.users["fakechannel"].Id, 10)
+
+	_, page := get(t, server, "http://localhost/kraken/streams?channel=FakeChannel,otherchannel", "")
+	if page["_total"] != float64(1) {
+		t.Errorf("Expected 1 live stream by channel name but got %v", page["_total"])
+	}
+
+	// v3 takes names, so an id is a mistake
+	status, _ := get(t, server, "http://localhost/kraken/streams?channel="+channelId, "")
+	if status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a channel id under v3 but got %v", status)
+	}
+
+	// v5 takes ids instead
+	req := httptest.NewRequest("GET", "http://localhost/kraken/streams?channel="+channelId, nil)
+	req.Header.Set("Accept", "application/vnd.twitchtv.v5+json")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for a channel id under v5 but got %v", recorder.Code)
+	}
+	page = map[string]interface{}{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &page)
+	if err != nil {
+		t.Fatalf("Couldn't decode the v5 streams response: %s", err)
+	}
+	if page["_total"] != float64(1) {
+		t.Errorf("Expected 1 live stream by channel id but got %v", page["_total"])
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	server := newTestServer()
+	now := server.start
+	server.Now = func() time.Time { return now }
+	server.ScheduleLive(time.Minute, "fakechannel", "a vidya game", "some title")
+	server.ScheduleOffline(2*time.Minute, "fakechannel")
+
+	url := "http://localhost/kraken/streams?channel=fakechannel"
+
+	_, page := get(t, server, url, "")
+	if page["_total"] != float64(0) {
+		t.Errorf("Expected no streams before the schedule but got %v", page["_total"])
+	}
+
+	now = now.Add(90 * time.Second)
+	_, page = get(t, server, url, "")
+	if page["_total"] != float64(1) {
+		t.Fatalf("Expected the scheduled stream to be live but got %v streams", page["_total"])
+	}
+	stream := page["streams"].([]interface{})[0].(map[string]interface{})
+	if stream["game"] != "a vidya game" {
+		t.Errorf("Expected the scheduled game but got %v", stream["game"])
+	}
+	if stream["created_at"] != server.start.Add(time.Minute).UTC().Format(time.RFC3339) {
+		t.Errorf("Expected the stream to start at the scheduled time but got %v", stream["created_at"])
+	}
+
+	now = now.Add(time.Minute)
+	_, page = get(t, server, url, "")
+	if page["_total"] != float64(0) {
+		t.Errorf("Expected the stream to be offline after the schedule but got %v streams", page["_total"])
+	}
+}
+
+func TestInjectedErrors(t *testing.T) {
+	server := newTestServer()
+	server.InjectError("/kraken/streams", http.StatusServiceUnavailable, 2)
+
+	for i := 0; i < 2; i++ {
+		status, body := get(t, server, "http://localhost/kraken/streams", "")
+		if status != http.StatusServiceUnavailable {
+			t.Errorf("Expected injected status 503 on request %v but got %v", i, status)
+		}
+		if body["status"] != float64(http.StatusServiceUnavailable) {
+			t.Errorf("Expected the status in the error body but got %v", body["status"])
+		}
+	}
+
+	// other paths aren't affected, and the error runs out
+	status, _ := get(t, server, "http://localhost/kraken", "")
+	if status != http.StatusOK {
+		t.Errorf("Expected status 200 for the root but got %v", status)
+	}
+	status, _ = get(t, server, "http://localhost/kraken/streams", "")
+	if status != http.StatusOK {
+		t.Errorf("Expected status 200 after the injected errors ran out but got %v", status)
+	}
+}
+
+func TestScenarioUnknownLogin(t *testing.T) {
+	scenario := &Scenario{}
+	err := json.Unmarshal([]byte(`{
+		"users": [{"login": "fakeusername"}, {"login": "fakechannel"}],
+		"follows": [{"user": "fakeusername", "channel": "fakechannel"}, {"user": "fakeusername", "channel": "typochannel"}]
+	}`), scenario)
+	if err != nil {
+		t.Fatalf("Couldn't decode the scenario: %s", err)
+	}
+
+	err = NewServer().Apply(scenario)
+	if err == nil || err.Error() != "follow 2: no user 'typochannel'; add it to the users" {
+		t.Errorf("Expected an error naming the unknown login but got %v", err)
+	}
+
+	scenario.Follows = scenario.Follows[:1]
+	err = NewServer().Apply(scenario)
+	if err != nil {
+		t.Errorf("Expected the scenario to apply but got %s", err)
+	}
+}
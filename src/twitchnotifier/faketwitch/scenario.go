@@ -0,0 +1,127 @@
+package faketwitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+/**
+A Scenario is the setup for a Server as JSON, so the runnable server can be scripted from a file:
+
+	{
+	  "users": [{"login": "fakeusername", "display_name": "FakeUsername"},
+	            {"login": "fakechannel", "display_name": "FakeChannel", "logo": "https://example.com/logo.png"}],
+	  "tokens": {"fakeoauth123": "fakeusername"},
+	  "follows": [{"user": "fakeusername", "channel": "fakechannel", "notifications": true}],
+	  "events": [{"after": "30s", "channel": "fakechannel", "live": true, "game": "a vidya game", "title": "hi"},
+	             {"after": "5m", "channel": "fakechannel", "live": false}],
+	  "errors": [{"path": "/kraken/streams", "status": 500, "count": 2}],
+	  "latency": "200ms"
+	}
+*/
+type Scenario struct {
+	Users []struct {
+		Login        string
+		Display_Name string
+		Logo         string
+	}
+	Tokens  map[string]string
+	Follows []struct {
+		User          string
+		Channel       string
+		Notifications bool
+	}
+	Events []struct {
+		After   string
+		Channel string
+		Live    bool
+		Game    string
+		Title   string
+	}
+	Errors  []InjectedError
+	Latency string
+}
+
+func LoadScenario(filename string) (*Scenario, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	scenario := &Scenario{}
+	err = json.Unmarshal(buf, scenario)
+	if err != nil {
+		return nil, err
+	}
+	return scenario, nil
+}
+
+// Check that a login in the scenario is one of its users, so a typo is an error rather than a panic
+// when the server gets to it
+func (server *Server) checkUser(what string, login string) error {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if _, ok := server.users[strings.ToLower(login)]; !ok {
+		return fmt.Errorf("%s: no user '%s'; add it to the users", what, login)
+	}
+	return nil
+}
+
+// Set up the server's world from the scenario
+func (server *Server) Apply(scenario *Scenario) error {
+	for _, user := range scenario.Users {
+		displayName := user.Display_Name
+		if displayName == "" {
+			displayName = user.Login
+		}
+		server.AddUser(user.Login, displayName)
+		if user.Logo != "" {
+			server.SetLogo(user.Login, user.Logo)
+		}
+	}
+	for token, login := range scenario.Tokens {
+		err := server.checkUser("token "+token, login)
+		if err != nil {
+			return err
+		}
+		server.AddToken(token, login)
+	}
+	for i, follow := range scenario.Follows {
+		for _, login := range []string{follow.User, follow.Channel} {
+			err := server.checkUser(fmt.Sprintf("follow %v", i+1), login)
+			if err != nil {
+				return err
+			}
+		}
+		server.Follow(follow.User, follow.Channel, follow.Notifications)
+	}
+	for i, event := range scenario.Events {
+		err := server.checkUser(fmt.Sprintf("event %v", i+1), event.Channel)
+		if err != nil {
+			return err
+		}
+		after, err := time.ParseDuration(event.After)
+		if err != nil {
+			return err
+		}
+		if event.Live {
+			server.ScheduleLive(after, event.Channel, event.Game, event.Title)
+		} else {
+			server.ScheduleOffline(after, event.Channel)
+		}
+	}
+	for _, injected := range scenario.Errors {
+		server.InjectError(injected.Path, injected.Status, injected.Count)
+	}
+	if scenario.Latency != "" {
+		latency, err := time.ParseDuration(scenario.Latency)
+		if err != nil {
+			return err
+		}
+		server.SetLatency(latency)
+	}
+	return nil
+}
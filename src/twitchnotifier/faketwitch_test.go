@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"twitchnotifier/faketwitch"
+)
+
+// Options that point the API at a fake twitch server
+func fakeTwitchOptions(server *httptest.Server) *Options {
+	kraken_url := server.URL + "/kraken"
+	validate_url := server.URL + "/oauth2/validate"
+	return &Options{kraken_url: &kraken_url, validate_url: &validate_url}
+}
+
+// TESTS
+
+func TestFakeTwitchEndToEnd(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	twitch := faketwitch.NewServer()
+	twitch.AddUser("fakeusername", "FakeUsername")
+	twitch.AddUser("fakechannel", "FakeChannel")
+	twitch.AddUser("otherchannel", "OtherChannel")
+	twitch.AddToken("fakeoauth123", "fakeusername")
+	twitch.Follow("fakeusername", "fakechannel", true)
+	twitch.Follow("fakeusername", "otherchannel", true)
+	server := httptest.NewServer(twitch)
+	defer server.Close()
+
+	sink := &recordingEventSink{}
+	app := newTestApp(fakeTwitchOptions(server), sink)
+
+	validation, err := app.validateToken("fakeoauth123")
+	if ctx.assertNoErr(err, "validateToken()") {
+		return
+	}
+	if ctx.assertStrEqual("fakeusername", validation.Login, "validated login") {
+		return
+	}
+
+	app.main_loop_iter.next()
+	if ctx.assert(len(sink.events) == 0, "expected no events with nobody live but got %v", len(sink.events)) {
+		return
+	}
+
+	twitch.GoLive("fakechannel", "a vidya game", "some title")
+	app.main_loop_iter.next()
+	if ctx.assert(len(sink.events) == 2, "expected 2 events after going live but got %v", len(sink.events)) {
+		return
+	}
+	if ctx.assertStrEqual(HEADLESS_EVENT_ONLINE, sink.events[0].Event, "first event") {
+		return
+	}
+	if ctx.assertStrEqual("FakeChannel", sink.events[0].Channel, "online channel") {
+		return
+	}
+	if ctx.assertStrEqual("a vidya game", sink.events[0].Game, "online game") {
+		return
+	}
+
+	// a blip on twitch's end is retried without losing track of who's live
+	twitch.InjectError("/kraken/streams/followed", 503, 1)
+	skipRetrySleeps(app.krakenInstance)
+	twitch.GoOffline("fakechannel")
+	app.main_loop_iter.next()
+	if ctx.assert(len(sink.events) == 3, "expected 3 events after going offline but got %v", len(sink.events)) {
+		return
+	}
+	if ctx.assertStrEqual(HEADLESS_EVENT_OFFLINE, sink.events[2].Event, "offline event") {
+		return
+	}
+
+	twitch.RevokeToken("fakeoauth123")
+	app.main_loop_iter.next()
+	if ctx.assert(app.need_reauth, "expected need_reauth after the token was revoked") {
+		return
+	}
+}
+
+func TestFakeTwitchPublicFollows(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	twitch := faketwitch.NewServer()
+	twitch.AddUser("fakeusername", "FakeUsername")
+	twitch.AddUser("fakechannel", "FakeChannel")
+	twitch.Follow("fakeusername", "fakechannel", true)
+	twitch.ScheduleLive(-time.Minute, "fakechannel", "a vidya game", "some title")
+	server := httptest.NewServer(twitch)
+	defer server.Close()
+
+	sink := &recordingEventSink{}
+	app := newTestApp(fakeTwitchOptions(server), sink)
+	username := "fakeusername"
+	app.options.username = &username
+	app._auth_oauth = ""
+	app.main_loop_iter = app.NewChannelWatcher()
+
+	app.main_loop_iter.next()
+	if ctx.assert(len(sink.events) == 2, "expected 2 events for the live stream but got %v", len(sink.events)) {
+		return
+	}
+	if ctx.assertStrEqual("FakeChannel", sink.events[0].Channel, "online channel") {
+		return
+	}
+}
@@ -1,3 +1,5 @@
+// +build !headless
+
 package main
 
 import (
@@ -10,6 +12,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"time"
 )
 
@@ -21,6 +24,24 @@ type NotificationQueueEntry struct {
 	title    string
 	msg      string
 	url      string
+	// goes ahead of the other queued notifications
+	high_priority bool
+	// the channel it's about, so a newer notification can replace it where that's supported
+	channel_name string
+	logo_url     string
+}
+
+// The desktop notification provider for the GUI, which shows notifications through the window's queue
+type OurWindowsBalloonTip struct {
+	main_window *MainStatusWindowImpl
+}
+
+func NewOurWindowsBalloonTip(main_window *MainStatusWindowImpl) *OurWindowsBalloonTip {
+	return &OurWindowsBalloonTip{main_window}
+}
+
+func (tip *OurWindowsBalloonTip) balloon_tip(title string, msg string, callback NotificationCallback, url string) {
+	tip.main_window.enqueue_notification(title, msg, callback, url)
 }
 
 // CONCRETE WINDOW DEFINITION AND CONSTRUCTOR
@@ -48,12 +69,14 @@ type MainStatusWindowImpl struct {
 	timeHelper                      *WxTimeHelper
 
 	copySelectedUrlMenuItem         wx.MenuItem
-}
+	showPopupsMenuItem              wx.MenuItem
 
-//func getTokenFilename() string {
-//	newParts := append(prefsRelativePath(), "twitchnotifier.token")
-//	return userRelativePath(newParts...)
-//}
+	statsPanel                      *ChannelStatsPanel
+	text_channel_filter             wx.SearchCtrl
+
+	online_list                     *OnlineChannelList
+	offline_list                    *OfflineChannelList
+}
 
 func InitMainStatusWindowImpl(testMode bool, replacementOptionsFunc func() *Options) *MainStatusWindowImpl {
 	out := &MainStatusWindowImpl{}
@@ -68,6 +91,7 @@ func InitMainStatusWindowImpl(testMode bool, replacementOptionsFunc func() *Opti
 	out.app = nil
 
 	out.copySelectedUrlMenuItem = nil
+	out.showPopupsMenuItem = nil
 
 	out.notifications_queue_in_progress = false
 	out.notifications_queue = make([]NotificationQueueEntry, 0)
@@ -82,11 +106,18 @@ func InitMainStatusWindowImpl(testMode bool, replacementOptionsFunc func() *Opti
 
 	out.clearLogo()
 
+	out.online_list = NewOnlineChannelList(out, out.list_online)
+	out.offline_list = NewOfflineChannelList(out, out.list_offline)
+
+	out.statsPanel = InitChannelStatsPanel(out)
+	out.initChannelFilter()
+
 	out.additionalBindings()
 
 	wx.Bind(out, wx.EVT_CLOSE_WINDOW, out._on_close, out.GetId())
 
 	wx.Bind(out, wx.EVT_LISTBOX_DCLICK, out._on_list_stream_event_log_dclick, out.list_stream_event_log.GetId())
+	out.bindContextMenus()
 
 	twitch_notifier_main := InitOurTwitchNotifierMain()
 	if replacementOptionsFunc == nil {
@@ -95,89 +126,78 @@ func InitMainStatusWindowImpl(testMode bool, replacementOptionsFunc func() *Opti
 		twitch_notifier_main.options = replacementOptionsFunc()
 	}
 	twitch_notifier_main.window_impl = out
+	if !testMode {
+		twitch_notifier_main.idleDetector = NewSystemIdleDetector()
+	}
 	oauth_option := twitch_notifier_main.options.authorization_oauth
 	msg("oauth option is %s", oauth_option)
 	if oauth_option != nil {
 		twitch_notifier_main._auth_oauth = *oauth_option
 	}
 
-	//tokenFilename := getTokenFilename()
-	//
-	//if twitch_notifier_main._auth_oauth == "" && !testMode && fileExists(tokenFilename) {
-	//	buf, err := ioutil.ReadFile(tokenFilename)
-	//	assert(err == nil, "error reading token file '%s': %s", tokenFilename, err)
-	//	twitch_notifier_main._auth_oauth = string(buf)
-	//}
-	//
-	//if twitch_notifier_main._auth_oauth == "" && !testMode {
-	//	// TODO remove this once we support web OAuth internally
-	//	oauthTokenSite := "http://twitchapps.com/tmi"
-	//	friendlyMessage := "Missing -auth-oauth\n" +
-	//		"\n" +
-	//		"Howdy! Twitch login within twitch-notifier isn't supported yet.\n" +
-	//		"\n" +
-	//		"You'll have to login and get an OAuth token in your browser (e.g. at %s), \n" +
-	//		"and then enter the token at the next prompt, or run:\n" +
-	//	        "\n" +
-	//		"  twitchnotifier -auth-oauth YOUR_TOKEN_HERE\n" +
-	//		"\n" +
-	//		"putting your token in for the placeholder.\n" +
-	//		"Sorry for the inconvenience!\n" +
-	//		"\n" +
-	//		"Click Ok to open %s"
-	//	result := wx.MessageBox(fmt.Sprintf(friendlyMessage, oauthTokenSite, oauthTokenSite), "twitch-notifier-go",
-	//	              wx.OK | wx.CANCEL)
-	//
-	//	enteredToken := false
-	//
-	//	if result == wx.OK {
-	//		webbrowser_open(oauthTokenSite)
-	//
-	//		// let's attempt to get a token from the user
-	//		msg("creating text entry dialog")
-	//		dlg := wx.NewTextEntryDialog(out, "Enter your Twitch OAuth token", fmt.Sprintf("This will be saved in '%s'", tokenFilename), "", wx.OK | wx.CANCEL)
-	//		if dlg.ShowModal() == wx.ID_OK {
-	//			newToken := strings.TrimSpace(dlg.GetValue());
-	//			if newToken != "" {
-	//				buf := []byte(newToken)
-	//				writeErr := ioutil.WriteFile(tokenFilename, buf, 0600)
-	//				assert(writeErr == nil, "Error writing token file '%s': %s", tokenFilename, writeErr)
-	//
-	//				twitch_notifier_main._auth_oauth = newToken
-	//				enteredToken = true
-	//			}
-	//		}
-	//
-	//	}
-	//	if !enteredToken {
-	//		log.Fatal("Exiting due to no auth")
-	//	}
-	//}
+	if twitch_notifier_main._auth_oauth == "" && !testMode {
+		tokenFilename := getTokenFilename()
+		savedToken, err := loadTokenFile(tokenFilename)
+		if err != nil {
+			msg("error reading token file '%s': %s", tokenFilename, err)
+		} else if savedToken != "" {
+			msg("using saved token from '%s'", tokenFilename)
+			twitch_notifier_main._auth_oauth = savedToken
+			twitch_notifier_main._auth_oauth_from_file = true
+		}
+	}
+
 	msg("after oauth setting check")
 	out.main_obj = twitch_notifier_main
+	twitch_notifier_main.channels.add_observer(NewChannelListsView(out))
 
 	if twitch_notifier_main.options.help != nil && *twitch_notifier_main.options.help {
 		flag.Usage()
 		log.Fatal("Showing usage")
 	}
 
-	// get rid of the options button for now
-	out.button_options.Hide()
+	if out.showPopupsMenuItem != nil {
+		no_popups := twitch_notifier_main.options.no_popups
+		out.showPopupsMenuItem.Check(no_popups == nil || !*no_popups)
+	}
 
 	return out
 }
 
+// Add the filter box above the channel lists
+func (win *MainStatusWindowImpl) initChannelFilter() {
+	win.text_channel_filter = wx.NewSearchCtrl(win.panel_1, wx.ID_ANY, "", wx.DefaultPosition, wx.DefaultSize, 0)
+	win.text_channel_filter.SetDescriptiveText("Filter by name, game or title")
+	win.text_channel_filter.ShowCancelButton(true)
+	win.text_channel_filter.SetToolTip("Show just the channels with these words in their name, game or title")
+	// between the channel info and the Online heading
+	win.sizer_7.Insert(1, win.text_channel_filter, 0, wx.EXPAND|wx.TOP|wx.BOTTOM, 5)
+	wx.Bind(win, wx.EVT_TEXT, win._on_text_channel_filter, win.text_channel_filter.GetId())
+	wx.Bind(win, wx.EVT_SEARCHCTRL_CANCEL_BTN, win._on_text_channel_filter_cancel, win.text_channel_filter.GetId())
+}
+
 // CONCRETE WINDOW METHODS
 
+func (win *MainStatusWindowImpl) _on_text_channel_filter(e wx.Event) {
+	win.main_obj.set_channel_filter(win.text_channel_filter.GetValue())
+}
+
+func (win *MainStatusWindowImpl) _on_text_channel_filter_cancel(e wx.Event) {
+	// this sends an EVT_TEXT, which clears the filter
+	win.text_channel_filter.Clear()
+}
+
 func (win *MainStatusWindowImpl) _on_list_gen_int(idx int, wasOnlineList bool) {
 	if idx >= 0 {
 		otherList := win.main_obj._list_for_is_online(!wasOnlineList)
 
-		otherList.SetSelection(-1)
+		otherList.set_selection(-1)
+		win.main_obj.channels.select_entry(win.main_obj.channels.entry_at(wasOnlineList, idx))
 		channel, stream := win.main_obj.getChannelAndStreamForListEntry(wasOnlineList, idx)
 		win.showInfo(channel, stream)
 		win.copySelectedUrlMenuItem.Enable(true)
 	} else {
+		win.main_obj.channels.select_entry(nil)
 		win.clearInfo()
 		win.copySelectedUrlMenuItem.Enable(false)
 	}
@@ -191,11 +211,22 @@ func (win *MainStatusWindowImpl) _on_list_gen(e wx.Event, wasOnlineList bool) {
 }
 
 func (win *MainStatusWindowImpl) _on_list_online_gen(e wx.Event) {
-	win._on_list_gen(e, true)
+	if win.online_list.selecting {
+		return
+	}
+	win._on_list_gen_int(wx.ToListEvent(e).GetIndex(), true)
 }
 
 func (win *MainStatusWindowImpl) _on_list_online_dclick(e wx.Event) {
-	win.main_obj.openSiteForListEntry(true, e)
+	win.main_obj.openSiteForListEntryIndex(true, wx.ToListEvent(e).GetIndex())
+}
+
+func (win *MainStatusWindowImpl) _on_list_online_col_click(e wx.Event) {
+	column := OnlineColumn(wx.ToListEvent(e).GetColumn())
+	if column < 0 || column >= ONLINE_COLUMN_COUNT {
+		return
+	}
+	win.main_obj.set_online_sort(win.main_obj.channels.online_sort.clicked(column))
 }
 
 func (win *MainStatusWindowImpl) _on_list_offline_gen(e wx.Event) {
@@ -208,6 +239,7 @@ func (win *MainStatusWindowImpl) _on_list_offline_dclick(e wx.Event) {
 
 func (win *MainStatusWindowImpl) _on_options_button_click(e wx.Event) {
 	win.main_obj.log("_on_options_button_click")
+	win.showPreferences()
 }
 
 func (win *MainStatusWindowImpl) _on_button_reload_channels_click(e wx.Event) {
@@ -286,6 +318,7 @@ func (win *MainStatusWindowImpl) clearStreamInfo() {
 func (win *MainStatusWindowImpl) clearInfo() {
 	win.button_open_channel.Enable(false)
 	win.clearStreamInfo()
+	win.statsPanel.clear()
 }
 
 func (win *MainStatusWindowImpl) showImageInWxImage(control wx.StaticBitmap, readCloser io.ReadCloser) {
@@ -341,6 +374,8 @@ func (win *MainStatusWindowImpl) showInfo(channel *ChannelInfo, stream *StreamIn
 		win.clearStreamInfo()
 	}
 
+	win.statsPanel.show_channel(channel)
+
 	win.main_obj.cancelDelayedUrlLoadsForContext("channel")
 
 	// set the logo to our default image pending the load of the channel image
@@ -437,6 +472,21 @@ func (win *MainStatusWindowImpl) cancel_timer_callback_immediate() bool {
 	return true
 }
 
+/** Restart the wait in progress with a new length, keeping its callback, e.g. when the poll
+    interval is changed. Returns false if there is no wait in progress.
+ */
+func (win *MainStatusWindowImpl) reschedule_timer(length time.Duration) bool {
+	if win.timer == nil {
+		return false
+	}
+	win.timer.Stop()
+	win.timer = nil
+	cur_callback := win.timer_callback
+	win.timer_callback = nil
+	win.set_timer_with_callback(length, cur_callback)
+	return true
+}
+
 func (win *MainStatusWindowImpl) _timer_internal_callback() {
 	win.timer = nil
 	cur_callback := win.timer_callback
@@ -461,8 +511,19 @@ func (win *MainStatusWindowImpl) set_balloon_click_callback(callback func() erro
 }
 
 func (win *MainStatusWindowImpl) enqueue_notification(title string, msg string, callback NotificationCallback, url string) {
-	notification := NotificationQueueEntry{callback.callback, title, msg, url}
-	win.notifications_queue = append(win.notifications_queue, notification)
+	notification := NotificationQueueEntry{callback.callback, title, msg, url, callback.high_priority, callback.channel_name, callback.logo_url}
+	if notification.high_priority {
+		// put it after any other high priority notifications waiting, but before the rest
+		pos := 0
+		for pos < len(win.notifications_queue) && win.notifications_queue[pos].high_priority {
+			pos++
+		}
+		win.notifications_queue = append(win.notifications_queue, NotificationQueueEntry{})
+		copy(win.notifications_queue[pos+1:], win.notifications_queue[pos:])
+		win.notifications_queue[pos] = notification
+	} else {
+		win.notifications_queue = append(win.notifications_queue, notification)
+	}
 	if !win.notifications_queue_in_progress {
 		// kick off the notification cycle
 		win._dispense_remaining_notifications()
@@ -524,8 +585,8 @@ func (win *MainStatusWindowImpl) _on_close(e wx.Event) {
 }
 
 func (win *MainStatusWindowImpl) _on_button_open_channel_click(e wx.Event) {
-	onlineSelection := win.list_online.GetSelection()
-	offlineSelection := win.list_offline.GetSelection()
+	onlineSelection := win.online_list.selection()
+	offlineSelection := win.offline_list.selection()
 	if onlineSelection != -1 {
 		win.main_obj.openSiteForListEntryIndex(true, onlineSelection)
 	} else if offlineSelection != -1 {
@@ -561,12 +622,22 @@ func (win *MainStatusWindowImpl) createMenuBar(menuInAppWindow bool) wx.MenuBar
 	reloadChannelsItem := menu.Append(wx.ID_ANY, "Reload Channels\tCtrl-R")
 	wx.Bind(menuBar, wx.EVT_MENU, win.onMenuReloadChannels, reloadChannelsItem.GetId())
 
+	showPopupsItem := menu.AppendCheckItem(wx.ID_ANY, "Show Notifications")
+	wx.Bind(menuBar, wx.EVT_MENU, win.onMenuShowPopups, showPopupsItem.GetId())
+	win.showPopupsMenuItem = showPopupsItem
+
+	prefsItem := menu.Append(wx.ID_PREFERENCES, "Preferences...")
+	wx.Bind(menuBar, wx.EVT_MENU, win.onMenuPrefs, prefsItem.GetId())
+
 	// for future use:
 	// menu.Append(wx.ID_HELP, "Help")
-	// menu.Append(wx.ID_PREFERENCES, "Preferences")
-	// wx.Bind(menuBar, wx.EVT_MENU, win.onMenuPrefs, wx.ID_PREFERENCES)
 	menu.Append(wx.ID_EXIT, "Quit twitch-notifier-go")
 
+	fileMenu := wx.NewMenu()
+	exportItem := fileMenu.Append(wx.ID_ANY, "Export Stream History...\tCtrl-E")
+	wx.Bind(menuBar, wx.EVT_MENU, win.onMenuExport, exportItem.GetId())
+
+	menuBar.Append(fileMenu, "File")
 	menuBar.Append(menu, "Info")
 
 	if menuInAppWindow {
@@ -589,7 +660,7 @@ func (win *MainStatusWindowImpl) onMenuHideGUI(e wx.Event) {
 func (win *MainStatusWindowImpl) getSelectedItemURL() (string, bool) {
 	for _, online := range []bool {true, false} {
 		list := win.main_obj._list_for_is_online(online)
-		idx := list.GetSelection()
+		idx := list.selection()
 		if idx >= 0 {
 			url, found := win.main_obj.getUrlForListEntry(online, idx)
 			if found {
@@ -604,14 +675,18 @@ func (win *MainStatusWindowImpl) onMenuCopySelectedURL(e wx.Event) {
 	url, found := win.getSelectedItemURL()
 
 	if found {
-		clipboard := wx.NewClipboard()
-		if !clipboard.IsOpened() {
-			clipboard.Open()
-			defer clipboard.Close()
-			clipData := wx.NewTextDataObject()
-			clipData.SetText(url)
-			clipboard.SetData(clipData)
-		}
+		copyToClipboard(url)
+	}
+}
+
+func copyToClipboard(text string) {
+	clipboard := wx.NewClipboard()
+	if !clipboard.IsOpened() {
+		clipboard.Open()
+		defer clipboard.Close()
+		clipData := wx.NewTextDataObject()
+		clipData.SetText(text)
+		clipboard.SetData(clipData)
 	}
 }
 
@@ -620,16 +695,66 @@ func (win *MainStatusWindowImpl) onMenuReloadChannels(e wx.Event) {
 	win.main_obj.doChannelsReload()
 }
 
+func (win *MainStatusWindowImpl) onMenuShowPopups(e wx.Event) {
+	msg("onMenuShowPopups")
+	showPopups := wx.ToCommandEvent(e).IsChecked()
+	win.main_obj.change_setting("no-popups", strconv.FormatBool(!showPopups))
+}
+
 func (win *MainStatusWindowImpl) onMenuAbout(e wx.Event) {
 	msg("onMenuAbout")
 	showAboutBox()
 }
 
-// For future use:
-// func (win *MainStatusWindowImpl) onMenuPrefs(e wx.Event) {
-// 	msg("onMenuPrefs")
-// 	wx.MessageBox("not implemented yet")
-// }
+func (win *MainStatusWindowImpl) onMenuPrefs(e wx.Event) {
+	msg("onMenuPrefs")
+	win.showPreferences()
+}
+
+func (win *MainStatusWindowImpl) onMenuExport(e wx.Event) {
+	msg("onMenuExport")
+	win.exportStreamHistory()
+}
+
+/**
+Ask where to save a file. wildcard is in the wx form, e.g. "CSV files (*.csv)|*.csv". Returns the
+index of the file type that was picked, and false if the dialog was cancelled.
+*/
+func (win *MainStatusWindowImpl) chooseSaveFile(message string, defaultFile string, wildcard string) (string, int, bool) {
+	dialog := wx.NewFileDialog(win, message, "", defaultFile, wildcard, wx.FD_SAVE|wx.FD_OVERWRITE_PROMPT)
+	defer dialog.Destroy()
+	if dialog.ShowModal() != wx.ID_OK {
+		return "", 0, false
+	}
+	return dialog.GetPath(), dialog.GetFilterIndex(), true
+}
+
+// Save the past streams from the history in the format the user picks
+func (win *MainStatusWindowImpl) exportStreamHistory() {
+	app := win.main_obj
+	sessions, err := app.stream_sessions()
+	if err != nil {
+		wx.MessageBox(fmt.Sprintf("Can't export the stream history: %s", err), "twitch-notifier", wx.OK|wx.ICON_WARNING)
+		return
+	}
+
+	filename, filterIndex, ok := win.chooseSaveFile("Export stream history", "twitchnotifier-history.csv", exportFormatsWildcard())
+	if !ok {
+		return
+	}
+	// go by the extension if there is one we know, otherwise the file type picked in the dialog
+	format := exportFormatForFilename(filename)
+	if filepath.Ext(filename) == "" && filterIndex >= 0 && filterIndex < len(exportFormats) {
+		format = exportFormats[filterIndex]
+		filename += format.extension
+	}
+	err = exportSessionsToFile(filename, format, sessions)
+	if err != nil {
+		wx.MessageBox(fmt.Sprintf("Error exporting the stream history to '%s': %s", filename, err), "twitch-notifier", wx.OK|wx.ICON_WARNING)
+		return
+	}
+	app.log(fmt.Sprintf("Exported %v streams to %s", len(sessions), filename))
+}
 
 // ABOUT BOX
 
@@ -672,6 +797,10 @@ func preApp() {
 }
 
 func commonMain(replacementOptionsFunc func() *Options) {
+	if runSubcommand(os.Args[1:]) {
+		return
+	}
+
 	preApp()
 
 	app := wx.NewApp("twitch-notifier-go")
@@ -691,6 +820,10 @@ func commonMain(replacementOptionsFunc func() *Options) {
 	// we're doing this in set_timeout so that it happens inside app.MainLoop() -- otherwise
 	// the wx thread safeguard gets confused
 	frame.set_timeout(0, frame.main_obj.main_loop_main_window_timer)
+	frame.set_timeout(CONFIG_CHECK_INTERVAL, frame.main_obj.watch_config)
+	if frame.main_obj.idleDetector != nil {
+		frame.set_timeout(IDLE_CHECK_INTERVAL, frame.main_obj.watch_idle)
+	}
 
 	msg("starting main loop")
 	app.MainLoop()
@@ -0,0 +1,359 @@
+package main
+
+/**
+The headless notifier drives a ChannelWatcher from a plain timer loop, and reports the stream events
+to sinks (stdout, a log file, hook commands) instead of to a GUI, so it can run on a server or in a
+container without wxWidgets or a display.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// HEADLESS EVENTS AND SINKS
+
+// A stream state change or notification reported by the headless notifier
+type HeadlessEvent struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Channel string    `json:"channel"`
+	Url     string    `json:"url"`
+	Game    string    `json:"game,omitempty"`
+	Title   string    `json:"title,omitempty"`
+	Message string    `json:"message,omitempty"`
+	// the stream the event is about, for online and change events
+	Stream  StreamID   `json:"stream,omitempty"`
+	Started *time.Time `json:"started,omitempty"`
+	// "high" for notifications a rule asked to stand out
+	Priority string `json:"priority,omitempty"`
+}
+
+const (
+	HEADLESS_EVENT_ONLINE  = "online"
+	HEADLESS_EVENT_OFFLINE = "offline"
+	HEADLESS_EVENT_NOTIFY  = "notify"
+	// a live stream switched games or changed its title
+	HEADLESS_EVENT_GAME_CHANGE  = "game_change"
+	HEADLESS_EVENT_TITLE_CHANGE = "title_change"
+
+	HEADLESS_PRIORITY_HIGH = "high"
+)
+
+// Somewhere the headless notifier can send its events
+type HeadlessEventSink interface {
+	stream_event(event *HeadlessEvent)
+}
+
+// The event for a channel going online or offline
+func (app *TwitchNotifierMain) new_stream_state_event(channel *ChannelInfo, new_online bool, stream *StreamInfo) *HeadlessEvent {
+	event := &HeadlessEvent{Channel: channel.Display_Name, Url: channel.Url}
+	if new_online {
+		event.Event = HEADLESS_EVENT_ONLINE
+		event.Time = app.get_stream_start_time_or_now(stream)
+		if stream.Game != nil {
+			event.Game = *stream.Game
+		}
+		event.Title = channel.Status
+		event.Stream = stream.Id
+		event.Started = &event.Time
+	} else {
+		event.Event = HEADLESS_EVENT_OFFLINE
+		event.Time = time.Now()
+	}
+	return event
+}
+
+// The event for a game or title change on a live stream
+func (app *TwitchNotifierMain) new_stream_change_event(stream *StreamInfo, change *StreamChange) *HeadlessEvent {
+	channel := stream.Channel
+	event := &HeadlessEvent{Time: time.Now(), Channel: channel.Display_Name, Url: channel.Url, Title: channel.Status}
+	if stream.Game != nil {
+		event.Game = *stream.Game
+	}
+	if change.kind == STREAM_CHANGE_GAME {
+		event.Event = HEADLESS_EVENT_GAME_CHANGE
+	} else {
+		event.Event = HEADLESS_EVENT_TITLE_CHANGE
+	}
+	event.Stream = stream.Id
+	started := app.get_stream_start_time_or_now(stream)
+	event.Started = &started
+	return event
+}
+
+// Writes events to an io.Writer one per line, either as text or as JSON
+type WriterEventSink struct {
+	writer     io.Writer
+	jsonFormat bool
+}
+
+func NewWriterEventSink(writer io.Writer, jsonFormat bool) *WriterEventSink {
+	return &WriterEventSink{writer, jsonFormat}
+}
+
+func (sink *WriterEventSink) stream_event(event *HeadlessEvent) {
+	var line string
+	if sink.jsonFormat {
+		buf, err := json.Marshal(event)
+		if err != nil {
+			msg("error marshalling event: %s", err)
+			return
+		}
+		line = string(buf)
+	} else {
+		line = fmt.Sprintf("%s %s %s %s", event.Time.Format(time.RFC3339), event.Event, event.Channel, event.Url)
+		if event.Game != "" {
+			line += fmt.Sprintf(" [%s]", event.Game)
+		}
+		if event.Title != "" {
+			line += fmt.Sprintf(" '%s'", event.Title)
+		}
+		if event.Priority != "" {
+			line += fmt.Sprintf(" (%s priority)", event.Priority)
+		}
+		if event.Message != "" {
+			line += fmt.Sprintf(": %s", event.Message)
+		}
+	}
+	_, err := fmt.Fprintln(sink.writer, line)
+	if err != nil {
+		msg("error writing event: %s", err)
+	}
+}
+
+// Runs a shell command for each online or offline event, with the event details in the environment
+type HookEventSink struct {
+	on_online  string
+	on_offline string
+}
+
+func NewHookEventSink(on_online string, on_offline string) *HookEventSink {
+	return &HookEventSink{on_online, on_offline}
+}
+
+func (sink *HookEventSink) stream_event(event *HeadlessEvent) {
+	var command string
+	switch event.Event {
+	case HEADLESS_EVENT_ONLINE:
+		command = sink.on_online
+	case HEADLESS_EVENT_OFFLINE:
+		command = sink.on_offline
+	}
+	if command == "" {
+		return
+	}
+
+	cmd := shellCommand(command)
+	cmd.Env = append(os.Environ(),
+		"TWITCH_EVENT="+event.Event,
+		"TWITCH_CHANNEL="+event.Channel,
+		"TWITCH_URL="+event.Url,
+		"TWITCH_GAME="+event.Game,
+		"TWITCH_TITLE="+event.Title,
+		"TWITCH_TIME="+event.Time.Format(time.RFC3339))
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Start()
+	if err != nil {
+		msg("error running %s hook '%s': %s", event.Event, command, err)
+		return
+	}
+	// reap the hook process without holding up the poll
+	go func() {
+		waitErr := cmd.Wait()
+		if waitErr != nil {
+			msg("%s hook '%s' failed: %s", event.Event, command, waitErr)
+		}
+	}()
+}
+
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/c", command)
+	}
+	return exec.Command("/bin/sh", "-c", command)
+}
+
+// HEADLESS APP CLASS
+
+// Extends TwitchNotifierMain to keep track of which channels are online and report the changes
+// to event sinks
+type HeadlessTwitchNotifierMain struct {
+	TwitchNotifierMain
+	sinks                     []HeadlessEventSink
+	channel_by_id             map[ChannelID]*ChannelInfo
+	online_channels           map[ChannelID]bool
+	previously_online_streams map[ChannelID]bool
+	main_loop_iter            *ChannelWatcher
+}
+
+func InitHeadlessTwitchNotifierMain(options *Options, sinks []HeadlessEventSink) *HeadlessTwitchNotifierMain {
+	out := &HeadlessTwitchNotifierMain{}
+	out.TwitchNotifierMain = *InitTwitchNotifierMain()
+	out.mainEventsInterface = out
+	out.windows_balloon_tip_obj = out
+	out.options = options
+	out.sinks = sinks
+	out.channel_by_id = make(map[ChannelID]*ChannelInfo)
+	out.online_channels = make(map[ChannelID]bool)
+	out.previously_online_streams = make(map[ChannelID]bool)
+	return out
+}
+
+func (app *HeadlessTwitchNotifierMain) send_event(event *HeadlessEvent) {
+	for _, sink := range app.sinks {
+		sink.stream_event(event)
+	}
+	app.send_webhook_event(event)
+}
+
+func (app *HeadlessTwitchNotifierMain) init_channel_display(followed_channel_entries []*ChannelInfo) {
+	app.channel_by_id = make(map[ChannelID]*ChannelInfo)
+	for _, channel := range followed_channel_entries {
+		app.channel_by_id[channel.Id] = channel
+	}
+	// forget about channels that aren't followed anymore
+	for channel_id := range app.online_channels {
+		_, ok := app.channel_by_id[channel_id]
+		if !ok {
+			delete(app.online_channels, channel_id)
+		}
+	}
+	app.log(fmt.Sprintf("Watching %v channels", len(followed_channel_entries)))
+}
+
+func (app *HeadlessTwitchNotifierMain) stream_state_change(channel_id ChannelID, new_online bool, stream *StreamInfo) {
+	delete(app.previously_online_streams, channel_id)
+
+	channel, ok := app.channel_by_id[channel_id]
+	if !ok {
+		msg("skipping channel id %v state change check", channel_id)
+		return
+	}
+	if stream != nil && stream.Channel != nil {
+		channel = stream.Channel
+		app.channel_by_id[channel_id] = channel
+	}
+
+	old_online := app.online_channels[channel_id]
+	if old_online == new_online {
+		return
+	}
+
+	if new_online {
+		app.online_channels[channel_id] = true
+	} else {
+		delete(app.online_channels, channel_id)
+	}
+	event := app.new_stream_state_event(channel, new_online, stream)
+	app.record_stream_history(channel, new_online, stream, event.Time)
+	app.send_event(event)
+}
+
+func (app *HeadlessTwitchNotifierMain) stream_change(channel_id ChannelID, stream *StreamInfo, change *StreamChange) {
+	app.send_event(app.new_stream_change_event(stream, change))
+}
+
+func (app *HeadlessTwitchNotifierMain) assume_all_streams_offline() {
+	app.previously_online_streams = make(map[ChannelID]bool)
+	for channel_id := range app.online_channels {
+		app.previously_online_streams[channel_id] = true
+	}
+}
+
+func (app *HeadlessTwitchNotifierMain) done_state_changes() {
+	// streams we saw in the previous update that we haven't seen again have gone offline
+	for channel_id := range app.previously_online_streams {
+		app.stream_state_change(channel_id, false, nil)
+	}
+	app.previously_online_streams = make(map[ChannelID]bool)
+}
+
+func (app *HeadlessTwitchNotifierMain) _channels_reload_complete() {
+
+}
+
+func (app *HeadlessTwitchNotifierMain) log(message string) {
+	log.Printf("twitch-notifier: %s", message)
+}
+
+// WindowsBalloonTipInterface implementation, so that notify_for_stream notifications become events
+func (app *HeadlessTwitchNotifierMain) balloon_tip(title string, message string, callback NotificationCallback, url string) {
+	event := &HeadlessEvent{
+		Time:    time.Now(),
+		Event:   HEADLESS_EVENT_NOTIFY,
+		Channel: callback.channel_name,
+		Url:     url,
+		Message: message,
+	}
+	if callback.high_priority {
+		event.Priority = HEADLESS_PRIORITY_HIGH
+	}
+	app.send_event(event)
+}
+
+// HEADLESS SCHEDULER
+
+var errTokenRejected = errors.New("The OAuth token was rejected; run again with a new -auth-oauth token")
+
+/**
+Poll with the ChannelWatcher until something arrives on stop. Returns errTokenRejected if twitch
+stops accepting the token, as there's no browser to login again with.
+*/
+func (app *HeadlessTwitchNotifierMain) run(stop <-chan os.Signal) error {
+	app.main_loop_iter = app.NewChannelWatcher()
+	for {
+		next_wait := app.main_loop_iter.next()
+		app.log(next_wait.reason)
+
+		if app.need_reauth {
+			return errTokenRejected
+		}
+
+		if !app.wait_for_next_poll(next_wait.length, stop) {
+			return nil
+		}
+	}
+}
+
+// Send what's still queued for the webhooks and close the stream history, before we exit
+func (app *HeadlessTwitchNotifierMain) shutdown() {
+	app.flush_webhook_sinks()
+	if app.streamHistory != nil {
+		err := app.streamHistory.Close()
+		if err != nil {
+			msg("Error closing the stream history: %s", err)
+		}
+		app.streamHistory = nil
+	}
+}
+
+// Wait until it's time for the next poll, or until the config changes. Returns false if we got a stop signal.
+func (app *HeadlessTwitchNotifierMain) wait_for_next_poll(length time.Duration, stop <-chan os.Signal) bool {
+	configTicker := time.NewTicker(CONFIG_CHECK_INTERVAL)
+	defer configTicker.Stop()
+	pollTime := time.After(length)
+	for {
+		select {
+		case sig := <-stop:
+			app.log(fmt.Sprintf("Got %s, stopping", sig))
+			return false
+		case <-configTicker.C:
+			if app.check_config() {
+				// poll right away with the new settings
+				return true
+			}
+		case <-pollTime:
+			return true
+		}
+	}
+}
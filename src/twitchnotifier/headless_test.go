@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+// Collects the events it gets so tests can check them
+type recordingEventSink struct {
+	events []*HeadlessEvent
+}
+
+func (sink *recordingEventSink) stream_event(event *HeadlessEvent) {
+	sink.events = append(sink.events, event)
+}
+
+func registerHeadlessFollows() {
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken",
+		httpmock.NewStringResponder(200, `{"token": {"user_name": "fakeusername"}}`))
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/users/fakeusername/follows/channels?limit=1&offset=0",
+		httpmock.NewStringResponder(200, `{"_total": 1, "follows": [{"notifications": true, "channel": {
+		  "_id": 123,
+		  "display_name": "FakeChannel",
+		  "url": "https://twitch.tv/fakechannel",
+		  "status": "somestatus",
+		  "logo": null
+		}}]}`))
+}
+
+func registerHeadlessStreams(online bool) {
+	body := `{"_total": 0, "streams": []}`
+	if online {
+		body = `{"_total": 1, "streams": [
+			{"channel": {
+				  "_id": 123,
+				  "display_name": "FakeChannel",
+				  "url": "https://twitch.tv/fakechannel",
+				  "status": "somestatus",
+				  "logo": null
+				},
+			 "is_playlist": false,
+			 "_id": 456,
+			 "created_at": "2016-01-01T01:01:01Z",
+			 "game": "a vidya game"
+			}
+		]}`
+	}
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/streams/followed?limit=1&offset=0&stream_type=live",
+		httpmock.NewStringResponder(200, body))
+}
+
+// TESTS
+
+func TestHeadlessOnlineThenOffline(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	sink := &recordingEventSink{}
+	app := newTestApp(&Options{}, sink)
+
+	registerHeadlessFollows()
+	registerHeadlessStreams(true)
+
+	app.main_loop_iter.next()
+
+	// the first poll gives us the stream going online and the notification for it
+	if ctx.assert(len(sink.events) == 2, "expected 2 events after the first poll but got %v", len(sink.events)) {
+		return
+	}
+	online := sink.events[0]
+	if ctx.assertStrEqual(HEADLESS_EVENT_ONLINE, online.Event, "first event") {
+		return
+	}
+	if ctx.assertStrEqual("FakeChannel", online.Channel, "online channel") {
+		return
+	}
+	if ctx.assertStrEqual("a vidya game", online.Game, "online game") {
+		return
+	}
+	if ctx.assertStrEqual(HEADLESS_EVENT_NOTIFY, sink.events[1].Event, "second event") {
+		return
+	}
+
+	// still online, so nothing new
+	registerHeadlessStreams(true)
+	app.main_loop_iter.next()
+	if ctx.assert(len(sink.events) == 2, "expected no new events while still online but got %v", len(sink.events)) {
+		return
+	}
+
+	registerHeadlessStreams(false)
+	app.main_loop_iter.next()
+
+	if ctx.assert(len(sink.events) == 3, "expected 3 events after going offline but got %v", len(sink.events)) {
+		return
+	}
+	if ctx.assertStrEqual(HEADLESS_EVENT_OFFLINE, sink.events[2].Event, "offline event") {
+		return
+	}
+	if ctx.assert(!app.online_channels[123], "channel still considered online after going offline") {
+		return
+	}
+}
+
+func TestHeadlessReauthNeeded(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	app := newTestApp(&Options{})
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken",
+		httpmock.NewStringResponder(401, `{"error": "Unauthorized", "status": 401}`))
+
+	err := app.run(make(chan os.Signal))
+	if ctx.assert(app.need_reauth, "expected need_reauth after a 401") {
+		return
+	}
+	if ctx.assert(err == errTokenRejected, "expected run() to return errTokenRejected but got %v", err) {
+		return
+	}
+}
+
+func TestWriterEventSink(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	eventTime, err := convert_rfc3339_time("2016-01-01T01:01:01Z")
+	if ctx.assertNoErr(err, "convert_rfc3339_time()") {
+		return
+	}
+	event := &HeadlessEvent{
+		Time:    eventTime,
+		Event:   HEADLESS_EVENT_ONLINE,
+		Channel: "FakeChannel",
+		Url:     "https://twitch.tv/fakechannel",
+		Game:    "a vidya game",
+	}
+
+	var textBuf bytes.Buffer
+	NewWriterEventSink(&textBuf, false).stream_event(event)
+	if ctx.assertStrEqual("2016-01-01T01:01:01Z online FakeChannel https://twitch.tv/fakechannel [a vidya game]\n",
+		textBuf.String(), "text event line") {
+		return
+	}
+
+	var jsonBuf bytes.Buffer
+	NewWriterEventSink(&jsonBuf, true).stream_event(event)
+	if ctx.assert(strings.HasSuffix(jsonBuf.String(), "\n"), "JSON event was not on its own line") {
+		return
+	}
+	decoded := &HeadlessEvent{}
+	err = json.Unmarshal(jsonBuf.Bytes(), decoded)
+	if ctx.assertNoErr(err, "json.Unmarshal()") {
+		return
+	}
+	if ctx.assertStrEqual("FakeChannel", decoded.Channel, "decoded channel") {
+		return
+	}
+	if ctx.assert(decoded.Time.Equal(event.Time), "decoded time %s didn't match %s", decoded.Time, event.Time) {
+		return
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+/**
+Access to the current twitch.tv "helix" HTTP API.
+
+Helix shares the request plumbing of the Kraken wrapper, and its lists are paged with
+CursorPagedKraken. Its JSON is shaped differently, so this also has what we need
+to turn helix responses into the same ChannelInfo and StreamInfo values the kraken API gives us.
+*/
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const HELIX_API_ROOT = "https://api.twitch.tv/helix"
+
+// The maximum number of ids helix accepts in one /users request
+const HELIX_MAX_IDS_PER_REQUEST = 100
+
+type Helix struct {
+	Kraken
+}
+
+func InitHelix(options ...KrakenOption) *Helix {
+	out := &Helix{}
+	out.Kraken = *InitKraken(append([]KrakenOption{WithBaseURL(HELIX_API_ROOT)}, options...)...)
+	return out
+}
+
+// HELIX RESPONSE DATA STRUCTURES
+
+type helixUser struct {
+	Id                string
+	Login             string
+	Display_Name      string
+	Profile_Image_Url string
+}
+
+type helixFollowedChannel struct {
+	Broadcaster_Id    string
+	Broadcaster_Login string
+	Broadcaster_Name  string
+}
+
+type helixStream struct {
+	Id            string
+	User_Id       string
+	User_Login    string
+	User_Name     string
+	Game_Name     string
+	Type          string
+	Title         string
+	Started_At    string
+	Viewer_Count  int
+	Thumbnail_Url string
+}
+
+// REQUESTS
+
+// Do a single helix API call and decode the JSON response into data
+func (obj *Helix) helix(data interface{}, params *url.Values, path ...string) error {
+	resp, err := obj.doAPIRequest(params, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return NewKrakenError(resp.StatusCode, "Got HTTP status code %v during helix request", resp.StatusCode)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(data)
+	if err != nil {
+		return NewKrakenDecodeError("error decoding the helix response to %s: %s", strings.Join(path, "/"), err)
+	}
+	return nil
+}
+
+// Look up helix users by id, in as few requests as we can
+func (obj *Helix) getUsersById(ids []string) ([]helixUser, error) {
+	out := []helixUser{}
+	for start := 0; start < len(ids); start += HELIX_MAX_IDS_PER_REQUEST {
+		end := start + HELIX_MAX_IDS_PER_REQUEST
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		params := url.Values{}
+		for _, id := range ids[start:end] {
+			params.Add("id", id)
+		}
+
+		var response struct {
+			Data []helixUser
+		}
+		err := obj.helix(&response, &params, "users")
+		if err != nil {
+			return out, err
+		}
+		out = append(out, response.Data...)
+	}
+	return out, nil
+}
+
+// CONVERSION TO OUR API RESPONSE DATA STRUCTURES
+
+func parseHelixId(id string) (float64, error) {
+	return strconv.ParseFloat(id, 64)
+}
+
+func helixChannelUrl(login string) string {
+	return "https://www.twitch.tv/" + login
+}
+
+// The kraken-style preview sizes from a helix thumbnail URL template
+func helixPreview(template string) StreamPreview {
+	size := func(width int, height int) string {
+		return strings.NewReplacer("{width}", strconv.Itoa(width), "{height}", strconv.Itoa(height)).Replace(template)
+	}
+	return StreamPreview{
+		Small:    size(80, 45),
+		Medium:   size(320, 180),
+		Large:    size(640, 360),
+		Template: template,
+	}
+}
+
+func (followed *helixFollowedChannel) toChannelInfo(logo *string) (*ChannelInfo, error) {
+	id, err := parseHelixId(followed.Broadcaster_Id)
+	if err != nil {
+		return nil, NewKrakenError(200, "Bad broadcaster_id '%s' in followed channel: %s", followed.Broadcaster_Id, err)
+	}
+	return &ChannelInfo{
+		Id:           ChannelID(id),
+		Display_Name: followed.Broadcaster_Name,
+		Url:          helixChannelUrl(followed.Broadcaster_Login),
+		Logo:         logo,
+	}, nil
+}
+
+// The channel in the returned stream has no logo, as helix streams don't include that
+func (stream *helixStream) toStreamInfo() (*StreamInfo, error) {
+	channelId, err := parseHelixId(stream.User_Id)
+	if err != nil {
+		return nil, NewKrakenError(200, "Bad user_id '%s' in stream: %s", stream.User_Id, err)
+	}
+	streamId, err := parseHelixId(stream.Id)
+	if err != nil {
+		return nil, NewKrakenError(200, "Bad id '%s' in stream: %s", stream.Id, err)
+	}
+
+	game := stream.Game_Name
+
+	return &StreamInfo{
+		Channel: &ChannelInfo{
+			Id:           ChannelID(channelId),
+			Display_Name: stream.User_Name,
+			Url:          helixChannelUrl(stream.User_Login),
+			Status:       stream.Title,
+		},
+		// helix only lists streams that are live, but just in case treat anything else like a playlist
+		Is_playlist: stream.Type != "live",
+		Id:          StreamID(streamId),
+		Created_at:  stream.Started_At,
+		Game:        &game,
+		Viewers:     stream.Viewer_Count,
+		Preview:     helixPreview(stream.Thumbnail_Url),
+	}, nil
+}
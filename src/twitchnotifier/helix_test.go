@@ -0,0 +1,186 @@
+package main
+
+import (
+	"github.com/jarcoal/httpmock"
+	"testing"
+)
+
+func newHelixTestApp() *TwitchNotifierMain {
+	app := InitTwitchNotifierMain()
+	helix := "helix"
+	app.options = &Options{api: &helix}
+	app.queryPageSize = 2
+	app.getAPIBackend().set_auth_token("fakeoauth123")
+	return app
+}
+
+// TESTS
+
+func TestHelixUsername(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/helix/users",
+		httpmock.NewStringResponder(200, `{"data": [{"id": "99", "login": "fakeusername", "display_name": "FakeUsername"}]}`))
+
+	backend := newHelixTestApp().getAPIBackend()
+
+	username, err := backend.get_username()
+	if ctx.assertNoErr(err, "get_username()") {
+		return
+	}
+	if ctx.assertStrEqual("fakeusername", username, "username") {
+		return
+	}
+}
+
+func TestHelixHTTPError(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/helix/users",
+		httpmock.NewStringResponder(401, `{"error": "Unauthorized", "status": 401, "message": "Invalid OAuth token"}`))
+
+	backend := newHelixTestApp().getAPIBackend()
+
+	_, err := backend.get_username()
+	if ctx.assertGotErr("Got HTTP status code 401 during helix request", err, "get_username()") {
+		return
+	}
+	krakenErr, wasKrakenErr := err.(*KrakenError)
+	if ctx.assert(wasKrakenErr, "expected error for HTTP error status to be KrakenError") {
+		return
+	}
+	if ctx.assert(krakenErr.statusCode == 401, "Got status code %v for HTTP Error 401", krakenErr.statusCode) {
+		return
+	}
+}
+
+func TestHelixFollowsPaging(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/helix/users?login=fakeusername",
+		httpmock.NewStringResponder(200, `{"data": [{"id": "99", "login": "fakeusername", "display_name": "FakeUsername"}]}`))
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/helix/channels/followed?first=2&user_id=99",
+		httpmock.NewStringResponder(200, `{"total": 3, "data": [
+			{"broadcaster_id": "123", "broadcaster_login": "fakechannel", "broadcaster_name": "FakeChannel"},
+			{"broadcaster_id": "124", "broadcaster_login": "otherchannel", "broadcaster_name": "OtherChannel"}
+		], "pagination": {"cursor": "page2"}}`))
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/helix/channels/followed?after=page2&first=2&user_id=99",
+		httpmock.NewStringResponder(200, `{"total": 3, "data": [
+			{"broadcaster_id": "125", "broadcaster_login": "thirdchannel", "broadcaster_name": "ThirdChannel"}
+		], "pagination": {}}`))
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/helix/users?id=123&id=124&id=125",
+		httpmock.NewStringResponder(200, `{"data": [
+			{"id": "123", "login": "fakechannel", "profile_image_url": "https://example.com/fakechannel.png"},
+			{"id": "124", "login": "otherchannel", "profile_image_url": ""},
+			{"id": "125", "login": "thirdchannel", "profile_image_url": "https://example.com/thirdchannel.png"}
+		]}`))
+
+	backend := newHelixTestApp().getAPIBackend()
+
+	follows, err := backend.get_follows("fakeusername")
+	if ctx.assertNoErr(err, "get_follows()") {
+		return
+	}
+	if ctx.assert(len(follows) == 3, "expected 3 follows from the two pages but got %v", len(follows)) {
+		return
+	}
+
+	first := follows[0]
+	if ctx.assert(first.Notifications, "expected helix follows to have notifications enabled") {
+		return
+	}
+	if ctx.assert(first.Channel.Id == 123, "expected channel id 123 but got %v", first.Channel.Id) {
+		return
+	}
+	if ctx.assertStrEqual("FakeChannel", first.Channel.Display_Name, "Display_Name") {
+		return
+	}
+	if ctx.assertStrEqual("https://www.twitch.tv/fakechannel", first.Channel.Url, "Url") {
+		return
+	}
+	if ctx.assert(first.Channel.Logo != nil, "expected a logo for the first channel") {
+		return
+	}
+	if ctx.assertStrEqual("https://example.com/fakechannel.png", *first.Channel.Logo, "Logo") {
+		return
+	}
+
+	if ctx.assert(follows[1].Channel.Logo == nil, "expected no logo for a channel with an empty profile image") {
+		return
+	}
+	if ctx.assertStrEqual("ThirdChannel", follows[2].Channel.Display_Name, "Display_Name of the item on the second page") {
+		return
+	}
+}
+
+func TestHelixStreamsFollowed(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	username := "fakeusername"
+	app := newHelixTestApp()
+	app.options.username = &username
+	backend := app.getAPIBackend()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/helix/users?login=fakeusername",
+		httpmock.NewStringResponder(200, `{"data": [{"id": "99", "login": "fakeusername", "display_name": "FakeUsername"}]}`))
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/helix/streams/followed?first=2&user_id=99",
+		httpmock.NewStringResponder(200, `{"data": [
+			{"id": "456", "user_id": "123", "user_login": "fakechannel", "user_name": "FakeChannel",
+			 "game_name": "a vidya game", "type": "live", "title": "somestatus",
+			 "started_at": "2016-01-01T01:01:01Z", "viewer_count": 42,
+			 "thumbnail_url": "https://static-cdn.jtvnw.net/previews-ttv/live_user_fakechannel-{width}x{height}.jpg"},
+			{"id": "789", "user_id": "555", "user_login": "notfollowed", "user_name": "NotFollowed",
+			 "game_name": "", "type": "live", "title": "", "started_at": "2016-01-01T01:01:01Z"}
+		], "pagination": {}}`))
+
+	followed := map[ChannelID]bool{123: true}
+	streams, err := backend.get_streams_channels_following(followed)
+	if ctx.assertNoErr(err, "get_streams_channels_following()") {
+		return
+	}
+	if ctx.assert(len(streams) == 1, "expected only the followed stream but got %v streams", len(streams)) {
+		return
+	}
+
+	streamChannel, ok := streams[123]
+	if ctx.assert(ok, "no stream for channel 123") {
+		return
+	}
+	stream := streamChannel.stream
+	if ctx.assert(stream.Id == 456, "expected stream id 456 but got %v", stream.Id) {
+		return
+	}
+	if ctx.assert(!stream.Is_playlist, "live stream was considered a playlist") {
+		return
+	}
+	if ctx.assertStrEqual("2016-01-01T01:01:01Z", stream.Created_at, "Created_at") {
+		return
+	}
+	if ctx.assertStrEqual("a vidya game", *stream.Game, "Game") {
+		return
+	}
+	if ctx.assertStrEqual("somestatus", streamChannel.channel.Status, "channel Status") {
+		return
+	}
+	if ctx.assertStrEqual("https://www.twitch.tv/fakechannel", streamChannel.channel.Url, "channel Url") {
+		return
+	}
+	if ctx.assert(stream.Viewers == 42, "expected 42 viewers but got %v", stream.Viewers) {
+		return
+	}
+	if ctx.assertStrEqual("https://static-cdn.jtvnw.net/previews-ttv/live_user_fakechannel-80x45.jpg", stream.Preview.Small, "small preview") {
+		return
+	}
+}
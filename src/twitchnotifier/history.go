@@ -0,0 +1,246 @@
+package main
+
+/**
+The stream history keeps each channel going online and offline in an embedded bolt database, so
+the stream event log survives a restart and there's a record to work out stats from.
+
+Events are kept in time order in one bucket, keyed by the event time and a sequence number, with
+the event as JSON. Events older than the -history-days option are pruned when the database is
+opened and once a day after that.
+*/
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var historyEventsBucket = []byte("events")
+
+const HISTORY_PRUNE_INTERVAL = 24 * time.Hour
+
+// A channel going online or offline
+type StreamHistoryEvent struct {
+	Time       time.Time `json:"time"`
+	Online     bool      `json:"online"`
+	Channel_Id ChannelID `json:"channel_id"`
+	Channel    string    `json:"channel"`
+	Url        string    `json:"url"`
+	// the stream that started or ended, if we know it
+	Stream StreamID `json:"stream,omitempty"`
+	Game   string   `json:"game,omitempty"`
+	Title  string   `json:"title,omitempty"`
+}
+
+type StreamHistory struct {
+	db *bolt.DB
+	// the latest event for each channel, so we don't record the same transition twice, e.g. a
+	// stream that's still going when we start up again
+	last_by_channel map[ChannelID]*StreamHistoryEvent
+	retention       time.Duration
+	last_prune      time.Time
+}
+
+func getHistoryFilename() string {
+	newParts := append(prefsRelativePath(), "twitchnotifier.history.db")
+	return userRelativePath(newParts...)
+}
+
+/**
+Open the history database, creating it if needed, and prune it. retention is how long to keep
+events for, or 0 to keep them forever.
+*/
+func OpenStreamHistory(filename string, retention time.Duration) (*StreamHistory, error) {
+	// another running copy of the app has it locked; don't wait around for it
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	out := &StreamHistory{db: db, retention: retention}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(historyEventsBucket)
+		return err
+	})
+	if err == nil {
+		_, err = out.prune(time.Now())
+	}
+	if err == nil {
+		err = out.load_last_by_channel()
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return out, nil
+}
+
+func (history *StreamHistory) Close() error {
+	return history.db.Close()
+}
+
+func (history *StreamHistory) load_last_by_channel() error {
+	history.last_by_channel = make(map[ChannelID]*StreamHistoryEvent)
+	events, err := history.events_since(time.Time{})
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		last, ok := history.last_by_channel[event.Channel_Id]
+		if !ok || !event.Time.Before(last.Time) {
+			history.last_by_channel[event.Channel_Id] = event
+		}
+	}
+	return nil
+}
+
+// The key for an event: the time, then a sequence number in case of a tie
+func historyEventKey(event_time time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(event_time.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+/**
+Record an event. Returns false if it was left out because it's the same transition as the last
+event for the channel.
+*/
+func (history *StreamHistory) add(event *StreamHistoryEvent) (bool, error) {
+	last, ok := history.last_by_channel[event.Channel_Id]
+	if ok && last.Online == event.Online && (!event.Online || last.Stream == event.Stream) {
+		return false, nil
+	}
+
+	buf, err := json.Marshal(event)
+	if err != nil {
+		return false, err
+	}
+	err = history.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(historyEventsBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(historyEventKey(event.Time, seq), buf)
+	})
+	if err != nil {
+		return false, err
+	}
+	history.last_by_channel[event.Channel_Id] = event
+
+	if time.Since(history.last_prune) >= HISTORY_PRUNE_INTERVAL {
+		_, err = history.prune(time.Now())
+	}
+	return true, err
+}
+
+// The events at or after since, in time order
+func (history *StreamHistory) events_since(since time.Time) ([]*StreamHistoryEvent, error) {
+	out := []*StreamHistoryEvent{}
+	err := history.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(historyEventsBucket).Cursor()
+		start := historyEventKey(since, 0)
+		if since.IsZero() {
+			start = nil
+		}
+		var key, value []byte
+		if start == nil {
+			key, value = cursor.First()
+		} else {
+			key, value = cursor.Seek(start)
+		}
+		for ; key != nil; key, value = cursor.Next() {
+			event := &StreamHistoryEvent{}
+			err := json.Unmarshal(value, event)
+			if err != nil {
+				msg("skipping bad history entry: %s", err)
+				continue
+			}
+			out = append(out, event)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// Delete the events older than the retention period. Returns how many were deleted.
+func (history *StreamHistory) prune(now time.Time) (int, error) {
+	history.last_prune = now
+	if history.retention <= 0 {
+		return 0, nil
+	}
+	cutoff := historyEventKey(now.Add(-history.retention), 0)
+	deleted := 0
+	err := history.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(historyEventsBucket).Cursor()
+		for key, _ := cursor.First(); key != nil && string(key) < string(cutoff); key, _ = cursor.First() {
+			err := cursor.Delete()
+			if err != nil {
+				return err
+			}
+			deleted += 1
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// APP HISTORY SUPPORT
+
+/**
+The stream history, opened the first time we need it. This is nil if there's no -history option,
+as in tests, or the database couldn't be opened.
+*/
+func (app *TwitchNotifierMain) getStreamHistory() *StreamHistory {
+	if !app.streamHistoryOpened {
+		app.streamHistoryOpened = true
+		if app.options.history_file == nil {
+			return nil
+		}
+		filename := *app.options.history_file
+		if filename == "" {
+			filename = getHistoryFilename()
+		}
+		var retention time.Duration
+		if app.options.history_days != nil && *app.options.history_days > 0 {
+			retention = time.Duration(*app.options.history_days) * 24 * time.Hour
+		}
+		history, err := OpenStreamHistory(filename, retention)
+		if err != nil {
+			app.getEventsInterface().log(fmt.Sprintf("Couldn't open the stream history '%s': %s", filename, err))
+			return nil
+		}
+		app.streamHistory = history
+	}
+	return app.streamHistory
+}
+
+// Record a channel going online or offline in the stream history. stream can be nil for offline.
+func (app *TwitchNotifierMain) record_stream_history(channel *ChannelInfo, online bool, stream *StreamInfo, event_time time.Time) {
+	history := app.getStreamHistory()
+	if history == nil {
+		return
+	}
+	event := &StreamHistoryEvent{
+		Time:       event_time,
+		Online:     online,
+		Channel_Id: channel.Id,
+		Channel:    channel.Display_Name,
+		Url:        channel.Url,
+	}
+	if stream != nil {
+		event.Stream = stream.Id
+		if stream.Game != nil {
+			event.Game = *stream.Game
+		}
+		event.Title = channel.Status
+	}
+	_, err := history.add(event)
+	app.streamSessions = nil
+	if err != nil {
+		app.getEventsInterface().log(fmt.Sprintf("Error saving stream history: %s", err))
+	}
+}
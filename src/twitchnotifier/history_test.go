@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempHistoryFilename(ctx *TestContext) (string, func()) {
+	dir, err := ioutil.TempDir("", "twitchnotifier")
+	if ctx.assertNoErr(err, "ioutil.TempDir()") {
+		return "", func() {}
+	}
+	return filepath.Join(dir, "twitchnotifier.history.db"), func() { os.RemoveAll(dir) }
+}
+
+func historyChannels(events []*StreamHistoryEvent) []string {
+	out := []string{}
+	for _, event := range events {
+		state := "offline"
+		if event.Online {
+			state = "online"
+		}
+		out = append(out, event.Channel+" "+state)
+	}
+	return out
+}
+
+// TESTS
+
+func TestStreamHistory(t *testing.T) {
+	ctx := NewTestCtx(t)
+	filename, cleanup := tempHistoryFilename(ctx)
+	defer cleanup()
+
+	history, err := OpenStreamHistory(filename, 0)
+	if ctx.assertNoErr(err, "OpenStreamHistory()") {
+		return
+	}
+	start := time.Date(2017, 8, 1, 12, 0, 0, 0, time.UTC)
+	events := []*StreamHistoryEvent{
+		{Time: start, Online: true, Channel_Id: 1, Channel: "FakeChannel", Stream: 10, Game: "a vidya game", Title: "some title"},
+		{Time: start.Add(time.Minute), Online: true, Channel_Id: 2, Channel: "OtherChannel", Stream: 20},
+		// the same stream again, as when we see it's still live on the next run
+		{Time: start, Online: true, Channel_Id: 1, Channel: "FakeChannel", Stream: 10},
+		{Time: start.Add(time.Hour), Online: false, Channel_Id: 1, Channel: "FakeChannel"},
+		{Time: start.Add(2 * time.Hour), Online: false, Channel_Id: 1, Channel: "FakeChannel"},
+	}
+	for i, event := range events {
+		added, err := history.add(event)
+		if ctx.assertNoErr(err, fmt.Sprintf("add() of event %v", i)) {
+			return
+		}
+		if ctx.assert(added == (i != 2 && i != 4), "add() of event %v returned %v", i, added) {
+			return
+		}
+	}
+	if ctx.assertNoErr(history.Close(), "Close()") {
+		return
+	}
+
+	// reopening keeps the events, and still knows the channels' latest states
+	history, err = OpenStreamHistory(filename, 0)
+	if ctx.assertNoErr(err, "OpenStreamHistory() again") {
+		return
+	}
+	defer history.Close()
+	added, err := history.add(&StreamHistoryEvent{Time: start.Add(3 * time.Hour), Online: false, Channel_Id: 1, Channel: "FakeChannel"})
+	if ctx.assert(err == nil && !added, "a repeated offline event after reopening was added, err %v", err) {
+		return
+	}
+
+	saved, err := history.events_since(time.Time{})
+	if ctx.assertNoErr(err, "events_since()") {
+		return
+	}
+	if ctx.assertStrEqual("FakeChannel online, OtherChannel online, FakeChannel offline", strings.Join(historyChannels(saved), ", "), "saved events") {
+		return
+	}
+	if ctx.assert(saved[0].Time.Equal(start) && saved[0].Stream == 10 && saved[0].Game == "a vidya game" && saved[0].Title == "some title",
+		"unexpected first event %+v", *saved[0]) {
+		return
+	}
+
+	saved, err = history.events_since(start.Add(time.Minute))
+	if ctx.assertNoErr(err, "events_since() a time") {
+		return
+	}
+	if ctx.assertStrEqual("OtherChannel online, FakeChannel offline", strings.Join(historyChannels(saved), ", "), "events since a time") {
+		return
+	}
+
+	// with 2 days retention, a prune 2 days and 30 minutes after the start removes the first two
+	history.retention = 48 * time.Hour
+	deleted, err := history.prune(start.Add(48*time.Hour + 30*time.Minute))
+	if ctx.assertNoErr(err, "prune()") {
+		return
+	}
+	if ctx.assert(deleted == 2, "expected 2 pruned events but got %v", deleted) {
+		return
+	}
+	saved, err = history.events_since(time.Time{})
+	if ctx.assertNoErr(err, "events_since() after pruning") {
+		return
+	}
+	if ctx.assertStrEqual("FakeChannel offline", strings.Join(historyChannels(saved), ", "), "events after pruning") {
+		return
+	}
+}
+
+func TestStreamHistoryFromWatcher(t *testing.T) {
+	ctx := NewTestCtx(t)
+	filename, cleanup := tempHistoryFilename(ctx)
+	defer cleanup()
+
+	twitch := newStreamChangeTestTwitch()
+	server := httptest.NewServer(twitch)
+	defer server.Close()
+
+	app := newTestApp(fakeTwitchOptions(server))
+	app.options.history_file = &filename
+	defer func() {
+		if app.streamHistory != nil {
+			app.streamHistory.Close()
+		}
+	}()
+
+	app.main_loop_iter.next()
+	twitch.GoOffline("otherchannel")
+	app.main_loop_iter.next()
+
+	history := app.getStreamHistory()
+	if ctx.assert(history != nil, "the stream history didn't open") {
+		return
+	}
+	saved, err := history.events_since(time.Time{})
+	if ctx.assertNoErr(err, "events_since()") {
+		return
+	}
+	if ctx.assert(len(saved) == 3, "expected 3 saved events but got %v", historyChannels(saved)) {
+		return
+	}
+	if ctx.assertStrEqual("OtherChannel offline", historyChannels(saved)[2], "last saved event") {
+		return
+	}
+	for _, event := range saved[:2] {
+		if ctx.assert(event.Online && event.Stream != 0 && event.Game == "a vidya game" && event.Title == "some title",
+			"unexpected online event %+v", *event) {
+			return
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// IDLE AND LOCK DETECTION
+
+// While the user is away from the computer we hold the online notifications, and show what went
+// live when they come back, so they don't miss anything in a pile of popups that already timed out
+
+// What the desktop session told us about the user being away
+type IdleState struct {
+	// how long since the last keyboard or mouse input
+	idle   time.Duration
+	locked bool
+}
+
+// Source of the session idle and lock state; a platform one, or a fake one for tests
+type IdleDetector interface {
+	idle_state() (IdleState, error)
+}
+
+// An online notification held while the user was away
+type heldNotification struct {
+	channel_name string
+	message      string
+	callback     NotificationCallback
+}
+
+const AWAY_SUMMARY_URL = "https://www.twitch.tv/directory/following/live"
+
+// How often the GUI checks whether the user is back, between polls
+const IDLE_CHECK_INTERVAL = 5 * time.Second
+
+// The -idle option as a duration; zero means only a locked session counts as away
+func (app *TwitchNotifierMain) idle_threshold() time.Duration {
+	if app.options.idle == nil || *app.options.idle <= 0 {
+		return 0
+	}
+	return time.Duration(*app.options.idle) * time.Second
+}
+
+/** Check whether the user is away, and if they just came back, show the notifications we held
+    while they were gone. This is done before each poll looks at the streams, and every
+    IDLE_CHECK_INTERVAL in between so the held notifications show up soon after the user is back.
+ */
+func (app *TwitchNotifierMain) check_idle() {
+	if app.idleDetector == nil {
+		return
+	}
+	state, err := app.idleDetector.idle_state()
+	if err != nil {
+		// if we can't tell, don't risk holding notifications indefinitely
+		app.getEventsInterface().log(fmt.Sprintf("Error checking idle state: %s", err))
+		state = IdleState{}
+	}
+	threshold := app.idle_threshold()
+	away := state.locked || (threshold > 0 && state.idle >= threshold)
+
+	if away && !app.away {
+		app.getEventsInterface().log("Away from the computer; holding notifications")
+	} else if !away && app.away {
+		app.getEventsInterface().log(fmt.Sprintf("Back at the computer; %v notifications were held", len(app.held_notifications)))
+	}
+	app.away = away
+	if !away {
+		app.show_held_notifications()
+	}
+}
+
+// Hold an online notification until the user is back; a newer stream replaces an older one
+func (app *TwitchNotifierMain) hold_notification(channel_name string, message string, callback NotificationCallback) {
+	entry := heldNotification{channel_name, message, callback}
+	for i, existing := range app.held_notifications {
+		if existing.channel_name == channel_name {
+			app.held_notifications[i] = entry
+			return
+		}
+	}
+	app.held_notifications = append(app.held_notifications, entry)
+}
+
+// Show the notifications held while the user was away, as one summary unless -no-unlock-notify=false
+func (app *TwitchNotifierMain) show_held_notifications() {
+	held := app.held_notifications
+	app.held_notifications = nil
+	if len(held) == 0 {
+		return
+	}
+
+	summarize := app.options.unlock_notify == nil || *app.options.unlock_notify
+	if !summarize || len(held) == 1 {
+		for _, entry := range held {
+			app.show_notification(entry.message, entry.callback)
+		}
+		return
+	}
+
+	names := []string{}
+	high_priority := false
+	for _, entry := range held {
+		names = append(names, entry.channel_name)
+		high_priority = high_priority || entry.callback.high_priority
+	}
+	message := fmt.Sprintf("While you were away, %v streams went live: %s", len(held), strings.Join(names, ", "))
+	app.show_notification(message, NotificationCallback{"", AWAY_SUMMARY_URL, high_priority, "", nil})
+}
@@ -0,0 +1,94 @@
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	LOGIND_NAME              = "org.freedesktop.login1"
+	LOGIND_PATH              = "/org/freedesktop/login1"
+	LOGIND_MANAGER_INTERFACE = "org.freedesktop.login1.Manager"
+	LOGIND_SESSION_INTERFACE = "org.freedesktop.login1.Session"
+)
+
+/**
+Idle and lock state on Linux, from logind's IdleHint, IdleSinceHint and LockedHint for our session,
+which we read from org.freedesktop.login1 on the system bus. We don't ask X11 for the time since the
+last input, so on a desktop that doesn't set IdleHint the user is only away while the session is
+locked.
+*/
+type systemIdleDetector struct {
+	session dbus.BusObject
+}
+
+// The idle detector for our logind session, or nil if we can't get the session from logind
+func NewSystemIdleDetector() IdleDetector {
+	session, err := connectLogindSession()
+	if err != nil {
+		msg("No idle detection; couldn't get our session from logind: %s", err)
+		return nil
+	}
+	return &systemIdleDetector{session}
+}
+
+func connectLogindSession() (dbus.BusObject, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil, err
+	}
+	manager := conn.Object(LOGIND_NAME, LOGIND_PATH)
+	var sessionPath dbus.ObjectPath
+	sessionId := os.Getenv("XDG_SESSION_ID")
+	if sessionId != "" {
+		err = manager.Call(LOGIND_MANAGER_INTERFACE+".GetSession", 0, sessionId).Store(&sessionPath)
+	} else {
+		err = manager.Call(LOGIND_MANAGER_INTERFACE+".GetSessionByPID", 0, uint32(os.Getpid())).Store(&sessionPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return conn.Object(LOGIND_NAME, sessionPath), nil
+}
+
+func (detector *systemIdleDetector) idle_state() (IdleState, error) {
+	properties := make(map[string]interface{})
+	for _, name := range []string{"IdleHint", "IdleSinceHint", "LockedHint"} {
+		value, err := detector.session.GetProperty(LOGIND_SESSION_INTERFACE + "." + name)
+		if err != nil {
+			// LockedHint is newer than the others, so it's fine for it to be missing
+			if name == "LockedHint" {
+				continue
+			}
+			return IdleState{}, err
+		}
+		properties[name] = value.Value()
+	}
+	return logindIdleState(properties, time.Now())
+}
+
+// The idle state from the logind session properties
+func logindIdleState(properties map[string]interface{}, now time.Time) (IdleState, error) {
+	state := IdleState{}
+	idleHint, ok := properties["IdleHint"].(bool)
+	if !ok {
+		return state, errors.New("no IdleHint for the session")
+	}
+	locked, _ := properties["LockedHint"].(bool)
+	state.locked = locked
+	if idleHint {
+		// microseconds since the epoch
+		since, ok := properties["IdleSinceHint"].(uint64)
+		if !ok || since == 0 {
+			return state, fmt.Errorf("bad IdleSinceHint %v", properties["IdleSinceHint"])
+		}
+		state.idle = now.Sub(time.Unix(0, int64(since)*int64(time.Microsecond)))
+	}
+	return state, nil
+}
@@ -0,0 +1,43 @@
+// +build linux
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogindIdleState(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	now := time.Unix(1500000000, 0)
+	state, err := logindIdleState(map[string]interface{}{"IdleHint": true, "IdleSinceHint": uint64(1499999700000000), "LockedHint": false}, now)
+	if ctx.assertNoErr(err, "logindIdleState()") {
+		return
+	}
+	if ctx.assert(state.idle == 5*time.Minute && !state.locked, "unexpected idle state %+v", state) {
+		return
+	}
+
+	state, err = logindIdleState(map[string]interface{}{"IdleHint": false, "IdleSinceHint": uint64(0), "LockedHint": true}, now)
+	if ctx.assertNoErr(err, "logindIdleState() for a locked session") {
+		return
+	}
+	if ctx.assert(state.idle == 0 && state.locked, "unexpected idle state %+v", state) {
+		return
+	}
+
+	// an older logind with no LockedHint
+	state, err = logindIdleState(map[string]interface{}{"IdleHint": false, "IdleSinceHint": uint64(0)}, now)
+	if ctx.assertNoErr(err, "logindIdleState() with no LockedHint") {
+		return
+	}
+	if ctx.assert(state.idle == 0 && !state.locked, "unexpected idle state %+v", state) {
+		return
+	}
+
+	_, err = logindIdleState(map[string]interface{}{}, now)
+	if ctx.assertGotErr("no IdleHint for the session", err, "logindIdleState() with no properties") {
+		return
+	}
+}
@@ -0,0 +1,8 @@
+// +build !linux
+
+package main
+
+// We don't have idle and lock detection on this platform yet, so the user is never away
+func NewSystemIdleDetector() IdleDetector {
+	return nil
+}
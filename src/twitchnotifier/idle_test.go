@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeIdleDetector struct {
+	state IdleState
+	err   error
+}
+
+func (detector *fakeIdleDetector) idle_state() (IdleState, error) {
+	return detector.state, detector.err
+}
+
+// TESTS
+
+func TestNotificationsHeldWhileLocked(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	twitch := newStreamChangeTestTwitch()
+	server := httptest.NewServer(twitch)
+	defer server.Close()
+
+	sink := &recordingEventSink{}
+	app := newTestApp(fakeTwitchOptions(server), sink)
+	detector := &fakeIdleDetector{state: IdleState{locked: true}}
+	app.idleDetector = detector
+
+	app.main_loop_iter.next()
+	if ctx.assert(len(eventsOfType(sink, HEADLESS_EVENT_NOTIFY)) == 0, "got notifications while locked") {
+		return
+	}
+	if ctx.assert(len(eventsOfType(sink, HEADLESS_EVENT_ONLINE)) == 2, "expected online events while locked but got %v", len(eventsOfType(sink, HEADLESS_EVENT_ONLINE))) {
+		return
+	}
+
+	// still locked; the held notifications aren't repeated
+	app.main_loop_iter.next()
+
+	detector.state = IdleState{}
+	app.main_loop_iter.next()
+	notifications := eventsOfType(sink, HEADLESS_EVENT_NOTIFY)
+	if ctx.assert(len(notifications) == 1, "expected one summary notification but got %v", len(notifications)) {
+		return
+	}
+	message := notifications[0].Message
+	if ctx.assert(strings.HasPrefix(message, "While you were away, 2 streams went live: ") &&
+		strings.Contains(message, "FakeChannel") && strings.Contains(message, "OtherChannel"),
+		"unexpected summary '%s'", message) {
+		return
+	}
+
+	app.main_loop_iter.next()
+	if ctx.assert(len(eventsOfType(sink, HEADLESS_EVENT_NOTIFY)) == 1, "the summary was shown again") {
+		return
+	}
+}
+
+func TestNotificationsHeldWhileIdle(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	twitch := newStreamChangeTestTwitch()
+	server := httptest.NewServer(twitch)
+	defer server.Close()
+
+	sink := &recordingEventSink{}
+	app := newTestApp(fakeTwitchOptions(server), sink)
+	idle := 300
+	no_unlock_notify := false
+	app.options.idle = &idle
+	app.options.unlock_notify = &no_unlock_notify
+	detector := &fakeIdleDetector{state: IdleState{idle: 299 * time.Second}}
+	app.idleDetector = detector
+
+	// not idle for long enough yet
+	app.main_loop_iter.next()
+	if ctx.assert(len(eventsOfType(sink, HEADLESS_EVENT_NOTIFY)) == 2, "expected 2 notifications but got %v", len(eventsOfType(sink, HEADLESS_EVENT_NOTIFY))) {
+		return
+	}
+
+	detector.state = IdleState{idle: 10 * time.Minute}
+	twitch.GoOffline("fakechannel")
+	app.main_loop_iter.next()
+	twitch.GoLive("fakechannel", "a vidya game", "back again")
+	app.main_loop_iter.next()
+	if ctx.assert(len(eventsOfType(sink, HEADLESS_EVENT_NOTIFY)) == 2, "got a notification while idle") {
+		return
+	}
+
+	// with -no-unlock-notify=false each held notification is shown on its own
+	detector.state = IdleState{idle: time.Second}
+	app.main_loop_iter.next()
+	notifications := eventsOfType(sink, HEADLESS_EVENT_NOTIFY)
+	if ctx.assert(len(notifications) == 3, "expected the held notification but got %v notifications", len(notifications)) {
+		return
+	}
+	if ctx.assert(strings.HasPrefix(notifications[2].Message, "FakeChannel is now live"), "unexpected held notification '%s'", notifications[2].Message) {
+		return
+	}
+}
+
+func TestIdleDetectorErrorIsNotAway(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	app := newTestApp(&Options{})
+	app.away = true
+	app.idleDetector = &fakeIdleDetector{err: errors.New("no session")}
+	app.check_idle()
+	if ctx.assert(!app.away, "still away after the idle detector failed") {
+		return
+	}
+}
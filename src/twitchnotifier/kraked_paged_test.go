@@ -145,6 +145,7 @@ func TestHTTPErrorOnInitialPage(t *testing.T) {
 		httpmock.NewStringResponder(500, `{"error": "something is wrong"}`))
 
 	kraken := InitKraken()
+	skipRetrySleeps(kraken)
 
 	pk, err := kraken.PagedKraken("somethings", 1, nil, "ohai")
 	if ctx.assertGotErr("Got HTTP status code 500 during page request", err, "PagedKraken()") {
@@ -173,6 +174,7 @@ func TestHTTPErrorOnLaterPage(t *testing.T) {
 		httpmock.NewStringResponder(200, `{"somethings": ["first thing"], "_total": 2}`))
 
 	kraken := InitKraken()
+	skipRetrySleeps(kraken)
 
 	pk, err := kraken.PagedKraken("somethings", 1, nil, "ohai")
 	if ctx.assertNoErr(err, "PagedKraken()") {
@@ -236,3 +238,67 @@ func TestHTTPErrorOnLaterPage(t *testing.T) {
 		return
 	}
 }
+
+func TestMalformedResponseIsDecodeError(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai",
+		httpmock.NewStringResponder(200, `{"_total": "lots", "somethings": []}`))
+
+	kraken := InitKraken()
+
+	pk, err := kraken.PagedKraken("somethings", 1, nil, "ohai")
+	if ctx.assert(err != nil, "expected an error for a bad _total value") {
+		return
+	}
+	if ctx.assert(isDecodeError(err), "expected a decode error but got %v", err) {
+		return
+	}
+	if ctx.assert(pk == nil, "paged kraken was not nil even through constructor gave error") {
+		return
+	}
+}
+
+func TestBadItemIsDecodeError(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai",
+		httpmock.NewStringResponder(200, `{"_total": 1, "somethings": [{"not": "a string"}]}`))
+
+	kraken := InitKraken()
+
+	pk, err := kraken.PagedKraken("somethings", 1, nil, "ohai")
+	if ctx.assertNoErr(err, "PagedKraken()") {
+		return
+	}
+
+	var value string
+	err = pk.Next(&value)
+	if ctx.assert(isDecodeError(err), "expected a decode error from Next() but got %v", err) {
+		return
+	}
+}
+
+func TestErrorFieldIsAPIError(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai",
+		httpmock.NewStringResponder(200, `{"error": "Something broke", "somethings": []}`))
+
+	kraken := InitKraken()
+
+	_, err := kraken.PagedKraken("somethings", 1, nil, "ohai")
+	if ctx.assertGotErr("The API returned an error field in the response: Something broke", err, "PagedKraken()") {
+		return
+	}
+	kind, wasKrakenError := krakenErrorKind(err)
+	if ctx.assert(wasKrakenError && kind == KRAKEN_API_ERROR, "expected an API error but got %v", err) {
+		return
+	}
+}
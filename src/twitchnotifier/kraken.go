@@ -20,35 +20,167 @@ import (
 	"time"
 )
 
+const KRAKEN_API_ROOT = "https://api.twitch.tv/kraken"
+const TWITCH_AUTH_URL = "https://api.twitch.tv/kraken/oauth2/authorize"
+
 type Kraken struct {
 	extraHeaders map[string]string
+	apiRoot      string
+	authURL      string
+	validateURL  string
+	httpClient   *http.Client
+	userAgent    string
+	rateLimiter  *RateLimiter
+}
+
+// KrakenOption changes a setting of a Kraken instance, e.g. InitKraken(WithBaseURL("http://localhost:8080/kraken"))
+type KrakenOption func(obj *Kraken)
+
+// Send API requests to a different server, such as a local mock or a caching proxy
+func WithBaseURL(baseURL string) KrakenOption {
+	return func(obj *Kraken) {
+		obj.apiRoot = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// Use a different OAuth authorize page for the browser login
+func WithAuthURL(authURL string) KrakenOption {
+	return func(obj *Kraken) {
+		obj.authURL = authURL
+	}
+}
+
+// Check OAuth tokens with a different validate endpoint
+func WithValidateURL(validateURL string) KrakenOption {
+	return func(obj *Kraken) {
+		obj.validateURL = validateURL
+	}
+}
+
+// Make the requests with the given client instead of http.DefaultClient
+func WithHTTPClient(client *http.Client) KrakenOption {
+	return func(obj *Kraken) {
+		obj.httpClient = client
+	}
+}
+
+// Give up on a request that takes longer than timeout
+func WithTimeout(timeout time.Duration) KrakenOption {
+	return func(obj *Kraken) {
+		if obj.httpClient == nil {
+			obj.httpClient = http.DefaultClient
+		}
+		// copy the client so we don't change the timeout for anyone else using it
+		client := *obj.httpClient
+		client.Timeout = timeout
+		obj.httpClient = &client
+	}
 }
 
-func InitKraken() *Kraken {
+func WithUserAgent(userAgent string) KrakenOption {
+	return func(obj *Kraken) {
+		obj.userAgent = userAgent
+	}
+}
+
+func InitKraken(options ...KrakenOption) *Kraken {
 	out := &Kraken{}
 	out.extraHeaders = make(map[string]string)
+	out.apiRoot = KRAKEN_API_ROOT
+	out.authURL = TWITCH_AUTH_URL
+	out.validateURL = TWITCH_VALIDATE_URL
+	out.httpClient = http.DefaultClient
+	out.rateLimiter = NewRateLimiter()
+	out.configure(options...)
 	return out
 }
 
+// Apply options to an existing instance
+func (obj *Kraken) configure(options ...KrakenOption) {
+	for _, option := range options {
+		option(obj)
+	}
+}
+
+// Where we are with the API's rate limit
+func (obj *Kraken) rateLimitBudget() RateLimitBudget {
+	return obj.rateLimiter.budget()
+}
+
 func (obj *Kraken) addHeader(headerName string, headerValue string) {
 	obj.extraHeaders[headerName] = headerValue
 }
 
 // ERROR STRUCT
 
+// What went wrong with an API request, so callers can decide what to do about it
+type KrakenErrorKind int
+
+const (
+	// the response wasn't the JSON we were expecting
+	KRAKEN_DECODE_ERROR KrakenErrorKind = iota
+	// the response had an error field in it
+	KRAKEN_API_ERROR
+	// we got an HTTP error status
+	KRAKEN_HTTP_STATUS_ERROR
+	// the API rejected our OAuth token
+	KRAKEN_AUTH_ERROR
+)
+
 type KrakenError struct {
 	msg        string
 	statusCode int
+	kind       KrakenErrorKind
 }
 
+// Make an error for a response with the given HTTP status code. A 200 status code means the
+// response came back fine but what was in it was no good.
 func NewKrakenError(statusCode int, format string, args ...interface{}) *KrakenError {
-	return &KrakenError{fmt.Sprintf(format, args...), statusCode}
+	var kind KrakenErrorKind
+	switch statusCode {
+	case 200:
+		kind = KRAKEN_DECODE_ERROR
+	case 401:
+		kind = KRAKEN_AUTH_ERROR
+	default:
+		kind = KRAKEN_HTTP_STATUS_ERROR
+	}
+	return &KrakenError{fmt.Sprintf(format, args...), statusCode, kind}
+}
+
+func NewKrakenDecodeError(format string, args ...interface{}) *KrakenError {
+	return NewKrakenError(200, format, args...)
+}
+
+func NewKrakenAPIError(statusCode int, apiReturnedError interface{}) *KrakenError {
+	return &KrakenError{fmt.Sprintf("The API returned an error field in the response: %v", apiReturnedError),
+		statusCode, KRAKEN_API_ERROR}
 }
 
 func (err *KrakenError) Error() string {
 	return err.msg
 }
 
+func krakenErrorKind(err error) (KrakenErrorKind, bool) {
+	krakenError, wasKrakenError := err.(*KrakenError)
+	if !wasKrakenError || krakenError == nil {
+		return 0, false
+	}
+	return krakenError.kind, true
+}
+
+// Whether the error is the API telling us our OAuth token is no good
+func isAuthError(err error) bool {
+	kind, ok := krakenErrorKind(err)
+	return ok && kind == KRAKEN_AUTH_ERROR
+}
+
+// Whether the error is from a response we couldn't make sense of
+func isDecodeError(err error) bool {
+	kind, ok := krakenErrorKind(err)
+	return ok && kind == KRAKEN_DECODE_ERROR
+}
+
 // PAGER STRUCT
 
 type KrakenPager struct {
@@ -88,13 +220,22 @@ func (state *KrakenPager) finishPagePostList() error {
 
 		// eat the array end
 		arrayEnd, arrayEndTokenErr := dec.Token()
-		state.assertWithCleanup(arrayEndTokenErr == nil, "json array start token error: %s", arrayEndTokenErr)
+		if arrayEndTokenErr != nil {
+			return state.decodeErrorWithCleanup("json array end token error: %s", arrayEndTokenErr)
+		}
 		arrayEndDelim, wasDelim := arrayEnd.(json.Delim)
-		state.assertWithCleanup(wasDelim, "json array end token was not a delim, was %s in %s", arrayEnd, state.path)
-		state.assertWithCleanup(arrayEndDelim == ']', "value for %s was not an array, was %s in %s",
-			state.resultsListKey, arrayEndDelim, state.path)
+		if !wasDelim {
+			return state.decodeErrorWithCleanup("json array end token was not a delim, was %s in %s", arrayEnd, state.path)
+		}
+		if arrayEndDelim != ']' {
+			return state.decodeErrorWithCleanup("value for %s was not an array, was %s in %s",
+				state.resultsListKey, arrayEndDelim, state.path)
+		}
 
-		state.seekToResultsListArrayOrEnd()
+		seekErr := state.seekToResultsListArrayOrEnd()
+		if seekErr != nil {
+			return seekErr
+		}
 	}
 
 	if !state.gotResponseTotalFieldValue {
@@ -125,7 +266,10 @@ func (state *KrakenPager) Next(val interface{}) error {
 	if !dec.More() {
 		if state.currentPageInProgress {
 			msg("out of items on page -- finish page")
-			state.finishPagePostList()
+			finishPageErr := state.finishPagePostList()
+			if finishPageErr != nil {
+				return finishPageErr
+			}
 		}
 		// actually there were no items available.
 		var errMsg string
@@ -140,7 +284,8 @@ func (state *KrakenPager) Next(val interface{}) error {
 
 	err := dec.Decode(val)
 	if err != nil {
-		return nil
+		state.cleanupPage()
+		return NewKrakenDecodeError("error decoding an item of %s in %s: %s", state.resultsListKey, state.path, err)
 	}
 
 	if !dec.More() {
@@ -171,7 +316,7 @@ func (state *KrakenPager) Next(val interface{}) error {
 	return nil
 }
 
-func (state *KrakenPager) seekToResultsListArrayOrEnd() {
+func (state *KrakenPager) seekToResultsListArrayOrEnd() error {
 	// iterate through the dictionary contents and stop until we get to the arg with the results list or the end
 	dec := state.currentPageDecoder
 
@@ -179,42 +324,62 @@ func (state *KrakenPager) seekToResultsListArrayOrEnd() {
 
 	for dec.More() {
 		t, tokenErr := dec.Token()
-		state.assertWithCleanup(tokenErr == nil, "json dict entry token error in %s: %s", state.path, tokenErr)
+		if tokenErr != nil {
+			return state.decodeErrorWithCleanup("json dict entry token error in %s: %s", state.path, tokenErr)
+		}
 
 		switch key := t.(type) {
 		default:
-			state.assertWithCleanup(false, "unexpected key type %T", t)
+			return state.decodeErrorWithCleanup("unexpected key type %T", t)
 		case json.Delim:
-			state.assertWithCleanup(key == '}', "unexpected delimiter %s", key)
+			if key != '}' {
+				return state.decodeErrorWithCleanup("unexpected delimiter %s", key)
+			}
 			break
 		case string:
 			msg("pagedKraken processing key %s", key)
 			if key == "_total" {
-				state.assertWithCleanup(state.gotResponseTotalFieldValue == false, "duplicate total field. huh?")
+				if state.gotResponseTotalFieldValue {
+					return state.decodeErrorWithCleanup("duplicate total field in %s", state.path)
+				}
 				decodeError := dec.Decode(&state.responseTotalFieldValue)
-				state.assertWithCleanup(decodeError == nil, "error getting total value")
+				if decodeError != nil {
+					return state.decodeErrorWithCleanup("error getting total value: %s", decodeError)
+				}
 				state.gotResponseTotalFieldValue = true
 				msg("saved a total value %v", state.responseTotalFieldValue)
 			} else if key == state.resultsListKey {
 				// ok we're up to the results list we want... this should be an array
 				arrayStart, arrayStartTokenErr := dec.Token()
-				state.assertWithCleanup(arrayStartTokenErr == nil, "json array start token error: %s", arrayStartTokenErr)
+				if arrayStartTokenErr != nil {
+					return state.decodeErrorWithCleanup("json array start token error: %s", arrayStartTokenErr)
+				}
 				arrayStartDelim, wasDelim := arrayStart.(json.Delim)
-				state.assertWithCleanup(wasDelim, "json array start token was not a delim, was %s in %s", arrayStart, state.path)
-				state.assertWithCleanup(arrayStartDelim == '[', "value for %s was not an array, was %s in %s",
-					state.resultsListKey, arrayStartDelim, state.path)
+				if !wasDelim {
+					return state.decodeErrorWithCleanup("json array start token was not a delim, was %s in %s", arrayStart, state.path)
+				}
+				if arrayStartDelim != '[' {
+					return state.decodeErrorWithCleanup("value for %s was not an array, was %s in %s",
+						state.resultsListKey, arrayStartDelim, state.path)
+				}
 				// ok, next up is an array element ready to read or end of list
-				return
+				return nil
 			} else if key == "error" {
 				var apiReturnedError interface{}
 				decodeError := dec.Decode(&apiReturnedError)
-				state.assertWithCleanup(decodeError == nil, "error decoding the returned error value: %s", decodeError)
-				assert(false, "The API returned an error field in the response: %s", apiReturnedError)
+				if decodeError != nil {
+					return state.decodeErrorWithCleanup("error decoding the returned error value: %s", decodeError)
+				}
+				statusCode := state.currentPageResponse.StatusCode
+				state.cleanupPage()
+				return NewKrakenAPIError(statusCode, apiReturnedError)
 			} else {
 				// just eat the other values
 				var unused interface{}
 				decodeError := dec.Decode(&unused)
-				state.assertWithCleanup(decodeError == nil, "error eating another value: %s", decodeError)
+				if decodeError != nil {
+					return state.decodeErrorWithCleanup("error eating another value: %s", decodeError)
+				}
 			}
 
 		}
@@ -223,6 +388,7 @@ func (state *KrakenPager) seekToResultsListArrayOrEnd() {
 	// if we got here we reached the end of the page
 	msg("reached the end of page in seekToResultsListArrayOrEnd")
 	state.cleanupPage()
+	return nil
 }
 
 func (pagerState *KrakenPager) cleanupPage() {
@@ -238,11 +404,10 @@ func (pagerState *KrakenPager) cleanupPage() {
 	}
 }
 
-func (pagerState *KrakenPager) assertWithCleanup(condition bool, format string, args ...interface{}) {
-	if !condition {
-		pagerState.cleanupPage()
-	}
-	assert(condition, format, args...)
+// Give up on the current page because the response wasn't what we expected
+func (pagerState *KrakenPager) decodeErrorWithCleanup(format string, args ...interface{}) error {
+	pagerState.cleanupPage()
+	return NewKrakenDecodeError(format, args...)
 }
 
 func copyValues(values url.Values) url.Values {
@@ -272,8 +437,6 @@ func (state *KrakenPager) loadPage() error {
 		return NewKrakenError(resp.StatusCode, errMsg)
 	}
 
-	assert(resp.StatusCode == 200, "got status code %s", resp.StatusCode)
-
 	state.currentPageInProgress = true
 	state.currentPageResponse = resp
 	state.gotResponseTotalFieldValue = false
@@ -284,16 +447,24 @@ func (state *KrakenPager) loadPage() error {
 
 	// read open bracket
 	t, tokenErr := dec.Token()
-	state.assertWithCleanup(tokenErr == nil, "json opening token error in %s: %s", state.path, tokenErr)
+	if tokenErr != nil {
+		return state.decodeErrorWithCleanup("json opening token error in %s: %s", state.path, tokenErr)
+	}
 	tDelim, wasDelim := t.(json.Delim)
-	state.assertWithCleanup(wasDelim, "json opening token was not a delim, was %s in %s", t, state.path)
-
-	state.assertWithCleanup(tDelim == '{', "response was not an object")
+	if !wasDelim {
+		return state.decodeErrorWithCleanup("json opening token was not a delim, was %s in %s", t, state.path)
+	}
+	if tDelim != '{' {
+		return state.decodeErrorWithCleanup("response was not an object")
+	}
 
 	state.responseTotalFieldValue = 0
 	state.gotResponseTotalFieldValue = false
 
-	state.seekToResultsListArrayOrEnd()
+	seekErr := state.seekToResultsListArrayOrEnd()
+	if seekErr != nil {
+		return seekErr
+	}
 
 	if !state.currentPageInProgress {
 		// page ended after the first seekToResultsListArrayOrEnd() -- this means we didn't even get an array start
@@ -363,7 +534,7 @@ func (obj *Kraken) PagedKraken(resultsListKey string, pageSize uint, addParams *
 }
 
 func (obj *Kraken) doAPIRequest(params *url.Values, path []string) (*http.Response, error) {
-	curUrl := strings.Join(append([]string{"https://api.twitch.tv/kraken"}, path...), "/")
+	curUrl := strings.Join(append([]string{obj.apiRoot}, path...), "/")
 	if params != nil {
 		curUrl += "?" + params.Encode()
 	}
@@ -375,13 +546,40 @@ func (obj *Kraken) doAPIRequest(params *url.Values, path []string) (*http.Respon
 
 	req.Header.Add("Client-ID", CLIENT_ID)
 	msg("Added header Client-ID")
+	if obj.userAgent != "" {
+		req.Header.Set("User-Agent", obj.userAgent)
+	}
 	for headerName, headerValue := range obj.extraHeaders {
 		req.Header.Add(headerName, headerValue)
 		msg("Added header %s: %s", headerName, headerValue)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
-	return resp, err
+	// stay within the rate limit, and back off and retry when the server is busy or having trouble
+	limiter := obj.rateLimiter
+	for attempt := uint(0); ; attempt++ {
+		err = limiter.wait()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := obj.httpClient.Do(req)
+		if err != nil {
+			return resp, err
+		}
+		limiter.update(resp)
+
+		if !isRetryableStatus(resp.StatusCode) || attempt >= limiter.maxRetries {
+			return resp, nil
+		}
+
+		delay := limiter.retryDelay(resp, attempt)
+		resp.Body.Close()
+		if delay > limiter.maxDelay {
+			// too long to hold things up for; let the caller come back later
+			return nil, &RateLimitError{delay, fmt.Sprintf("got HTTP status code %v from %s", resp.StatusCode, curUrl)}
+		}
+		msg("Got HTTP status code %v from %s; retrying in %v", resp.StatusCode, curUrl, delay)
+		limiter.sleep(delay)
+	}
 }
 
 func (obj *Kraken) kraken(data interface{}, path ...string) error {
@@ -390,9 +588,15 @@ func (obj *Kraken) kraken(data interface{}, path ...string) error {
 		return err
 	}
 	defer resp.Body.Close()
-	assert(resp.StatusCode == 200, "got status code %s", resp.StatusCode)
+	if resp.StatusCode != 200 {
+		return NewKrakenError(resp.StatusCode, "Got HTTP status code %v during request", resp.StatusCode)
+	}
 
-	return json.NewDecoder(resp.Body).Decode(data)
+	err = json.NewDecoder(resp.Body).Decode(data)
+	if err != nil {
+		return NewKrakenDecodeError("error decoding the response to %s: %s", strings.Join(path, "/"), err)
+	}
+	return nil
 }
 
 func roundToSeconds(d time.Duration) time.Duration {
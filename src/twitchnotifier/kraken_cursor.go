@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ResultsPager is the iteration part of a pager, which both the offset-based KrakenPager and the
+// cursor-based KrakenCursorPager provide
+type ResultsPager interface {
+	More() bool
+	Next(val interface{}) error
+}
+
+// CURSOR PAGER STRUCT
+
+type KrakenCursorPager struct {
+	krakenInstance *Kraken
+	path           []string
+	resultsListKey string
+	pageSize       uint
+
+	// names of the GET params used for the page size and the cursor of the page to get
+	pageSizeParam string
+	cursorParam   string
+
+	// cursor to request the current page with; empty for the first page
+	cursor string
+	// cursor the current page gave us for the page after it, if any
+	nextCursor string
+
+	endOfResults bool
+	// the error loading the next page in More(), which the next Next() returns
+	pageErr error
+
+	currentPageInProgress bool
+	currentPageDecoder    *json.Decoder
+	currentPageResponse   *http.Response
+
+	baseParams url.Values
+}
+
+func (state *KrakenCursorPager) AddParam(key, value string) {
+	state.baseParams.Add(key, value)
+}
+
+/**
+More indicates whether there are additional results available via Next(). A cursor doesn't promise
+there are more items, so this loads the next page if need be to find out. If that load fails this
+is true and the next Next() returns the error, after which the page is tried again.
+*/
+func (state *KrakenCursorPager) More() bool {
+	if state.endOfResults {
+		return false
+	}
+	if state.pageErr != nil || state.currentPageInProgress {
+		return true
+	}
+	pageErr := state.loadPage()
+	if pageErr == nil {
+		pageErr = state.checkForEmptyPage()
+	}
+	if pageErr != nil {
+		msg("error loading the next page, saving it for Next(): %s", pageErr)
+		state.pageErr = pageErr
+		return true
+	}
+	return !state.endOfResults
+}
+
+// This deserializes the next list item into val, with the same behavior as json.Unmarshal.
+// New pages will be requested as necessary, so this may block until a request completes.
+func (state *KrakenCursorPager) Next(val interface{}) error {
+	if !state.More() {
+		return NewKrakenError(200, "Next() called with no more items in %s", state.path)
+	}
+	if state.pageErr != nil {
+		pageErr := state.pageErr
+		state.pageErr = nil
+		return pageErr
+	}
+
+	assert(state.currentPageInProgress, "In Next(), no page in progress after More()")
+
+	dec := state.currentPageDecoder
+	assert(dec != nil, "in Next(): current page was in progress but current page decoder was nil")
+
+	err := dec.Decode(val)
+	if err != nil {
+		state.cleanupPage()
+		return NewKrakenDecodeError("error decoding an item of %s in %s: %s", state.resultsListKey, state.path, err)
+	}
+
+	if !dec.More() {
+		// we are at the end of the array for this page
+		finishPageErr := state.finishPagePostList()
+		if finishPageErr != nil {
+			return finishPageErr
+		}
+		state.cleanupPage()
+
+		if state.nextCursor == "" || state.nextCursor == state.cursor {
+			// no more pages
+			state.endOfResults = true
+		} else {
+			// the next More() loads the next page
+			state.cursor = state.nextCursor
+		}
+	}
+
+	return nil
+}
+
+// If the page just loaded has no items, finish it off and end the results
+func (state *KrakenCursorPager) checkForEmptyPage() error {
+	if state.currentPageInProgress {
+		assert(state.currentPageDecoder != nil, "current page in progress but current page decoder is nil")
+		if !state.currentPageDecoder.More() {
+			finishPageErr := state.finishPagePostList()
+			if finishPageErr != nil {
+				return finishPageErr
+			}
+			state.cleanupPage()
+			state.endOfResults = true
+		}
+	}
+	return nil
+}
+
+func (state *KrakenCursorPager) finishPagePostList() error {
+	dec := state.currentPageDecoder
+
+	// eat the array end
+	arrayEnd, arrayEndTokenErr := dec.Token()
+	if arrayEndTokenErr != nil {
+		return state.decodeErrorWithCleanup("json array end token error: %s", arrayEndTokenErr)
+	}
+	arrayEndDelim, wasDelim := arrayEnd.(json.Delim)
+	if !wasDelim {
+		return state.decodeErrorWithCleanup("json array end token was not a delim, was %s in %s", arrayEnd, state.path)
+	}
+	if arrayEndDelim != ']' {
+		return state.decodeErrorWithCleanup("value for %s was not an array, was %s in %s",
+			state.resultsListKey, arrayEndDelim, state.path)
+	}
+
+	// the pagination cursor may come after the results list
+	return state.seekToResultsListArrayOrEnd()
+}
+
+func (state *KrakenCursorPager) seekToResultsListArrayOrEnd() error {
+	// iterate through the dictionary contents and stop until we get to the arg with the results list or the end
+	dec := state.currentPageDecoder
+
+	assert(state.currentPageInProgress, "Page was not in progress!")
+
+	for dec.More() {
+		t, tokenErr := dec.Token()
+		if tokenErr != nil {
+			return state.decodeErrorWithCleanup("json dict entry token error in %s: %s", state.path, tokenErr)
+		}
+
+		switch key := t.(type) {
+		default:
+			return state.decodeErrorWithCleanup("unexpected key type %T", t)
+		case json.Delim:
+			if key != '}' {
+				return state.decodeErrorWithCleanup("unexpected delimiter %s", key)
+			}
+			break
+		case string:
+			msg("cursor pagedKraken processing key %s", key)
+			if key == "pagination" {
+				var pagination struct {
+					Cursor string
+				}
+				decodeError := dec.Decode(&pagination)
+				if decodeError != nil {
+					return state.decodeErrorWithCleanup("error getting pagination value: %s", decodeError)
+				}
+				state.nextCursor = pagination.Cursor
+				msg("saved a cursor value '%s'", state.nextCursor)
+			} else if key == state.resultsListKey {
+				// ok we're up to the results list we want... this should be an array
+				arrayStart, arrayStartTokenErr := dec.Token()
+				if arrayStartTokenErr != nil {
+					return state.decodeErrorWithCleanup("json array start token error: %s", arrayStartTokenErr)
+				}
+				arrayStartDelim, wasDelim := arrayStart.(json.Delim)
+				if !wasDelim {
+					return state.decodeErrorWithCleanup("json array start token was not a delim, was %s in %s", arrayStart, state.path)
+				}
+				if arrayStartDelim != '[' {
+					return state.decodeErrorWithCleanup("value for %s was not an array, was %s in %s",
+						state.resultsListKey, arrayStartDelim, state.path)
+				}
+				// ok, next up is an array element ready to read or end of list
+				return nil
+			} else if key == "error" {
+				var apiReturnedError interface{}
+				decodeError := dec.Decode(&apiReturnedError)
+				if decodeError != nil {
+					return state.decodeErrorWithCleanup("error decoding the returned error value: %s", decodeError)
+				}
+				statusCode := state.currentPageResponse.StatusCode
+				state.cleanupPage()
+				return NewKrakenAPIError(statusCode, apiReturnedError)
+			} else {
+				// just eat the other values
+				var unused interface{}
+				decodeError := dec.Decode(&unused)
+				if decodeError != nil {
+					return state.decodeErrorWithCleanup("error eating another value: %s", decodeError)
+				}
+			}
+
+		}
+	}
+
+	// if we got here we reached the end of the page
+	msg("reached the end of page in seekToResultsListArrayOrEnd")
+	state.cleanupPage()
+	return nil
+}
+
+func (pagerState *KrakenCursorPager) cleanupPage() {
+	if pagerState.currentPageInProgress {
+		if pagerState.currentPageResponse == nil {
+			msg("KrakenCursorPager.cleanup(): currentPageResponse was already nil")
+		} else {
+			pagerState.currentPageResponse.Body.Close()
+		}
+		pagerState.currentPageResponse = nil
+		pagerState.currentPageDecoder = nil
+		pagerState.currentPageInProgress = false
+	}
+}
+
+// Give up on the current page because the response wasn't what we expected
+func (pagerState *KrakenCursorPager) decodeErrorWithCleanup(format string, args ...interface{}) error {
+	pagerState.cleanupPage()
+	return NewKrakenDecodeError(format, args...)
+}
+
+func (state *KrakenCursorPager) loadPage() error {
+	params := copyValues(state.baseParams)
+	params.Add(state.pageSizeParam, strconv.Itoa(int(state.pageSize)))
+	if state.cursor != "" {
+		params.Add(state.cursorParam, state.cursor)
+	}
+
+	msg("cursor pagedKraken for %s loading entries after '%s'", state.path, state.cursor)
+
+	resp, err := state.krakenInstance.doAPIRequest(&params, state.path)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		errMsg := fmt.Sprintf("Got HTTP status code %v during page request", resp.StatusCode)
+		return NewKrakenError(resp.StatusCode, errMsg)
+	}
+
+	state.currentPageInProgress = true
+	state.currentPageResponse = resp
+	state.nextCursor = ""
+
+	dec := json.NewDecoder(resp.Body)
+	assert(dec != nil, "json.NewDecoder returned nil")
+	state.currentPageDecoder = dec
+
+	// read open bracket
+	t, tokenErr := dec.Token()
+	if tokenErr != nil {
+		return state.decodeErrorWithCleanup("json opening token error in %s: %s", state.path, tokenErr)
+	}
+	tDelim, wasDelim := t.(json.Delim)
+	if !wasDelim {
+		return state.decodeErrorWithCleanup("json opening token was not a delim, was %s in %s", t, state.path)
+	}
+	if tDelim != '{' {
+		return state.decodeErrorWithCleanup("response was not an object")
+	}
+
+	seekErr := state.seekToResultsListArrayOrEnd()
+	if seekErr != nil {
+		return seekErr
+	}
+
+	if !state.currentPageInProgress {
+		// page ended after the first seekToResultsListArrayOrEnd() -- this means we didn't even get an array start
+		return NewKrakenError(200, "Response object was missing the '%s' field", state.resultsListKey)
+	}
+
+	return nil
+}
+
+// CursorPagedKraken is an iterator for calling APIs that provide access to a larger list using cursor semantics.
+//
+// Use this with APIs that take a page size and a cursor as GET parameters (helix calls these first and after)
+// and respond with a JSON object that has an arbitrarily-named field with an array of items, and a
+// pagination object with a cursor field for the next page if there might be one.
+//
+//	iter, err := CursorPagedKraken("data", 25, nil, "some", "path", "parts", "go", "here")
+//	if err != nil {
+func (obj *Kraken) CursorPagedKraken(resultsListKey string, pageSize uint, addParams *url.Values, path ...string) (*KrakenCursorPager, error) {
+
+	out := &KrakenCursorPager{}
+
+	out.krakenInstance = obj
+	out.path = path
+	out.resultsListKey = resultsListKey
+	out.pageSize = pageSize
+	out.pageSizeParam = "first"
+	out.cursorParam = "after"
+	out.cursor = ""
+	out.baseParams = url.Values{}
+
+	out.endOfResults = false
+
+	if addParams != nil {
+		// process these before load so they go into the first request
+		for key, values := range *addParams {
+			for _, value := range values {
+				out.AddParam(key, value)
+			}
+		}
+	}
+
+	// load the first page
+	err := out.loadPage()
+	if err != nil {
+		// there was a problem
+		return nil, err
+	}
+
+	// an empty first page means no results at all
+	err = out.checkForEmptyPage()
+	if err != nil {
+		return nil, err
+	}
+
+	// all good
+	return out, nil
+}
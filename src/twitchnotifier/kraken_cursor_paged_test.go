@@ -0,0 +1,254 @@
+package main
+
+import (
+	"github.com/jarcoal/httpmock"
+	"testing"
+)
+
+// TESTS
+
+func TestCursorMissingFieldError(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai",
+		httpmock.NewStringResponder(200, `{"pagination": {}}`))
+
+	kraken := InitKraken()
+
+	pk, err := kraken.CursorPagedKraken("somethings", 1, nil, "ohai")
+	if ctx.assertGotErr("Response object was missing the 'somethings' field", err, "CursorPagedKraken()") {
+		return
+	}
+	if ctx.assert(pk == nil, "cursor pager was not nil even through constructor gave error") {
+		return
+	}
+}
+
+func TestCursorNoObjects(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai",
+		httpmock.NewStringResponder(200, `{"somethings": [], "pagination": {}}`))
+
+	kraken := InitKraken()
+
+	pk, err := kraken.CursorPagedKraken("somethings", 1, nil, "ohai")
+	if ctx.assertNoErr(err, "CursorPagedKraken()") {
+		return
+	}
+	if ctx.assert(pk != nil, "cursor pager was nil even through it should be a no-item iterator") {
+		return
+	}
+
+	if ctx.assert(!pk.More(), "even through there are no items, pk.More() was true") {
+		return
+	}
+}
+
+func TestCursorPages(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai?first=2",
+		httpmock.NewStringResponder(200, `{"pagination": {"cursor": "abc"}, "somethings": ["first thing", "second thing"]}`))
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai?after=abc&first=2",
+		httpmock.NewStringResponder(200, `{"somethings": ["third thing"], "pagination": {}}`))
+
+	kraken := InitKraken()
+
+	pk, err := kraken.CursorPagedKraken("somethings", 2, nil, "ohai")
+	if ctx.assertNoErr(err, "CursorPagedKraken()") {
+		return
+	}
+
+	values := []string{}
+	for pk.More() {
+		var value string
+		nextErr := pk.Next(&value)
+		if ctx.assertNoErr(nextErr, "Next()") {
+			return
+		}
+		values = append(values, value)
+	}
+
+	if ctx.assert(len(values) == 3, "expected 3 values across the pages but got %v", len(values)) {
+		return
+	}
+	if ctx.assertStrEqual("third thing", values[2], "value from the second page") {
+		return
+	}
+}
+
+func TestCursorOnLastPage(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	// A cursor can come back even when there aren't any more items
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai?first=1",
+		httpmock.NewStringResponder(200, `{"somethings": ["meat popsicle"], "pagination": {"cursor": "abc"}}`))
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai?after=abc&first=1",
+		httpmock.NewStringResponder(200, `{"somethings": [], "pagination": {}}`))
+
+	kraken := InitKraken()
+
+	pk, err := kraken.CursorPagedKraken("somethings", 1, nil, "ohai")
+	if ctx.assertNoErr(err, "CursorPagedKraken()") {
+		return
+	}
+
+	var actualVal string
+	nextErr := pk.Next(&actualVal)
+	if ctx.assertNoErr(nextErr, "first Next() call") {
+		return
+	}
+	if ctx.assertStrEqual("meat popsicle", actualVal, "first value") {
+		return
+	}
+
+	if ctx.assert(!pk.More(), "the page after the cursor was empty but More() was still true") {
+		return
+	}
+}
+
+func TestCursorHTTPErrorOnLaterPage(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai?first=1",
+		httpmock.NewStringResponder(200, `{"somethings": ["first thing"], "pagination": {"cursor": "abc"}}`))
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai?after=abc&first=1",
+		httpmock.NewStringResponder(500, `{"error": "something is wrong"}`))
+
+	kraken := InitKraken()
+	skipRetrySleeps(kraken)
+
+	pk, err := kraken.CursorPagedKraken("somethings", 1, nil, "ohai")
+	if ctx.assertNoErr(err, "CursorPagedKraken()") {
+		return
+	}
+
+	var value string
+	nextError := pk.Next(&value)
+	if ctx.assertNoErr(nextError, "first Next() call") {
+		return
+	}
+	if ctx.assertStrEqual("first thing", value, "first value") {
+		return
+	}
+
+	// we couldn't tell if there are more items, so we should still be trying
+	if ctx.assert(pk.More(), "No more items but we expected second item") {
+		return
+	}
+
+	nextError = pk.Next(&value)
+	if ctx.assertGotErr("Got HTTP status code 500 during page request", nextError, "second Next() call") {
+		return
+	}
+	krakenErr, wasKrakenErr := nextError.(*KrakenError)
+	if ctx.assert(wasKrakenErr, "expected error for HTTP error status to be KrakenError") {
+		return
+	}
+	if ctx.assert(krakenErr.statusCode == 500, "Got status code %v for HTTP Error 500", krakenErr.statusCode) {
+		return
+	}
+
+	// should be ready for retry
+
+	httpmock.Reset()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai?after=abc&first=1",
+		httpmock.NewStringResponder(200, `{"somethings": ["second thing"], "pagination": {}}`))
+
+	nextError = pk.Next(&value)
+	if ctx.assertNoErr(nextError, "retried Next() call") {
+		return
+	}
+	if ctx.assertStrEqual("second thing", value, "second value") {
+		return
+	}
+	if ctx.assert(!pk.More(), "expected no more items after the page with no cursor") {
+		return
+	}
+}
+
+func TestCursorRetriedPageEmpty(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai?first=1",
+		httpmock.NewStringResponder(200, `{"somethings": ["first thing"], "pagination": {"cursor": "abc"}}`))
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai?after=abc&first=1",
+		httpmock.NewStringResponder(500, `{"error": "something is wrong"}`))
+
+	kraken := InitKraken()
+	skipRetrySleeps(kraken)
+
+	pk, err := kraken.CursorPagedKraken("somethings", 1, nil, "ohai")
+	if ctx.assertNoErr(err, "CursorPagedKraken()") {
+		return
+	}
+	var value string
+	if ctx.assertNoErr(pk.Next(&value), "first Next() call") {
+		return
+	}
+
+	// the page after the cursor fails, so we can't tell yet and Next() gives us the error
+	if ctx.assert(pk.More(), "expected More() while the next page couldn't be loaded") {
+		return
+	}
+	if ctx.assertGotErr("Got HTTP status code 500 during page request", pk.Next(&value), "Next() after the failed page") {
+		return
+	}
+
+	// then it comes back empty
+	httpmock.Reset()
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai?after=abc&first=1",
+		httpmock.NewStringResponder(200, `{"somethings": [], "pagination": {}}`))
+
+	if ctx.assert(!pk.More(), "expected no more items after the empty page") {
+		return
+	}
+}
+
+func TestNextWithRetryTakesEitherPager(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai?limit=1&offset=0",
+		httpmock.NewStringResponder(200, `{"somethings": ["offset thing"], "_total": 1}`))
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai?first=1",
+		httpmock.NewStringResponder(200, `{"somethings": ["cursor thing"], "pagination": {}}`))
+
+	app := InitTwitchNotifierMain()
+	app.options = &Options{}
+
+	offsetPager, err := app.krakenInstance.PagedKraken("somethings", 1, nil, "ohai")
+	if ctx.assertNoErr(err, "PagedKraken()") {
+		return
+	}
+	cursorPager, err := app.krakenInstance.CursorPagedKraken("somethings", 1, nil, "ohai")
+	if ctx.assertNoErr(err, "CursorPagedKraken()") {
+		return
+	}
+
+	var value string
+	for _, pager := range []ResultsPager{offsetPager, cursorPager} {
+		err = app.nextWithRetry(pager, &value, 2)
+		if ctx.assertNoErr(err, "nextWithRetry()") {
+			return
+		}
+	}
+	if ctx.assertStrEqual("cursor thing", value, "value from the cursor pager") {
+		return
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// These use their own local servers instead of httpmock, so they can run in parallel
+
+// A client with its own transport, as httpmock swaps out http.DefaultTransport while other tests run
+func newLocalHTTPClient() *http.Client {
+	return &http.Client{Transport: &http.Transport{}}
+}
+
+// TESTS
+
+func TestKrakenBaseURLAndUserAgent(t *testing.T) {
+	t.Parallel()
+	ctx := NewTestCtx(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, `{"path": "%s", "user_agent": "%s", "client_id": "%s"}`,
+			req.URL.Path, req.Header.Get("User-Agent"), req.Header.Get("Client-ID"))
+	}))
+	defer server.Close()
+
+	kraken := InitKraken(WithBaseURL(server.URL+"/kraken/"), WithHTTPClient(newLocalHTTPClient()),
+		WithUserAgent("twitch-notifier-test/1.0"))
+
+	var response struct {
+		Path       string
+		User_Agent string
+		Client_Id  string
+	}
+	err := kraken.kraken(&response, "users", "fakeusername")
+	if ctx.assertNoErr(err, "kraken()") {
+		return
+	}
+	if ctx.assertStrEqual("/kraken/users/fakeusername", response.Path, "request path") {
+		return
+	}
+	if ctx.assertStrEqual("twitch-notifier-test/1.0", response.User_Agent, "user agent") {
+		return
+	}
+	if ctx.assertStrEqual(CLIENT_ID, response.Client_Id, "client id") {
+		return
+	}
+}
+
+func TestKrakenTimeout(t *testing.T) {
+	t.Parallel()
+	ctx := NewTestCtx(t)
+
+	done := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client := newLocalHTTPClient()
+	kraken := InitKraken(WithBaseURL(server.URL), WithHTTPClient(client), WithTimeout(50*time.Millisecond))
+
+	var response struct{}
+	err := kraken.kraken(&response, "slow")
+	if ctx.assert(err != nil, "expected a timeout error") {
+		return
+	}
+	if ctx.assert(client.Timeout == 0, "WithTimeout changed the timeout of the client it was given") {
+		return
+	}
+}
+
+func TestHelixOptionsFromCommandLine(t *testing.T) {
+	t.Parallel()
+	ctx := NewTestCtx(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/helix/users" {
+			http.NotFound(w, req)
+			return
+		}
+		fmt.Fprint(w, `{"data": [{"id": "99", "login": "fakeusername", "display_name": "FakeUsername"}]}`)
+	}))
+	defer server.Close()
+
+	app := InitTwitchNotifierMain()
+	helix := "helix"
+	helixURL := server.URL + "/helix"
+	authURL := "http://localhost/authorize"
+	app.options = &Options{api: &helix, helix_url: &helixURL, auth_url: &authURL}
+	app.helixInstance.configure(WithHTTPClient(newLocalHTTPClient()))
+
+	backend := app.getAPIBackend()
+	backend.set_auth_token("fakeoauth123")
+	username, err := backend.get_username()
+	if ctx.assertNoErr(err, "get_username()") {
+		return
+	}
+	if ctx.assertStrEqual("fakeusername", username, "username") {
+		return
+	}
+	if ctx.assertStrEqual(authURL, app.krakenInstance.authURL, "auth URL") {
+		return
+	}
+}
+
+func TestTimeoutWithNoClient(t *testing.T) {
+	t.Parallel()
+	ctx := NewTestCtx(t)
+
+	kraken := InitKraken(WithHTTPClient(nil), WithTimeout(time.Second))
+	if ctx.assert(kraken.httpClient != nil && kraken.httpClient.Timeout == time.Second, "expected a client with the timeout") {
+		return
+	}
+	if ctx.assert(http.DefaultClient.Timeout == 0, "WithTimeout changed the timeout of http.DefaultClient") {
+		return
+	}
+}
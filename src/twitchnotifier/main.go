@@ -1,10 +1,11 @@
 package main
 
-//#cgo windows LDFLAGS: -static-libgcc -static-libstdc++ -Wl,-Bstatic -lstdc++ -lpthread -Wl,-Bdynamic
-import "C"
-
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"log"
+	"os"
 )
 
 // CONSTANTS AND SIMILAR
@@ -15,6 +16,10 @@ func getNeededTwitchScopes() []string {
 	return []string{"user_read"} // required for /streams/followed
 }
 
+func getNeededHelixScopes() []string {
+	return []string{"user:read:follows"} // required for /helix/streams/followed and /helix/channels/followed
+}
+
 // COMMAND LINE OPTIONS STUFF
 
 type Options struct {
@@ -31,26 +36,110 @@ type Options struct {
 	help                      *bool
 	reload_time_interval_mins *uint
 	hide_on_launch            *bool
+	api                       *string
+	kraken_url                *string
+	helix_url                 *string
+	auth_url                  *string
+	validate_url              *string
+	http_timeout              *int
+	user_agent                *string
+	rules_file                *string
+	config_file               *string
+	webhook_urls              *string
+	webhook_secret            *string
+	chat_routes_file          *string
+	history_file              *string
+	history_days              *int
+	player                    *string
+	player_quality            *string
+	open_with                 *string
+	mutes_file                *string
+
+	// the settings from the config file, which also holds the ones changed in the GUI
+	config *Config
 }
 
-func parse_args() *Options {
+// Define the flags for the options on a FlagSet
+func defineOptions(flags *flag.FlagSet) *Options {
 	options := &Options{}
-	options.username = flag.String("username", "", "username to use")
-	options.no_browser_auth = flag.Bool("no-browser-auth", false, "don't authenticate through twitch website login if token not supplied")
-	options.poll = flag.Int("poll", 60, "poll interval")
-	options.all = flag.Bool("all", false, "Watch all followed streams, not just ones with notifications enabled")
-	options.idle = flag.Int("idle", 300, "idle time threshold to consider locked (seconds)")
-	options.unlock_notify = flag.Bool("no-unlock-notify", true, "Don't notify again on unlock")
-	options.debug_output = flag.Bool("debug", false, "Debug mode")
-	options.authorization_oauth = flag.String("auth-oauth", "", "Authorization OAuth header value to send")
-	options.ui = flag.Bool("ui", false, "Use the wxpython UI")
-	options.no_popups = flag.Bool("no-popups", false, "Don't do popups, for when using just the UI")
-	options.help = flag.Bool("help", false, "Show usage")
-	options.reload_time_interval_mins = flag.Uint("reload-time-interval", 60, "Number of minutes between automatic channel reloads")
-	options.hide_on_launch = flag.Bool("hide", false, "Don't show the GUI on launch")
+	options.username = flags.String("username", "", "username to use")
+	options.no_browser_auth = flags.Bool("no-browser-auth", false, "don't authenticate through twitch website login if token not supplied")
+	options.poll = flags.Int("poll", 60, "poll interval")
+	options.all = flags.Bool("all", false, "Watch all followed streams, not just ones with notifications enabled")
+	options.idle = flags.Int("idle", 300, "idle time threshold to consider locked (seconds)")
+	options.unlock_notify = flags.Bool("no-unlock-notify", true, "Don't notify again for each stream that went live while idle or locked, just show a summary")
+	options.debug_output = flags.Bool("debug", false, "Debug mode")
+	options.authorization_oauth = flags.String("auth-oauth", "", "Authorization OAuth header value to send")
+	options.ui = flags.Bool("ui", false, "Use the wxpython UI")
+	options.no_popups = flags.Bool("no-popups", false, "Don't do popups, for when using just the UI")
+	options.help = flags.Bool("help", false, "Show usage")
+	options.reload_time_interval_mins = flags.Uint("reload-time-interval", 60, "Number of minutes between automatic channel reloads")
+	options.hide_on_launch = flags.Bool("hide", false, "Don't show the GUI on launch")
+	options.api = flags.String("api", "kraken", "Twitch API to use: kraken or helix (helix treats all follows as having notifications enabled)")
+	options.kraken_url = flags.String("kraken-url", "", "Base URL of the kraken API, e.g. to use a local mock or caching proxy")
+	options.helix_url = flags.String("helix-url", "", "Base URL of the helix API")
+	options.auth_url = flags.String("auth-url", "", "URL of the OAuth authorize page for the browser login")
+	options.validate_url = flags.String("validate-url", "", "URL of the OAuth token validate endpoint")
+	options.http_timeout = flags.Int("http-timeout", 30, "Timeout for API requests (seconds)")
+	options.user_agent = flags.String("user-agent", "", "User-Agent header to send with API requests")
+	options.rules_file = flags.String("rules", "", "JSON file of notification rules (default twitchnotifier.rules.json in the prefs dir)")
+	options.config_file = flags.String("config", "", "Settings file (default twitchnotifier.config.json in the prefs dir)")
+	options.webhook_urls = flags.String("webhook", "", "Comma-separated URLs to POST stream events to as JSON")
+	options.webhook_secret = flags.String("webhook-secret", "", "Key to sign webhook requests with (HMAC-SHA256)")
+	options.chat_routes_file = flags.String("chat-routes", "", "JSON file of Discord and Slack webhooks to post notifications to (default twitchnotifier.chat.json in the prefs dir)")
+	options.history_file = flags.String("history", "", "Database to keep the stream online/offline history in (default twitchnotifier.history.db in the prefs dir)")
+	options.history_days = flags.Int("history-days", 90, "Days of stream history to keep; 0 keeps it all")
+	options.player = flags.String("player", "", "Command to open streams with, e.g. \"streamlink {url} {quality}\" or \"mpv {url}\"")
+	options.player_quality = flags.String("player-quality", "best", "Stream quality for the {quality} in -player")
+	options.open_with = flags.String("open-with", OPEN_WITH_BROWSER, "What clicking a stream opens it in: browser or player")
+	options.mutes_file = flags.String("mutes", "", "JSON file to keep the muted channels in (default twitchnotifier.mutes.json in the prefs dir)")
+	return options
+}
+
+// helix has no public follows list, so it can't find the followed channels without a login
+var errHelixUsernameOnly = errors.New("username-only mode needs -api kraken; the helix API can't get follows without a login")
+
+// Check for a bad -api value and option combinations that can't work. Username-only mode is
+// -username with -no-browser-auth and no token from -auth-oauth or the token file.
+func checkOptions(options *Options, tokenFilename string) error {
+	err := checkAPIOption(*options.api)
+	if err != nil {
+		return fmt.Errorf("bad value '%s' for -api: %s", *options.api, err)
+	}
+	if *options.api != "helix" || *options.username == "" || !*options.no_browser_auth || *options.authorization_oauth != "" {
+		return nil
+	}
+	savedToken, err := loadTokenFile(tokenFilename)
+	if err != nil || savedToken != "" {
+		// there's a token to log in with, or at least the token file will say what's wrong
+		return nil
+	}
+	return errHelixUsernameOnly
+}
+
+func parse_args() *Options {
+	options := defineOptions(flag.CommandLine)
 	msg("before flag parse")
 	flag.Parse()
 	msg("after flag parse")
+
+	configFilename := *options.config_file
+	if configFilename == "" {
+		configFilename = os.Getenv(configEnvName("config"))
+	}
+	if configFilename == "" {
+		configFilename = getConfigFilename()
+	}
+	config, err := LoadConfig(configFilename, flag.CommandLine, os.LookupEnv)
+	if err != nil {
+		msg("Error in config: %s", err)
+	}
+	options.config = config
+
+	err = checkOptions(options, getTokenFilename())
+	if err != nil {
+		log.Fatal(err)
+	}
 	return options
 }
 
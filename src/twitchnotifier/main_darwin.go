@@ -1,4 +1,4 @@
-// +build darwin
+// +build darwin,!headless
 
 package main
 
@@ -35,10 +35,6 @@ func main() {
 	})
 }
 
-func prefsRelativePath() []string {
-	return []string{"Library", "Preferences"}
-}
-
 func (win *MainStatusWindowImpl) osNotification(notification *NotificationQueueEntry) {
 
 	assert(notification != nil, "called with null notification queue entry")
@@ -0,0 +1,88 @@
+// +build headless
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Options that only apply to the headless build
+type HeadlessOptions struct {
+	event_log   *string
+	json_events *bool
+	on_online   *string
+	on_offline  *string
+}
+
+func main() {
+	if runSubcommand(os.Args[1:]) {
+		return
+	}
+
+	headlessOptions := &HeadlessOptions{}
+	headlessOptions.event_log = flag.String("event-log", "", "Append stream events to this file as well as stdout")
+	headlessOptions.json_events = flag.Bool("json-events", false, "Write stream events as JSON lines instead of text")
+	headlessOptions.on_online = flag.String("on-online", "", "Shell command to run when a stream goes online")
+	headlessOptions.on_offline = flag.String("on-offline", "", "Shell command to run when a stream goes offline")
+
+	options := parse_args()
+	if *options.help {
+		flag.Usage()
+		return
+	}
+
+	sinks := []HeadlessEventSink{NewWriterEventSink(os.Stdout, *headlessOptions.json_events)}
+	var eventLog *os.File
+	if *headlessOptions.event_log != "" {
+		var err error
+		eventLog, err = os.OpenFile(*headlessOptions.event_log, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("Couldn't open event log %s: %s", *headlessOptions.event_log, err)
+		}
+		sinks = append(sinks, NewWriterEventSink(eventLog, *headlessOptions.json_events))
+	}
+	if *headlessOptions.on_online != "" || *headlessOptions.on_offline != "" {
+		sinks = append(sinks, NewHookEventSink(*headlessOptions.on_online, *headlessOptions.on_offline))
+	}
+
+	app := InitHeadlessTwitchNotifierMain(options, sinks)
+
+	// there's no browser login here, so use the token we were given or the one the GUI saved
+	token := *options.authorization_oauth
+	if token == "" {
+		savedToken, err := loadTokenFile(getTokenFilename())
+		if err != nil {
+			log.Fatalf("Couldn't read the saved token: %s", err)
+		}
+		token = savedToken
+	}
+	if token != "" {
+		_, err := app.validateToken(token)
+		if isAuthError(err) {
+			log.Fatal("The OAuth token was rejected; run again with a new -auth-oauth token")
+		} else if err != nil {
+			// twitch may be having a moment; the poll will report any real problem with the token
+			msg("Couldn't validate the OAuth token: %s", err)
+		}
+		app._auth_oauth = token
+	} else if *options.username == "" {
+		log.Fatal("Headless mode needs -auth-oauth, a token saved by the GUI, or -username")
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	err := app.run(stop)
+	// log.Fatal doesn't run deferred calls, so close everything first
+	app.shutdown()
+	if eventLog != nil {
+		eventLog.Close()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
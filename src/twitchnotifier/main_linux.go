@@ -1,18 +1,129 @@
-// +build linux
+// +build linux,!headless
 
 package main
 
-import "github.com/rakslice/wxGo/wx"
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/rakslice/wxGo/wx"
+)
+
+// The D-Bus notification service, if there is one; otherwise we fall back to wx.NotificationMessage
+var dbusNotifier *DBusNotifier
+var dbusNotifierChecked bool
+
+// Channel logos we've saved for notification icons, by URL; they're removed when we exit
+var notificationLogoFiles = make(map[string]string)
+var notificationLogoFilesMutex sync.Mutex
 
 func main() {
 	commonMain(nil)
+	removeNotificationLogoFiles()
 }
 
-func prefsRelativePath() []string {
-	return []string{}
+func removeNotificationLogoFiles() {
+	notificationLogoFilesMutex.Lock()
+	defer notificationLogoFilesMutex.Unlock()
+
+	for url, logoFile := range notificationLogoFiles {
+		err := os.Remove(logoFile)
+		if err != nil {
+			msg("Error removing the saved logo for %s: %s", url, err)
+		}
+		delete(notificationLogoFiles, url)
+	}
+}
+
+func (win *MainStatusWindowImpl) getDBusNotifier() *DBusNotifier {
+	if !dbusNotifierChecked {
+		dbusNotifierChecked = true
+		notifier, err := ConnectDBusNotifier(func(callback func()) {
+			// the D-Bus signals come in on their own goroutine
+			win.set_timeout(0, callback)
+		})
+		if err != nil {
+			msg("Using wx notifications; couldn't use the D-Bus notification service: %s", err)
+		} else {
+			dbusNotifier = notifier
+		}
+	}
+	return dbusNotifier
 }
 
 func (win *MainStatusWindowImpl) osNotification(notification *NotificationQueueEntry) {
+	notifier := win.getDBusNotifier()
+	if notifier == nil {
+		win.wxNotification(notification)
+		// wx has no callback timeout semantics, so call for the next notification right away
+		win.notificationTimeout()
+		return
+	}
+
+	// the notification server queues notifications itself, so call for the next one as soon as this
+	// one is sent; waiting for its logo keeps them in order
+	win.dbusNotification(notifier, *notification, func() {
+		win.set_timeout(0, win.notificationTimeout)
+	})
+}
+
+// Send a notification to the D-Bus service, with the channel logo as the icon once we have it saved,
+// and call sent after
+func (win *MainStatusWindowImpl) dbusNotification(notifier *DBusNotifier, notification NotificationQueueEntry, sent func()) {
+	show := func(icon string) {
+		defer sent()
+		on_open := func() {
+			err := notification.callback()
+			if err != nil {
+				win.main_obj.log(fmt.Sprintf("Error opening the notification's link: %s", err))
+			}
+		}
+		_, err := notifier.notify(notification.channel_name, notification.title, notification.msg, icon, notification.high_priority, on_open)
+		if err != nil {
+			msg("D-Bus notification for '%s' failed: %s", notification.msg, err)
+		}
+	}
+
+	if notification.logo_url == "" {
+		show("")
+		return
+	}
+	notificationLogoFilesMutex.Lock()
+	logoFile, ok := notificationLogoFiles[notification.logo_url]
+	notificationLogoFilesMutex.Unlock()
+	if ok {
+		show(logoFile)
+		return
+	}
+
+	// this calls back on another goroutine, which is fine since we don't touch wx from it
+	win.main_obj.doDelayedUrlLoad("notification", notification.logo_url, func(rs *http.Response) {
+		if rs == nil {
+			show("")
+			return
+		}
+		defer rs.Body.Close()
+		if rs.StatusCode != 200 {
+			msg("Got HTTP error %v %s retrieving %s", rs.StatusCode, rs.Status, notification.logo_url)
+			show("")
+			return
+		}
+		logoFile, err := readToTempFile(rs.Body)
+		if err != nil {
+			msg("Error saving logo %s: %s", notification.logo_url, err)
+			show("")
+			return
+		}
+		notificationLogoFilesMutex.Lock()
+		notificationLogoFiles[notification.logo_url] = logoFile
+		notificationLogoFilesMutex.Unlock()
+		show(logoFile)
+	})
+}
+
+func (win *MainStatusWindowImpl) wxNotification(notification *NotificationQueueEntry) {
 	nm := wx.NewNotificationMessage()
 	nm.SetParent(win)
 	icon := win._get_asset_icon()
@@ -20,20 +131,22 @@ func (win *MainStatusWindowImpl) osNotification(notification *NotificationQueueE
 	nm.SetIcon(icon)
 	nm.SetTitle(notification.title)
 	nm.SetMessage(notification.msg)
-	nm.SetFlags(wx.ICON_INFORMATION)
+	if notification.high_priority {
+		nm.SetFlags(wx.ICON_WARNING)
+	} else {
+		nm.SetFlags(wx.ICON_INFORMATION)
+	}
 	result := nm.Show(1)
 	if !result {
 		msg("wx.NotificationMessage.Show() indicated that the notification for '%s' was not shown", notification.msg)
 	}
-
-	// there are no callback timeout semantics so call for the next notification right away
-	win.notificationTimeout()
 }
 
 func (win *MainStatusWindowImpl) additionalBindings() {
 	// last param should be a specific object id if we have one e.g. out.toolbar_icon.GetId()?
 	wx.Bind(win.toolbar_icon, wx.EVT_TASKBAR_CLICK, win._on_toolbar_icon_left_dclick, wx.ID_ANY)
-	// FIXME the event constants for these appear to be missing
+	// FIXME the event constants for these appear to be missing, so notification clicks only
+	// work through the D-Bus notifications
 	//wx.Bind(win.toolbar_icon, wx.EVT_NOTIFICATION_MESSAGE_CLICK, win._on_toolbar_balloon_click, wx.ID_ANY)
 	//wx.Bind(win.toolbar_icon, wx.EVT_NOTIFICATION_MESSAGE_DISMISSED, win._on_toolbar_balloon_timeout, wx.ID_ANY)
 
@@ -1,13 +1,16 @@
 //go:generate goversioninfo  -64
 //go:generate windres -i icon.rc -O coff -o icon.syso
 
-// +build windows
+// +build windows,!headless
 
 // To install goversioninfo, do:
 //   go get github.com/josephspurrier/goversioninfo/cmd/goversioninfo
 
 package main
 
+//#cgo windows LDFLAGS: -static-libgcc -static-libstdc++ -Wl,-Bstatic -lstdc++ -lpthread -Wl,-Bdynamic
+import "C"
+
 import (
 	"github.com/rakslice/wxGo/wx"
 	"time"
@@ -63,10 +66,6 @@ func main() {
 	commonMain(nil)
 }
 
-func prefsRelativePath() []string {
-	return []string{}
-}
-
 func _get_asset_icon_info() (string, int) {
 	subpath := "IDI_ICON_ICO"
 	bitmap_type := wx.BITMAP_TYPE_ICO_RESOURCE
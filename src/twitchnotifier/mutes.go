@@ -0,0 +1,220 @@
+package main
+
+/**
+Channels the user has muted from the channel context menu, so they get no notifications for a while
+or for good. The mutes are kept in a JSON file so they last across restarts:
+
+	{
+	  "mutes": [
+	    {"channel_id": 12345, "channel": "SomeChannel", "until": "2017-08-02T03:00:00Z"},
+	    {"channel_id": 67890, "channel": "SomeOtherChannel"}
+	  ]
+	}
+
+A mute with no until lasts until it's taken off. A muted channel still shows up in the lists and the
+stream event log; it just doesn't get popups or chat posts.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The lengths of time the context menu offers to mute a channel for; 0 is until it's unmuted
+var muteDurations = []struct {
+	label  string
+	length time.Duration
+}{
+	{"For 1 Hour", time.Hour},
+	{"For 8 Hours", 8 * time.Hour},
+	{"For 1 Day", 24 * time.Hour},
+	{"For 1 Week", 7 * 24 * time.Hour},
+	{"Until Unmuted", 0},
+}
+
+var errMutesNotSaved = errors.New("the mutes file couldn't be read, so changes to it aren't saved")
+
+type ChannelMute struct {
+	Channel_Id ChannelID `json:"channel_id"`
+	// the display name, just so the file makes sense to read
+	Channel string `json:"channel"`
+	// nil for a mute that lasts until it's taken off
+	Until *time.Time `json:"until,omitempty"`
+}
+
+type ChannelMutesFile struct {
+	Mutes []*ChannelMute `json:"mutes"`
+}
+
+type ChannelMutes struct {
+	// where changes are saved; empty if they aren't
+	filename string
+	mutes    map[ChannelID]*ChannelMute
+}
+
+func getMutesFilename() string {
+	newParts := append(prefsRelativePath(), "twitchnotifier.mutes.json")
+	return userRelativePath(newParts...)
+}
+
+// Mutes that are saved to filename when they change; empty for ones that aren't saved
+func NewChannelMutes(filename string) *ChannelMutes {
+	return &ChannelMutes{filename: filename, mutes: make(map[ChannelID]*ChannelMute)}
+}
+
+/**
+Load the mutes from a file, leaving out any that ran out before now. If there is no mutes file we
+get no mutes and no error.
+*/
+func LoadChannelMutes(filename string, now time.Time) (*ChannelMutes, error) {
+	out := NewChannelMutes(filename)
+	if !fileExists(filename) {
+		return out, nil
+	}
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	mutesFile := &ChannelMutesFile{}
+	err = json.Unmarshal(buf, mutesFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, mute := range mutesFile.Mutes {
+		if mute != nil && (mute.Until == nil || mute.Until.After(now)) {
+			out.mutes[mute.Channel_Id] = mute
+		}
+	}
+	return out, nil
+}
+
+// The mute on a channel as of now, or nil if it isn't muted
+func (mutes *ChannelMutes) muted_until(channel_id ChannelID, now time.Time) *ChannelMute {
+	mute := mutes.mutes[channel_id]
+	if mute == nil || (mute.Until != nil && !mute.Until.After(now)) {
+		return nil
+	}
+	return mute
+}
+
+func (mutes *ChannelMutes) is_muted(channel_id ChannelID, now time.Time) bool {
+	return mutes.muted_until(channel_id, now) != nil
+}
+
+/**
+Mute a channel until a time, or for good with the zero time, and save the mutes. The mute takes
+effect even if saving it fails.
+*/
+func (mutes *ChannelMutes) mute(channel *ChannelInfo, until time.Time) error {
+	mute := &ChannelMute{Channel_Id: channel.Id, Channel: channel.Display_Name}
+	if !until.IsZero() {
+		until = until.UTC()
+		mute.Until = &until
+	}
+	mutes.mutes[channel.Id] = mute
+	return mutes.save()
+}
+
+func (mutes *ChannelMutes) unmute(channel_id ChannelID) error {
+	if _, ok := mutes.mutes[channel_id]; !ok {
+		return nil
+	}
+	delete(mutes.mutes, channel_id)
+	return mutes.save()
+}
+
+type channelMuteOrder []*ChannelMute
+
+func (order channelMuteOrder) Len() int      { return len(order) }
+func (order channelMuteOrder) Swap(i, j int) { order[i], order[j] = order[j], order[i] }
+func (order channelMuteOrder) Less(i, j int) bool {
+	a_name, b_name := strings.ToLower(order[i].Channel), strings.ToLower(order[j].Channel)
+	if a_name != b_name {
+		return a_name < b_name
+	}
+	return order[i].Channel_Id < order[j].Channel_Id
+}
+
+// Write the mutes out in channel name order, so the file reads well and doesn't churn
+func (mutes *ChannelMutes) save() error {
+	if mutes.filename == "" {
+		return errMutesNotSaved
+	}
+	mutesFile := &ChannelMutesFile{Mutes: []*ChannelMute{}}
+	for _, mute := range mutes.mutes {
+		mutesFile.Mutes = append(mutesFile.Mutes, mute)
+	}
+	sort.Sort(channelMuteOrder(mutesFile.Mutes))
+	buf, err := json.MarshalIndent(mutesFile, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(mutes.filename, buf, 0644)
+}
+
+// e.g. "Muted until Tue Aug 1 21:00", or just "Muted" for one with no end
+func (mute *ChannelMute) desc() string {
+	if mute.Until == nil {
+		return "Muted"
+	}
+	return fmt.Sprintf("Muted until %s", mute.Until.Local().Format("Mon Jan 2 15:04"))
+}
+
+// APP MUTES SUPPORT
+
+// The -mutes file, or the default one in the prefs dir; none for options that weren't made from the
+// command line, so mutes then last until we exit
+func (app *TwitchNotifierMain) mutes_filename() string {
+	if app.options.mutes_file == nil {
+		return ""
+	}
+	if *app.options.mutes_file != "" {
+		return *app.options.mutes_file
+	}
+	return getMutesFilename()
+}
+
+func (app *TwitchNotifierMain) getChannelMutes() *ChannelMutes {
+	if app.channelMutes == nil {
+		mutesFilename := app.mutes_filename()
+		mutes, err := LoadChannelMutes(mutesFilename, time.Now())
+		if err != nil {
+			app.getEventsInterface().log(fmt.Sprintf("Error loading channel mutes from '%s': %s", mutesFilename, err))
+			// don't save over a file we couldn't read
+			mutes = NewChannelMutes("")
+		}
+		app.channelMutes = mutes
+	}
+	return app.channelMutes
+}
+
+// Mute a channel's notifications for a length of time, or until it's unmuted with 0
+func (app *TwitchNotifierMain) mute_channel(channel *ChannelInfo, length time.Duration) error {
+	until := time.Time{}
+	if length > 0 {
+		until = time.Now().Add(length)
+	}
+	mutes := app.getChannelMutes()
+	err := mutes.mute(channel, until)
+	if err != nil {
+		err = fmt.Errorf("couldn't save the mute for %s: %s", channel.Display_Name, err)
+		app.getEventsInterface().log(err.Error())
+	}
+	app.getEventsInterface().log(fmt.Sprintf("%s: %s", channel.Display_Name, mutes.mutes[channel.Id].desc()))
+	return err
+}
+
+func (app *TwitchNotifierMain) unmute_channel(channel *ChannelInfo) error {
+	err := app.getChannelMutes().unmute(channel.Id)
+	if err != nil {
+		err = fmt.Errorf("couldn't save the unmute for %s: %s", channel.Display_Name, err)
+		app.getEventsInterface().log(err.Error())
+	}
+	app.getEventsInterface().log(fmt.Sprintf("%s: Unmuted", channel.Display_Name))
+	return err
+}
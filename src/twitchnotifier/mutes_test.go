@@ -0,0 +1,139 @@
+package main
+
+import (
+	"github.com/jarcoal/httpmock"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func noChannelMutes() *ChannelMutes {
+	return NewChannelMutes("")
+}
+
+// TESTS
+
+func TestChannelMutesFile(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	tempDir, err := ioutil.TempDir("", "mutestest")
+	if ctx.assertNoErr(err, "TempDir()") {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+	mutesFilename := path.Join(tempDir, "twitchnotifier.mutes.json")
+
+	now := time.Date(2017, 8, 1, 20, 0, 0, 0, time.UTC)
+	mutes, err := LoadChannelMutes(mutesFilename, now)
+	if ctx.assertNoErr(err, "LoadChannelMutes() with no file") {
+		return
+	}
+	if ctx.assert(!mutes.is_muted(1, now), "expected no mutes with no file") {
+		return
+	}
+
+	if ctx.assertNoErr(mutes.mute(&ChannelInfo{Id: 2, Display_Name: "Zulu"}, time.Time{}), "mute() for good") {
+		return
+	}
+	if ctx.assertNoErr(mutes.mute(&ChannelInfo{Id: 1, Display_Name: "alpha"}, now.Add(time.Hour)), "mute() for an hour") {
+		return
+	}
+	buf, err := ioutil.ReadFile(mutesFilename)
+	if ctx.assertNoErr(err, "ReadFile()") {
+		return
+	}
+	if ctx.assert(strings.Index(string(buf), "alpha") < strings.Index(string(buf), "Zulu"), "expected the mutes in name order: %s", buf) {
+		return
+	}
+
+	// they're still there after a restart, until the timed one runs out
+	mutes, err = LoadChannelMutes(mutesFilename, now.Add(30*time.Minute))
+	if ctx.assertNoErr(err, "LoadChannelMutes()") {
+		return
+	}
+	if ctx.assert(mutes.is_muted(1, now.Add(30*time.Minute)) && mutes.is_muted(2, now.Add(30*time.Minute)), "expected both channels muted") {
+		return
+	}
+	if ctx.assert(!mutes.is_muted(1, now.Add(time.Hour)), "expected the mute to run out after an hour") {
+		return
+	}
+	if ctx.assertStrEqual("Muted", mutes.muted_until(2, now).desc(), "description of a mute for good") {
+		return
+	}
+
+	mutes, err = LoadChannelMutes(mutesFilename, now.Add(2*time.Hour))
+	if ctx.assertNoErr(err, "LoadChannelMutes() later") {
+		return
+	}
+	if ctx.assert(!mutes.is_muted(1, now.Add(2*time.Hour)) && mutes.is_muted(2, now.Add(2*time.Hour)), "expected just the mute for good to be left") {
+		return
+	}
+
+	if ctx.assertNoErr(mutes.unmute(2), "unmute()") {
+		return
+	}
+	mutes, err = LoadChannelMutes(mutesFilename, now)
+	if ctx.assertNoErr(err, "LoadChannelMutes() after unmuting") {
+		return
+	}
+	if ctx.assert(!mutes.is_muted(2, now), "expected the unmute to be saved") {
+		return
+	}
+}
+
+func TestChannelMutesBadFile(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	tempDir, err := ioutil.TempDir("", "mutestest")
+	if ctx.assertNoErr(err, "TempDir()") {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+	mutesFilename := path.Join(tempDir, "twitchnotifier.mutes.json")
+	if ctx.assertNoErr(ioutil.WriteFile(mutesFilename, []byte(`{"mutes": [`), 0644), "WriteFile()") {
+		return
+	}
+
+	_, err = LoadChannelMutes(mutesFilename, time.Now())
+	if ctx.assertGotErr("unexpected end of JSON input", err, "LoadChannelMutes() of a broken file") {
+		return
+	}
+
+	// mutes that can't be saved still work for this run
+	mutes := noChannelMutes()
+	err = mutes.mute(&ChannelInfo{Id: 1, Display_Name: "alpha"}, time.Time{})
+	if ctx.assertGotErr(errMutesNotSaved.Error(), err, "mute() with nowhere to save") {
+		return
+	}
+	if ctx.assert(mutes.is_muted(1, time.Now()), "expected the channel muted anyway") {
+		return
+	}
+}
+
+func TestMutedChannelGetsNoNotification(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	sink := &recordingEventSink{}
+	app := newTestApp(&Options{}, sink)
+	app.channelMutes.mute(&ChannelInfo{Id: 123, Display_Name: "FakeChannel"}, time.Now().Add(time.Hour))
+
+	registerHeadlessFollows()
+	registerHeadlessStreams(true)
+	app.main_loop_iter.next()
+
+	if ctx.assert(len(sink.events) == 1, "expected just the online event for a muted channel but got %v", len(sink.events)) {
+		return
+	}
+	if ctx.assertStrEqual(HEADLESS_EVENT_ONLINE, sink.events[0].Event, "first event") {
+		return
+	}
+	// muted isn't waiting, so the stream doesn't get another try
+	if ctx.assert(app.main_loop_iter.last_streams[123] == 456, "expected the stream to be recorded as handled") {
+		return
+	}
+}
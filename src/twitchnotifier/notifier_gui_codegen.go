@@ -1,3 +1,5 @@
+// +build !headless
+
 package main
 
 // Generated by wxg_to_golang at 2017-02-26 18:03:38.028000
@@ -14,7 +16,7 @@ type MainStatusWindow struct {
 	sizer_7 wx.BoxSizer
 	sizer_3 wx.BoxSizer
 	label_1 wx.StaticText
-	list_online wx.ListBox
+	list_online wx.ListCtrl
 	label_2 wx.StaticText
 	list_offline wx.ListBox
 	notebook_1 wx.Notebook
@@ -47,6 +49,7 @@ type MainStatusWindow struct {
 type MainStatusWindowEvents interface {
 	_on_list_online_gen(e wx.Event)
 	_on_list_online_dclick(e wx.Event)
+	_on_list_online_col_click(e wx.Event)
 	_on_list_offline_gen(e wx.Event)
 	_on_list_offline_dclick(e wx.Event)
 	_on_options_button_click(e wx.Event)
@@ -63,7 +66,7 @@ func initMainStatusWindow(eventInterface MainStatusWindowEvents) *MainStatusWind
 	out.sizer_7 = wx.NewBoxSizer(wx.VERTICAL)
 	out.sizer_3 = wx.NewBoxSizer(wx.HORIZONTAL)
 	out.label_1 = wx.NewStaticText(out.panel_1, wx.ID_ANY, "Online")
-	out.list_online = wx.NewListBox(out.panel_1, wx.ID_ANY, wx.DefaultPosition, wx.DefaultSize, []string {})
+	out.list_online = wx.NewListCtrl(out.panel_1, wx.ID_ANY, wx.DefaultPosition, wx.DefaultSize, wx.LC_REPORT|wx.LC_SINGLE_SEL|wx.SUNKEN_BORDER)
 	out.label_2 = wx.NewStaticText(out.panel_1, wx.ID_ANY, "Offline")
 	out.list_offline = wx.NewListBox(out.panel_1, wx.ID_ANY, wx.DefaultPosition, wx.DefaultSize, []string {})
 	out.notebook_1 = wx.NewNotebook(out.panel_1, wx.ID_ANY, wx.DefaultPosition, wx.DefaultSize, 0, "notebook_1")
@@ -95,8 +98,9 @@ func initMainStatusWindow(eventInterface MainStatusWindowEvents) *MainStatusWind
 	out.set_properties()
 	out.do_layout()
 	
-	wx.Bind(out, wx.EVT_LISTBOX, eventInterface._on_list_online_gen, out.list_online.GetId())
-	wx.Bind(out, wx.EVT_LISTBOX_DCLICK, eventInterface._on_list_online_dclick, out.list_online.GetId())
+	wx.Bind(out, wx.EVT_LIST_ITEM_SELECTED, eventInterface._on_list_online_gen, out.list_online.GetId())
+	wx.Bind(out, wx.EVT_LIST_ITEM_ACTIVATED, eventInterface._on_list_online_dclick, out.list_online.GetId())
+	wx.Bind(out, wx.EVT_LIST_COL_CLICK, eventInterface._on_list_online_col_click, out.list_online.GetId())
 	wx.Bind(out, wx.EVT_LISTBOX, eventInterface._on_list_offline_gen, out.list_offline.GetId())
 	wx.Bind(out, wx.EVT_LISTBOX_DCLICK, eventInterface._on_list_offline_dclick, out.list_offline.GetId())
 	wx.Bind(out, wx.EVT_BUTTON, eventInterface._on_options_button_click, out.button_options.GetId())
@@ -112,7 +116,7 @@ func (out MainStatusWindow) do_layout() {
 	out.panel_1.SetSizer(out.sizer_7)
 	out.sizer_7.Add(out.sizer_3, 0, wx.EXPAND, 0)
 	out.sizer_7.Add(out.label_1, 0, wx.EXPAND, 0)
-	out.sizer_7.Add(out.list_online, 0, wx.EXPAND, 0)
+	out.sizer_7.Add(out.list_online, 1, wx.EXPAND, 0)
 	out.sizer_7.Add(out.label_2, 0, wx.EXPAND, 0)
 	out.sizer_7.Add(out.list_offline, 1, wx.EXPAND, 0)
 	out.sizer_7.Add(out.notebook_1, 1, wx.EXPAND, 0)
@@ -153,7 +157,6 @@ func (window MainStatusWindow) set_properties() {
 	window.SetBackgroundColour(wx.NewColour(byte(240), byte(240), byte(240)))
 	window.list_online.SetToolTip("Double-Click to open stream page")
 	window.list_offline.SetToolTip("Double-Click to open channel page")
-	window.button_options.Enable(false)
 	window.bitmap_channel_logo.SetMinSize(wx.NewSize(128, 128))
 	window.button_open_channel.Enable(false)
 }
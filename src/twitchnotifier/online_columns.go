@@ -0,0 +1,134 @@
+package main
+
+/**
+The columns of the online channels list, what goes in them, and the order the list takes when it's
+sorted by each of them
+*/
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+type OnlineColumn int
+
+const (
+	ONLINE_COLUMN_CHANNEL OnlineColumn = iota
+	ONLINE_COLUMN_VIEWERS
+	ONLINE_COLUMN_GAME
+	ONLINE_COLUMN_UPTIME
+	ONLINE_COLUMN_COUNT
+)
+
+var onlineColumnHeadings = [ONLINE_COLUMN_COUNT]string{"Channel", "Viewers", "Game", "Uptime"}
+
+// The column the online list is sorted by, and which way
+type OnlineListSort struct {
+	Column     OnlineColumn
+	Descending bool
+}
+
+/**
+The sort after a click on a column heading. Clicking the column the list is already sorted by
+reverses it; a new column starts out ascending, except viewers, which starts with the most watched.
+*/
+func (order OnlineListSort) clicked(column OnlineColumn) OnlineListSort {
+	if column == order.Column {
+		return OnlineListSort{column, !order.Descending}
+	}
+	return OnlineListSort{column, column == ONLINE_COLUMN_VIEWERS}
+}
+
+// The heading of a column, with an arrow on the one the list is sorted by
+func (order OnlineListSort) heading(column OnlineColumn) string {
+	heading := onlineColumnHeadings[column]
+	if column != order.Column {
+		return heading
+	}
+	if order.Descending {
+		return heading + " ▼"
+	}
+	return heading + " ▲"
+}
+
+// When a stream started, or the zero time if we can't tell
+func streamStartTime(stream *StreamInfo) time.Time {
+	if stream == nil {
+		return time.Time{}
+	}
+	start, err := convert_rfc3339_time(stream.Created_at)
+	if err != nil {
+		return time.Time{}
+	}
+	return start
+}
+
+func streamGame(stream *StreamInfo) string {
+	if stream == nil || stream.Game == nil {
+		return ""
+	}
+	return *stream.Game
+}
+
+// The text for each column of an online channel's row; label goes in the channel column
+func onlineColumnTexts(label string, stream *StreamInfo, now time.Time) [ONLINE_COLUMN_COUNT]string {
+	out := [ONLINE_COLUMN_COUNT]string{}
+	out[ONLINE_COLUMN_CHANNEL] = label
+	if stream == nil {
+		return out
+	}
+	out[ONLINE_COLUMN_VIEWERS] = strconv.Itoa(stream.Viewers)
+	out[ONLINE_COLUMN_GAME] = streamGame(stream)
+	if start := streamStartTime(stream); !start.IsZero() {
+		out[ONLINE_COLUMN_UPTIME] = time_desc(now.Sub(start))
+	}
+	return out
+}
+
+/**
+Whether channel a goes before channel b in the online list. Channels that tie on the sort column
+go in name order, whichever way the list is sorted, and then in id order, so no two channels are
+equal and the order is always the same for the same streams.
+*/
+func (order OnlineListSort) less(a *ChannelInfo, a_stream *StreamInfo, b *ChannelInfo, b_stream *StreamInfo) bool {
+	// how a compares to b in the sort column: negative if a is less
+	compare := 0
+	switch order.Column {
+	case ONLINE_COLUMN_CHANNEL:
+		compare = strings.Compare(strings.ToLower(a.Display_Name), strings.ToLower(b.Display_Name))
+		if compare == 0 && a.Id != b.Id {
+			compare = 1
+			if a.Id < b.Id {
+				compare = -1
+			}
+		}
+	case ONLINE_COLUMN_VIEWERS:
+		var a_viewers, b_viewers int
+		if a_stream != nil {
+			a_viewers = a_stream.Viewers
+		}
+		if b_stream != nil {
+			b_viewers = b_stream.Viewers
+		}
+		compare = a_viewers - b_viewers
+	case ONLINE_COLUMN_GAME:
+		compare = strings.Compare(strings.ToLower(streamGame(a_stream)), strings.ToLower(streamGame(b_stream)))
+	case ONLINE_COLUMN_UPTIME:
+		// the later a stream started, the less it's been up
+		a_start := streamStartTime(a_stream)
+		b_start := streamStartTime(b_stream)
+		if a_start.After(b_start) {
+			compare = -1
+		} else if b_start.After(a_start) {
+			compare = 1
+		}
+	}
+	if order.Descending {
+		compare = -compare
+	}
+	if compare != 0 || order.Column == ONLINE_COLUMN_CHANNEL {
+		return compare < 0
+	}
+	return OnlineListSort{}.less(a, nil, b, nil)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Three live channels, and the time to look at them from
+func newOnlineTestStreams() ([]*ChannelInfo, map[ChannelID]*StreamInfo, time.Time) {
+	now := time.Date(2017, 8, 1, 20, 0, 0, 0, time.UTC)
+	game := func(name string) *string { return &name }
+	channels := []*ChannelInfo{
+		{Id: 1, Display_Name: "alpha"},
+		{Id: 2, Display_Name: "Bravo"},
+		{Id: 3, Display_Name: "charlie"},
+	}
+	streams := map[ChannelID]*StreamInfo{
+		1: {Id: 10, Game: game("Zelda"), Viewers: 50, Created_at: "2017-08-01T19:30:00Z"},
+		2: {Id: 20, Game: game("another game"), Viewers: 1200, Created_at: "2017-08-01T17:00:00Z"},
+		3: {Id: 30, Game: game("zelda"), Viewers: 50, Created_at: "2017-08-01T19:55:00Z"},
+	}
+	return channels, streams, now
+}
+
+func onlineTestOrder(order OnlineListSort) string {
+	channels, streams, _ := newOnlineTestStreams()
+	entries := []*ChannelListEntry{}
+	for _, channel := range channels {
+		entries = append(entries, &ChannelListEntry{Channel: channel, Stream: streams[channel.Id], Online: true})
+	}
+	less := func(a *ChannelListEntry, b *ChannelListEntry) bool {
+		return order.less(a.Channel, a.Stream, b.Channel, b.Stream)
+	}
+	sort.Sort(channelEntryOrder{entries, less})
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Channel.Display_Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// TESTS
+
+func TestKrakenStreamViewersAndPreview(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	stream := &StreamInfo{}
+	err := json.Unmarshal([]byte(`{"_id": 456, "game": "a vidya game", "viewers": 1234, "created_at": "2016-01-01T01:01:01Z",
+		"preview": {"small": "https://example.com/80x45.jpg", "medium": "https://example.com/320x180.jpg",
+		            "large": "https://example.com/640x360.jpg", "template": "https://example.com/{width}x{height}.jpg"}}`), stream)
+	if ctx.assertNoErr(err, "json.Unmarshal()") {
+		return
+	}
+	if ctx.assert(stream.Viewers == 1234, "expected 1234 viewers but got %v", stream.Viewers) {
+		return
+	}
+	if ctx.assertStrEqual("https://example.com/80x45.jpg", stream.Preview.Small, "small preview") {
+		return
+	}
+	if ctx.assertStrEqual("https://example.com/{width}x{height}.jpg", stream.Preview.Template, "preview template") {
+		return
+	}
+}
+
+func TestOnlineColumnTexts(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	_, streams, now := newOnlineTestStreams()
+	texts := onlineColumnTexts("Bravo", streams[2], now)
+	if ctx.assertStrEqual("Bravo|1200|another game|3 h 00 m", strings.Join(texts[:], "|"), "column texts") {
+		return
+	}
+
+	// an offline channel just has its name
+	texts = onlineColumnTexts("Bravo", nil, now)
+	if ctx.assertStrEqual("Bravo|||", strings.Join(texts[:], "|"), "column texts with no stream") {
+		return
+	}
+}
+
+func TestOnlineListSort(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	cases := []struct {
+		order    OnlineListSort
+		expected string
+	}{
+		{OnlineListSort{ONLINE_COLUMN_CHANNEL, false}, "alpha,Bravo,charlie"},
+		{OnlineListSort{ONLINE_COLUMN_CHANNEL, true}, "charlie,Bravo,alpha"},
+		// ties go in name order either way
+		{OnlineListSort{ONLINE_COLUMN_VIEWERS, true}, "Bravo,alpha,charlie"},
+		{OnlineListSort{ONLINE_COLUMN_VIEWERS, false}, "alpha,charlie,Bravo"},
+		{OnlineListSort{ONLINE_COLUMN_GAME, false}, "Bravo,alpha,charlie"},
+		{OnlineListSort{ONLINE_COLUMN_UPTIME, false}, "charlie,alpha,Bravo"},
+		{OnlineListSort{ONLINE_COLUMN_UPTIME, true}, "Bravo,alpha,charlie"},
+	}
+	for _, c := range cases {
+		if ctx.assertStrEqual(c.expected, onlineTestOrder(c.order), "order for "+c.order.heading(c.order.Column)) {
+			return
+		}
+	}
+}
+
+func TestOnlineListSortClicked(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	order := OnlineListSort{}
+	order = order.clicked(ONLINE_COLUMN_VIEWERS)
+	if ctx.assert(order == OnlineListSort{ONLINE_COLUMN_VIEWERS, true}, "expected most viewers first but got %+v", order) {
+		return
+	}
+	order = order.clicked(ONLINE_COLUMN_VIEWERS)
+	if ctx.assert(order == OnlineListSort{ONLINE_COLUMN_VIEWERS, false}, "expected the second click to reverse it but got %+v", order) {
+		return
+	}
+	order = order.clicked(ONLINE_COLUMN_GAME)
+	if ctx.assert(order == OnlineListSort{ONLINE_COLUMN_GAME, false}, "expected a new column to start ascending but got %+v", order) {
+		return
+	}
+	if ctx.assertStrEqual("Game ▲", order.heading(ONLINE_COLUMN_GAME), "sorted column heading") {
+		return
+	}
+	if ctx.assertStrEqual("Viewers", order.heading(ONLINE_COLUMN_VIEWERS), "other column heading") {
+		return
+	}
+}
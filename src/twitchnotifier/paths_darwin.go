@@ -0,0 +1,7 @@
+// +build darwin
+
+package main
+
+func prefsRelativePath() []string {
+	return []string{"Library", "Preferences"}
+}
@@ -0,0 +1,7 @@
+// +build linux
+
+package main
+
+func prefsRelativePath() []string {
+	return []string{}
+}
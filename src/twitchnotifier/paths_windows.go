@@ -0,0 +1,7 @@
+// +build windows
+
+package main
+
+func prefsRelativePath() []string {
+	return []string{}
+}
@@ -0,0 +1,258 @@
+package main
+
+/**
+Opening streams: in the browser, or in an external player like streamlink or mpv. The player is a
+command template from the -player option, with these placeholders filled in:
+
+	{url}      the stream or channel URL
+	{quality}  the -player-quality option, e.g. best or 720p
+	{channel}  the channel name
+
+e.g. -player "streamlink {url} {quality}" or -player "mpv {url}". If there's no {url} the URL goes
+on the end. -open-with says which one double clicks and notification clicks use. A channel's chat
+always opens in the browser, in twitch's popout chat page.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	OPEN_WITH_BROWSER = "browser"
+	OPEN_WITH_PLAYER  = "player"
+
+	// how much of the player's error output goes in the log when it fails
+	PLAYER_STDERR_LOG_LIMIT = 500
+)
+
+var errNoPlayer = errors.New("no player command is set up; set the -player option")
+
+const TWITCH_CHAT_POPOUT_URL = "https://www.twitch.tv/popout/%s/chat?popout="
+
+/**
+Split a command line into arguments on spaces, with single and double quotes to keep spaces in an
+argument, and backslash to escape a character outside single quotes
+*/
+func splitCommandLine(command string) ([]string, error) {
+	args := []string{}
+	var current bytes.Buffer
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, c := range command {
+		switch {
+		case escaped:
+			current.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, errors.New("backslash at the end")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// The player command's arguments for a stream, with the placeholders filled in
+func playerCommandArgs(template string, url string, quality string, channel_name string) ([]string, error) {
+	args, err := splitCommandLine(template)
+	if err != nil {
+		return nil, fmt.Errorf("bad player command '%s': %s", template, err)
+	}
+	if len(args) == 0 {
+		return nil, errNoPlayer
+	}
+	replacer := strings.NewReplacer("{url}", url, "{quality}", quality, "{channel}", channel_name)
+	hasUrl := false
+	for i, arg := range args {
+		if strings.Contains(arg, "{url}") {
+			hasUrl = true
+		}
+		args[i] = replacer.Replace(arg)
+	}
+	if !hasUrl {
+		args = append(args, url)
+	}
+	return args, nil
+}
+
+/**
+Keeps just the last limit bytes written to it, so a player that writes to stderr for hours doesn't
+use up memory for output we only log the end of
+*/
+type tailWriter struct {
+	limit     int
+	tail      []byte
+	truncated bool
+}
+
+func newTailWriter(limit int) *tailWriter {
+	return &tailWriter{limit: limit}
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.tail = append(w.tail, p...)
+	if len(w.tail) > w.limit {
+		// copy the end so the buffer doesn't keep growing underneath
+		w.tail = append([]byte{}, w.tail[len(w.tail)-w.limit:]...)
+		w.truncated = true
+	}
+	return len(p), nil
+}
+
+// The tail as text, starting on a whole UTF-8 character, with "..." in front if anything was cut off
+func (w *tailWriter) String() string {
+	tail := w.tail
+	if w.truncated {
+		for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+			tail = tail[1:]
+		}
+	}
+	output := strings.TrimSpace(string(tail))
+	if w.truncated {
+		output = "..." + output
+	}
+	return output
+}
+
+/**
+Start the player. The player runs on its own; when it exits, done is called from another goroutine
+with any error and the end of what it wrote to stderr.
+*/
+func startPlayer(args []string, done func(err error, stderr string)) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	stderr := newTailWriter(PLAYER_STDERR_LOG_LIMIT)
+	cmd.Stderr = stderr
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	go func() {
+		err := cmd.Wait()
+		if done != nil {
+			done(err, stderr.String())
+		}
+	}()
+	return nil
+}
+
+// APP STREAM OPENING SUPPORT
+
+func (app *TwitchNotifierMain) player_command() string {
+	if app.options.player == nil {
+		return ""
+	}
+	return strings.TrimSpace(*app.options.player)
+}
+
+func (app *TwitchNotifierMain) has_player() bool {
+	return app.player_command() != ""
+}
+
+// Whether clicks open streams in the player, from the -open-with option
+func (app *TwitchNotifierMain) open_with_player_by_default() bool {
+	return app.options.open_with != nil && *app.options.open_with == OPEN_WITH_PLAYER && app.has_player()
+}
+
+/**
+Open a stream in the player or the browser, as the -open-with option says. Failures are reported
+in the log as well as returned.
+*/
+func (app *TwitchNotifierMain) open_stream_default(url string, channel_name string) error {
+	return app.open_stream(url, channel_name, app.open_with_player_by_default())
+}
+
+func (app *TwitchNotifierMain) open_stream(url string, channel_name string, in_player bool) error {
+	var err error
+	if in_player {
+		err = app.open_stream_in_player(url, channel_name)
+	} else {
+		err = webbrowser_open(url)
+		if err != nil {
+			err = fmt.Errorf("couldn't open the browser for %s: %s", url, err)
+		}
+	}
+	if err != nil {
+		app.getEventsInterface().log(err.Error())
+	}
+	return err
+}
+
+// The popout chat page for a channel, or "" if we don't know its login
+func chatPopoutUrl(channel *ChannelInfo) string {
+	login := channelLogin(channel)
+	if login == "" {
+		return ""
+	}
+	return fmt.Sprintf(TWITCH_CHAT_POPOUT_URL, strings.ToLower(login))
+}
+
+func (app *TwitchNotifierMain) open_chat(channel *ChannelInfo) error {
+	chatUrl := chatPopoutUrl(channel)
+	var err error
+	if chatUrl == "" {
+		err = fmt.Errorf("don't know where the chat for %s is", channel.Display_Name)
+	} else if err = webbrowser_open(chatUrl); err != nil {
+		err = fmt.Errorf("couldn't open the browser for %s: %s", chatUrl, err)
+	}
+	if err != nil {
+		app.getEventsInterface().log(err.Error())
+	}
+	return err
+}
+
+func (app *TwitchNotifierMain) open_stream_in_player(url string, channel_name string) error {
+	quality := "best"
+	if app.options.player_quality != nil && *app.options.player_quality != "" {
+		quality = *app.options.player_quality
+	}
+	args, err := playerCommandArgs(app.player_command(), url, quality, channel_name)
+	if err != nil {
+		return err
+	}
+	msg("starting player: %q", args)
+	err = startPlayer(args, func(err error, stderr string) {
+		// this is on the player's goroutine, so it can't go to the GUI log
+		if err != nil {
+			msg("player for %s exited with %s: %s", url, err, stderr)
+		} else {
+			msg("player for %s exited", url)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't start the player '%s': %s", args[0], err)
+	}
+	app.getEventsInterface().log(fmt.Sprintf("Opened %s in %s", url, args[0]))
+	return nil
+}
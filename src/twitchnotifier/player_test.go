@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// TESTS
+
+func TestSplitCommandLine(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	cases := map[string]string{
+		"streamlink {url} best":                   "streamlink|{url}|best",
+		`  mpv   --title "{channel} live" {url} `: "mpv|--title|{channel} live|{url}",
+		`"C:\\Program Files\\mpv\\mpv.exe" {url}`: `C:\Program Files\mpv\mpv.exe|{url}`,
+		`sh -c 'echo "$0" > out' {url}`:           `sh|-c|echo "$0" > out|{url}`,
+		`player with\ space ""`:                   "player|with space|",
+	}
+	for command, expected := range cases {
+		args, err := splitCommandLine(command)
+		if ctx.assertNoErr(err, "splitCommandLine() of "+command) {
+			return
+		}
+		if ctx.assertStrEqual(expected, strings.Join(args, "|"), "splitCommandLine() of "+command) {
+			return
+		}
+	}
+
+	_, err := splitCommandLine(`mpv "{url}`)
+	if ctx.assertGotErr(`unterminated " quote`, err, "splitCommandLine() with an open quote") {
+		return
+	}
+}
+
+func TestPlayerCommandArgs(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	args, err := playerCommandArgs("streamlink --title {channel} {url} {quality}", "https://www.twitch.tv/fakechannel", "720p", "FakeChannel")
+	if ctx.assertNoErr(err, "playerCommandArgs()") {
+		return
+	}
+	if ctx.assertStrEqual("streamlink|--title|FakeChannel|https://www.twitch.tv/fakechannel|720p", strings.Join(args, "|"), "streamlink args") {
+		return
+	}
+
+	// with no {url}, it goes on the end
+	args, err = playerCommandArgs("mpv --fs", "https://www.twitch.tv/fakechannel", "best", "FakeChannel")
+	if ctx.assertNoErr(err, "playerCommandArgs() with no {url}") {
+		return
+	}
+	if ctx.assertStrEqual("mpv|--fs|https://www.twitch.tv/fakechannel", strings.Join(args, "|"), "mpv args") {
+		return
+	}
+
+	_, err = playerCommandArgs("  ", "https://www.twitch.tv/fakechannel", "best", "FakeChannel")
+	if ctx.assert(err == errNoPlayer, "expected errNoPlayer but got %v", err) {
+		return
+	}
+}
+
+func TestOpenStreamInPlayer(t *testing.T) {
+	ctx := NewTestCtx(t)
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh to run as the player")
+	}
+
+	dir, err := ioutil.TempDir("", "twitchnotifier")
+	if ctx.assertNoErr(err, "ioutil.TempDir()") {
+		return
+	}
+	defer os.RemoveAll(dir)
+	outFilename := filepath.Join(dir, "player.out")
+
+	app := newTestApp(&Options{})
+	player := `/bin/sh -c 'echo "$0 $1" > "$2"' {url} {quality} ` + outFilename
+	quality := "480p"
+	open_with := OPEN_WITH_PLAYER
+	app.options.player = &player
+	app.options.player_quality = &quality
+	app.options.open_with = &open_with
+
+	callback := NotificationCallback{"FakeChannel", "https://www.twitch.tv/fakechannel", false, "", app.open_stream_default}
+	if ctx.assertNoErr(callback.callback(), "clicking the notification") {
+		return
+	}
+	var output []byte
+	for i := 0; i < 100; i++ {
+		output, err = ioutil.ReadFile(outFilename)
+		if err == nil && len(output) > 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if ctx.assertStrEqual("https://www.twitch.tv/fakechannel 480p\n", string(output), "what the player got") {
+		return
+	}
+
+	// a player that isn't there is an error rather than a silent failure
+	player = filepath.Join(dir, "no-such-player") + " {url}"
+	err = app.open_stream_default("https://www.twitch.tv/fakechannel", "FakeChannel")
+	if ctx.assert(err != nil && strings.HasPrefix(err.Error(), "couldn't start the player"), "expected a start error but got %v", err) {
+		return
+	}
+
+	// -open-with player with no player command falls back to the browser
+	player = ""
+	if ctx.assert(!app.open_with_player_by_default(), "expected the browser with no player command") {
+		return
+	}
+}
+
+func TestPlayerStderrTail(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	w := newTailWriter(PLAYER_STDERR_LOG_LIMIT)
+	fmt.Fprint(w, "  short error\n")
+	if ctx.assertStrEqual("short error", w.String(), "tail of short output") {
+		return
+	}
+
+	// 2-byte characters after an odd number of bytes, so the limit falls inside one
+	fmt.Fprint(w, "x")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprint(w, "é")
+	}
+	fmt.Fprint(w, "the end")
+	output := w.String()
+	if ctx.assert(len(w.tail) == PLAYER_STDERR_LOG_LIMIT, "kept %v bytes instead of %v", len(w.tail), PLAYER_STDERR_LOG_LIMIT) {
+		return
+	}
+	if ctx.assert(utf8.ValidString(output), "the tail isn't valid UTF-8: %q", output) {
+		return
+	}
+	if ctx.assert(strings.HasPrefix(output, "...é") && strings.HasSuffix(output, "éthe end"), "unexpected tail %q", output) {
+		return
+	}
+}
+
+func TestChatPopoutUrl(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	channel := &ChannelInfo{Display_Name: "FakeChannel", Url: "https://www.twitch.tv/FakeChannel/"}
+	if ctx.assertStrEqual("https://www.twitch.tv/popout/fakechannel/chat?popout=", chatPopoutUrl(channel), "chat URL") {
+		return
+	}
+	if ctx.assertStrEqual("", chatPopoutUrl(&ChannelInfo{Display_Name: "NoUrl"}), "chat URL with no channel URL") {
+		return
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// PREFERENCES
+
+// The settings the preferences dialog edits
+type Preferences struct {
+	poll_secs                 int
+	reload_time_interval_mins uint
+	all                       bool
+	popups                    bool
+	idle_secs                 int
+	hide_on_launch            bool
+}
+
+// What needs redoing in the running app after a preferences change
+type PreferencesChange struct {
+	// the next poll has to be rescheduled
+	poll_changed bool
+	// the followed channels have to be reloaded
+	follows_changed bool
+}
+
+// The preferences as they are now in the options
+func (app *TwitchNotifierMain) current_preferences() *Preferences {
+	out := &Preferences{poll_secs: 60, reload_time_interval_mins: 60, popups: true, idle_secs: 300}
+	options := app.options
+	if options.poll != nil {
+		out.poll_secs = *options.poll
+	}
+	if options.reload_time_interval_mins != nil {
+		out.reload_time_interval_mins = *options.reload_time_interval_mins
+	}
+	if options.all != nil {
+		out.all = *options.all
+	}
+	if options.no_popups != nil {
+		out.popups = !*options.no_popups
+	}
+	if options.idle != nil {
+		out.idle_secs = *options.idle
+	}
+	if options.hide_on_launch != nil {
+		out.hide_on_launch = *options.hide_on_launch
+	}
+	return out
+}
+
+// Save the preferences that changed to the config file, which also puts them in the options
+func (app *TwitchNotifierMain) save_preferences(prefs *Preferences) PreferencesChange {
+	old := app.current_preferences()
+	change := PreferencesChange{}
+
+	if prefs.poll_secs != old.poll_secs {
+		app.change_setting("poll", strconv.Itoa(prefs.poll_secs))
+		change.poll_changed = true
+	}
+	if prefs.reload_time_interval_mins != old.reload_time_interval_mins {
+		app.change_setting("reload-time-interval", fmt.Sprintf("%v", prefs.reload_time_interval_mins))
+	}
+	if prefs.all != old.all {
+		app.change_setting("all", strconv.FormatBool(prefs.all))
+		change.follows_changed = true
+	}
+	if prefs.popups != old.popups {
+		app.change_setting("no-popups", strconv.FormatBool(!prefs.popups))
+	}
+	if prefs.idle_secs != old.idle_secs {
+		app.change_setting("idle", strconv.Itoa(prefs.idle_secs))
+	}
+	if prefs.hide_on_launch != old.hide_on_launch {
+		app.change_setting("hide", strconv.FormatBool(prefs.hide_on_launch))
+	}
+
+	if change.follows_changed {
+		app.need_channels_refresh = true
+	}
+	return change
+}
@@ -0,0 +1,98 @@
+// +build !headless
+
+package main
+
+/**
+A wx.Dialog for editing the Preferences
+*/
+
+import (
+	"github.com/rakslice/wxGo/wx"
+)
+
+type PreferencesDialog struct {
+	wx.Dialog
+	sizer wx.BoxSizer
+
+	spin_poll                 wx.SpinCtrl
+	spin_reload_time_interval wx.SpinCtrl
+	spin_idle                 wx.SpinCtrl
+	checkbox_all              wx.CheckBox
+	checkbox_popups           wx.CheckBox
+	checkbox_hide_on_launch   wx.CheckBox
+}
+
+func InitPreferencesDialog(parent wx.Window, prefs *Preferences) *PreferencesDialog {
+	out := &PreferencesDialog{}
+	out.Dialog = wx.NewDialog(parent, wx.ID_ANY, "twitch-notifier preferences",
+		wx.DefaultPosition, wx.DefaultSize, wx.DEFAULT_DIALOG_STYLE)
+	out.sizer = wx.NewBoxSizer(wx.VERTICAL)
+
+	// the API rate limits don't leave room for polling more than once a minute
+	out.spin_poll = out.addSpinRow("Poll every (seconds)", 60, 3600, prefs.poll_secs)
+	out.spin_reload_time_interval = out.addSpinRow("Reload followed channels every (minutes)", 1, 24*60, int(prefs.reload_time_interval_mins))
+	out.spin_idle = out.addSpinRow("Idle after (seconds)", 0, 24*60*60, prefs.idle_secs)
+
+	out.checkbox_all = out.addCheckBox("Watch all followed channels, not just ones with notifications on", prefs.all)
+	out.checkbox_popups = out.addCheckBox("Show notifications", prefs.popups)
+	out.checkbox_hide_on_launch = out.addCheckBox("Start hidden", prefs.hide_on_launch)
+
+	buttons := out.CreateStdDialogButtonSizer(wx.OK | wx.CANCEL)
+	out.sizer.Add(buttons, 0, wx.ALL|wx.EXPAND, 10)
+
+	out.SetSizerAndFit(out.sizer)
+	return out
+}
+
+func (dialog *PreferencesDialog) addSpinRow(label string, min int, max int, value int) wx.SpinCtrl {
+	row := wx.NewBoxSizer(wx.HORIZONTAL)
+	row.Add(wx.NewStaticText(dialog, wx.ID_ANY, label), 1, wx.ALIGN_CENTER_VERTICAL|wx.RIGHT, 10)
+	spin := wx.NewSpinCtrl(dialog, wx.ID_ANY, "", wx.DefaultPosition, wx.DefaultSize, wx.SP_ARROW_KEYS, min, max, value)
+	row.Add(spin, 0, 0, 0)
+	dialog.sizer.Add(row, 0, wx.LEFT|wx.RIGHT|wx.TOP|wx.EXPAND, 10)
+	return spin
+}
+
+func (dialog *PreferencesDialog) addCheckBox(label string, value bool) wx.CheckBox {
+	checkbox := wx.NewCheckBox(dialog, wx.ID_ANY, label)
+	checkbox.SetValue(value)
+	dialog.sizer.Add(checkbox, 0, wx.LEFT|wx.RIGHT|wx.TOP, 10)
+	return checkbox
+}
+
+// The preferences as they are in the dialog
+func (dialog *PreferencesDialog) preferences() *Preferences {
+	return &Preferences{
+		poll_secs:                 dialog.spin_poll.GetValue(),
+		reload_time_interval_mins: uint(dialog.spin_reload_time_interval.GetValue()),
+		all:            dialog.checkbox_all.GetValue(),
+		popups:         dialog.checkbox_popups.GetValue(),
+		idle_secs:      dialog.spin_idle.GetValue(),
+		hide_on_launch: dialog.checkbox_hide_on_launch.GetValue(),
+	}
+}
+
+/**
+Show the preferences dialog, and if it's OKed, save the changes and apply them to the running app
+*/
+func (win *MainStatusWindowImpl) showPreferences() {
+	app := win.main_obj
+	dialog := InitPreferencesDialog(win, app.current_preferences())
+	result := dialog.ShowModal()
+	prefs := dialog.preferences()
+	dialog.Destroy()
+	if result != wx.ID_OK {
+		return
+	}
+
+	change := app.save_preferences(prefs)
+	if win.showPopupsMenuItem != nil {
+		win.showPopupsMenuItem.Check(prefs.popups)
+	}
+	if change.follows_changed {
+		// this polls right away, and the poll schedules the next one with the new interval
+		app.doChannelsReload()
+	} else if change.poll_changed && app.main_loop_iter != nil {
+		win.reschedule_timer(app.main_loop_iter.poll_interval())
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"testing"
+)
+
+func newPreferencesTestApp(ctx *TestContext, filename string, args ...string) *TwitchNotifierMain {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	options := defineOptions(flags)
+	flags.Parse(args)
+	config, err := LoadConfig(filename, flags, noEnv)
+	if ctx.assertNoErr(err, "LoadConfig()") {
+		return nil
+	}
+	options.config = config
+	app := InitTwitchNotifierMain()
+	app.options = options
+	app.need_channels_refresh = false
+	return app
+}
+
+// TESTS
+
+func TestSavePreferences(t *testing.T) {
+	ctx := NewTestCtx(t)
+	filename, cleanup := tempConfigFilename(ctx)
+	defer cleanup()
+
+	app := newPreferencesTestApp(ctx, filename, "-poll", "120")
+	if app == nil {
+		return
+	}
+
+	prefs := app.current_preferences()
+	if ctx.assert(prefs.poll_secs == 120 && prefs.popups && !prefs.all, "unexpected initial preferences %+v", *prefs) {
+		return
+	}
+
+	// nothing changed
+	change := app.save_preferences(prefs)
+	if ctx.assert(!change.poll_changed && !change.follows_changed, "expected no change but got %+v", change) {
+		return
+	}
+
+	prefs.poll_secs = 90
+	prefs.popups = false
+	prefs.idle_secs = 600
+	change = app.save_preferences(prefs)
+	if ctx.assert(change.poll_changed && !change.follows_changed, "expected just a poll change but got %+v", change) {
+		return
+	}
+	if ctx.assert(*app.options.poll == 90 && *app.options.no_popups && *app.options.idle == 600,
+		"options weren't updated: poll %v no-popups %v idle %v", *app.options.poll, *app.options.no_popups, *app.options.idle) {
+		return
+	}
+	if ctx.assert(!app.need_channels_refresh, "a poll change shouldn't reload the channels") {
+		return
+	}
+
+	prefs.all = true
+	change = app.save_preferences(prefs)
+	if ctx.assert(!change.poll_changed && change.follows_changed, "expected just a follows change but got %+v", change) {
+		return
+	}
+	if ctx.assert(app.need_channels_refresh, "expected a channel refresh after -all changed") {
+		return
+	}
+
+	buf, err := ioutil.ReadFile(filename)
+	if ctx.assertNoErr(err, "ioutil.ReadFile()") {
+		return
+	}
+	saved := map[string]interface{}{}
+	if ctx.assertNoErr(json.Unmarshal(buf, &saved), "json.Unmarshal()") {
+		return
+	}
+	if ctx.assert(saved["poll"] == float64(90) && saved["no-popups"] == true && saved["idle"] == float64(600) && saved["all"] == true && len(saved) == 4,
+		"unexpected saved config %v", saved) {
+		return
+	}
+
+	// a new run picks up the saved preferences
+	app = newPreferencesTestApp(ctx, filename)
+	if app == nil {
+		return
+	}
+	if ctx.assert(*app.current_preferences() == *prefs, "expected %+v after loading but got %+v", *prefs, *app.current_preferences()) {
+		return
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func newPublicFollowsTestApp() *TwitchNotifierMain {
+	app := InitTwitchNotifierMain()
+	username := "fakeusername"
+	noBrowserAuth := true
+	app.options = &Options{}
+	app.options.username = &username
+	app.options.no_browser_auth = &noBrowserAuth
+	withoutUserFiles(app)
+	app.queryPageSize = 100
+	return app
+}
+
+// TESTS
+
+func TestPublicStreamsAreBatched(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	app := newPublicFollowsTestApp()
+	backend := app.getAPIBackend().(*KrakenBackend)
+
+	followed := map[ChannelID]bool{}
+	for i := 1; i <= KRAKEN_MAX_CHANNELS_PER_STREAMS_REQUEST+1; i++ {
+		followed[ChannelID(i)] = true
+		// the logins come from the follows list
+		backend.channel_logins[ChannelID(i)] = fmt.Sprintf("channel%03d", i)
+	}
+
+	requestedChannels := []string{}
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/streams",
+		func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("Authorization") != "" {
+				return httpmock.NewStringResponse(400, `{"error": "unexpected Authorization header"}`), nil
+			}
+			channels := req.URL.Query().Get("channel")
+			requestedChannels = append(requestedChannels, channels)
+
+			// channel 101 is the only one that's live
+			streams := ""
+			total := 0
+			for _, channel := range strings.Split(channels, ",") {
+				if _, err := strconv.Atoi(channel); err == nil {
+					return httpmock.NewStringResponse(400, `{"error": "v3 takes channel names, not ids"}`), nil
+				}
+				if channel == "channel101" {
+					streams = `{"channel": {"_id": 101, "display_name": "LiveChannel", "url": "https://twitch.tv/livechannel",
+						"status": "somestatus", "logo": null}, "is_playlist": false, "_id": 456,
+						"created_at": "2016-01-01T01:01:01Z", "game": "a vidya game"}`
+					total = 1
+				}
+			}
+			return httpmock.NewStringResponse(200, fmt.Sprintf(`{"_total": %v, "streams": [%s]}`, total, streams)), nil
+		})
+
+	streams, err := backend.get_streams_channels_following(followed)
+	if ctx.assertNoErr(err, "get_streams_channels_following()") {
+		return
+	}
+
+	if ctx.assert(len(requestedChannels) == 2, "expected 2 batched streams requests but got %v", len(requestedChannels)) {
+		return
+	}
+	if ctx.assert(len(strings.Split(requestedChannels[0], ",")) == KRAKEN_MAX_CHANNELS_PER_STREAMS_REQUEST,
+		"first batch had %v channels", len(strings.Split(requestedChannels[0], ","))) {
+		return
+	}
+
+	if ctx.assert(len(streams) == 1, "expected 1 live stream but got %v", len(streams)) {
+		return
+	}
+	liveStream, ok := streams[101]
+	if ctx.assert(ok, "live channel missing from the streams") {
+		return
+	}
+	if ctx.assertStrEqual("LiveChannel", liveStream.channel.Display_Name, "live channel name") {
+		return
+	}
+}
+
+func TestUsernameOnlyWatcher(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	app := newPublicFollowsTestApp()
+	app.queryPageSize = 1
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/users/fakeusername/follows/channels?limit=1&offset=0",
+		httpmock.NewStringResponder(200, `{"_total": 1, "follows": [{"notifications": true, "channel": {
+		  "_id": 123,
+		  "display_name": "FakeChannel",
+		  "url": "https://twitch.tv/fakechannel",
+		  "status": "somestatus",
+		  "logo": null
+		}}]}`))
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/streams?channel=fakechannel&limit=1&offset=0&stream_type=live",
+		httpmock.NewStringResponder(200, `{"_total": 0, "streams": []}`))
+	// the authenticated endpoint shouldn't be used at all
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/streams/followed",
+		httpmock.NewStringResponder(401, `{"error": "Unauthorized", "status": 401}`))
+
+	watcher := app.NewChannelWatcher()
+	watcher.next()
+
+	if ctx.assert(!app.need_reauth, "username-only mode asked for a login") {
+		return
+	}
+	if ctx.assert(watcher.channels_followed[123], "followed channel missing") {
+		return
+	}
+}
+
+func TestHelixNeedsLoginForFollows(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	app := newPublicFollowsTestApp()
+	helix := "helix"
+	app.options.api = &helix
+
+	_, err := app.getAPIBackend().get_follows("fakeusername")
+	if ctx.assertGotErr(errHelixUsernameOnly.Error(), err, "helix follows without a login") {
+		return
+	}
+	if ctx.assert(!isAuthError(err), "helix follows without a login shouldn't look like a rejected login") {
+		return
+	}
+}
+
+func TestHelixUsernameOnlyOptionsRejected(t *testing.T) {
+	ctx := NewTestCtx(t)
+	tempDir, err := ioutil.TempDir("", "tokentest")
+	if ctx.assertNoErr(err, "TempDir()") {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+	tokenFilename := path.Join(tempDir, "twitchnotifier.token")
+
+	parse := func(args ...string) *Options {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		options := defineOptions(flags)
+		flags.Parse(args)
+		return options
+	}
+	usernameOnly := []string{"-username", "fakeusername", "-no-browser-auth"}
+
+	err = checkOptions(parse(append(usernameOnly, "-api", "helix")...), tokenFilename)
+	if ctx.assertGotErr(errHelixUsernameOnly.Error(), err, "checkOptions() for helix username-only mode") {
+		return
+	}
+	err = checkOptions(parse(usernameOnly...), tokenFilename)
+	if ctx.assertNoErr(err, "checkOptions() for kraken username-only mode") {
+		return
+	}
+	err = checkOptions(parse("-api", "nonsense"), tokenFilename)
+	if ctx.assertGotErr("bad value 'nonsense' for -api: expected kraken or helix", err, "checkOptions() for an unknown api") {
+		return
+	}
+	err = checkOptions(parse(append(usernameOnly, "-api", "helix", "-auth-oauth", "sometoken")...), tokenFilename)
+	if ctx.assertNoErr(err, "checkOptions() for helix with a token") {
+		return
+	}
+
+	// a saved token means it isn't username-only mode
+	err = saveTokenFile(tokenFilename, "savedtoken")
+	if ctx.assertNoErr(err, "saveTokenFile()") {
+		return
+	}
+	err = checkOptions(parse(append(usernameOnly, "-api", "helix")...), tokenFilename)
+	if ctx.assertNoErr(err, "checkOptions() for helix with a saved token") {
+		return
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+/**
+Keeps our API requests within the twitch rate limits.
+
+Twitch gives each client a bucket of requests that refills over a minute, and tells us where we
+are with it in the Ratelimit-Limit, Ratelimit-Remaining and Ratelimit-Reset response headers.
+The RateLimiter keeps a token bucket that starts from our own guess and then follows those
+headers, and works out how long to back off before retrying a 429 or a 5xx.
+
+Requests can run on the GUI thread, so a request only ever waits a few seconds inline. When the
+limit or the server wants a longer wait than that, the request fails with a RateLimitError saying
+how long, and the watcher schedules its next poll for then instead.
+*/
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// what we assume the limit is until the server tells us otherwise
+	DEFAULT_RATE_LIMIT        = 30
+	DEFAULT_RATE_LIMIT_PERIOD = time.Minute
+
+	DEFAULT_MAX_RETRIES  = 3
+	DEFAULT_BACKOFF_BASE = time.Second
+	DEFAULT_MAX_BACKOFF  = 4 * time.Second
+
+	// the longest a request waits inline, for the rate limit or before a retry
+	DEFAULT_MAX_REQUEST_DELAY = 5 * time.Second
+)
+
+// A request that would have had to wait longer than we'll wait inline
+type RateLimitError struct {
+	// how long until it's worth trying again
+	Delay  time.Duration
+	reason string
+}
+
+func (err *RateLimitError) Error() string {
+	return fmt.Sprintf("%s; try again in %v", err.reason, roundToSeconds(err.Delay))
+}
+
+// How long to wait before trying again, if the error is a RateLimitError
+func rateLimitDelay(err error) (time.Duration, bool) {
+	rateLimitError, ok := err.(*RateLimitError)
+	if !ok || rateLimitError == nil {
+		return 0, false
+	}
+	return rateLimitError.Delay, true
+}
+
+// Where we are with the rate limit, for showing to the user
+type RateLimitBudget struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+	// whether the numbers came from the server's headers rather than our own guess
+	FromServer bool
+}
+
+func (budget RateLimitBudget) String() string {
+	out := fmt.Sprintf("%v of %v requests left", budget.Remaining, budget.Limit)
+	if !budget.Reset.IsZero() {
+		out += fmt.Sprintf(", full again at %s", budget.Reset.Format("15:04:05"))
+	}
+	return out
+}
+
+type RateLimiter struct {
+	mutex sync.Mutex
+
+	limit           float64
+	tokens          float64
+	refillPerSecond float64
+	lastRefill      time.Time
+	// when the server said the bucket will be full again
+	resetTime  time.Time
+	fromServer bool
+
+	maxRetries  uint
+	backoffBase time.Duration
+	maxBackoff  time.Duration
+	maxDelay    time.Duration
+
+	// so tests can run without waiting around
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func NewRateLimiter() *RateLimiter {
+	out := &RateLimiter{}
+	out.limit = DEFAULT_RATE_LIMIT
+	out.tokens = DEFAULT_RATE_LIMIT
+	out.refillPerSecond = DEFAULT_RATE_LIMIT / DEFAULT_RATE_LIMIT_PERIOD.Seconds()
+	out.maxRetries = DEFAULT_MAX_RETRIES
+	out.backoffBase = DEFAULT_BACKOFF_BASE
+	out.maxBackoff = DEFAULT_MAX_BACKOFF
+	out.maxDelay = DEFAULT_MAX_REQUEST_DELAY
+	out.now = time.Now
+	out.sleep = time.Sleep
+	out.lastRefill = out.now()
+	return out
+}
+
+func (limiter *RateLimiter) refill() {
+	now := limiter.now()
+	elapsed := now.Sub(limiter.lastRefill).Seconds()
+	limiter.lastRefill = now
+	if elapsed <= 0 {
+		return
+	}
+	if !limiter.resetTime.IsZero() && !now.Before(limiter.resetTime) {
+		// the server's window has rolled over
+		limiter.tokens = limiter.limit
+		limiter.resetTime = time.Time{}
+		return
+	}
+	limiter.tokens += elapsed * limiter.refillPerSecond
+	if limiter.tokens > limiter.limit {
+		limiter.tokens = limiter.limit
+	}
+}
+
+// How long until we can make a request, taking a token if we can make it now
+func (limiter *RateLimiter) take() time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.refill()
+	if limiter.tokens >= 1 {
+		limiter.tokens -= 1
+		return 0
+	}
+	if !limiter.resetTime.IsZero() && limiter.tokens <= 0 {
+		return limiter.resetTime.Sub(limiter.now())
+	}
+	if limiter.refillPerSecond <= 0 {
+		return limiter.backoffBase
+	}
+	return time.Duration((1 - limiter.tokens) / limiter.refillPerSecond * float64(time.Second))
+}
+
+/**
+Block until we have the budget for another request, or return a RateLimitError if that would take
+longer than we wait inline
+*/
+func (limiter *RateLimiter) wait() error {
+	for {
+		delay := limiter.take()
+		if delay <= 0 {
+			return nil
+		}
+		if delay > limiter.maxDelay {
+			return &RateLimitError{delay, "out of API requests until the rate limit resets"}
+		}
+		msg("rate limit: waiting %v before the next request", delay)
+		limiter.sleep(delay)
+	}
+}
+
+// Follow the rate limit headers in a response, if it has them
+func (limiter *RateLimiter) update(resp *http.Response) {
+	remaining, remainingErr := strconv.Atoi(resp.Header.Get("Ratelimit-Remaining"))
+	if remainingErr != nil {
+		return
+	}
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limit, limitErr := strconv.Atoi(resp.Header.Get("Ratelimit-Limit"))
+	if limitErr == nil && limit > 0 {
+		limiter.limit = float64(limit)
+	}
+
+	now := limiter.now()
+	limiter.lastRefill = now
+	limiter.tokens = float64(remaining)
+	limiter.fromServer = true
+
+	reset, resetErr := strconv.ParseInt(resp.Header.Get("Ratelimit-Reset"), 10, 64)
+	if resetErr == nil {
+		limiter.resetTime = time.Unix(reset, 0)
+		untilReset := limiter.resetTime.Sub(now).Seconds()
+		if untilReset > 0 {
+			// spread what's left to get back to full over the time until the reset
+			limiter.refillPerSecond = (limiter.limit - limiter.tokens) / untilReset
+		}
+	}
+}
+
+// The current rate limit budget
+func (limiter *RateLimiter) budget() RateLimitBudget {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.refill()
+	return RateLimitBudget{int(limiter.limit), int(limiter.tokens), limiter.resetTime, limiter.fromServer}
+}
+
+// Whether a response status is worth trying the request again for
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// How long to wait before retrying after the given response; attempt is 0 for the first retry
+func (limiter *RateLimiter) retryDelay(resp *http.Response, attempt uint) time.Duration {
+	now := limiter.now()
+
+	retryAfter := resp.Header.Get("Retry-After")
+	if retryAfter != "" {
+		seconds, err := strconv.Atoi(retryAfter)
+		if err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+		retryTime, err := http.ParseTime(retryAfter)
+		if err == nil {
+			return retryTime.Sub(now)
+		}
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		limiter.mutex.Lock()
+		resetTime := limiter.resetTime
+		limiter.mutex.Unlock()
+		if resetTime.After(now) {
+			return resetTime.Sub(now)
+		}
+	}
+
+	// exponential backoff
+	delay := limiter.backoffBase << attempt
+	if delay > limiter.maxBackoff || delay <= 0 {
+		delay = limiter.maxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+// Don't actually wait between retries in tests that go through the retry path
+func skipRetrySleeps(kraken *Kraken) {
+	kraken.rateLimiter.sleep = func(time.Duration) {}
+}
+
+// A RateLimiter on a fake clock that records the sleeps instead of doing them
+func newFakeClockRateLimiter() (*RateLimiter, *time.Time, *[]time.Duration) {
+	limiter := NewRateLimiter()
+	now := time.Unix(1500000000, 0)
+	sleeps := []time.Duration{}
+	limiter.now = func() time.Time {
+		return now
+	}
+	limiter.sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+		now = now.Add(d)
+	}
+	limiter.lastRefill = now
+	return limiter, &now, &sleeps
+}
+
+func rateLimitResponse(statusCode int, headers map[string]string) *http.Response {
+	resp := httpmock.NewStringResponse(statusCode, `{}`)
+	for key, value := range headers {
+		resp.Header.Set(key, value)
+	}
+	return resp
+}
+
+// TESTS
+
+func TestRateLimiterFollowsHeaders(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	limiter, now, sleeps := newFakeClockRateLimiter()
+
+	reset := now.Add(3 * time.Second)
+	limiter.update(rateLimitResponse(200, map[string]string{
+		"Ratelimit-Limit":     "800",
+		"Ratelimit-Remaining": "1",
+		"Ratelimit-Reset":     strconv.FormatInt(reset.Unix(), 10),
+	}))
+
+	budget := limiter.budget()
+	if ctx.assert(budget.Limit == 800 && budget.Remaining == 1 && budget.FromServer,
+		"unexpected budget after headers: %+v", budget) {
+		return
+	}
+
+	// the one request left goes right away
+	if ctx.assertNoErr(limiter.wait(), "wait() for the last request") {
+		return
+	}
+	if ctx.assert(len(*sleeps) == 0, "expected no wait for the last request in the budget but got %v", *sleeps) {
+		return
+	}
+
+	// and then we wait for the reset
+	if ctx.assertNoErr(limiter.wait(), "wait() for the reset") {
+		return
+	}
+	if ctx.assert(len(*sleeps) == 1 && (*sleeps)[0] == 3*time.Second, "expected to wait 3 s for the reset but got %v", *sleeps) {
+		return
+	}
+	if ctx.assert(limiter.budget().Remaining == 799, "expected a full bucket less one after the reset but got %+v", limiter.budget()) {
+		return
+	}
+}
+
+func TestRateLimiterWontWaitLong(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	limiter, now, sleeps := newFakeClockRateLimiter()
+	limiter.update(rateLimitResponse(200, map[string]string{
+		"Ratelimit-Limit":     "800",
+		"Ratelimit-Remaining": "0",
+		"Ratelimit-Reset":     strconv.FormatInt(now.Add(40*time.Second).Unix(), 10),
+	}))
+
+	err := limiter.wait()
+	delay, ok := rateLimitDelay(err)
+	if ctx.assert(ok && delay == 40*time.Second, "expected a rate limit error to wait 40 s but got %v", err) {
+		return
+	}
+	if ctx.assert(len(*sleeps) == 0, "expected no sleeping for a long wait but got %v", *sleeps) {
+		return
+	}
+}
+
+func TestRateLimiterRefillsWithoutHeaders(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	limiter, _, sleeps := newFakeClockRateLimiter()
+
+	for i := 0; i < DEFAULT_RATE_LIMIT; i++ {
+		limiter.wait()
+	}
+	if ctx.assert(len(*sleeps) == 0, "expected the first %v requests to go right away but got %v", DEFAULT_RATE_LIMIT, *sleeps) {
+		return
+	}
+
+	if ctx.assertNoErr(limiter.wait(), "wait() for a token") {
+		return
+	}
+	expected := DEFAULT_RATE_LIMIT_PERIOD / DEFAULT_RATE_LIMIT
+	if ctx.assert(len(*sleeps) == 1 && (*sleeps)[0] == expected, "expected to wait %v for a token but got %v", expected, *sleeps) {
+		return
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	limiter, now, _ := newFakeClockRateLimiter()
+
+	delay := limiter.retryDelay(rateLimitResponse(429, map[string]string{"Retry-After": "7"}), 0)
+	if ctx.assert(delay == 7*time.Second, "expected a Retry-After of seconds to give 7s but got %v", delay) {
+		return
+	}
+
+	retryAt := now.Add(20 * time.Second).UTC().Format(http.TimeFormat)
+	delay = limiter.retryDelay(rateLimitResponse(503, map[string]string{"Retry-After": retryAt}), 0)
+	if ctx.assert(delay == 20*time.Second, "expected a Retry-After date to give 20s but got %v", delay) {
+		return
+	}
+
+	delay = limiter.retryDelay(rateLimitResponse(500, nil), 2)
+	if ctx.assert(delay == 4*DEFAULT_BACKOFF_BASE, "expected the third retry to back off 4x but got %v", delay) {
+		return
+	}
+
+	delay = limiter.retryDelay(rateLimitResponse(500, nil), 20)
+	if ctx.assert(delay == DEFAULT_MAX_BACKOFF, "expected the backoff to be capped but got %v", delay) {
+		return
+	}
+}
+
+func TestRequestRetriesTooManyRequests(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	requests := 0
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai",
+		func(req *http.Request) (*http.Response, error) {
+			requests += 1
+			if requests == 1 {
+				return rateLimitResponse(429, map[string]string{"Retry-After": "3"}), nil
+			}
+			return httpmock.NewStringResponse(200, `{"value": "it worked"}`), nil
+		})
+
+	kraken := InitKraken()
+	limiter, _, sleeps := newFakeClockRateLimiter()
+	kraken.rateLimiter = limiter
+
+	var response struct {
+		Value string
+	}
+	err := kraken.kraken(&response, "ohai")
+	if ctx.assertNoErr(err, "kraken()") {
+		return
+	}
+	if ctx.assertStrEqual("it worked", response.Value, "value after the retry") {
+		return
+	}
+	if ctx.assert(requests == 2, "expected 2 requests but got %v", requests) {
+		return
+	}
+	if ctx.assert(len(*sleeps) == 1 && (*sleeps)[0] == 3*time.Second, "expected to wait 3s for the retry but got %v", *sleeps) {
+		return
+	}
+}
+
+func TestRequestWontWaitForLongRetryAfter(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	requests := 0
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai",
+		func(req *http.Request) (*http.Response, error) {
+			requests += 1
+			return rateLimitResponse(429, map[string]string{"Retry-After": "3600"}), nil
+		})
+
+	kraken := InitKraken()
+	limiter, _, sleeps := newFakeClockRateLimiter()
+	kraken.rateLimiter = limiter
+
+	var response struct{}
+	err := kraken.kraken(&response, "ohai")
+	delay, ok := rateLimitDelay(err)
+	if ctx.assert(ok && delay == time.Hour, "expected a rate limit error to wait an hour but got %v", err) {
+		return
+	}
+	if ctx.assert(requests == 1 && len(*sleeps) == 0, "expected one request and no sleeping but got %v requests and sleeps %v", requests, *sleeps) {
+		return
+	}
+}
+
+func TestRequestGivesUpAfterMaxRetries(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	requests := 0
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/ohai",
+		func(req *http.Request) (*http.Response, error) {
+			requests += 1
+			return httpmock.NewStringResponse(503, `{"error": "Service Unavailable"}`), nil
+		})
+
+	kraken := InitKraken()
+	skipRetrySleeps(kraken)
+
+	var response struct{}
+	err := kraken.kraken(&response, "ohai")
+	if ctx.assertGotErr("Got HTTP status code 503 during request", err, "kraken()") {
+		return
+	}
+	if ctx.assert(requests == DEFAULT_MAX_RETRIES+1, "expected %v requests but got %v", DEFAULT_MAX_RETRIES+1, requests) {
+		return
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+/**
+Notification rules decide whether a stream going live gets a notification, beyond the follow's own
+notifications setting. They live in a JSON file:
+
+	{
+	  "rules": [
+	    {"channel": "SomeChannel", "games": ["Minecraft"], "action": "suppress"},
+	    {"title_keywords": ["tournament", "finals"], "action": "notify_high"},
+	    {"days": ["sat", "sun"], "from": "09:00", "to": "23:00", "min_uptime_mins": 5, "action": "notify"}
+	  ],
+	  "change_notifications": ["SomeOtherChannel"]
+	}
+
+A rule matches when all of the conditions it has match. Rules for the stream's channel are checked
+first, then the global rules (ones with no channel), each in file order, and the first rule that
+matches decides. When no rule matches we go by the follow's notifications setting as before.
+
+change_notifications lists the channels (or "*" for all of them) that we tell the user about when
+they switch games or change their title while live.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RuleAction string
+
+const (
+	RULE_ACTION_NOTIFY      RuleAction = "notify"
+	RULE_ACTION_SUPPRESS    RuleAction = "suppress"
+	RULE_ACTION_NOTIFY_HIGH RuleAction = "notify_high"
+)
+
+// A notification rule as it is in the rules file
+type NotificationRuleConfig struct {
+	// channel display name or login; empty for a global rule
+	Channel        string   `json:"channel,omitempty"`
+	Games          []string `json:"games,omitempty"`
+	Title_Keywords []string `json:"title_keywords,omitempty"`
+	Title_Regex    string   `json:"title_regex,omitempty"`
+	// time of day range as "HH:MM", which can wrap past midnight
+	From            string     `json:"from,omitempty"`
+	To              string     `json:"to,omitempty"`
+	Days            []string   `json:"days,omitempty"`
+	Min_Uptime_Mins int        `json:"min_uptime_mins,omitempty"`
+	Action          RuleAction `json:"action"`
+}
+
+type NotificationRulesFile struct {
+	Rules                []*NotificationRuleConfig `json:"rules"`
+	Change_Notifications []string                  `json:"change_notifications,omitempty"`
+}
+
+// A notification rule ready to check streams against
+type NotificationRule struct {
+	config      *NotificationRuleConfig
+	title_regex *regexp.Regexp
+	// minutes since midnight; from < 0 if the rule has no time range
+	from_mins int
+	to_mins   int
+	days      map[time.Weekday]bool
+}
+
+type NotificationRules struct {
+	channel_rules []*NotificationRule
+	global_rules  []*NotificationRule
+	// channels that want game and title change notifications
+	change_channels []string
+}
+
+// What the rules decided about a stream
+type RuleDecision int
+
+const (
+	// no rule matched, so go by the follow's notification setting
+	RULE_DECISION_DEFAULT RuleDecision = iota
+	RULE_DECISION_NOTIFY
+	RULE_DECISION_NOTIFY_HIGH
+	RULE_DECISION_SUPPRESS
+	// a rule would match once the stream has been up long enough, so ask again later
+	RULE_DECISION_WAIT
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func getRulesFilename() string {
+	newParts := append(prefsRelativePath(), "twitchnotifier.rules.json")
+	return userRelativePath(newParts...)
+}
+
+// Load the rules from a file. If there is no rules file we get no rules and no error.
+func LoadNotificationRules(filename string) (*NotificationRules, error) {
+	if !fileExists(filename) {
+		return NewNotificationRules(nil)
+	}
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseNotificationRules(buf)
+}
+
+func ParseNotificationRules(buf []byte) (*NotificationRules, error) {
+	rulesFile := &NotificationRulesFile{}
+	err := json.Unmarshal(buf, rulesFile)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := NewNotificationRules(rulesFile.Rules)
+	if err != nil {
+		return nil, err
+	}
+	rules.change_channels = rulesFile.Change_Notifications
+	return rules, nil
+}
+
+func NewNotificationRules(configs []*NotificationRuleConfig) (*NotificationRules, error) {
+	out := &NotificationRules{}
+	for i, config := range configs {
+		rule, err := NewNotificationRule(config)
+		if err != nil {
+			return nil, fmt.Errorf("rule %v: %s", i+1, err)
+		}
+		if config.Channel == "" {
+			out.global_rules = append(out.global_rules, rule)
+		} else {
+			out.channel_rules = append(out.channel_rules, rule)
+		}
+	}
+	return out, nil
+}
+
+func NewNotificationRule(config *NotificationRuleConfig) (*NotificationRule, error) {
+	if config == nil {
+		return nil, fmt.Errorf("rule is null")
+	}
+	out := &NotificationRule{config: config, from_mins: -1, to_mins: -1}
+
+	switch config.Action {
+	case RULE_ACTION_NOTIFY, RULE_ACTION_SUPPRESS, RULE_ACTION_NOTIFY_HIGH:
+	default:
+		return nil, fmt.Errorf("unknown action '%s'; expected notify, suppress or notify_high", config.Action)
+	}
+
+	if config.Title_Regex != "" {
+		re, err := regexp.Compile("(?i)" + config.Title_Regex)
+		if err != nil {
+			return nil, fmt.Errorf("bad title_regex: %s", err)
+		}
+		out.title_regex = re
+	}
+
+	if config.From != "" || config.To != "" {
+		if config.From == "" || config.To == "" {
+			return nil, fmt.Errorf("a time range needs both from and to")
+		}
+		var err error
+		out.from_mins, err = parseTimeOfDay(config.From)
+		if err != nil {
+			return nil, err
+		}
+		out.to_mins, err = parseTimeOfDay(config.To)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(config.Days) > 0 {
+		out.days = make(map[time.Weekday]bool)
+		for _, day := range config.Days {
+			name := strings.ToLower(day)
+			if len(name) > 3 {
+				name = name[:3]
+			}
+			weekday, ok := weekdayNames[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown day '%s'", day)
+			}
+			out.days[weekday] = true
+		}
+	}
+
+	if config.Min_Uptime_Mins < 0 {
+		return nil, fmt.Errorf("min_uptime_mins can't be negative")
+	}
+
+	return out, nil
+}
+
+// Minutes since midnight for an "HH:MM" time
+func parseTimeOfDay(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) == 2 {
+		hours, hoursErr := strconv.Atoi(parts[0])
+		mins, minsErr := strconv.Atoi(parts[1])
+		if hoursErr == nil && minsErr == nil && hours >= 0 && hours < 24 && mins >= 0 && mins < 60 {
+			return hours*60 + mins, nil
+		}
+	}
+	return 0, fmt.Errorf("bad time of day '%s'; expected HH:MM", value)
+}
+
+// The channel login from its URL, for matching rules by login as well as display name
+func channelLogin(channel *ChannelInfo) string {
+	if channel.Url == "" {
+		return ""
+	}
+	return path.Base(strings.TrimRight(channel.Url, "/"))
+}
+
+// Whether name is the channel's display name or login
+func channelNameMatches(name string, channel *ChannelInfo) bool {
+	return strings.EqualFold(name, channel.Display_Name) || strings.EqualFold(name, channelLogin(channel))
+}
+
+func (rule *NotificationRule) matchesChannel(channel *ChannelInfo) bool {
+	if rule.config.Channel == "" {
+		return true
+	}
+	return channelNameMatches(rule.config.Channel, channel)
+}
+
+// Whether the rule matches a stream, apart from its minimum uptime
+func (rule *NotificationRule) matchesStream(stream *StreamInfo, now time.Time) bool {
+	config := rule.config
+
+	if !rule.matchesChannel(stream.Channel) {
+		return false
+	}
+
+	if len(config.Games) > 0 {
+		game := ""
+		if stream.Game != nil {
+			game = *stream.Game
+		}
+		found := false
+		for _, ruleGame := range config.Games {
+			if strings.EqualFold(ruleGame, game) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	title := stream.Channel.Status
+	if len(config.Title_Keywords) > 0 {
+		lowerTitle := strings.ToLower(title)
+		found := false
+		for _, keyword := range config.Title_Keywords {
+			if strings.Contains(lowerTitle, strings.ToLower(keyword)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if rule.title_regex != nil && !rule.title_regex.MatchString(title) {
+		return false
+	}
+
+	if rule.days != nil && !rule.days[now.Weekday()] {
+		return false
+	}
+
+	if rule.from_mins >= 0 {
+		nowMins := now.Hour()*60 + now.Minute()
+		if rule.from_mins <= rule.to_mins {
+			if nowMins < rule.from_mins || nowMins >= rule.to_mins {
+				return false
+			}
+		} else if nowMins < rule.from_mins && nowMins >= rule.to_mins {
+			// a range past midnight like 22:00 to 02:00
+			return false
+		}
+	}
+
+	return true
+}
+
+func (rule *NotificationRule) decision() RuleDecision {
+	switch rule.config.Action {
+	case RULE_ACTION_SUPPRESS:
+		return RULE_DECISION_SUPPRESS
+	case RULE_ACTION_NOTIFY_HIGH:
+		return RULE_DECISION_NOTIFY_HIGH
+	default:
+		return RULE_DECISION_NOTIFY
+	}
+}
+
+// Decide about notifying for a stream that went live at start_time, as of now
+func (rules *NotificationRules) evaluate(stream *StreamInfo, start_time time.Time, now time.Time) RuleDecision {
+	if rules == nil || stream.Channel == nil {
+		return RULE_DECISION_DEFAULT
+	}
+	uptime := now.Sub(start_time)
+	for _, ruleList := range [][]*NotificationRule{rules.channel_rules, rules.global_rules} {
+		for _, rule := range ruleList {
+			if !rule.matchesStream(stream, now) {
+				continue
+			}
+			if uptime < time.Duration(rule.config.Min_Uptime_Mins)*time.Minute {
+				return RULE_DECISION_WAIT
+			}
+			return rule.decision()
+		}
+	}
+	return RULE_DECISION_DEFAULT
+}
+
+// Whether the user wants to hear about game and title changes on the channel
+func (rules *NotificationRules) wantsChangeNotifications(channel *ChannelInfo) bool {
+	if rules == nil || channel == nil {
+		return false
+	}
+	for _, name := range rules.change_channels {
+		if name == "*" || channelNameMatches(name, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// EDITING THE RULES FILE
+
+// Read the rules file as it is, for changing and saving back. No rules file gives an empty one.
+func LoadNotificationRulesFile(filename string) (*NotificationRulesFile, error) {
+	rulesFile := &NotificationRulesFile{}
+	if !fileExists(filename) {
+		return rulesFile, nil
+	}
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(buf, rulesFile)
+	if err != nil {
+		return nil, err
+	}
+	return rulesFile, nil
+}
+
+// Save the rules, as long as they're all good, so we never write a file that won't load
+func (rulesFile *NotificationRulesFile) save(filename string) error {
+	_, err := NewNotificationRules(rulesFile.Rules)
+	if err != nil {
+		return err
+	}
+	if rulesFile.Rules == nil {
+		rulesFile.Rules = []*NotificationRuleConfig{}
+	}
+	buf, err := json.MarshalIndent(rulesFile, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf, 0644)
+}
+
+/**
+Whether a rule is one the per-channel rules dialog can edit: a rule for the channel with at most a
+list of games. Anything with more conditions than that is left for editing the file by hand.
+*/
+func (config *NotificationRuleConfig) isSimpleChannelRule(channel *ChannelInfo) bool {
+	return config != nil && config.Channel != "" && channelNameMatches(config.Channel, channel) &&
+		len(config.Title_Keywords) == 0 && config.Title_Regex == "" && config.From == "" && config.To == "" &&
+		len(config.Days) == 0 && config.Min_Uptime_Mins == 0
+}
+
+/**
+The channel's simple rule, if it has one, and how many other rules the file has for the channel.
+The simple rule is the first one; any after it are counted with the others.
+*/
+func (rulesFile *NotificationRulesFile) channel_rule(channel *ChannelInfo) (*NotificationRuleConfig, int) {
+	var simple *NotificationRuleConfig
+	others := 0
+	for _, config := range rulesFile.Rules {
+		if simple == nil && config.isSimpleChannelRule(channel) {
+			simple = config
+		} else if config != nil && config.Channel != "" && channelNameMatches(config.Channel, channel) {
+			others++
+		}
+	}
+	return simple, others
+}
+
+/**
+Set the action of the channel's simple rule, and the games it's for (none for any game). An empty
+action takes the rule out, leaving the channel to the other rules and its follow's setting.
+*/
+func (rulesFile *NotificationRulesFile) set_channel_rule(channel *ChannelInfo, action RuleAction, games []string) {
+	simple, _ := rulesFile.channel_rule(channel)
+	if action == "" {
+		if simple == nil {
+			return
+		}
+		rules := []*NotificationRuleConfig{}
+		for _, config := range rulesFile.Rules {
+			if config != simple {
+				rules = append(rules, config)
+			}
+		}
+		rulesFile.Rules = rules
+		return
+	}
+	if simple == nil {
+		simple = &NotificationRuleConfig{Channel: channel.Display_Name}
+		rulesFile.Rules = append(rulesFile.Rules, simple)
+	}
+	simple.Action = action
+	simple.Games = games
+}
+
+/**
+Whether the channel is named in change_notifications, and whether everyone is through "*", in which
+case naming it or not makes no difference
+*/
+func (rulesFile *NotificationRulesFile) channel_change_notifications(channel *ChannelInfo) (bool, bool) {
+	named, all := false, false
+	for _, name := range rulesFile.Change_Notifications {
+		if name == "*" {
+			all = true
+		} else if channelNameMatches(name, channel) {
+			named = true
+		}
+	}
+	return named, all
+}
+
+func (rulesFile *NotificationRulesFile) set_channel_change_notifications(channel *ChannelInfo, wanted bool) {
+	named, _ := rulesFile.channel_change_notifications(channel)
+	if wanted {
+		if !named {
+			rulesFile.Change_Notifications = append(rulesFile.Change_Notifications, channel.Display_Name)
+		}
+		return
+	}
+	names := []string{}
+	for _, name := range rulesFile.Change_Notifications {
+		if name == "*" || !channelNameMatches(name, channel) {
+			names = append(names, name)
+		}
+	}
+	rulesFile.Change_Notifications = names
+}
+
+// The games in a comma-separated list as typed in the rules dialog, without blanks
+func parseGamesList(text string) []string {
+	var out []string
+	for _, game := range strings.Split(text, ",") {
+		if game = strings.TrimSpace(game); game != "" {
+			out = append(out, game)
+		}
+	}
+	return out
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+// Empty rules, so tests don't pick up a rules file in the home dir
+func noNotificationRules() *NotificationRules {
+	rules, _ := NewNotificationRules(nil)
+	return rules
+}
+
+func newRulesTestStream(channel_name string, game string, title string) *StreamInfo {
+	return &StreamInfo{
+		Channel: &ChannelInfo{Id: 123, Display_Name: channel_name, Url: "https://twitch.tv/fakechannel", Status: title},
+		Id:      456,
+		Game:    &game,
+	}
+}
+
+func parseTestRules(ctx *TestContext, rulesJSON string) *NotificationRules {
+	rules, err := ParseNotificationRules([]byte(rulesJSON))
+	if ctx.assertNoErr(err, "ParseNotificationRules()") {
+		return nil
+	}
+	return rules
+}
+
+// TESTS
+
+func TestRulesMatching(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	rules := parseTestRules(ctx, `{"rules": [
+		{"channel": "fakechannel", "games": ["Boring Game"], "action": "suppress"},
+		{"title_regex": "\\bfinals?\\b", "action": "notify_high"},
+		{"title_keywords": ["charity", "marathon"], "days": ["saturday", "sun"], "action": "notify"},
+		{"from": "22:00", "to": "02:00", "action": "suppress"}
+	]}`)
+	if rules == nil {
+		return
+	}
+
+	// 2016-01-02 was a Saturday
+	saturdayNoon := time.Date(2016, 1, 2, 12, 0, 0, 0, time.Local)
+	mondayNoon := time.Date(2016, 1, 4, 12, 0, 0, 0, time.Local)
+	lateNight := time.Date(2016, 1, 4, 23, 30, 0, 0, time.Local)
+	earlyMorning := time.Date(2016, 1, 5, 1, 0, 0, 0, time.Local)
+
+	cases := []struct {
+		desc     string
+		stream   *StreamInfo
+		now      time.Time
+		expected RuleDecision
+	}{
+		{"channel rule by login", newRulesTestStream("FakeChannel", "boring game", "the finals"), mondayNoon, RULE_DECISION_SUPPRESS},
+		{"other game for channel rule", newRulesTestStream("FakeChannel", "Fun Game", "the finals"), mondayNoon, RULE_DECISION_NOTIFY_HIGH},
+		{"regex needs whole word", newRulesTestStream("FakeChannel", "Fun Game", "finalsweek"), mondayNoon, RULE_DECISION_DEFAULT},
+		{"keyword on a matching day", newRulesTestStream("FakeChannel", "Fun Game", "Charity stream"), saturdayNoon, RULE_DECISION_NOTIFY},
+		{"keyword on another day", newRulesTestStream("FakeChannel", "Fun Game", "Charity stream"), mondayNoon, RULE_DECISION_DEFAULT},
+		{"time range before midnight", newRulesTestStream("FakeChannel", "Fun Game", "hi"), lateNight, RULE_DECISION_SUPPRESS},
+		{"time range after midnight", newRulesTestStream("FakeChannel", "Fun Game", "hi"), earlyMorning, RULE_DECISION_SUPPRESS},
+		{"outside time range", newRulesTestStream("FakeChannel", "Fun Game", "hi"), mondayNoon, RULE_DECISION_DEFAULT},
+	}
+
+	for _, c := range cases {
+		decision := rules.evaluate(c.stream, c.now, c.now)
+		if ctx.assert(decision == c.expected, "In %s, expected decision %v but got %v", c.desc, c.expected, decision) {
+			return
+		}
+	}
+}
+
+func TestRulesMinUptime(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	rules := parseTestRules(ctx, `{"rules": [{"min_uptime_mins": 5, "action": "notify"}]}`)
+	if rules == nil {
+		return
+	}
+	stream := newRulesTestStream("FakeChannel", "Fun Game", "hi")
+	start := time.Date(2016, 1, 4, 12, 0, 0, 0, time.Local)
+
+	decision := rules.evaluate(stream, start, start.Add(2*time.Minute))
+	if ctx.assert(decision == RULE_DECISION_WAIT, "expected to wait on a new stream but got %v", decision) {
+		return
+	}
+	decision = rules.evaluate(stream, start, start.Add(5*time.Minute))
+	if ctx.assert(decision == RULE_DECISION_NOTIFY, "expected to notify once the stream was up long enough but got %v", decision) {
+		return
+	}
+}
+
+func TestRulesBadConfig(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	cases := []struct {
+		rulesJSON   string
+		expectedErr string
+	}{
+		{`{"rules": [{"action": "shout"}]}`, "rule 1: unknown action 'shout'; expected notify, suppress or notify_high"},
+		{`{"rules": [{"action": "notify"}, {"title_regex": "(", "action": "notify"}]}`, "rule 2: bad title_regex: error parsing regexp: missing closing ): `(?i)(`"},
+		{`{"rules": [{"from": "9:00", "action": "notify"}]}`, "rule 1: a time range needs both from and to"},
+		{`{"rules": [{"from": "9:00", "to": "25:00", "action": "notify"}]}`, "rule 1: bad time of day '25:00'; expected HH:MM"},
+		{`{"rules": [{"days": ["someday"], "action": "notify"}]}`, "rule 1: unknown day 'someday'"},
+	}
+	for _, c := range cases {
+		_, err := ParseNotificationRules([]byte(c.rulesJSON))
+		if ctx.assertGotErr(c.expectedErr, err, c.rulesJSON) {
+			return
+		}
+	}
+}
+
+func TestWatcherAppliesRules(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	sink := &recordingEventSink{}
+	app := newTestApp(&Options{}, sink)
+	app.notificationRules = parseTestRules(ctx, `{"rules": [
+		{"channel": "FakeChannel", "games": ["a vidya game"], "min_uptime_mins": 60, "action": "notify_high"}
+	]}`)
+	if app.notificationRules == nil {
+		return
+	}
+
+	registerHeadlessFollows()
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/streams/followed?limit=1&offset=0&stream_type=live",
+		httpmock.NewStringResponder(200, `{"_total": 1, "streams": [
+			{"channel": {"_id": 123, "display_name": "FakeChannel", "url": "https://twitch.tv/fakechannel", "status": "somestatus"},
+			 "_id": 456,
+			 "created_at": "`+time.Now().Add(-10*time.Minute).UTC().Format(time.RFC3339)+`",
+			 "game": "a vidya game"
+			}
+		]}`))
+
+	app.main_loop_iter.next()
+	if ctx.assert(len(sink.events) == 1, "expected just the online event while the rule waits but got %v", len(sink.events)) {
+		return
+	}
+	if ctx.assert(app.main_loop_iter.last_streams[123] == 0, "stream was recorded as notified while the rule waits") {
+		return
+	}
+
+	// the stream has been up long enough by the next poll
+	registerHeadlessStreams(true)
+	app.main_loop_iter.next()
+	if ctx.assert(len(sink.events) == 2, "expected the notification once the stream was up long enough but got %v events", len(sink.events)) {
+		return
+	}
+	if ctx.assertStrEqual(HEADLESS_EVENT_NOTIFY, sink.events[1].Event, "second event") {
+		return
+	}
+	if ctx.assertStrEqual(HEADLESS_PRIORITY_HIGH, sink.events[1].Priority, "notification priority") {
+		return
+	}
+}
+
+func TestRulesFileChannelEditing(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	tempDir, err := ioutil.TempDir("", "rulestest")
+	if ctx.assertNoErr(err, "TempDir()") {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+	rulesFilename := path.Join(tempDir, "twitchnotifier.rules.json")
+	err = ioutil.WriteFile(rulesFilename, []byte(`{"rules": [
+		{"channel": "fakechannel", "title_keywords": ["finals"], "action": "notify_high"},
+		{"title_keywords": ["rerun"], "action": "suppress"}
+	], "change_notifications": ["OtherChannel"]}`), 0644)
+	if ctx.assertNoErr(err, "WriteFile()") {
+		return
+	}
+
+	app := newTestApp(&Options{})
+	app.options.rules_file = &rulesFilename
+	channel := newRulesTestStream("FakeChannel", "", "").Channel
+
+	settings, err := app.channel_rule_settings(channel)
+	if ctx.assertNoErr(err, "channel_rule_settings()") {
+		return
+	}
+	if ctx.assert(settings.Action == "" && settings.Other_Rules == 1 && !settings.Change_Notifications, "unexpected settings to start with %+v", *settings) {
+		return
+	}
+
+	err = app.save_channel_rule_settings(channel, &ChannelRuleSettings{Action: RULE_ACTION_SUPPRESS, Games: []string{"Minecraft"}, Change_Notifications: true})
+	if ctx.assertNoErr(err, "save_channel_rule_settings()") {
+		return
+	}
+	settings, err = app.channel_rule_settings(channel)
+	if ctx.assertNoErr(err, "channel_rule_settings() after saving") {
+		return
+	}
+	if ctx.assert(settings.Action == RULE_ACTION_SUPPRESS && strings.Join(settings.Games, "|") == "Minecraft" && settings.Change_Notifications, "unexpected saved settings %+v", *settings) {
+		return
+	}
+
+	// the saved rules are picked up right away, after the ones that were already there
+	now := time.Now()
+	rules := app.getNotificationRules()
+	if ctx.assert(rules.evaluate(newRulesTestStream("FakeChannel", "minecraft", "building"), now, now) == RULE_DECISION_SUPPRESS, "expected the new rule to suppress") {
+		return
+	}
+	if ctx.assert(rules.evaluate(newRulesTestStream("FakeChannel", "minecraft", "the finals"), now, now) == RULE_DECISION_NOTIFY_HIGH, "expected the older rule to go first") {
+		return
+	}
+	if ctx.assert(rules.wantsChangeNotifications(channel), "expected change notifications for the channel") {
+		return
+	}
+
+	// back to how it was
+	err = app.save_channel_rule_settings(channel, &ChannelRuleSettings{})
+	if ctx.assertNoErr(err, "save_channel_rule_settings() with no rule") {
+		return
+	}
+	rulesFile, err := LoadNotificationRulesFile(rulesFilename)
+	if ctx.assertNoErr(err, "LoadNotificationRulesFile()") {
+		return
+	}
+	if ctx.assert(len(rulesFile.Rules) == 2, "expected the other 2 rules to be left but got %v", len(rulesFile.Rules)) {
+		return
+	}
+	if ctx.assertStrEqual("OtherChannel", strings.Join(rulesFile.Change_Notifications, "|"), "change notifications") {
+		return
+	}
+
+	// a rule that wouldn't load doesn't get saved
+	rulesFile.set_channel_rule(channel, RuleAction("sometimes"), nil)
+	if ctx.assertGotErr("rule 3: unknown action 'sometimes'; expected notify, suppress or notify_high", rulesFile.save(rulesFilename), "save() of a bad rule") {
+		return
+	}
+}
+
+func TestNoUserFilesWithoutOptions(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	// options that weren't made from the command line, like a test's
+	app := InitTwitchNotifierMain()
+	app.options = &Options{}
+
+	if ctx.assert(app.rules_filename() == "" && app.chat_routes_filename() == "" && app.mutes_filename() == "",
+		"expected no user files but got rules '%s', chat routes '%s', mutes '%s'",
+		app.rules_filename(), app.chat_routes_filename(), app.mutes_filename()) {
+		return
+	}
+	if ctx.assert(app.getStreamHistory() == nil, "expected no stream history") {
+		return
+	}
+	channel := newRulesTestStream("FakeChannel", "", "").Channel
+	err := app.save_channel_rule_settings(channel, &ChannelRuleSettings{Action: RULE_ACTION_SUPPRESS})
+	if ctx.assertGotErr(errNoRulesFile.Error(), err, "save_channel_rule_settings() with no rules file") {
+		return
+	}
+}
+
+func TestParseGamesList(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	if ctx.assertStrEqual("Minecraft|The Legend of Zelda", strings.Join(parseGamesList(" Minecraft, ,The Legend of Zelda,"), "|"), "games") {
+		return
+	}
+	if ctx.assert(len(parseGamesList("  ")) == 0, "expected no games from a blank list") {
+		return
+	}
+}
@@ -1,3 +1,4 @@
+// +build !headless
 
 package main
 
@@ -0,0 +1,312 @@
+package main
+
+/**
+Streaming statistics for each channel, worked out from the stream history: how many streams and
+hours live, the average stream length, when the channel usually starts on each day of the week,
+and which games it streams the most.
+*/
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how many games go in the games column of the stats export
+const STATS_EXPORT_GAMES = 3
+
+var errNoStreamHistory = errors.New("there is no stream history")
+
+// One stream, from the channel going online to going offline
+type StreamSession struct {
+	Channel_Id ChannelID
+	Channel    string
+	Url        string
+	Stream     StreamID
+	Start      time.Time
+	// zero if we didn't see the stream end, e.g. because the app wasn't running
+	End   time.Time
+	Game  string
+	Title string
+}
+
+func (session *StreamSession) length() time.Duration {
+	return session.End.Sub(session.Start)
+}
+
+// Pair up history events into streams, in start time order
+func historySessions(events []*StreamHistoryEvent) []*StreamSession {
+	out := []*StreamSession{}
+	open := make(map[ChannelID]*StreamSession)
+	for _, event := range events {
+		if event.Online {
+			// if the channel already had a stream going, we missed it ending
+			session := &StreamSession{
+				Channel_Id: event.Channel_Id,
+				Channel:    event.Channel,
+				Url:        event.Url,
+				Stream:     event.Stream,
+				Start:      event.Time,
+				Game:       event.Game,
+				Title:      event.Title,
+			}
+			open[event.Channel_Id] = session
+			out = append(out, session)
+		} else if session, ok := open[event.Channel_Id]; ok {
+			session.End = event.Time
+			delete(open, event.Channel_Id)
+		}
+	}
+	return out
+}
+
+// The time spent streaming a game, with the game of a stream being the one it started with
+type GameTime struct {
+	Game     string
+	Sessions int
+	Time     time.Duration
+}
+
+type gameTimesByMost []*GameTime
+
+func (games gameTimesByMost) Len() int      { return len(games) }
+func (games gameTimesByMost) Swap(i, j int) { games[i], games[j] = games[j], games[i] }
+func (games gameTimesByMost) Less(i, j int) bool {
+	if games[i].Time != games[j].Time {
+		return games[i].Time > games[j].Time
+	}
+	if games[i].Sessions != games[j].Sessions {
+		return games[i].Sessions > games[j].Sessions
+	}
+	return games[i].Game < games[j].Game
+}
+
+type ChannelStats struct {
+	Channel_Id ChannelID
+	Channel    string
+	// when the first stream we know of started
+	Since    time.Time
+	Sessions int
+	// the streams we know the length of, which the times come from
+	Timed_Sessions  int
+	Total_Live      time.Duration
+	Average_Session time.Duration
+	// for each time.Weekday, how many streams started then, and the median time of day they started
+	Weekday_Starts [7]int
+	Usual_Start    [7]time.Duration
+	// most streamed first
+	Games []*GameTime
+}
+
+type channelStatsByName []*ChannelStats
+
+func (stats channelStatsByName) Len() int      { return len(stats) }
+func (stats channelStatsByName) Swap(i, j int) { stats[i], stats[j] = stats[j], stats[i] }
+func (stats channelStatsByName) Less(i, j int) bool {
+	return strings.ToLower(stats[i].Channel) < strings.ToLower(stats[j].Channel)
+}
+
+type durationsAscending []time.Duration
+
+func (durations durationsAscending) Len() int           { return len(durations) }
+func (durations durationsAscending) Swap(i, j int)      { durations[i], durations[j] = durations[j], durations[i] }
+func (durations durationsAscending) Less(i, j int) bool { return durations[i] < durations[j] }
+
+/**
+Work out the stats for each channel, sorted by channel name. A channel in live with a stream that
+hasn't ended yet gets that stream counted up to now. Start times are in loc.
+*/
+func computeChannelStats(sessions []*StreamSession, live map[ChannelID]bool, now time.Time, loc *time.Location) []*ChannelStats {
+	by_channel := make(map[ChannelID][]*StreamSession)
+	for _, session := range sessions {
+		by_channel[session.Channel_Id] = append(by_channel[session.Channel_Id], session)
+	}
+
+	out := []*ChannelStats{}
+	for channel_id, channel_sessions := range by_channel {
+		stats := &ChannelStats{Channel_Id: channel_id, Sessions: len(channel_sessions)}
+		games := make(map[string]*GameTime)
+		starts := [7][]time.Duration{}
+		for i, session := range channel_sessions {
+			stats.Channel = session.Channel
+			if i == 0 {
+				stats.Since = session.Start
+			}
+
+			length := session.length()
+			if session.End.IsZero() {
+				length = 0
+				if i == len(channel_sessions)-1 && live[channel_id] {
+					length = now.Sub(session.Start)
+				}
+			}
+			if length > 0 {
+				stats.Timed_Sessions += 1
+				stats.Total_Live += length
+			}
+
+			if session.Game != "" {
+				game, ok := games[session.Game]
+				if !ok {
+					game = &GameTime{Game: session.Game}
+					games[session.Game] = game
+				}
+				game.Sessions += 1
+				game.Time += length
+			}
+
+			local_start := session.Start.In(loc)
+			day_start := time.Date(local_start.Year(), local_start.Month(), local_start.Day(), 0, 0, 0, 0, loc)
+			weekday := local_start.Weekday()
+			starts[weekday] = append(starts[weekday], local_start.Sub(day_start))
+		}
+
+		if stats.Timed_Sessions > 0 {
+			stats.Average_Session = stats.Total_Live / time.Duration(stats.Timed_Sessions)
+		}
+		for weekday, times := range starts {
+			stats.Weekday_Starts[weekday] = len(times)
+			if len(times) > 0 {
+				sort.Sort(durationsAscending(times))
+				stats.Usual_Start[weekday] = times[len(times)/2]
+			}
+		}
+		for _, game := range games {
+			stats.Games = append(stats.Games, game)
+		}
+		sort.Sort(gameTimesByMost(stats.Games))
+		out = append(out, stats)
+	}
+	sort.Sort(channelStatsByName(out))
+	return out
+}
+
+// A time of day as 24-hour clock time
+func time_of_day_desc(offset time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", offset/time.Hour, (offset/time.Minute)%60)
+}
+
+// The usual start times, e.g. "Mon 19:30, Wed 20:00"
+func (stats *ChannelStats) usual_starts_desc() string {
+	parts := []string{}
+	for weekday, count := range stats.Weekday_Starts {
+		if count > 0 {
+			parts = append(parts, fmt.Sprintf("%s %s", time.Weekday(weekday).String()[:3], time_of_day_desc(stats.Usual_Start[weekday])))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// The top games with their hours, e.g. "Some Game (12.5 h); Other Game (3.0 h)"
+func (stats *ChannelStats) top_games_desc(count int, separator string) string {
+	parts := []string{}
+	for i, game := range stats.Games {
+		if i >= count {
+			break
+		}
+		parts = append(parts, fmt.Sprintf("%s (%.1f h)", game.Game, game.Time.Hours()))
+	}
+	return strings.Join(parts, separator)
+}
+
+// Write the stats as CSV, one channel per row
+func writeChannelStatsCSV(w io.Writer, stats []*ChannelStats) error {
+	writer := csv.NewWriter(w)
+	header := []string{"channel", "since", "streams", "hours_live", "average_stream_minutes"}
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		header = append(header, strings.ToLower(weekday.String()[:3])+"_usual_start")
+	}
+	header = append(header, "top_games")
+	err := writer.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for _, channel := range stats {
+		row := []string{
+			channel.Channel,
+			channel.Since.UTC().Format(time.RFC3339),
+			strconv.Itoa(channel.Sessions),
+			strconv.FormatFloat(channel.Total_Live.Hours(), 'f', 2, 64),
+			strconv.FormatFloat(channel.Average_Session.Minutes(), 'f', 0, 64),
+		}
+		for weekday, count := range channel.Weekday_Starts {
+			start := ""
+			if count > 0 {
+				start = time_of_day_desc(channel.Usual_Start[weekday])
+			}
+			row = append(row, start)
+		}
+		row = append(row, channel.top_games_desc(STATS_EXPORT_GAMES, "; "))
+		err = writer.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// APP STATS SUPPORT
+
+// The stream sessions from the history, which are kept until the history next changes
+func (app *TwitchNotifierMain) stream_sessions() ([]*StreamSession, error) {
+	if app.streamSessions != nil {
+		return app.streamSessions, nil
+	}
+	history := app.getStreamHistory()
+	if history == nil {
+		return nil, errNoStreamHistory
+	}
+	events, err := history.events_since(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	app.streamSessions = historySessions(events)
+	return app.streamSessions, nil
+}
+
+// A channel's streams from a list of them, newest first
+func channelSessions(sessions []*StreamSession, channel_id ChannelID) []*StreamSession {
+	out := []*StreamSession{}
+	for i := len(sessions) - 1; i >= 0; i-- {
+		if sessions[i].Channel_Id == channel_id {
+			out = append(out, sessions[i])
+		}
+	}
+	return out
+}
+
+// The stats for each channel in the history; live has the channels that are online now
+func (app *TwitchNotifierMain) channel_stats(live map[ChannelID]bool) ([]*ChannelStats, error) {
+	sessions, err := app.stream_sessions()
+	if err != nil {
+		return nil, err
+	}
+	return computeChannelStats(sessions, live, time.Now(), time.Local), nil
+}
+
+// The stats for one channel, or nil if it has no streams in the history
+func (app *TwitchNotifierMain) one_channel_stats(channel_id ChannelID, live map[ChannelID]bool) (*ChannelStats, error) {
+	sessions, err := app.stream_sessions()
+	if err != nil {
+		return nil, err
+	}
+	// the stats go through the streams oldest first
+	channel_sessions := []*StreamSession{}
+	for _, session := range sessions {
+		if session.Channel_Id == channel_id {
+			channel_sessions = append(channel_sessions, session)
+		}
+	}
+	stats := computeChannelStats(channel_sessions, live, time.Now(), time.Local)
+	if len(stats) == 0 {
+		return nil, nil
+	}
+	return stats[0], nil
+}
@@ -0,0 +1,131 @@
+// +build !headless
+
+package main
+
+/**
+A notebook page showing the ChannelStats for the channel selected in the online and offline lists
+*/
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rakslice/wxGo/wx"
+)
+
+// how many games the stats page lists
+const STATS_PANEL_GAMES = 5
+
+type ChannelStatsPanel struct {
+	wx.Panel
+	win   *MainStatusWindowImpl
+	sizer wx.BoxSizer
+
+	label_channel     wx.StaticText
+	label_streams     wx.StaticText
+	label_hours       wx.StaticText
+	label_average     wx.StaticText
+	label_usual_start wx.StaticText
+	label_games       wx.StaticText
+	button_export     wx.Button
+}
+
+/**
+Add the stats page to the window's notebook, next to the stream and debug logs
+*/
+func InitChannelStatsPanel(win *MainStatusWindowImpl) *ChannelStatsPanel {
+	out := &ChannelStatsPanel{win: win}
+	out.Panel = wx.NewPanel(win.notebook_1, wx.ID_ANY, wx.DefaultPosition, wx.DefaultSize, wx.TAB_TRAVERSAL)
+	out.sizer = wx.NewBoxSizer(wx.VERTICAL)
+
+	out.label_channel = wx.NewStaticText(out, wx.ID_ANY, "")
+	out.sizer.Add(out.label_channel, 0, wx.ALL|wx.EXPAND, 5)
+	out.label_streams = out.addRow("Streams:")
+	out.label_hours = out.addRow("Hours live:")
+	out.label_average = out.addRow("Average stream:")
+	out.label_usual_start = out.addRow("Usually starts:")
+	out.label_games = out.addRow("Most streamed:")
+
+	out.sizer.AddStretchSpacer(1)
+	out.button_export = wx.NewButton(out, wx.ID_ANY, "&Export All Channels...", wx.DefaultPosition, wx.DefaultSize, 0, wx.DefaultValidator, "&Export All Channels...")
+	out.sizer.Add(out.button_export, 0, wx.ALL, 5)
+	wx.Bind(out, wx.EVT_BUTTON, out._on_button_export_click, out.button_export.GetId())
+
+	out.SetSizer(out.sizer)
+	win.notebook_1.AddPage(out, "Channel Stats")
+	out.clear()
+	return out
+}
+
+func (panel *ChannelStatsPanel) addRow(heading string) wx.StaticText {
+	row := wx.NewBoxSizer(wx.HORIZONTAL)
+	headingLabel := wx.NewStaticText(panel, wx.ID_ANY, heading)
+	headingLabel.SetMinSize(wx.NewSize(120, -1))
+	row.Add(headingLabel, 0, 0, 0)
+	value := wx.NewStaticText(panel, wx.ID_ANY, "")
+	row.Add(value, 1, wx.EXPAND, 0)
+	panel.sizer.Add(row, 0, wx.LEFT|wx.RIGHT|wx.EXPAND, 5)
+	return value
+}
+
+func (panel *ChannelStatsPanel) clear() {
+	panel.label_channel.SetLabel("Select a channel to see its stats")
+	for _, label := range []wx.StaticText{panel.label_streams, panel.label_hours, panel.label_average, panel.label_usual_start, panel.label_games} {
+		label.SetLabel("")
+	}
+}
+
+// Show the stats for a channel from the stream history
+func (panel *ChannelStatsPanel) show_channel(channel *ChannelInfo) {
+	panel.clear()
+	if channel == nil {
+		return
+	}
+
+	stats, err := panel.win.main_obj.one_channel_stats(channel.Id, panel.win.main_obj.live_channels())
+	if err != nil {
+		panel.label_channel.SetLabel(fmt.Sprintf("No stats for %s: %s", channel.Display_Name, err))
+		return
+	}
+	if stats == nil {
+		panel.label_channel.SetLabel(fmt.Sprintf("No streams by %s in the history yet", channel.Display_Name))
+		return
+	}
+
+	panel.label_channel.SetLabel(fmt.Sprintf("%s since %s", channel.Display_Name, stats.Since.Local().Format("Jan 2, 2006")))
+	panel.label_streams.SetLabel(fmt.Sprintf("%v", stats.Sessions))
+	panel.label_hours.SetLabel(fmt.Sprintf("%.1f", stats.Total_Live.Hours()))
+	if stats.Timed_Sessions > 0 {
+		panel.label_average.SetLabel(time_desc(stats.Average_Session))
+	}
+	panel.label_usual_start.SetLabel(stats.usual_starts_desc())
+	panel.label_games.SetLabel(stats.top_games_desc(STATS_PANEL_GAMES, "\n"))
+	panel.Layout()
+}
+
+func (panel *ChannelStatsPanel) _on_button_export_click(e wx.Event) {
+	app := panel.win.main_obj
+	stats, err := app.channel_stats(app.live_channels())
+	if err != nil {
+		wx.MessageBox(fmt.Sprintf("Can't export the stats: %s", err), "twitch-notifier", wx.OK|wx.ICON_WARNING)
+		return
+	}
+
+	filename, _, ok := panel.win.chooseSaveFile("Export channel stats", "twitchnotifier-stats.csv", "CSV files (*.csv)|*.csv")
+	if !ok {
+		return
+	}
+	file, err := os.Create(filename)
+	if err == nil {
+		err = writeChannelStatsCSV(file, stats)
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		wx.MessageBox(fmt.Sprintf("Error exporting the stats to '%s': %s", filename, err), "twitch-notifier", wx.OK|wx.ICON_WARNING)
+		return
+	}
+	app.log(fmt.Sprintf("Exported stats for %v channels to %s", len(stats), filename))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Two weeks of FakeChannel streaming on Mondays and Wednesdays, and one OtherChannel stream still going
+func newStatsTestEvents() []*StreamHistoryEvent {
+	// a Monday
+	monday := time.Date(2017, 7, 31, 0, 0, 0, 0, time.UTC)
+	events := []*StreamHistoryEvent{}
+	stream := func(start time.Time, length time.Duration, game string) {
+		events = append(events,
+			&StreamHistoryEvent{Time: start, Online: true, Channel_Id: 1, Channel: "FakeChannel", Stream: StreamID(len(events)), Game: game},
+			&StreamHistoryEvent{Time: start.Add(length), Online: false, Channel_Id: 1, Channel: "FakeChannel"})
+	}
+	stream(monday.Add(19*time.Hour), 2*time.Hour, "a vidya game")
+	stream(monday.Add(2*24*time.Hour+20*time.Hour), 3*time.Hour, "other game")
+	stream(monday.Add(7*24*time.Hour+19*time.Hour+30*time.Minute), 2*time.Hour, "a vidya game")
+	stream(monday.Add(9*24*time.Hour+20*time.Hour), time.Hour, "a vidya game")
+	// this one we didn't see end
+	events = append(events, &StreamHistoryEvent{Time: monday.Add(14*24*time.Hour + 18*time.Hour), Online: true, Channel_Id: 1, Channel: "FakeChannel", Stream: 99})
+	events = append(events, &StreamHistoryEvent{Time: monday.Add(15*24*time.Hour + 18*time.Hour), Online: true, Channel_Id: 1, Channel: "FakeChannel", Stream: 100})
+
+	events = append(events, &StreamHistoryEvent{Time: monday.Add(15 * 24 * time.Hour), Online: true, Channel_Id: 2, Channel: "OtherChannel", Stream: 200, Game: "a vidya game"})
+	return events
+}
+
+// TESTS
+
+func TestHistorySessions(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	sessions := historySessions(newStatsTestEvents())
+	if ctx.assert(len(sessions) == 7, "expected 7 sessions but got %v", len(sessions)) {
+		return
+	}
+	if ctx.assert(sessions[0].Game == "a vidya game" && sessions[0].length() == 2*time.Hour, "unexpected first session %+v", *sessions[0]) {
+		return
+	}
+	if ctx.assert(sessions[4].Stream == 99 && sessions[4].End.IsZero(), "expected the unfinished session to have no end: %+v", *sessions[4]) {
+		return
+	}
+}
+
+func TestChannelStats(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	sessions := historySessions(newStatsTestEvents())
+	// OtherChannel is still live, FakeChannel's last stream isn't
+	now := sessions[6].Start.Add(90 * time.Minute)
+	stats := computeChannelStats(sessions, map[ChannelID]bool{2: true}, now, time.UTC)
+	if ctx.assert(len(stats) == 2, "expected stats for 2 channels but got %v", len(stats)) {
+		return
+	}
+
+	fake := stats[0]
+	if ctx.assertStrEqual("FakeChannel", fake.Channel, "first channel") {
+		return
+	}
+	if ctx.assert(fake.Sessions == 6 && fake.Timed_Sessions == 4, "expected 6 streams, 4 timed, but got %v, %v", fake.Sessions, fake.Timed_Sessions) {
+		return
+	}
+	if ctx.assert(fake.Total_Live == 8*time.Hour && fake.Average_Session == 2*time.Hour,
+		"expected 8 h live averaging 2 h but got %v averaging %v", fake.Total_Live, fake.Average_Session) {
+		return
+	}
+	// Mondays at 19:00, 19:30 and 18:00 make 19:00 the median
+	if ctx.assertStrEqual("Mon 19:00, Tue 18:00, Wed 20:00", fake.usual_starts_desc(), "usual starts") {
+		return
+	}
+	if ctx.assertStrEqual("a vidya game (5.0 h); other game (3.0 h)", fake.top_games_desc(5, "; "), "top games") {
+		return
+	}
+
+	other := stats[1]
+	if ctx.assert(other.Sessions == 1 && other.Total_Live == now.Sub(sessions[6].Start),
+		"expected the live stream to count up to now but got %v", other.Total_Live) {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	if ctx.assertNoErr(writeChannelStatsCSV(buf, stats), "writeChannelStatsCSV()") {
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if ctx.assert(len(lines) == 3, "expected 3 CSV lines but got %v", lines) {
+		return
+	}
+	if ctx.assertStrEqual("channel,since,streams,hours_live,average_stream_minutes,sun_usual_start,mon_usual_start,tue_usual_start,wed_usual_start,thu_usual_start,fri_usual_start,sat_usual_start,top_games", lines[0], "CSV header") {
+		return
+	}
+	if ctx.assertStrEqual("FakeChannel,2017-07-31T19:00:00Z,6,8.00,120,,19:00,18:00,20:00,,,,a vidya game (5.0 h); other game (3.0 h)", lines[1], "CSV row") {
+		return
+	}
+}
+
+func TestChannelSessions(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	sessions := historySessions(newStatsTestEvents())
+	channel_sessions := channelSessions(sessions, sessions[0].Channel_Id)
+	if ctx.assert(len(channel_sessions) > 1, "expected more than one session for the channel") {
+		return
+	}
+	for i, session := range channel_sessions {
+		if ctx.assert(session.Channel_Id == sessions[0].Channel_Id, "session %v is for another channel", i) {
+			return
+		}
+		if i > 0 && ctx.assert(!session.Start.After(channel_sessions[i-1].Start), "sessions not newest first at %v", i) {
+			return
+		}
+	}
+	if ctx.assert(len(channelSessions(sessions, 999)) == 0, "expected no sessions for a channel not in the history") {
+		return
+	}
+}
+
+func TestOneChannelStatsFollowHistoryWrites(t *testing.T) {
+	ctx := NewTestCtx(t)
+	filename, cleanup := tempHistoryFilename(ctx)
+	defer cleanup()
+
+	app := newTestApp(&Options{})
+	app.options.history_file = &filename
+	defer func() {
+		if app.streamHistory != nil {
+			app.streamHistory.Close()
+		}
+	}()
+
+	channel := &ChannelInfo{Id: 1, Display_Name: "FakeChannel", Url: "https://www.twitch.tv/fakechannel"}
+	game := "a vidya game"
+	stream := &StreamInfo{Channel: channel, Id: 456, Game: &game}
+	start := time.Now().Add(-24 * time.Hour)
+	app.record_stream_history(channel, true, stream, start)
+	app.record_stream_history(channel, false, nil, start.Add(2*time.Hour))
+
+	stats, err := app.one_channel_stats(channel.Id, nil)
+	if ctx.assertNoErr(err, "one_channel_stats()") {
+		return
+	}
+	if ctx.assert(stats != nil && stats.Sessions == 1 && stats.Total_Live == 2*time.Hour, "unexpected stats %+v", stats) {
+		return
+	}
+
+	// a new stream is picked up rather than the sessions from before it
+	app.record_stream_history(channel, true, stream, start.Add(4*time.Hour))
+	app.record_stream_history(channel, false, nil, start.Add(5*time.Hour))
+	stats, err = app.one_channel_stats(channel.Id, nil)
+	if ctx.assertNoErr(err, "one_channel_stats() after more history") {
+		return
+	}
+	if ctx.assert(stats != nil && stats.Sessions == 2 && stats.Total_Live == 3*time.Hour, "unexpected stats after more history %+v", stats) {
+		return
+	}
+
+	stats, err = app.one_channel_stats(999, nil)
+	if ctx.assert(err == nil && stats == nil, "expected no stats for a channel not in the history but got %+v, %v", stats, err) {
+		return
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"twitchnotifier/faketwitch"
+)
+
+func newStreamChangeTestTwitch() *faketwitch.Server {
+	twitch := faketwitch.NewServer()
+	twitch.AddUser("fakeusername", "FakeUsername")
+	twitch.AddUser("fakechannel", "FakeChannel")
+	twitch.AddUser("otherchannel", "OtherChannel")
+	twitch.AddToken("fakeoauth123", "fakeusername")
+	twitch.Follow("fakeusername", "fakechannel", true)
+	twitch.Follow("fakeusername", "otherchannel", true)
+	twitch.GoLive("fakechannel", "a vidya game", "some title")
+	twitch.GoLive("otherchannel", "a vidya game", "some title")
+	return twitch
+}
+
+// The events of the given type in the order they were sent
+func eventsOfType(sink *recordingEventSink, eventType string) []*HeadlessEvent {
+	out := []*HeadlessEvent{}
+	for _, event := range sink.events {
+		if event.Event == eventType {
+			out = append(out, event)
+		}
+	}
+	return out
+}
+
+// TESTS
+
+func TestStreamChangeEvents(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	twitch := newStreamChangeTestTwitch()
+	server := httptest.NewServer(twitch)
+	defer server.Close()
+
+	sink := &recordingEventSink{}
+	app := newTestApp(fakeTwitchOptions(server), sink)
+	app.notificationRules = parseTestRules(ctx, `{"rules": [], "change_notifications": ["fakechannel"]}`)
+	if app.notificationRules == nil {
+		return
+	}
+
+	app.main_loop_iter.next()
+	if ctx.assert(len(eventsOfType(sink, HEADLESS_EVENT_NOTIFY)) == 2, "expected 2 online notifications but got %v", len(eventsOfType(sink, HEADLESS_EVENT_NOTIFY))) {
+		return
+	}
+
+	// nothing changed
+	app.main_loop_iter.next()
+	if ctx.assert(len(eventsOfType(sink, HEADLESS_EVENT_GAME_CHANGE)) == 0, "got a game change event with no change") {
+		return
+	}
+
+	twitch.GoLive("fakechannel", "another vidya game", "some title")
+	twitch.GoLive("otherchannel", "another vidya game", "some title")
+	app.main_loop_iter.next()
+	gameChanges := eventsOfType(sink, HEADLESS_EVENT_GAME_CHANGE)
+	if ctx.assert(len(gameChanges) == 1, "expected a game change event for just the opted in channel but got %v", len(gameChanges)) {
+		return
+	}
+	if ctx.assertStrEqual("FakeChannel", gameChanges[0].Channel, "game change channel") {
+		return
+	}
+	if ctx.assertStrEqual("another vidya game", gameChanges[0].Game, "game change game") {
+		return
+	}
+	notifications := eventsOfType(sink, HEADLESS_EVENT_NOTIFY)
+	if ctx.assert(len(notifications) == 3, "expected a notification for the game change but got %v notifications", len(notifications)) {
+		return
+	}
+	if ctx.assertStrEqual("FakeChannel switched to another vidya game", notifications[2].Message, "game change notification") {
+		return
+	}
+
+	twitch.GoLive("fakechannel", "another vidya game", "a new title")
+	app.main_loop_iter.next()
+	titleChanges := eventsOfType(sink, HEADLESS_EVENT_TITLE_CHANGE)
+	if ctx.assert(len(titleChanges) == 1, "expected a title change event but got %v", len(titleChanges)) {
+		return
+	}
+	if ctx.assertStrEqual("a new title", titleChanges[0].Title, "title change title") {
+		return
+	}
+	notifications = eventsOfType(sink, HEADLESS_EVENT_NOTIFY)
+	if ctx.assertStrEqual("FakeChannel changed the title to 'a new title'", notifications[len(notifications)-1].Message, "title change notification") {
+		return
+	}
+}
+
+func TestNewStreamIsNotAStreamChange(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	twitch := newStreamChangeTestTwitch()
+	server := httptest.NewServer(twitch)
+	defer server.Close()
+
+	sink := &recordingEventSink{}
+	app := newTestApp(fakeTwitchOptions(server), sink)
+	app.notificationRules = parseTestRules(ctx, `{"rules": [], "change_notifications": ["*"]}`)
+	if app.notificationRules == nil {
+		return
+	}
+
+	app.main_loop_iter.next()
+
+	// a new stream on the channel is a new online notification, not a change
+	twitch.GoOffline("fakechannel")
+	app.main_loop_iter.next()
+	twitch.GoLive("fakechannel", "another vidya game", "another title")
+	app.main_loop_iter.next()
+
+	if ctx.assert(len(eventsOfType(sink, HEADLESS_EVENT_GAME_CHANGE)) == 0, "got a game change event for a new stream") {
+		return
+	}
+	if ctx.assert(len(eventsOfType(sink, HEADLESS_EVENT_TITLE_CHANGE)) == 0, "got a title change event for a new stream") {
+		return
+	}
+	if ctx.assert(len(eventsOfType(sink, HEADLESS_EVENT_NOTIFY)) == 3, "expected 3 online notifications but got %v", len(eventsOfType(sink, HEADLESS_EVENT_NOTIFY))) {
+		return
+	}
+}
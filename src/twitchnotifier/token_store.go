@@ -0,0 +1,101 @@
+package main
+
+/**
+Keeps the OAuth token we got from the browser login in a file in the user's prefs dir, so we don't
+have to do the login on every launch, and checks it with twitch before we use it.
+*/
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const TWITCH_VALIDATE_URL = "https://id.twitch.tv/oauth2/validate"
+
+func getTokenFilename() string {
+	newParts := append(prefsRelativePath(), "twitchnotifier.token")
+	return userRelativePath(newParts...)
+}
+
+// Strip the whitespace and any "oauth:" prefix (as in tokens from twitchapps.com/tmi) from a token
+func normalizeOAuthToken(token string) string {
+	authToken := strings.TrimSpace(token)
+
+	tmiOauthPrefix := "oauth:"
+	if strings.HasPrefix(authToken, tmiOauthPrefix) {
+		authToken = authToken[len(tmiOauthPrefix):]
+	}
+	return authToken
+}
+
+// Read a saved token. If there is no token file we get an empty token and no error.
+func loadTokenFile(tokenFilename string) (string, error) {
+	if !fileExists(tokenFilename) {
+		return "", nil
+	}
+	buf, err := ioutil.ReadFile(tokenFilename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
+
+// Save a token where only the current user can read it
+func saveTokenFile(tokenFilename string, token string) error {
+	err := ioutil.WriteFile(tokenFilename, []byte(token), 0600)
+	if err != nil {
+		return err
+	}
+	// WriteFile only uses the mode for new files, so fix up the permissions of an older file
+	return os.Chmod(tokenFilename, 0600)
+}
+
+func deleteTokenFile(tokenFilename string) error {
+	if !fileExists(tokenFilename) {
+		return nil
+	}
+	return os.Remove(tokenFilename)
+}
+
+// What twitch tells us about a valid token
+type TokenValidation struct {
+	Client_Id  string
+	Login      string
+	User_Id    string
+	Scopes     []string
+	Expires_In int
+}
+
+// Check a token with the twitch validate endpoint. A token that is expired or revoked gives
+// a *KrakenError with status 401.
+func (obj *Kraken) validateToken(token string) (*TokenValidation, error) {
+	req, err := http.NewRequest("GET", obj.validateURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "OAuth "+normalizeOAuthToken(token))
+	if obj.userAgent != "" {
+		req.Header.Set("User-Agent", obj.userAgent)
+	}
+
+	msg("GET %s", obj.validateURL)
+	resp, err := obj.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, NewKrakenError(resp.StatusCode, "Got HTTP status code %v validating the OAuth token", resp.StatusCode)
+	}
+
+	validation := &TokenValidation{}
+	err = json.NewDecoder(resp.Body).Decode(validation)
+	if err != nil {
+		return nil, err
+	}
+	return validation, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"github.com/jarcoal/httpmock"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// TESTS
+
+func TestTokenFileRoundTrip(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	tempDir, err := ioutil.TempDir("", "tokentest")
+	if ctx.assertNoErr(err, "TempDir()") {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	tokenFilename := path.Join(tempDir, "twitchnotifier.token")
+
+	token, err := loadTokenFile(tokenFilename)
+	if ctx.assertNoErr(err, "loadTokenFile() with no file") {
+		return
+	}
+	if ctx.assertStrEqual("", token, "token with no file") {
+		return
+	}
+
+	err = saveTokenFile(tokenFilename, "sometoken123")
+	if ctx.assertNoErr(err, "saveTokenFile()") {
+		return
+	}
+
+	info, err := os.Stat(tokenFilename)
+	if ctx.assertNoErr(err, "Stat()") {
+		return
+	}
+	if ctx.assert(info.Mode().Perm() == 0600, "expected token file permissions 0600 but got %o", info.Mode().Perm()) {
+		return
+	}
+
+	token, err = loadTokenFile(tokenFilename)
+	if ctx.assertNoErr(err, "loadTokenFile()") {
+		return
+	}
+	if ctx.assertStrEqual("sometoken123", token, "saved token") {
+		return
+	}
+
+	err = deleteTokenFile(tokenFilename)
+	if ctx.assertNoErr(err, "deleteTokenFile()") {
+		return
+	}
+	if ctx.assert(!fileExists(tokenFilename), "token file still exists after delete") {
+		return
+	}
+}
+
+func TestSaveTokenFixesPermissions(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	tempDir, err := ioutil.TempDir("", "tokentest")
+	if ctx.assertNoErr(err, "TempDir()") {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	tokenFilename := path.Join(tempDir, "twitchnotifier.token")
+	err = ioutil.WriteFile(tokenFilename, []byte("oldtoken"), 0644)
+	if ctx.assertNoErr(err, "WriteFile()") {
+		return
+	}
+
+	err = saveTokenFile(tokenFilename, "newtoken")
+	if ctx.assertNoErr(err, "saveTokenFile()") {
+		return
+	}
+
+	info, err := os.Stat(tokenFilename)
+	if ctx.assertNoErr(err, "Stat()") {
+		return
+	}
+	if ctx.assert(info.Mode().Perm() == 0600, "expected token file permissions 0600 but got %o", info.Mode().Perm()) {
+		return
+	}
+}
+
+func TestNormalizeOAuthToken(t *testing.T) {
+	ctx := NewTestCtx(t)
+	if ctx.assertStrEqual("abc123", normalizeOAuthToken(" oauth:abc123\n"), "normalized tmi token") {
+		return
+	}
+	if ctx.assertStrEqual("abc123", normalizeOAuthToken("abc123"), "normalized plain token") {
+		return
+	}
+}
+
+func TestValidateToken(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://id.twitch.tv/oauth2/validate",
+		httpmock.NewStringResponder(200, `{"client_id": "pkvo0qdzjzxeapwpf8bfogx050n4bn8", "login": "fakeusername",
+			"scopes": ["user_read"], "user_id": "99", "expires_in": 5000}`))
+
+	validation, err := InitKraken().validateToken("oauth:fakeoauth123")
+	if ctx.assertNoErr(err, "validateToken()") {
+		return
+	}
+	if ctx.assertStrEqual("fakeusername", validation.Login, "Login") {
+		return
+	}
+	if ctx.assert(validation.Expires_In == 5000, "expected Expires_In 5000 but got %v", validation.Expires_In) {
+		return
+	}
+}
+
+func TestValidateRevokedToken(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://id.twitch.tv/oauth2/validate",
+		httpmock.NewStringResponder(401, `{"status": 401, "message": "invalid access token"}`))
+
+	_, err := InitKraken().validateToken("fakeoauth123")
+	if ctx.assertGotErr("Got HTTP status code 401 validating the OAuth token", err, "validateToken()") {
+		return
+	}
+	if ctx.assert(isAuthError(err), "expected a 401 from validate to be an auth error") {
+		return
+	}
+}
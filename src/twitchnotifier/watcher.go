@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CHANNEL WATCHER
+
+// This does the polling of the twitch API, telling the app about channel and stream changes through
+// its MainEventsInterface, so it works the same with or without a GUI
+
+type WaitItem struct {
+	length time.Duration
+	reason string
+}
+
+type ChannelWatcher struct {
+	app               *TwitchNotifierMain
+	channels_followed map[ChannelID]bool
+	channel_info      map[ChannelID]*ChannelInfo
+	last_streams      map[ChannelID]StreamID
+	// the game and title we last saw for each live stream, to spot changes
+	last_stream_details map[ChannelID]streamDetails
+
+	channels_followed_names []string
+	channel_load_retries int
+}
+
+func (app *TwitchNotifierMain) NewChannelWatcher() *ChannelWatcher {
+	watcher := &ChannelWatcher{}
+	watcher.app = app
+	watcher.channels_followed = make(map[ChannelID]bool)
+	watcher.channel_info = make(map[ChannelID]*ChannelInfo)
+	watcher.last_streams = make(map[ChannelID]StreamID)
+	watcher.last_stream_details = make(map[ChannelID]streamDetails)
+	watcher.channel_load_retries = 0
+	return watcher
+}
+
+// If the API rejected our OAuth token, flag that we need to login again
+func (watcher *ChannelWatcher) checkAuthError(err error) *WaitItem {
+	if isAuthError(err) {
+		watcher.app.need_reauth = true
+		return &WaitItem{0, "The OAuth token was rejected; logging in again"}
+	}
+	return nil
+}
+
+// If a request hit the rate limit, wait until it's worth trying again rather than holding up the app
+func (watcher *ChannelWatcher) checkRateLimitError(err error) *WaitItem {
+	if delay, ok := rateLimitDelay(err); ok {
+		return &WaitItem{delay, fmt.Sprintf("API rate limit: %s", err)}
+	}
+	return nil
+}
+
+// Give up on this poll because of an API error, and try again at the next poll
+func (watcher *ChannelWatcher) errorWait(err error, context string) WaitItem {
+	var errorType string
+	kind, _ := krakenErrorKind(err)
+	switch {
+	case isDecodeError(err):
+		errorType = "bad response"
+	case kind == KRAKEN_API_ERROR:
+		errorType = "API error"
+	case kind == KRAKEN_HTTP_STATUS_ERROR:
+		errorType = "HTTP error"
+	default:
+		errorType = "error"
+	}
+	interval := watcher.poll_interval()
+	reason := fmt.Sprintf("Got %s during %s: %s; trying again in %v s", errorType, context, err, interval.Seconds())
+	return WaitItem{interval, reason}
+}
+
+func (watcher *ChannelWatcher) checkFollowsRequestError(err error, context string) *WaitItem {
+	if ret := watcher.checkAuthError(err); ret != nil {
+		return ret
+	}
+	if ret := watcher.checkRateLimitError(err); ret != nil {
+		return ret
+	}
+	if err != nil && watcher.channel_load_retries >= 10 {
+		msg("follows %s error: %s; %v retries failed", context, err, watcher.channel_load_retries)
+		watcher.channel_load_retries = 0
+		ret := watcher.errorWait(err, "followed channels "+context)
+		return &ret
+	}
+	if err != nil {
+		watcher.channel_load_retries += 1
+		msg("follows %s error: %s; retry %v", context, err, watcher.channel_load_retries)
+		// we can't really do much with follows in a bad state... we need a quick retry
+		// we haven't cleared the flag for a channel reload yet, so just go around
+		return &WaitItem{10 * time.Second, "retrying followed channels list"}
+	}
+	return nil
+}
+
+func (watcher *ChannelWatcher) next() WaitItem {
+	/* This method does one API poll, potentially loading the user's list of followed channels
+	   first, makes the calls to stuff in watcher.app to update followed stream details, and then
+	   returns a token with info about the pause before the next poll so the caller can
+	   sleep and/or schedule the next call
+	*/
+
+	// check if it's time to do a channel reload
+	curTime := time.Now()
+	elapsedSinceLastRefresh := curTime.Sub(watcher.app.lastReloadTime)
+	msg("%0.2f seconds since last refresh", elapsedSinceLastRefresh.Seconds())
+	app := watcher.app
+	backend := app.getAPIBackend()
+
+	var reloadTimeInterval time.Duration
+	if app.options.reload_time_interval_mins != nil {
+		reloadTimeInterval = time.Duration(*app.options.reload_time_interval_mins) * time.Minute
+	} else {
+		reloadTimeInterval = 10 * time.Minute
+	}
+	msg("%0.2f seconds between autorefreshes", reloadTimeInterval.Seconds())
+	if elapsedSinceLastRefresh >= reloadTimeInterval {
+		app.need_channels_refresh = true
+		msg("doing scheduled refresh")
+	}
+
+	// do channel reload if necessary
+	if app.need_channels_refresh {
+		msg("doing a refresh")
+		watcher.channels_followed = make(map[ChannelID]bool)
+		watcher.channel_info = make(map[ChannelID]*ChannelInfo)
+		watcher.channels_followed_names = []string{}
+
+		// first time querying
+
+		if app._auth_oauth != "" {
+			authToken := normalizeOAuthToken(app._auth_oauth)
+
+			backend.set_auth_token(authToken)
+
+			// FIXME set fast query mode (support for slow query later)
+
+			if app.options.username == nil || *app.options.username == "" {
+				username, err := backend.get_username()
+				if ret := watcher.checkAuthError(err); ret != nil {
+					return *ret
+				}
+				if ret := watcher.checkRateLimitError(err); ret != nil {
+					return *ret
+				}
+				if err == nil && username == "" {
+					err = NewKrakenDecodeError("got empty username from root request")
+				}
+				if err != nil {
+					return watcher.errorWait(err, "username request")
+				}
+				app.options.username = &username
+
+			}
+		}
+
+		notificationsDisabledFor := []string{}
+
+		if app.options.username == nil || *app.options.username == "" {
+			return watcher.errorWait(errors.New("no username or OAuth token to find the followed channels with"), "follows request")
+		}
+
+		msg("got username")
+
+		follows, err := backend.get_follows(*app.options.username)
+		if ret := watcher.checkFollowsRequestError(err, "request"); ret != nil {
+			return *ret
+		}
+		for _, follow := range follows {
+			channel := follow.Channel
+			channel_id := channel.Id
+			channel_name := channel.Display_Name
+			msg("processing channel follow for %s", channel_name)
+			notifications_enabled := follow.Notifications
+			if (app.options.all != nil && *app.options.all) || notifications_enabled {
+				watcher.channels_followed[channel_id] = true
+				watcher.channels_followed_names = append(watcher.channels_followed_names, channel_name)
+				watcher.channel_info[channel_id] = channel
+				watcher.app.follow_notification[channel_id] = notifications_enabled
+			} else {
+				notificationsDisabledFor = append(notificationsDisabledFor, channel_name)
+			}
+		}
+
+		msg("processing followed channels")
+
+		followed_channel_entries := ChannelSlice{}
+
+		for channel_id, present := range watcher.channels_followed {
+			if !present {
+				continue
+			}
+			followed_channel_entries = append(followed_channel_entries, watcher.channel_info[channel_id])
+		}
+
+		msg("sorting")
+
+		sort.Sort(followed_channel_entries)
+
+		msg("init channel display")
+
+		app.getEventsInterface().init_channel_display(followed_channel_entries)
+
+		msg("channels reload complete")
+
+		watcher.app.lastReloadTime = curTime
+		app.need_channels_refresh = false
+		watcher.channel_load_retries = 0
+
+		app.getEventsInterface()._channels_reload_complete()
+	} // done channels refresh
+
+	// regular status change checks time
+	app.check_idle()
+
+	// FIXME just fast query implemented for now
+	channel_stream_iterator, streamsError := backend.get_streams_channels_following(watcher.channels_followed)
+
+	if ret := watcher.checkAuthError(streamsError); ret != nil {
+		return *ret
+	}
+	if ret := watcher.checkRateLimitError(streamsError); ret != nil {
+		return *ret
+	}
+
+	if streamsError != nil {
+		app.getEventsInterface().log(fmt.Sprintf("Error during update streams follows request: %s", streamsError))
+		app.getEventsInterface().log("Processing any partial update and waiting until the next request time")
+	} else {
+		app.getEventsInterface().assume_all_streams_offline()
+	}
+
+	for channel_id, channel_stream := range channel_stream_iterator {
+		var channel *ChannelInfo = channel_stream.channel
+		var stream *StreamInfo = channel_stream.stream
+		assert(channel != nil, "channel_stream had no channel")
+		channel_name := channel.Display_Name
+
+		// update channel info from the channel object in this stream
+		watcher.channel_info[channel_id] = channel
+
+		stream_we_consider_online := stream != nil && !stream.Is_playlist
+
+		app.getEventsInterface().stream_state_change(channel_id, stream_we_consider_online, stream)
+
+		if stream_we_consider_online {
+			stream_id := stream.Id
+			val, ok := watcher.last_streams[channel_id]
+			//msg("stream fetch output: %v, %v", uint64(val), ok)
+			decided := true
+			if !ok || val != stream_id {
+				// stream was previously offline or was a different stream id
+				decided = app.notify_for_stream(channel_name, stream)
+			} else {
+				for _, change := range watcher.stream_changes(channel_id, stream) {
+					app.notify_for_stream_change(channel_id, stream, change)
+				}
+			}
+			if decided {
+				watcher.last_streams[channel_id] = stream_id
+			}
+			watcher.last_stream_details[channel_id] = newStreamDetails(stream)
+		} else {
+			//msg("channel %s is offline", channel_name)
+			if stream == nil {
+
+				app.getEventsInterface().log(fmt.Sprintf("channel_id %v had stream null", channel_id))
+			} else {
+				app.getEventsInterface().log(fmt.Sprintf("channel_id %v is_playlist %v", channel_id, stream.Is_playlist))
+			}
+			_, ok := watcher.last_streams[channel_id]
+			if ok {
+				// was previously online
+				delete(watcher.last_streams, channel_id)
+			}
+			delete(watcher.last_stream_details, channel_id)
+		}
+
+	}
+
+	app.getEventsInterface().done_state_changes()
+
+	interval := watcher.poll_interval()
+	reason := fmt.Sprintf("Waiting %v s for next poll (API budget: %s)", interval.Seconds(), backend.rate_limit_budget())
+	return WaitItem{interval, reason}
+
+}
+
+type streamDetails struct {
+	game  string
+	title string
+}
+
+func newStreamDetails(stream *StreamInfo) streamDetails {
+	out := streamDetails{}
+	if stream.Game != nil {
+		out.game = *stream.Game
+	}
+	if stream.Channel != nil {
+		out.title = stream.Channel.Status
+	}
+	return out
+}
+
+// The game and title changes since we last saw the stream
+func (watcher *ChannelWatcher) stream_changes(channel_id ChannelID, stream *StreamInfo) []*StreamChange {
+	last, ok := watcher.last_stream_details[channel_id]
+	if !ok {
+		return nil
+	}
+	current := newStreamDetails(stream)
+	changes := []*StreamChange{}
+	if current.game != last.game {
+		changes = append(changes, &StreamChange{STREAM_CHANGE_GAME, last.game, current.game})
+	}
+	if current.title != last.title {
+		changes = append(changes, &StreamChange{STREAM_CHANGE_TITLE, last.title, current.title})
+	}
+	return changes
+}
+
+// The time between polls from the -poll option
+func (watcher *ChannelWatcher) poll_interval() time.Duration {
+	var sleep_until_next_poll_s int
+	if watcher.app.options.poll == nil {
+		sleep_until_next_poll_s = 60
+	} else {
+		sleep_until_next_poll_s = *watcher.app.options.poll
+	}
+
+	if sleep_until_next_poll_s < 60 {
+		sleep_until_next_poll_s = 60
+	}
+	return time.Duration(sleep_until_next_poll_s) * time.Second
+}
+
+// SORTABLE LIST OF CHANNELS
+
+type ChannelSlice []*ChannelInfo
+
+func (l ChannelSlice) Len() int {
+	return len(l)
+}
+
+func (l ChannelSlice) Less(i, j int) bool {
+	return strings.ToLower(l[i].Display_Name) < strings.ToLower(l[j].Display_Name)
+}
+
+func (l ChannelSlice) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+/**
+Turn off everything that's kept in a file in the prefs dir, so a test app doesn't read or change the
+user's own rules, chat routes, mutes or stream history. Options built in a test already leave these
+off, but newTestApp makes sure of it.
+*/
+func withoutUserFiles(app *TwitchNotifierMain) {
+	app.notificationRules = noNotificationRules()
+	app.chatRoutes = noChatRoutes()
+	app.channelMutes = noChannelMutes()
+	app.options.rules_file = nil
+	app.options.chat_routes_file = nil
+	app.options.mutes_file = nil
+	app.options.history_file = nil
+}
+
+/**
+A headless test app with the given options, a fake token and none of the user's files. Tests that
+only need the watcher use its TwitchNotifierMain.
+*/
+func newTestApp(options *Options, sinks ...HeadlessEventSink) *HeadlessTwitchNotifierMain {
+	app := InitHeadlessTwitchNotifierMain(options, sinks)
+	fake_oauth_token := "fakeoauth123"
+	app.options.authorization_oauth = &fake_oauth_token
+	app._auth_oauth = fake_oauth_token
+	withoutUserFiles(&app.TwitchNotifierMain)
+	app.queryPageSize = 1
+	app.main_loop_iter = app.NewChannelWatcher()
+	return app
+}
+
+// TESTS
+
+func TestWatcherRecoversFromRootError(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	app := &newTestApp(&Options{}).TwitchNotifierMain
+	skipRetrySleeps(app.krakenInstance)
+	watcher := app.NewChannelWatcher()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken",
+		httpmock.NewStringResponder(500, `{"error": "Internal Server Error", "status": 500}`))
+
+	wait := watcher.next()
+	if ctx.assert(strings.Contains(wait.reason, "HTTP error during username request"), "unexpected wait reason '%s'", wait.reason) {
+		return
+	}
+	if ctx.assert(wait.length == watcher.poll_interval(), "expected to wait until the next poll but got %v", wait.length) {
+		return
+	}
+	if ctx.assert(!app.need_reauth, "a 500 shouldn't make us login again") {
+		return
+	}
+
+	// the next poll works
+	httpmock.Reset()
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken",
+		httpmock.NewStringResponder(200, `{"token": {"user_name": "fakeusername"}}`))
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/users/fakeusername/follows/channels?limit=1&offset=0",
+		httpmock.NewStringResponder(200, `{"_total": 0, "follows": []}`))
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/streams/followed?limit=1&offset=0&stream_type=live",
+		httpmock.NewStringResponder(200, `{"_total": 0, "streams": []}`))
+
+	wait = watcher.next()
+	if ctx.assert(strings.HasPrefix(wait.reason, "Waiting 60 s for next poll"), "unexpected wait reason after recovering '%s'", wait.reason) {
+		return
+	}
+}
+
+func TestWatcherWaitsOutTheRateLimit(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	app := &newTestApp(&Options{}).TwitchNotifierMain
+	watcher := app.NewChannelWatcher()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken",
+		httpmock.NewStringResponder(200, `{"token": {"user_name": "fakeusername"}}`))
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/users/fakeusername/follows/channels?limit=1&offset=0",
+		httpmock.NewStringResponder(200, `{"_total": 0, "follows": []}`))
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken/streams/followed?limit=1&offset=0&stream_type=live",
+		func(req *http.Request) (*http.Response, error) {
+			resp := httpmock.NewStringResponse(429, `{"error": "Too Many Requests", "status": 429}`)
+			resp.Header.Set("Retry-After", "120")
+			return resp, nil
+		})
+
+	// the poll comes back straight away with a wait for as long as the server asked
+	wait := watcher.next()
+	if ctx.assert(strings.HasPrefix(wait.reason, "API rate limit"), "unexpected wait reason '%s'", wait.reason) {
+		return
+	}
+	if ctx.assert(wait.length == 2*time.Minute, "expected to wait 2 min but got %v", wait.length) {
+		return
+	}
+}
+
+func TestWatcherRecoversFromMalformedRoot(t *testing.T) {
+	ctx := NewTestCtx(t)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	app := &newTestApp(&Options{}).TwitchNotifierMain
+	watcher := app.NewChannelWatcher()
+
+	httpmock.RegisterResponder("GET", "https://api.twitch.tv/kraken",
+		httpmock.NewStringResponder(200, `{"token": `))
+
+	wait := watcher.next()
+	if ctx.assert(strings.Contains(wait.reason, "bad response during username request"), "unexpected wait reason '%s'", wait.reason) {
+		return
+	}
+}
+
+func TestBadStreamStartTime(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	app := &newTestApp(&Options{}).TwitchNotifierMain
+	game := "a vidya game"
+	stream := &StreamInfo{Created_at: "yesterday-ish", Game: &game}
+
+	_, err := app.get_stream_start_time(stream)
+	if ctx.assert(isDecodeError(err), "expected a decode error for a bad start time but got %v", err) {
+		return
+	}
+	if ctx.assertStrEqual("FakeChannel is now live with a vidya game", app.create_online_message("FakeChannel", stream), "online message") {
+		return
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+/**
+Outbound webhooks: each online, offline, game change and title change event is POSTed as JSON, in
+the same format as the headless -json-events output, to each of the URLs in the -webhook option.
+With -webhook-secret, the body is signed with HMAC-SHA256, so the receiver can check it came from
+us:
+
+	X-Twitch-Notifier-Signature: sha256=<hex digest of the body>
+
+Requests go out in order from a goroutine per URL, so a slow receiver doesn't hold up the polls,
+and failed requests are retried a few times.
+*/
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	WEBHOOK_SIGNATURE_HEADER = "X-Twitch-Notifier-Signature"
+	WEBHOOK_USER_AGENT       = "twitch-notifier-go webhook"
+
+	WEBHOOK_TRIES = 4
+	// events waiting to go out to a URL before we start dropping them
+	WEBHOOK_QUEUE_SIZE = 100
+	// how long to wait for the queued events to go out when we exit
+	WEBHOOK_FLUSH_TIMEOUT = 30 * time.Second
+)
+
+// the events that go out to webhooks; notifications are left to the desktop
+var webhookEvents = map[string]bool{
+	HEADLESS_EVENT_ONLINE:       true,
+	HEADLESS_EVENT_OFFLINE:      true,
+	HEADLESS_EVENT_GAME_CHANGE:  true,
+	HEADLESS_EVENT_TITLE_CHANGE: true,
+}
+
+var errWebhookRedirect = errors.New("not following redirect from webhook URL")
+
+type webhookRequest struct {
+	event *HeadlessEvent
+	body  []byte
+}
+
+// POSTs events to a webhook URL
+type WebhookEventSink struct {
+	url    string
+	secret string
+	client *http.Client
+	queue  chan *webhookRequest
+	// closed once the queue is closed and everything in it is sent
+	done chan struct{}
+	// time to wait before the first retry, which doubles for each one after that
+	retry_delay time.Duration
+	// called with each request's final result, for tests
+	sent func(event *HeadlessEvent, err error)
+}
+
+func NewWebhookEventSink(webhookUrl string, secret string, timeout time.Duration) (*WebhookEventSink, error) {
+	parsed, err := url.Parse(webhookUrl)
+	if err != nil {
+		return nil, fmt.Errorf("bad webhook URL '%s': %s", webhookUrl, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("bad webhook URL '%s': expected an http or https URL", webhookUrl)
+	}
+
+	out := &WebhookEventSink{}
+	out.url = webhookUrl
+	out.secret = secret
+	out.client = &http.Client{
+		Timeout: timeout,
+		// only send to the URL we were given
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return errWebhookRedirect
+		},
+	}
+	out.queue = make(chan *webhookRequest, WEBHOOK_QUEUE_SIZE)
+	out.done = make(chan struct{})
+	out.retry_delay = time.Second
+	go out.send_queued()
+	return out, nil
+}
+
+func (sink *WebhookEventSink) stream_event(event *HeadlessEvent) {
+	if !webhookEvents[event.Event] {
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		msg("error marshalling webhook event: %s", err)
+		return
+	}
+	sink.enqueue(event, body)
+}
+
+// Queue a request body to go out; event is what it's about, for the logs
+func (sink *WebhookEventSink) enqueue(event *HeadlessEvent, body []byte) {
+	select {
+	case sink.queue <- &webhookRequest{event, body}:
+	default:
+		msg("webhook queue for %s is full; dropping %s event for %s", sink.url, event.Event, event.Channel)
+	}
+}
+
+// Stop sending once the queued events are done
+func (sink *WebhookEventSink) close() {
+	close(sink.queue)
+}
+
+// Wait for the queued events to go out after close(). Returns false if they didn't by the deadline.
+func (sink *WebhookEventSink) wait_sent(deadline time.Time) bool {
+	select {
+	case <-sink.done:
+		return true
+	case <-time.After(deadline.Sub(time.Now())):
+		msg("gave up waiting for the queued webhook events to %s", sink.url)
+		return false
+	}
+}
+
+func (sink *WebhookEventSink) send_queued() {
+	defer close(sink.done)
+	for request := range sink.queue {
+		err := sink.send_with_retry(request.body)
+		if err != nil {
+			msg("webhook %s event for %s to %s failed: %s", request.event.Event, request.event.Channel, sink.url, err)
+		}
+		if sink.sent != nil {
+			sink.sent(request.event, err)
+		}
+	}
+}
+
+func (sink *WebhookEventSink) send_with_retry(body []byte) error {
+	delay := sink.retry_delay
+	var err error
+	for try := 1; try <= WEBHOOK_TRIES; try++ {
+		var retryable bool
+		retryable, err = sink.send(body)
+		if err == nil || !retryable {
+			return err
+		}
+		if try < WEBHOOK_TRIES {
+			msg("webhook request to %s failed: %s; retrying in %v", sink.url, err, delay)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return err
+}
+
+// The signature header value for a request body
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Do one request. Returns whether a failure is worth retrying.
+func (sink *WebhookEventSink) send(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", sink.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", WEBHOOK_USER_AGENT)
+	if sink.secret != "" {
+		req.Header.Set(WEBHOOK_SIGNATURE_HEADER, webhookSignature(sink.secret, body))
+	}
+
+	resp, err := sink.client.Do(req)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok && urlErr.Err == errWebhookRedirect {
+			return false, err
+		}
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retryable, fmt.Errorf("got HTTP status %s", resp.Status)
+}
+
+// APP WEBHOOK SUPPORT
+
+// The webhook sinks for the -webhook option, created the first time we need them
+func (app *TwitchNotifierMain) getWebhookSinks() []*WebhookEventSink {
+	if app.webhookSinks == nil {
+		app.webhookSinks = []*WebhookEventSink{}
+		if app.options.webhook_urls == nil || *app.options.webhook_urls == "" {
+			return app.webhookSinks
+		}
+		secret := ""
+		if app.options.webhook_secret != nil {
+			secret = *app.options.webhook_secret
+		}
+		timeout := app.webhook_timeout()
+		for _, webhookUrl := range strings.Split(*app.options.webhook_urls, ",") {
+			webhookUrl = strings.TrimSpace(webhookUrl)
+			if webhookUrl == "" {
+				continue
+			}
+			sink, err := NewWebhookEventSink(webhookUrl, secret, timeout)
+			if err != nil {
+				app.getEventsInterface().log(err.Error())
+				continue
+			}
+			app.webhookSinks = append(app.webhookSinks, sink)
+		}
+	}
+	return app.webhookSinks
+}
+
+// The -http-timeout option, for webhook requests
+func (app *TwitchNotifierMain) webhook_timeout() time.Duration {
+	if app.options.http_timeout != nil && *app.options.http_timeout > 0 {
+		return time.Duration(*app.options.http_timeout) * time.Second
+	}
+	return 30 * time.Second
+}
+
+// Stop the webhook sinks, so they're created again from the current options
+func (app *TwitchNotifierMain) reset_webhook_sinks() {
+	for _, sink := range app.webhookSinks {
+		sink.close()
+	}
+	app.webhookSinks = nil
+}
+
+/**
+Stop the webhook sinks and chat destinations and wait for their queued events to go out, for up to
+WEBHOOK_FLUSH_TIMEOUT between them. For when we're exiting.
+*/
+func (app *TwitchNotifierMain) flush_webhook_sinks() {
+	sinks := app.webhookSinks
+	if app.chatRoutes != nil {
+		for _, destination := range app.chatRoutes.destinations {
+			sinks = append(sinks, destination.sink)
+		}
+	}
+	app.reset_webhook_sinks()
+	app.reset_chat_routes()
+	deadline := time.Now().Add(WEBHOOK_FLUSH_TIMEOUT)
+	for _, sink := range sinks {
+		sink.wait_sent(deadline)
+	}
+}
+
+func (app *TwitchNotifierMain) send_webhook_event(event *HeadlessEvent) {
+	for _, sink := range app.getWebhookSinks() {
+		sink.stream_event(event)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type receivedWebhook struct {
+	body      []byte
+	signature string
+}
+
+// A webhook receiver that fails the first failCount requests with a 503
+func newWebhookTestServer(failCount int) (*httptest.Server, chan *receivedWebhook) {
+	received := make(chan *receivedWebhook, 10)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests += 1
+		if requests <= failCount {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- &receivedWebhook{body, r.Header.Get(WEBHOOK_SIGNATURE_HEADER)}
+	}))
+	return server, received
+}
+
+func waitForWebhook(ctx *TestContext, received chan *receivedWebhook) *receivedWebhook {
+	select {
+	case webhook := <-received:
+		return webhook
+	case <-time.After(5 * time.Second):
+		ctx.assert(false, "no webhook request arrived")
+		return nil
+	}
+}
+
+// TESTS
+
+func TestWebhookSignedAndRetried(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	server, received := newWebhookTestServer(2)
+	defer server.Close()
+
+	sink, err := NewWebhookEventSink(server.URL+"/hook", "sekrit", 5*time.Second)
+	if ctx.assertNoErr(err, "NewWebhookEventSink()") {
+		return
+	}
+	defer sink.close()
+	sink.retry_delay = time.Millisecond
+
+	started := time.Date(2017, 8, 1, 12, 0, 0, 0, time.UTC)
+	sink.stream_event(&HeadlessEvent{Time: started, Event: HEADLESS_EVENT_NOTIFY, Channel: "FakeChannel", Message: "FakeChannel is now live"})
+	sink.stream_event(&HeadlessEvent{Time: started, Event: HEADLESS_EVENT_ONLINE, Channel: "FakeChannel", Url: "https://www.twitch.tv/fakechannel",
+		Game: "a vidya game", Title: "some title", Stream: 123, Started: &started})
+
+	webhook := waitForWebhook(ctx, received)
+	if webhook == nil {
+		return
+	}
+	if ctx.assertStrEqual(webhookSignature("sekrit", webhook.body), webhook.signature, "webhook signature") {
+		return
+	}
+	payload := map[string]interface{}{}
+	if ctx.assertNoErr(json.Unmarshal(webhook.body, &payload), "json.Unmarshal()") {
+		return
+	}
+	if ctx.assert(payload["event"] == "online" && payload["channel"] == "FakeChannel" && payload["stream"] == float64(123) &&
+		payload["game"] == "a vidya game" && payload["title"] == "some title" && payload["started"] == "2017-08-01T12:00:00Z" &&
+		payload["url"] == "https://www.twitch.tv/fakechannel",
+		"unexpected webhook payload %v", payload) {
+		return
+	}
+
+	// the notification event didn't go out
+	select {
+	case extra := <-received:
+		ctx.assert(false, "unexpected extra webhook %s", extra.body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWebhookQueueSentOnClose(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	server, received := newWebhookTestServer(1)
+	defer server.Close()
+
+	sink, err := NewWebhookEventSink(server.URL+"/hook", "", 5*time.Second)
+	if ctx.assertNoErr(err, "NewWebhookEventSink()") {
+		return
+	}
+	sink.retry_delay = 10 * time.Millisecond
+
+	sink.stream_event(&HeadlessEvent{Event: HEADLESS_EVENT_ONLINE, Channel: "FakeChannel"})
+	sink.stream_event(&HeadlessEvent{Event: HEADLESS_EVENT_OFFLINE, Channel: "FakeChannel"})
+	sink.close()
+	if ctx.assert(sink.wait_sent(time.Now().Add(5*time.Second)), "queued webhooks weren't sent") {
+		return
+	}
+	if ctx.assert(len(received) == 2, "expected 2 webhooks after close but got %v", len(received)) {
+		return
+	}
+}
+
+func TestWebhookDoesntFollowRedirects(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	elsewhere, received := newWebhookTestServer(0)
+	defer elsewhere.Close()
+	redirecting := httptest.NewServer(http.RedirectHandler(elsewhere.URL, http.StatusTemporaryRedirect))
+	defer redirecting.Close()
+
+	sink, err := NewWebhookEventSink(redirecting.URL, "", 5*time.Second)
+	if ctx.assertNoErr(err, "NewWebhookEventSink()") {
+		return
+	}
+	defer sink.close()
+	results := make(chan error, 1)
+	sink.sent = func(event *HeadlessEvent, err error) {
+		results <- err
+	}
+
+	sink.stream_event(&HeadlessEvent{Time: time.Now(), Event: HEADLESS_EVENT_OFFLINE, Channel: "FakeChannel"})
+	select {
+	case err := <-results:
+		if ctx.assert(err != nil, "expected the redirected webhook to fail") {
+			return
+		}
+	case <-time.After(5 * time.Second):
+		ctx.assert(false, "the webhook request didn't finish")
+		return
+	}
+	if ctx.assert(len(received) == 0, "the webhook followed the redirect") {
+		return
+	}
+
+	_, err = NewWebhookEventSink("ftp://example.com/hook", "", time.Second)
+	if ctx.assertGotErr("bad webhook URL 'ftp://example.com/hook': expected an http or https URL", err, "NewWebhookEventSink() with an ftp URL") {
+		return
+	}
+}
+
+func TestWebhookFromWatcher(t *testing.T) {
+	ctx := NewTestCtx(t)
+
+	webhookServer, received := newWebhookTestServer(0)
+	defer webhookServer.Close()
+
+	twitch := newStreamChangeTestTwitch()
+	twitch.GoOffline("otherchannel")
+	server := httptest.NewServer(twitch)
+	defer server.Close()
+
+	app := newTestApp(fakeTwitchOptions(server))
+	webhook_urls := webhookServer.URL + "/one, "
+	app.options.webhook_urls = &webhook_urls
+	defer app.reset_webhook_sinks()
+
+	app.main_loop_iter.next()
+	webhook := waitForWebhook(ctx, received)
+	if webhook == nil {
+		return
+	}
+	if ctx.assert(len(app.getWebhookSinks()) == 1, "expected 1 webhook sink but got %v", len(app.getWebhookSinks())) {
+		return
+	}
+	event := &HeadlessEvent{}
+	if ctx.assertNoErr(json.Unmarshal(webhook.body, event), "json.Unmarshal()") {
+		return
+	}
+	if ctx.assert(event.Event == HEADLESS_EVENT_ONLINE && event.Channel == "FakeChannel" && event.Stream != 0 && event.Started != nil,
+		"unexpected webhook event %+v", *event) {
+		return
+	}
+	if ctx.assert(webhook.signature == "", "got a signature with no secret") {
+		return
+	}
+}